@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	lookupAPIAddress string
+	lookupProfile    string
+	lookupResolve    bool
+	lookupJSON       bool
+
+	asnRegex = regexp.MustCompile(`^(?i:AS)?[0-9]+$`)
+
+	lookupCmd = &cobra.Command{
+		Use:   "lookup <domain|ip|asn|country>",
+		Short: "Look up an entity in the filter lists of the running Portmaster",
+		Long: `Look up a domain, IP address, ASN (eg. AS13335) or two letter country code in the filter lists of the running Portmaster.
+Parent domains and CNAMEs are checked like the Portmaster does when filtering connections.
+If a profile ID is given, the filter list settings of that profile are used and the result shows whether the profile would block the entity.`,
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			// The lookup only talks to the API and needs neither the registry nor logging.
+			return nil
+		},
+		RunE: runLookup,
+	}
+)
+
+func init() {
+	flags := lookupCmd.Flags()
+	{
+		flags.StringVar(&lookupAPIAddress, "api-address", "127.0.0.1:817", "Address of the Portmaster API.")
+		flags.StringVar(&lookupProfile, "profile", "", "ID of the local profile to check the filter lists of.")
+		flags.BoolVar(&lookupResolve, "resolve", false, "Resolve the domain to also check its CNAMEs and IP address.")
+		flags.BoolVar(&lookupJSON, "json", false, "Print the raw API response.")
+	}
+
+	rootCmd.AddCommand(lookupCmd)
+}
+
+// The following types mirror the API response of the filter list lookup.
+type (
+	lookupResult struct {
+		CNAMEs  []string
+		IP      string
+		Lookups []*lookupEntity
+		Profile *lookupProfileResult
+	}
+
+	lookupEntity struct {
+		Type        string
+		Value       string
+		BloomHit    bool
+		DatabaseHit bool
+		NetworkHit  bool
+		Sources     []*lookupSource
+	}
+
+	lookupSource struct {
+		ID           string
+		Name         string
+		CategoryName string
+		Origins      []*lookupOrigin
+	}
+
+	lookupOrigin struct {
+		Resource string
+		Entry    string
+		Line     int
+	}
+
+	lookupProfileResult struct {
+		ID      string
+		Blocked bool
+		Reason  string
+	}
+)
+
+func runLookup(_ *cobra.Command, args []string) error {
+	params := url.Values{}
+	entity := strings.TrimSpace(args[0])
+	switch {
+	case net.ParseIP(entity) != nil:
+		params.Set("ip", entity)
+	case asnRegex.MatchString(entity):
+		params.Set("asn", entity)
+	case len(entity) == 2 && !strings.Contains(entity, "."):
+		params.Set("country", entity)
+	default:
+		params.Set("domain", entity)
+	}
+	if lookupProfile != "" {
+		params.Set("profile", lookupProfile)
+	}
+	if lookupResolve {
+		params.Set("resolve", "true")
+	}
+
+	data, err := queryAPI("intel/filterlists/lookup", params)
+	if err != nil {
+		return err
+	}
+	if lookupJSON {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	result := &lookupResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	printLookupResult(result)
+	return nil
+}
+
+// queryAPI sends a GET request to the given endpoint of the Portmaster API
+// and returns the response body.
+func queryAPI(endpoint string, params url.Values) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/api/v1/%s?%s", lookupAPIAddress, endpoint, params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Portmaster API, is the Portmaster running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, errors.New(msg)
+	}
+	return data, nil
+}
+
+func printLookupResult(result *lookupResult) {
+	if len(result.CNAMEs) > 0 {
+		fmt.Printf("CNAMEs: %s\n", strings.Join(result.CNAMEs, " -> "))
+	}
+	if result.IP != "" {
+		fmt.Printf("IP: %s\n", result.IP)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(tw, "Type\tEntity\tBloom\tDatabase\tLists\n")
+	for _, l := range result.Lookups {
+		database := "-"
+		switch {
+		case l.DatabaseHit:
+			database = "hit"
+		case l.BloomHit:
+			database = "miss (bloom false positive)"
+		}
+		if l.NetworkHit {
+			database += ", network hit"
+		}
+
+		lists := make([]string, 0, len(l.Sources))
+		for _, s := range l.Sources {
+			lists = append(lists, s.String())
+		}
+		if len(lists) == 0 {
+			lists = append(lists, "-")
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.Type, l.Value, yesNo(l.BloomHit), database, strings.Join(lists, ", "))
+	}
+	_ = tw.Flush()
+
+	// Print where the entities were found.
+	for _, l := range result.Lookups {
+		for _, s := range l.Sources {
+			for _, o := range s.Origins {
+				switch {
+				case o.Line > 0:
+					fmt.Printf("%s: %s line %d: %s\n", l.Value, s.ID, o.Line, o.Entry)
+				case o.Resource != "":
+					fmt.Printf("%s: %s resource %s\n", l.Value, s.ID, o.Resource)
+				}
+			}
+		}
+	}
+
+	if result.Profile != nil {
+		if result.Profile.Blocked {
+			fmt.Printf("\nProfile %s blocks this: %s\n", result.Profile.ID, result.Profile.Reason)
+		} else {
+			fmt.Printf("\nProfile %s does not block this with filter lists.\n", result.Profile.ID)
+		}
+	}
+}
+
+func (s *lookupSource) String() string {
+	name := s.ID
+	if s.Name != "" && s.Name != s.ID {
+		name += " (" + s.Name + ")"
+	}
+	if s.CategoryName != "" {
+		name += " [" + s.CategoryName + "]"
+	}
+	return name
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -56,8 +56,41 @@
 	SilenceUsage: true,
 }
 
+var recoverNftablesCmd = &cobra.Command{
+	Use:   "recover-nftables",
+	Short: "Removes obsolete nftables tables in case of an unclean shutdown",
+	RunE: func(*cobra.Command, []string) error {
+		// interception.DeactivateNftablesFirewall shells out to the nft binary.
+		// Make sure its output is always english by setting LC_ALL=C
+		currentLocale := os.Getenv("LC_ALL")
+		os.Setenv("LC_ALL", "C")                 // nolint:errcheck - we tried at least ...
+		defer os.Setenv("LC_ALL", currentLocale) // nolint:errcheck
+
+		err := interception.DeactivateNftablesFirewall()
+		if err == nil {
+			return nil
+		}
+
+		mr, ok := err.(*multierror.Error)
+		if !ok {
+			return err
+		}
+
+		for _, err := range mr.Errors {
+			if strings.Contains(err.Error(), "Operation not permitted") {
+				return fmt.Errorf("failed to cleanup nftables: %w", os.ErrPermission)
+			}
+		}
+
+		mr.ErrorFormat = formatNfqErrors
+		return mr.ErrorOrNil()
+	},
+	SilenceUsage: true,
+}
+
 func init() {
 	rootCmd.AddCommand(recoverIPTablesCmd)
+	rootCmd.AddCommand(recoverNftablesCmd)
 }
 
 func formatNfqErrors(es []error) string {
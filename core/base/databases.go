@@ -34,14 +34,14 @@ func registerDatabases() error {
 		return err
 	}
 
-	// _, err = database.Register(&database.Database{
-	//   Name:        "history",
-	//   Description: "Historic event data",
-	//   StorageType: DefaultDatabaseStorageType,
-	// })
-	// if err != nil {
-	//   return err
-	// }
+	_, err = database.Register(&database.Database{
+		Name:        "history",
+		Description: "Historic event data, such as the connection history",
+		StorageType: DefaultDatabaseStorageType,
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,45 @@
+package pmtesting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/safing/portbase/database"
+)
+
+// TestMainWithDatabases provides a unit test setup routine for packages that
+// only need databases. It registers the given databases in a temporary data
+// root without starting any modules. Storage types other than hashmap must be
+// imported by the caller.
+func TestMainWithDatabases(m *testing.M, dbs ...*database.Database) {
+	os.Exit(runTestsWithDatabases(m, dbs))
+}
+
+func runTestsWithDatabases(m *testing.M, dbs []*database.Database) int {
+	tmpDir, err := ioutil.TempDir("", "portmaster-testing")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create data root: %s\n", err)
+		return 1
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := database.InitializeWithPath(tmpDir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize database: %s\n", err)
+		return 1
+	}
+	defer func() {
+		_ = database.Shutdown()
+	}()
+	for _, db := range dbs {
+		if _, err := database.Register(db); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to register %s database: %s\n", db.Name, err)
+			return 1
+		}
+	}
+
+	return m.Run()
+}
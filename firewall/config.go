@@ -21,6 +21,18 @@
 	cfgOptionPermanentVerdictsOrder = 96
 	permanentVerdicts               config.BoolOption
 
+	CfgOptionEnableInspectionKey   = "filter/enableInspection"
+	cfgOptionEnableInspectionOrder = 97
+	enableInspection               config.BoolOption
+
+	CfgOptionInspectionMaxBytesKey   = "filter/inspectionMaxBytes"
+	cfgOptionInspectionMaxBytesOrder = 98
+	inspectionMaxBytes               config.IntOption
+
+	CfgOptionInspectionMaxPacketsKey   = "filter/inspectionMaxPackets"
+	cfgOptionInspectionMaxPacketsOrder = 99
+	inspectionMaxPackets               config.IntOption
+
 	devMode          config.BoolOption
 	apiListenAddress config.StringOption
 )
@@ -44,6 +56,72 @@ func registerConfig() error {
 	}
 	permanentVerdicts = config.Concurrent.GetAsBool(CfgOptionPermanentVerdictsKey, true)
 
+	err = config.Register(&config.Option{
+		Name:           "Connection Inspection",
+		Key:            CfgOptionEnableInspectionKey,
+		Description:    "Inspect the payload of allowed connections with the registered inspectors. Inspected connections do not receive a permanent verdict until inspection has finished.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionEnableInspectionOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enableInspection = config.Concurrent.GetAsBool(CfgOptionEnableInspectionKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Inspection Byte Limit",
+		Key:            CfgOptionInspectionMaxBytesKey,
+		Description:    "Stop inspecting a connection after this amount of payload has been inspected.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   16384,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionInspectionMaxBytesOrder,
+			config.UnitAnnotation:         "bytes",
+			config.CategoryAnnotation:     "Advanced",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionEnableInspectionKey,
+				Value: true,
+			},
+		},
+		ValidationRegex: `^[1-9][0-9]{2,7}$`,
+	})
+	if err != nil {
+		return err
+	}
+	inspectionMaxBytes = config.Concurrent.GetAsInt(CfgOptionInspectionMaxBytesKey, 16384)
+
+	err = config.Register(&config.Option{
+		Name:           "Inspection Packet Limit",
+		Key:            CfgOptionInspectionMaxPacketsKey,
+		Description:    "Stop inspecting a connection after this amount of packets has been inspected.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   32,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionInspectionMaxPacketsOrder,
+			config.UnitAnnotation:         "packets",
+			config.CategoryAnnotation:     "Advanced",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionEnableInspectionKey,
+				Value: true,
+			},
+		},
+		ValidationRegex: `^[1-9][0-9]{0,4}$`,
+	})
+	if err != nil {
+		return err
+	}
+	inspectionMaxPackets = config.Concurrent.GetAsInt(CfgOptionInspectionMaxPacketsKey, 32)
+
 	err = config.Register(&config.Option{
 		Name:           "Prompt Desktop Notifications",
 		Key:            CfgOptionAskWithSystemNotificationsKey,
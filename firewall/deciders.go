@@ -0,0 +1,284 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// DeciderFn is a function that takes part in the firewall decision pipeline.
+// It must return true if it set a verdict on the connection. The connection
+// and the profile are locked when a decider is called.
+type DeciderFn func(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, pkt packet.Packet) bool
+
+// Decider describes a decider of the firewall decision pipeline.
+type Decider struct {
+	// Name is the unique name of the decider. It is recorded in the reason of
+	// the verdicts it sets.
+	Name string
+
+	// Priority defines the position of the decider in the pipeline. Deciders
+	// with a lower priority are run first. Deciders with the same priority are
+	// run in the order they were registered.
+	Priority int
+
+	// ConnectionTypes restricts the decider to the given connection types. If
+	// empty, the decider is run for all connection types.
+	ConnectionTypes []network.ConnectionType
+
+	// Enabled is checked before every run of the decider. If nil, the decider is
+	// always enabled.
+	Enabled config.BoolOption
+
+	// Fn is the decider function.
+	Fn DeciderFn
+
+	registrationIndex int
+}
+
+// Priorities of the built-in deciders. Use these as a reference to position
+// additional deciders in the pipeline.
+const (
+	DeciderPriorityPortmasterConnection = 100
+	DeciderPrioritySelfCommunication    = 200
+	DeciderPriorityConnectionType       = 300
+	DeciderPriorityConnectionScope      = 400
+	DeciderPriorityEndpointLists        = 500
+	DeciderPriorityResolverScope        = 600
+	DeciderPriorityDNSSEC               = 650
+	DeciderPriorityConnectivityDomain   = 700
+	DeciderPriorityBypassPrevention     = 800
+	DeciderPriorityFilterLists          = 900
+	DeciderPriorityDropInbound          = 1000
+	DeciderPriorityDomainHeuristics     = 1100
+	DeciderPriorityAutoPermitRelated    = 1200
+)
+
+// Names of pseudo deciders, which are recorded when no decider set a verdict.
+const (
+	DeciderNameDefaultAction  = "default action"
+	DeciderNameSystemResolver = "system resolver"
+	DeciderNameUnknownProfile = "unknown profile"
+)
+
+var (
+	decidersLock sync.RWMutex
+	deciders     = make(map[string]*Decider)
+	// deciderPipeline holds the sorted list of deciders. It is replaced, never
+	// modified, in order to be able to use it without holding the lock.
+	deciderPipeline []*Decider
+
+	// ErrDeciderAlreadyRegistered is returned when a decider with the same name
+	// is already registered.
+	ErrDeciderAlreadyRegistered = errors.New("decider already registered")
+)
+
+var (
+	portmasterConnectionDecider = mustRegisterDecider(&Decider{
+		Name:     "portmaster connection",
+		Priority: DeciderPriorityPortmasterConnection,
+		Fn:       checkPortmasterConnection,
+	})
+	selfCommunicationDecider = mustRegisterDecider(&Decider{
+		Name:     "self communication",
+		Priority: DeciderPrioritySelfCommunication,
+		Fn:       checkSelfCommunication,
+	})
+	connectionTypeDecider = mustRegisterDecider(&Decider{
+		Name:            "connection type",
+		Priority:        DeciderPriorityConnectionType,
+		ConnectionTypes: []network.ConnectionType{network.IPConnection},
+		Fn:              checkConnectionType,
+	})
+	connectionScopeDecider = mustRegisterDecider(&Decider{
+		Name:     "connection scope",
+		Priority: DeciderPriorityConnectionScope,
+		Fn:       checkConnectionScope,
+	})
+	endpointListsDecider = mustRegisterDecider(&Decider{
+		Name:     "endpoint lists",
+		Priority: DeciderPriorityEndpointLists,
+		Fn:       checkEndpointLists,
+	})
+	resolverScopeDecider = mustRegisterDecider(&Decider{
+		Name:            "resolver scope",
+		Priority:        DeciderPriorityResolverScope,
+		ConnectionTypes: []network.ConnectionType{network.IPConnection},
+		Fn:              checkResolverScope,
+	})
+	dnssecDecider = mustRegisterDecider(&Decider{
+		Name:            "dnssec",
+		Priority:        DeciderPriorityDNSSEC,
+		ConnectionTypes: []network.ConnectionType{network.IPConnection},
+		Fn:              checkDNSSEC,
+	})
+	connectivityDomainDecider = mustRegisterDecider(&Decider{
+		Name:     "connectivity domain",
+		Priority: DeciderPriorityConnectivityDomain,
+		Fn:       checkConnectivityDomain,
+	})
+	bypassPreventionDecider = mustRegisterDecider(&Decider{
+		Name:     "bypass prevention",
+		Priority: DeciderPriorityBypassPrevention,
+		Fn:       checkBypassPrevention,
+	})
+	filterListsDecider = mustRegisterDecider(&Decider{
+		Name:     "filter lists",
+		Priority: DeciderPriorityFilterLists,
+		Fn:       checkFilterLists,
+	})
+	dropInboundDecider = mustRegisterDecider(&Decider{
+		Name:     "drop inbound",
+		Priority: DeciderPriorityDropInbound,
+		Fn:       dropInbound,
+	})
+	domainHeuristicsDecider = mustRegisterDecider(&Decider{
+		Name:     "domain heuristics",
+		Priority: DeciderPriorityDomainHeuristics,
+		Fn:       checkDomainHeuristics,
+	})
+	autoPermitRelatedDecider = mustRegisterDecider(&Decider{
+		Name:     "auto permit related",
+		Priority: DeciderPriorityAutoPermitRelated,
+		Fn:       checkAutoPermitRelated,
+	})
+
+	// dnsFromSystemResolverDeciders is a reduced pipeline for DNS requests of
+	// the system resolver.
+	dnsFromSystemResolverDeciders = []*Decider{
+		connectivityDomainDecider,
+		bypassPreventionDecider,
+	}
+)
+
+// RegisterDecider adds a decider to the firewall decision pipeline.
+func RegisterDecider(decider *Decider) error {
+	switch {
+	case decider == nil:
+		return errors.New("decider is nil")
+	case decider.Name == "":
+		return errors.New("decider is missing a name")
+	case decider.Fn == nil:
+		return fmt.Errorf("decider %s is missing a decider function", decider.Name)
+	}
+
+	decidersLock.Lock()
+	defer decidersLock.Unlock()
+
+	if _, ok := deciders[decider.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDeciderAlreadyRegistered, decider.Name)
+	}
+	decider.registrationIndex = len(deciders)
+	deciders[decider.Name] = decider
+
+	// Rebuild the pipeline.
+	newPipeline := make([]*Decider, 0, len(deciders))
+	for _, d := range deciders {
+		newPipeline = append(newPipeline, d)
+	}
+	sort.Slice(newPipeline, func(i, j int) bool {
+		if newPipeline[i].Priority != newPipeline[j].Priority {
+			return newPipeline[i].Priority < newPipeline[j].Priority
+		}
+		return newPipeline[i].registrationIndex < newPipeline[j].registrationIndex
+	})
+	deciderPipeline = newPipeline
+
+	return nil
+}
+
+func mustRegisterDecider(decider *Decider) *Decider {
+	if err := RegisterDecider(decider); err != nil {
+		panic(err)
+	}
+	return decider
+}
+
+// getDeciderPipeline returns the current decider pipeline. The returned slice
+// must not be modified.
+func getDeciderPipeline() []*Decider {
+	decidersLock.RLock()
+	defer decidersLock.RUnlock()
+
+	return deciderPipeline
+}
+
+// IsEnabled returns whether the decider is currently enabled.
+func (d *Decider) IsEnabled() bool {
+	return d.Enabled == nil || d.Enabled()
+}
+
+// AppliesTo returns whether the decider should be run for the given
+// connection type.
+func (d *Decider) AppliesTo(connType network.ConnectionType) bool {
+	if len(d.ConnectionTypes) == 0 {
+		return true
+	}
+
+	for _, t := range d.ConnectionTypes {
+		if t == connType {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Decider) connectionTypeNames() []string {
+	if len(d.ConnectionTypes) == 0 {
+		return []string{"all"}
+	}
+
+	names := make([]string, 0, len(d.ConnectionTypes))
+	for _, t := range d.ConnectionTypes {
+		switch t {
+		case network.IPConnection:
+			names = append(names, "ip")
+		case network.DNSRequest:
+			names = append(names, "dns")
+		default:
+			names = append(names, fmt.Sprintf("unknown (%d)", t))
+		}
+	}
+	return names
+}
+
+type deciderExport struct {
+	Name            string
+	Priority        int
+	ConnectionTypes []string
+	Enabled         bool
+}
+
+func registerDeciderAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "filter/deciders",
+		Read:        api.PermitUser,
+		StructFunc:  exportDeciderPipeline,
+		Name:        "List Firewall Deciders",
+		Description: "List the deciders of the firewall decision pipeline in the order they are run.",
+	})
+}
+
+func exportDeciderPipeline(*api.Request) (interface{}, error) {
+	pipeline := getDeciderPipeline()
+
+	export := make([]deciderExport, 0, len(pipeline))
+	for _, d := range pipeline {
+		export = append(export, deciderExport{
+			Name:            d.Name,
+			Priority:        d.Priority,
+			ConnectionTypes: d.connectionTypeNames(),
+			Enabled:         d.IsEnabled(),
+		})
+	}
+
+	return export, nil
+}
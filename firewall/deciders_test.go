@@ -0,0 +1,158 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// resetDecidersAfterTest restores the registered deciders when the test
+// finishes.
+func resetDecidersAfterTest(t *testing.T) {
+	t.Helper()
+
+	decidersLock.Lock()
+	defer decidersLock.Unlock()
+
+	savedDeciders := make(map[string]*Decider, len(deciders))
+	for name, d := range deciders {
+		savedDeciders[name] = d
+	}
+	savedPipeline := deciderPipeline
+
+	t.Cleanup(func() {
+		decidersLock.Lock()
+		defer decidersLock.Unlock()
+
+		deciders = savedDeciders
+		deciderPipeline = savedPipeline
+	})
+}
+
+func noDecision(context.Context, *network.Connection, *profile.LayeredProfile, packet.Packet) bool {
+	return false
+}
+
+func TestDeciderOrder(t *testing.T) {
+	resetDecidersAfterTest(t)
+
+	for _, d := range []*Decider{
+		{Name: "test last", Priority: DeciderPriorityAutoPermitRelated + 1, Fn: noDecision},
+		{Name: "test before filter lists", Priority: DeciderPriorityFilterLists - 1, Fn: noDecision},
+		{Name: "test with filter lists 1", Priority: DeciderPriorityFilterLists, Fn: noDecision},
+		{Name: "test with filter lists 2", Priority: DeciderPriorityFilterLists, Fn: noDecision},
+	} {
+		if err := RegisterDecider(d); err != nil {
+			t.Fatalf("failed to register decider %s: %s", d.Name, err)
+		}
+	}
+
+	// Get the relevant part of the pipeline.
+	var order []string
+	for _, d := range getDeciderPipeline() {
+		switch d.Priority {
+		case DeciderPriorityFilterLists - 1,
+			DeciderPriorityFilterLists,
+			DeciderPriorityAutoPermitRelated,
+			DeciderPriorityAutoPermitRelated + 1:
+			order = append(order, d.Name)
+		}
+	}
+
+	expected := []string{
+		"test before filter lists",
+		filterListsDecider.Name, // Registered first.
+		"test with filter lists 1",
+		"test with filter lists 2",
+		autoPermitRelatedDecider.Name,
+		"test last",
+	}
+	if len(order) != len(expected) {
+		t.Fatalf("unexpected pipeline order: %v", order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected pipeline order: %v", order)
+		}
+	}
+}
+
+func TestDeciderRegistration(t *testing.T) {
+	resetDecidersAfterTest(t)
+
+	pipelineLength := len(getDeciderPipeline())
+
+	// A decider name may only be registered once.
+	err := RegisterDecider(&Decider{Name: filterListsDecider.Name, Fn: noDecision})
+	if !errors.Is(err, ErrDeciderAlreadyRegistered) {
+		t.Errorf("expected ErrDeciderAlreadyRegistered, got %v", err)
+	}
+	if err := RegisterDecider(&Decider{Name: "test duplicate", Fn: noDecision}); err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterDecider(&Decider{Name: "test duplicate", Fn: noDecision})
+	if !errors.Is(err, ErrDeciderAlreadyRegistered) {
+		t.Errorf("expected ErrDeciderAlreadyRegistered, got %v", err)
+	}
+
+	// Invalid deciders.
+	for _, d := range []*Decider{
+		nil,
+		{Fn: noDecision},
+		{Name: "test without function"},
+	} {
+		if err := RegisterDecider(d); err == nil {
+			t.Errorf("invalid decider %+v was registered", d)
+		}
+	}
+
+	if len(getDeciderPipeline()) != pipelineLength+1 {
+		t.Errorf("expected pipeline with %d deciders, got %d", pipelineLength+1, len(getDeciderPipeline()))
+	}
+}
+
+func TestDeciderAttribution(t *testing.T) {
+	blocker := &Decider{
+		Name: "test blocker",
+		Fn: func(_ context.Context, conn *network.Connection, _ *profile.LayeredProfile, _ packet.Packet) bool {
+			conn.Block("blocked by test", "")
+			return true
+		},
+	}
+	accepter := &Decider{
+		Name: "test accepter",
+		Fn: func(_ context.Context, conn *network.Connection, _ *profile.LayeredProfile, _ packet.Packet) bool {
+			conn.Accept("accepted by test", "")
+			return true
+		},
+	}
+
+	conn := &network.Connection{}
+	if !runDecider(context.Background(), blocker, conn, nil, nil) {
+		t.Fatal("blocker should have decided")
+	}
+	if conn.Verdict != network.VerdictBlock || conn.Reason.Decider != blocker.Name {
+		t.Fatalf("unexpected verdict %s by %q", conn.Verdict, conn.Reason.Decider)
+	}
+
+	// Accepting cannot lower the verdict, so the decision must stay
+	// attributed to the blocker.
+	if !runDecider(context.Background(), accepter, conn, nil, nil) {
+		t.Fatal("accepter should have decided")
+	}
+	if conn.Verdict != network.VerdictBlock || conn.Reason.Decider != blocker.Name {
+		t.Errorf("unexpected verdict %s by %q", conn.Verdict, conn.Reason.Decider)
+	}
+
+	// Deciders without a decision do not change the attribution.
+	if runDecider(context.Background(), &Decider{Name: "test undecided", Fn: noDecision}, conn, nil, nil) {
+		t.Error("undecided decider should not have decided")
+	}
+	if conn.Reason.Decider != blocker.Name {
+		t.Errorf("unexpected decider %q", conn.Reason.Decider)
+	}
+}
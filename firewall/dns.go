@@ -179,6 +179,15 @@ func FilterResolvedDNS(
 	// Only filter criticial things if request comes from the system resolver.
 	sysResolver := conn.Process().IsSystemResolver()
 
+	// Block answers that failed DNSSEC validation. Answers to the system
+	// resolver are checked when the resulting connection is made.
+	if !sysResolver &&
+		layeredProfile.BlockDNSSECFailures() &&
+		rrCache.Resolver.DNSSEC == resolver.DNSSECBogus {
+		conn.Block("DNSSEC validation of DNS answer failed", profile.CfgOptionBlockDNSSECFailuresKey)
+		return rrCache
+	}
+
 	// Filter dns records and return if the query is blocked.
 	rrCache = filterDNSResponse(ctx, conn, layeredProfile, rrCache, sysResolver)
 	if conn.Verdict == network.VerdictBlock {
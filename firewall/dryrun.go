@@ -0,0 +1,228 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
+)
+
+// Outcomes of deciders, as recorded in a DecisionTrace.
+const (
+	DeciderOutcomeNotApplicable = "not applicable"
+	DeciderOutcomeDisabled      = "disabled"
+	DeciderOutcomeNoDecision    = "no decision"
+	DeciderOutcomeDecided       = "decided"
+)
+
+// DeciderTraceEntry records the outcome of a single decider.
+type DeciderTraceEntry struct {
+	Decider string
+	Outcome string
+}
+
+// DecisionTrace records the outcome of every decider that was considered
+// while deciding on a connection.
+type DecisionTrace []DeciderTraceEntry
+
+// add adds an entry to the trace. It is a no-op if the trace is nil.
+func (t *DecisionTrace) add(decider *Decider, outcome string) {
+	if t == nil {
+		return
+	}
+
+	*t = append(*t, DeciderTraceEntry{
+		Decider: decider.Name,
+		Outcome: outcome,
+	})
+}
+
+// DryRunResult is the result of a dry run of the decision pipeline.
+type DryRunResult struct {
+	// Verdict is the verdict the connection would receive. It is "undecided"
+	// if the user would be prompted.
+	Verdict string
+	// Prompt is set if the user would be prompted to decide.
+	Prompt bool
+	// Reason is the human readable reason of the verdict.
+	Reason string
+	// OptionKey is the configuration option key of the setting that was
+	// responsible for the verdict.
+	OptionKey string
+	// Profile is the database key of the profile that held the setting that
+	// was responsible for the verdict.
+	Profile string
+	// Decider is the name of the decider that set the verdict.
+	Decider string
+	// Trace holds the outcome of every decider that was considered.
+	Trace DecisionTrace
+}
+
+// DryRun evaluates how a connection of the local profile with the given ID
+// to the given entity would be handled. If the entity has no IP address, a
+// DNS request for the entity's domain is evaluated. No prompts are shown and
+// nothing is saved.
+func DryRun(ctx context.Context, profileID string, entity *intel.Entity, inbound bool) (*DryRunResult, error) {
+	// Get the profile. Only local profiles are used for connections.
+	localProfile, err := profile.GetProfile(profile.SourceLocal, profileID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	return dryRun(ctx, localProfile, entity, inbound), nil
+}
+
+func dryRun(ctx context.Context, localProfile *profile.Profile, entity *intel.Entity, inbound bool) *DryRunResult {
+	// Evaluate with a temporary layered profile, so that the layered profile
+	// in use is not changed.
+	layeredProfile := profile.NewTemporaryLayeredProfile(localProfile)
+
+	// The hypothetical connection records the verdict without logging
+	// conflicting verdicts and is never saved.
+	conn := network.NewHypotheticalConnection(ctx, process.NewVirtualProcess(layeredProfile), entity, inbound)
+	conn.Lock()
+	defer conn.Unlock()
+
+	result := &DryRunResult{}
+	if !runDecisionPipeline(ctx, conn, layeredProfile, nil, &result.Trace) {
+		result.Prompt = true
+		conn.Reason.Decider = DeciderNameDefaultAction
+		conn.Reason.Msg = "user would be prompted by default action"
+		conn.Reason.OptionKey = profile.CfgOptionDefaultActionKey
+		conn.Reason.Profile = layeredProfile.GetProfileSource(profile.CfgOptionDefaultActionKey)
+	}
+
+	result.Verdict = conn.Verdict.String()
+	result.Reason = conn.Reason.Msg
+	result.OptionKey = conn.Reason.OptionKey
+	result.Profile = conn.Reason.Profile
+	result.Decider = conn.Reason.Decider
+	return result
+}
+
+func registerDryRunAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "filter/dryrun",
+		Read:        api.PermitUser,
+		StructFunc:  handleDryRun,
+		Name:        "Dry Run Firewall Decision",
+		Description: "Evaluate how a hypothetical connection would be handled, without any side effects. If no IP is given, a DNS request for the domain is evaluated.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodGet,
+				Field:       "profile",
+				Value:       "<ID>",
+				Description: "Specify the ID of the local profile to evaluate the connection for.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "domain",
+				Value:       "<domain>",
+				Description: "Specify the domain of the remote entity.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "ip",
+				Value:       "<ip>",
+				Description: "Specify the IP address of the remote entity.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "port",
+				Value:       "<port>",
+				Description: "Specify the destination port. For inbound connections, this is the local port.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "protocol",
+				Value:       "tcp|udp|<number>",
+				Description: "Specify the IP protocol. The default is tcp.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "direction",
+				Value:       "outbound|inbound",
+				Description: "Specify the direction of the connection. The default is outbound.",
+			},
+		},
+	})
+}
+
+func handleDryRun(ar *api.Request) (interface{}, error) {
+	q := ar.Request.URL.Query()
+
+	profileID := q.Get("profile")
+	if profileID == "" {
+		return nil, errors.New("missing profile ID")
+	}
+
+	entity := &intel.Entity{}
+	if domain := q.Get("domain"); domain != "" {
+		entity.Domain = dns.Fqdn(strings.ToLower(domain))
+		if _, ok := dns.IsDomainName(entity.Domain); !ok {
+			return nil, fmt.Errorf("invalid domain: %s", domain)
+		}
+	}
+	if ipParam := q.Get("ip"); ipParam != "" {
+		ip := net.ParseIP(ipParam)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", ipParam)
+		}
+		entity.SetIP(ip)
+	}
+	if entity.IP == nil && entity.Domain == "" {
+		return nil, errors.New("missing domain or IP address")
+	}
+
+	entity.Protocol = uint8(packet.TCP)
+	switch protocol := strings.ToLower(q.Get("protocol")); protocol {
+	case "", "tcp":
+	case "udp":
+		entity.Protocol = uint8(packet.UDP)
+	default:
+		n, err := strconv.ParseUint(protocol, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol: %s", protocol)
+		}
+		entity.Protocol = uint8(n)
+	}
+
+	var inbound bool
+	switch direction := strings.ToLower(q.Get("direction")); direction {
+	case "", "outbound":
+	case "inbound":
+		inbound = true
+	default:
+		return nil, fmt.Errorf("invalid direction: %s", direction)
+	}
+
+	if portParam := q.Get("port"); portParam != "" {
+		port, err := strconv.ParseUint(portParam, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", portParam)
+		}
+		// The port is always the destination port. For inbound connections, the
+		// remote port is not known.
+		entity.SetDstPort(uint16(port))
+		if !inbound {
+			entity.Port = uint16(port)
+		}
+	}
+	if inbound && entity.IP == nil {
+		return nil, errors.New("inbound connections require an IP address")
+	}
+
+	return DryRun(ar.Context(), profileID, entity, inbound)
+}
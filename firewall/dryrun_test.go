@@ -0,0 +1,110 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+func TestDryRun(t *testing.T) {
+	blockingProfile := profile.New(profile.SourceLocal, "dryrun-test-block", "", map[string]interface{}{
+		profile.CfgOptionDefaultActionKey: "block",
+		profile.CfgOptionEndpointsKey: []string{
+			"- ads.example.com",
+			"+ .example.com",
+			"+ 192.0.2.1",
+		},
+	})
+	askingProfile := profile.New(profile.SourceLocal, "dryrun-test-ask", "", map[string]interface{}{
+		profile.CfgOptionDefaultActionKey: "ask",
+	})
+
+	domainEntity := func(domain string) *intel.Entity {
+		return &intel.Entity{
+			Domain: domain,
+		}
+	}
+	ipEntity := func(ip string) *intel.Entity {
+		entity := &intel.Entity{
+			Protocol: uint8(packet.TCP),
+			Port:     443,
+		}
+		entity.SetIP(net.ParseIP(ip))
+		entity.SetDstPort(443)
+		return entity
+	}
+
+	for _, test := range []struct {
+		name    string
+		profile *profile.Profile
+		entity  *intel.Entity
+		verdict network.Verdict
+		prompt  bool
+		decider string
+	}{
+		{
+			name:    "permitted domain",
+			profile: blockingProfile,
+			entity:  domainEntity("www.example.com."),
+			verdict: network.VerdictAccept,
+			decider: endpointListsDecider.Name,
+		},
+		{
+			name:    "blocked subdomain",
+			profile: blockingProfile,
+			entity:  domainEntity("ads.example.com."),
+			verdict: network.VerdictBlock,
+			decider: endpointListsDecider.Name,
+		},
+		{
+			name:    "default action block",
+			profile: blockingProfile,
+			entity:  domainEntity("example.net."),
+			verdict: network.VerdictBlock,
+			decider: DeciderNameDefaultAction,
+		},
+		{
+			name:    "permitted IP",
+			profile: blockingProfile,
+			entity:  ipEntity("192.0.2.1"),
+			verdict: network.VerdictAccept,
+			decider: endpointListsDecider.Name,
+		},
+		{
+			name:    "default action ask",
+			profile: askingProfile,
+			entity:  domainEntity("example.net."),
+			verdict: network.VerdictUndecided,
+			prompt:  true,
+			decider: DeciderNameDefaultAction,
+		},
+	} {
+		result := dryRun(context.Background(), test.profile, test.entity, false)
+		if result.Verdict != test.verdict.String() ||
+			result.Prompt != test.prompt ||
+			result.Decider != test.decider {
+			t.Errorf(
+				"%s: expected %s (prompt=%v) by %s, got %s (prompt=%v) by %s: %s",
+				test.name,
+				test.verdict, test.prompt, test.decider,
+				result.Verdict, result.Prompt, result.Decider, result.Reason,
+			)
+		}
+		if len(result.Trace) == 0 {
+			t.Errorf("%s: missing decision trace", test.name)
+		}
+	}
+
+	// The dry run must not change the profiles or save the connection.
+	if blockingProfile.LayeredProfile() != nil || askingProfile.LayeredProfile() != nil {
+		t.Error("dry run assigned a layered profile to the local profile")
+	}
+	if _, ok := network.GetConnection("hypothetical"); ok {
+		t.Error("dry run saved the hypothetical connection")
+	}
+}
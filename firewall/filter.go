@@ -5,6 +5,8 @@
 	"github.com/safing/portbase/modules/subsystems"
 
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/firewall/inspection/tlsinspect"
 
 	// module dependencies
 	_ "github.com/safing/portmaster/core"
@@ -44,6 +46,26 @@ func filterPrep() (err error) {
 		return err
 	}
 
+	err = registerDeciderAPI()
+	if err != nil {
+		return err
+	}
+
+	err = registerDryRunAPI()
+	if err != nil {
+		return err
+	}
+
+	err = registerListLookupAPI()
+	if err != nil {
+		return err
+	}
+
+	err = inspection.RegisterInspector(tlsinspect.New())
+	if err != nil {
+		return err
+	}
+
 	filterEnabled = config.GetAsBool(CfgOptionEnableFilterKey, true)
 	return nil
 }
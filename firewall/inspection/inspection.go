@@ -1,102 +1,292 @@
 package inspection
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+
+	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/network/packet"
 )
 
-//nolint:golint,stylecheck // FIXME
+// Action is the result of an inspection run.
+type Action uint8
+
+// Inspection Actions.
 const (
-	DO_NOTHING uint8 = iota
-	BLOCK_PACKET
-	DROP_PACKET
-	BLOCK_CONN
-	DROP_CONN
-	STOP_INSPECTING
+	// ActionContinue continues the inspection. The packet receives the verdict
+	// of the connection.
+	ActionContinue Action = iota
+	// ActionStop stops the inspector for this connection. The packet receives
+	// the verdict of the connection.
+	ActionStop
+	// ActionBlockPacket blocks the current packet and continues the inspection.
+	ActionBlockPacket
+	// ActionDropPacket drops the current packet and continues the inspection.
+	ActionDropPacket
+	// ActionBlockConnection blocks the connection and stops all inspection.
+	ActionBlockConnection
+	// ActionDropConnection drops the connection and stops all inspection.
+	ActionDropConnection
 )
 
-type inspectorFn func(*network.Connection, packet.Packet) uint8
+// Verdict returns the verdict that the action maps to. Actions that do not
+// change the verdict return network.VerdictUndecided.
+func (a Action) Verdict() network.Verdict {
+	switch a {
+	case ActionBlockPacket, ActionBlockConnection:
+		return network.VerdictBlock
+	case ActionDropPacket, ActionDropConnection:
+		return network.VerdictDrop
+	default:
+		return network.VerdictUndecided
+	}
+}
+
+// Stream holds the reassembled payload of a connection.
+type Stream struct {
+	// Outbound holds the payload sent to the remote entity.
+	Outbound *netutils.SimpleStreamAssembler
+	// Inbound holds the payload received from the remote entity.
+	Inbound *netutils.SimpleStreamAssembler
+
+	// Packets holds the amount of inspected packets.
+	Packets int
+	// Bytes holds the amount of inspected payload bytes.
+	Bytes int
+}
+
+// Inspector inspects the payload of connections.
+type Inspector interface {
+	// Name returns the unique name of the inspector.
+	Name() string
+
+	// Start is called when the inspection of a connection starts. It returns
+	// whether the connection should be inspected and the per-connection state
+	// of the inspector. The connection is locked.
+	Start(conn *network.Connection) (state interface{}, inspect bool)
+
+	// Inspect is called whenever new payload was reassembled. Inbound is set if
+	// the new payload was received from the remote entity. The returned reason
+	// is used when the connection verdict is changed. Inspectors may also set
+	// the connection verdict themselves in order to provide a more detailed
+	// reason. The connection is locked.
+	Inspect(ctx context.Context, conn *network.Connection, stream *Stream, inbound bool, state interface{}) (action Action, reason string)
+}
 
 var (
-	inspectors      []inspectorFn
-	inspectorNames  []string
-	inspectVerdicts []network.Verdict
-	inspectorsLock  sync.Mutex
+	inspectors     []Inspector
+	inspectorsLock sync.RWMutex
+
+	// ErrInspectorAlreadyRegistered is returned when an inspector with the same
+	// name is already registered.
+	ErrInspectorAlreadyRegistered = errors.New("inspector already registered")
 )
 
 // RegisterInspector registers a traffic inspector.
-func RegisterInspector(name string, inspector inspectorFn, inspectVerdict network.Verdict) (index int) {
+func RegisterInspector(inspector Inspector) error {
 	inspectorsLock.Lock()
 	defer inspectorsLock.Unlock()
-	index = len(inspectors)
+
+	for _, registered := range inspectors {
+		if registered.Name() == inspector.Name() {
+			return fmt.Errorf("%w: %s", ErrInspectorAlreadyRegistered, inspector.Name())
+		}
+	}
+
 	inspectors = append(inspectors, inspector)
-	inspectorNames = append(inspectorNames, name)
-	inspectVerdicts = append(inspectVerdicts, inspectVerdict)
-	return
+	return nil
 }
 
-// RunInspectors runs all the applicable inspectors on the given packet.
-func RunInspectors(conn *network.Connection, pkt packet.Packet) (network.Verdict, bool) {
-	// inspectorsLock.Lock()
-	// defer inspectorsLock.Unlock()
+// connInspection holds the inspection state of a connection.
+type connInspection struct {
+	stream     Stream
+	assembler  *tcpassembly.Assembler
+	factory    *streamFactory
+	inspectors []*activeInspector
+
+	maxBytes   int
+	maxPackets int
+}
+
+type activeInspector struct {
+	Inspector
+	state interface{}
+}
 
-	activeInspectors := conn.GetActiveInspectors()
-	if activeInspectors == nil {
-		activeInspectors = make([]bool, len(inspectors))
-		conn.SetActiveInspectors(activeInspectors)
+// streamFactory hands the tcp assembler the stream of the direction of the
+// packet that is currently being assembled.
+type streamFactory struct {
+	stream  *Stream
+	inbound bool
+}
+
+// New implements tcpassembly.StreamFactory's New function.
+func (f *streamFactory) New(_, _ gopacket.Flow) tcpassembly.Stream {
+	if f.inbound {
+		return f.stream.Inbound
 	}
+	return f.stream.Outbound
+}
 
-	inspectorData := conn.GetInspectorData()
-	if inspectorData == nil {
-		inspectorData = make(map[uint8]interface{})
-		conn.SetInspectorData(inspectorData)
+// StartInspection starts the inspection of the given connection, if any
+// registered inspector is interested in it. Inspection stops automatically
+// after maxBytes of payload or maxPackets packets. It returns whether the
+// connection is being inspected. The connection must be locked.
+func StartInspection(conn *network.Connection, maxBytes, maxPackets int) bool {
+	inspectorsLock.RLock()
+	defer inspectorsLock.RUnlock()
+
+	ci := &connInspection{
+		stream: Stream{
+			Outbound: netutils.NewSimpleStreamAssembler(),
+			Inbound:  netutils.NewSimpleStreamAssembler(),
+		},
+		maxBytes:   maxBytes,
+		maxPackets: maxPackets,
+	}
+	for _, inspector := range inspectors {
+		state, inspect := inspector.Start(conn)
+		if inspect {
+			ci.inspectors = append(ci.inspectors, &activeInspector{
+				Inspector: inspector,
+				state:     state,
+			})
+		}
+	}
+	if len(ci.inspectors) == 0 {
+		return false
 	}
 
-	continueInspection := false
-	verdict := network.VerdictUndecided
+	// Reassemble TCP streams. Every connection gets its own assembler, as
+	// connections are handled concurrently.
+	if conn.IPProtocol == packet.TCP {
+		ci.factory = &streamFactory{stream: &ci.stream}
+		ci.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(ci.factory))
+		ci.assembler.MaxBufferedPagesPerConnection = 16
+		ci.assembler.MaxBufferedPagesTotal = 32
+	}
 
-	for key, skip := range activeInspectors {
+	conn.SetInspectionState(ci)
+	conn.Inspecting = true
+	return true
+}
 
-		if skip {
-			continue
-		}
+// StopInspection stops the inspection of the given connection. The connection
+// must be locked.
+func StopInspection(conn *network.Connection) {
+	conn.SetInspectionState(nil)
+	conn.Inspecting = false
+}
 
-		// check if the current verdict is already past the inspection criteria.
-		if conn.Verdict > inspectVerdicts[key] {
-			activeInspectors[key] = true
-			continue
-		}
+// RunInspectors runs all the active inspectors on the given packet. It
+// returns the verdict for the packet and whether inspection should continue.
+// The connection must be locked.
+func RunInspectors(conn *network.Connection, pkt packet.Packet) (network.Verdict, bool) {
+	ci, ok := conn.GetInspectionState().(*connInspection)
+	if !ok {
+		return network.VerdictUndecided, false
+	}
+
+	// Load the payload, if not yet done.
+	if err := pkt.LoadPacketData(); err != nil {
+		log.Tracer(pkt.Ctx()).Debugf("inspection: stopping inspection, failed to load payload: %s", err)
+		StopInspection(conn)
+		return network.VerdictUndecided, false
+	}
+	ci.stream.Packets++
+	ci.stream.Bytes += len(pkt.Payload())
 
-		action := inspectors[key](conn, pkt) // Actually run inspector
-		switch action {
-		case DO_NOTHING:
-			if verdict < network.VerdictAccept {
-				verdict = network.VerdictAccept
+	// Run inspectors if there is new payload.
+	verdict := network.VerdictUndecided
+	if ci.reassemble(pkt) {
+		inbound := pkt.IsInbound()
+		for i, inspector := range ci.inspectors {
+			if inspector == nil {
+				continue
 			}
-			continueInspection = true
-		case BLOCK_PACKET:
-			if verdict < network.VerdictBlock {
-				verdict = network.VerdictBlock
+
+			action, reason := inspector.Inspect(pkt.Ctx(), conn, &ci.stream, inbound, inspector.state)
+			switch action {
+			case ActionContinue:
+			case ActionStop:
+				ci.inspectors[i] = nil
+			case ActionBlockPacket, ActionDropPacket:
+				log.Tracer(pkt.Ctx()).Debugf("inspection: %s rejected packet: %s", inspector.Name(), reason)
+				if action.Verdict() > verdict {
+					verdict = action.Verdict()
+				}
+			case ActionBlockConnection, ActionDropConnection:
+				log.Tracer(pkt.Ctx()).Infof("inspection: %s rejected connection: %s", inspector.Name(), reason)
+				if conn.Verdict < action.Verdict() {
+					conn.SetVerdict(action.Verdict(), reason, "", nil)
+				}
+				StopInspection(conn)
+				return conn.Verdict, false
+			default:
+				log.Tracer(pkt.Ctx()).Warningf("inspection: %s returned unknown action %d", inspector.Name(), action)
 			}
-			continueInspection = true
-		case DROP_PACKET:
-			verdict = network.VerdictDrop
-			continueInspection = true
-		case BLOCK_CONN:
-			conn.SetVerdict(network.VerdictBlock, "", "", nil)
-			verdict = conn.Verdict
-			activeInspectors[key] = true
-		case DROP_CONN:
-			conn.SetVerdict(network.VerdictDrop, "", "", nil)
-			verdict = conn.Verdict
-			activeInspectors[key] = true
-		case STOP_INSPECTING:
-			activeInspectors[key] = true
 		}
+	}
 
+	// Check if inspection should continue.
+	switch {
+	case !ci.hasActiveInspectors():
+		log.Tracer(pkt.Ctx()).Trace("inspection: all inspectors finished")
+	case ci.stream.Bytes >= ci.maxBytes:
+		log.Tracer(pkt.Ctx()).Tracef("inspection: byte budget of %d exhausted", ci.maxBytes)
+	case ci.stream.Packets >= ci.maxPackets:
+		log.Tracer(pkt.Ctx()).Tracef("inspection: packet budget of %d exhausted", ci.maxPackets)
+	default:
+		return verdict, true
 	}
 
-	return verdict, continueInspection
+	StopInspection(conn)
+	return verdict, false
+}
+
+// reassemble adds the payload of the packet to the stream and returns whether
+// new payload is available.
+func (ci *connInspection) reassemble(pkt packet.Packet) (newData bool) {
+	direction := ci.stream.Outbound
+	if pkt.IsInbound() {
+		direction = ci.stream.Inbound
+	}
+	previousLen := direction.CumulatedLen
+
+	// Reassemble TCP streams, if the packet layers are available.
+	if ci.assembler != nil && pkt.Layers() != nil {
+		tcp, ok := pkt.Layers().TransportLayer().(*layers.TCP)
+		networkLayer := pkt.Layers().NetworkLayer()
+		if ok && networkLayer != nil {
+			ci.factory.inbound = pkt.IsInbound()
+			ci.assembler.AssembleWithTimestamp(networkLayer.NetworkFlow(), tcp, time.Now())
+			return direction.CumulatedLen > previousLen
+		}
+	}
+
+	// Otherwise, treat every packet as the next piece of the stream.
+	if len(pkt.Payload()) > 0 {
+		direction.Reassembled([]tcpassembly.Reassembly{{
+			Bytes: pkt.Payload(),
+		}})
+	}
+	return direction.CumulatedLen > previousLen
+}
+
+func (ci *connInspection) hasActiveInspectors() bool {
+	for _, inspector := range ci.inspectors {
+		if inspector != nil {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,273 @@
+package inspection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// testInspector returns the configured actions in order and records every
+// call in the shared call log.
+type testInspector struct {
+	name    string
+	inspect bool
+	actions []Action
+
+	calls *[]string
+}
+
+func (ti *testInspector) Name() string {
+	return ti.name
+}
+
+func (ti *testInspector) Start(_ *network.Connection) (interface{}, bool) {
+	*ti.calls = append(*ti.calls, "start "+ti.name)
+	// Every connection gets its own position in the action list.
+	return new(int), ti.inspect
+}
+
+func (ti *testInspector) Inspect(_ context.Context, _ *network.Connection, _ *Stream, _ bool, state interface{}) (Action, string) {
+	*ti.calls = append(*ti.calls, "inspect "+ti.name)
+
+	position := state.(*int)
+	action := ActionContinue
+	if *position < len(ti.actions) {
+		action = ti.actions[*position]
+	}
+	*position++
+	return action, ti.name
+}
+
+// testPacket is a packet with a fixed payload.
+type testPacket struct {
+	packet.Base
+	payload []byte
+}
+
+func newTestPacket(payload string) *testPacket {
+	pkt := &testPacket{
+		payload: []byte(payload),
+	}
+	pkt.SetCtx(context.Background())
+	pkt.SetOutbound()
+	return pkt
+}
+
+func (pkt *testPacket) LoadPacketData() error      { return nil }
+func (pkt *testPacket) Payload() []byte            { return pkt.payload }
+func (pkt *testPacket) Accept() error              { return nil }
+func (pkt *testPacket) Block() error               { return nil }
+func (pkt *testPacket) Drop() error                { return nil }
+func (pkt *testPacket) PermanentAccept() error     { return nil }
+func (pkt *testPacket) PermanentBlock() error      { return nil }
+func (pkt *testPacket) PermanentDrop() error       { return nil }
+func (pkt *testPacket) RerouteToNameserver() error { return nil }
+func (pkt *testPacket) RerouteToTunnel() error     { return nil }
+
+// registerTestInspectors replaces the registered inspectors for the duration
+// of the test.
+func registerTestInspectors(t *testing.T, testInspectors ...*testInspector) {
+	t.Helper()
+
+	inspectorsLock.Lock()
+	previous := inspectors
+	inspectors = nil
+	inspectorsLock.Unlock()
+	t.Cleanup(func() {
+		inspectorsLock.Lock()
+		defer inspectorsLock.Unlock()
+		inspectors = previous
+	})
+
+	for _, inspector := range testInspectors {
+		if err := RegisterInspector(inspector); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestConnection() *network.Connection {
+	return &network.Connection{
+		IPProtocol: packet.UDP,
+		Verdict:    network.VerdictAccept,
+	}
+}
+
+func assertCalls(t *testing.T, calls *[]string, expected ...string) {
+	t.Helper()
+
+	if len(*calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, *calls)
+	}
+	for i := range expected {
+		if (*calls)[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, *calls)
+		}
+	}
+	*calls = nil
+}
+
+func TestRegisterInspector(t *testing.T) {
+	calls := new([]string)
+	registerTestInspectors(t,
+		&testInspector{name: "first", inspect: true, calls: calls},
+		&testInspector{name: "second", inspect: false, calls: calls},
+		&testInspector{name: "third", inspect: true, calls: calls},
+	)
+
+	err := RegisterInspector(&testInspector{name: "second", calls: calls})
+	if !errors.Is(err, ErrInspectorAlreadyRegistered) {
+		t.Errorf("expected duplicate registration to fail, got %v", err)
+	}
+
+	// Inspectors are started and run in the order of their registration.
+	conn := newTestConnection()
+	if !StartInspection(conn, 1000, 10) {
+		t.Fatal("expected inspection to start")
+	}
+	assertCalls(t, calls, "start first", "start second", "start third")
+
+	if _, cont := RunInspectors(conn, newTestPacket("data")); !cont {
+		t.Fatal("expected inspection to continue")
+	}
+	assertCalls(t, calls, "inspect first", "inspect third")
+
+	// No inspection without interested inspectors.
+	registerTestInspectors(t,
+		&testInspector{name: "uninterested", inspect: false, calls: calls},
+	)
+	conn = newTestConnection()
+	if StartInspection(conn, 1000, 10) || conn.Inspecting {
+		t.Error("expected inspection not to start")
+	}
+}
+
+func TestInspectionActions(t *testing.T) {
+	calls := new([]string)
+
+	t.Run("continue", func(t *testing.T) {
+		registerTestInspectors(t,
+			&testInspector{name: "a", inspect: true, calls: calls},
+		)
+		conn := newTestConnection()
+		StartInspection(conn, 1000, 10)
+
+		verdict, cont := RunInspectors(conn, newTestPacket("data"))
+		if verdict != network.VerdictUndecided || !cont || !conn.Inspecting {
+			t.Errorf("expected undecided packet and continued inspection, got %s, %v", verdict, cont)
+		}
+		if conn.Verdict != network.VerdictAccept {
+			t.Errorf("connection verdict changed to %s", conn.Verdict)
+		}
+		*calls = nil
+	})
+
+	t.Run("block packet", func(t *testing.T) {
+		registerTestInspectors(t,
+			&testInspector{name: "a", inspect: true, actions: []Action{ActionBlockPacket}, calls: calls},
+			&testInspector{name: "b", inspect: true, calls: calls},
+		)
+		conn := newTestConnection()
+		StartInspection(conn, 1000, 10)
+		*calls = nil
+
+		verdict, cont := RunInspectors(conn, newTestPacket("data"))
+		if verdict != network.VerdictBlock || !cont {
+			t.Errorf("expected blocked packet and continued inspection, got %s, %v", verdict, cont)
+		}
+		if conn.Verdict != network.VerdictAccept {
+			t.Errorf("connection verdict changed to %s", conn.Verdict)
+		}
+		assertCalls(t, calls, "inspect a", "inspect b")
+	})
+
+	t.Run("block connection", func(t *testing.T) {
+		registerTestInspectors(t,
+			&testInspector{name: "a", inspect: true, actions: []Action{ActionBlockConnection}, calls: calls},
+			&testInspector{name: "b", inspect: true, calls: calls},
+		)
+		conn := newTestConnection()
+		StartInspection(conn, 1000, 10)
+		*calls = nil
+
+		verdict, cont := RunInspectors(conn, newTestPacket("data"))
+		if verdict != network.VerdictBlock || cont {
+			t.Errorf("expected blocked connection and stopped inspection, got %s, %v", verdict, cont)
+		}
+		if conn.Verdict != network.VerdictBlock || conn.Reason.Msg != "a" {
+			t.Errorf("expected connection to be blocked by a, got %s: %s", conn.Verdict, conn.Reason.Msg)
+		}
+		if conn.Inspecting || conn.GetInspectionState() != nil {
+			t.Error("expected inspection to be stopped")
+		}
+		// Following inspectors are not run anymore.
+		assertCalls(t, calls, "inspect a")
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		registerTestInspectors(t,
+			&testInspector{name: "a", inspect: true, actions: []Action{ActionStop}, calls: calls},
+			&testInspector{name: "b", inspect: true, actions: []Action{ActionContinue, ActionStop}, calls: calls},
+		)
+		conn := newTestConnection()
+		StartInspection(conn, 1000, 10)
+		*calls = nil
+
+		if _, cont := RunInspectors(conn, newTestPacket("data")); !cont {
+			t.Fatal("expected inspection to continue while b is active")
+		}
+		assertCalls(t, calls, "inspect a", "inspect b")
+
+		verdict, cont := RunInspectors(conn, newTestPacket("more data"))
+		if verdict != network.VerdictUndecided || cont {
+			t.Errorf("expected inspection to stop after all inspectors finished, got %s, %v", verdict, cont)
+		}
+		assertCalls(t, calls, "inspect b")
+		if conn.Inspecting {
+			t.Error("expected inspection to be stopped")
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		registerTestInspectors(t,
+			&testInspector{name: "a", inspect: true, calls: calls},
+		)
+		conn := newTestConnection()
+		StartInspection(conn, 1000, 2)
+
+		if _, cont := RunInspectors(conn, newTestPacket("data")); !cont {
+			t.Fatal("expected inspection to continue")
+		}
+		if _, cont := RunInspectors(conn, newTestPacket("data")); cont {
+			t.Error("expected inspection to stop after the packet budget")
+		}
+		*calls = nil
+	})
+}
+
+func TestInspectorRemovalIsPerConnection(t *testing.T) {
+	calls := new([]string)
+	registerTestInspectors(t,
+		&testInspector{name: "a", inspect: true, actions: []Action{ActionStop}, calls: calls},
+		&testInspector{name: "b", inspect: true, calls: calls},
+	)
+
+	first := newTestConnection()
+	second := newTestConnection()
+	StartInspection(first, 1000, 10)
+	StartInspection(second, 1000, 10)
+	*calls = nil
+
+	// Stop a on the first connection.
+	RunInspectors(first, newTestPacket("data"))
+	assertCalls(t, calls, "inspect a", "inspect b")
+	RunInspectors(first, newTestPacket("data"))
+	assertCalls(t, calls, "inspect b")
+
+	// a is still active on the second connection.
+	RunInspectors(second, newTestPacket("data"))
+	assertCalls(t, calls, "inspect a", "inspect b")
+}
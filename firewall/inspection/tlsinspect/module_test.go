@@ -0,0 +1,12 @@
+package tlsinspect
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/core/pmtesting"
+	"github.com/safing/portmaster/intel"
+)
+
+func TestMain(m *testing.M) {
+	pmtesting.TestMain(m, intel.Module)
+}
@@ -0,0 +1,346 @@
+package tlsinspect
+
+import (
+	"crypto/md5" //nolint:gosec // JA3 is defined to use MD5.
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordTypeHandshake = 22
+	recordHeaderSize    = 5
+
+	handshakeTypeClientHello = 1
+	handshakeTypeServerHello = 2
+	handshakeTypeCertificate = 11
+	handshakeHeaderSize      = 4
+
+	extensionServerName        = 0
+	extensionSupportedGroups   = 10
+	extensionECPointFormats    = 11
+	extensionALPN              = 16
+	extensionSupportedVersions = 43
+
+	serverNameTypeHostName = 0
+
+	tlsVersion13 = 0x0304
+
+	maxHandshakeBytesToBuffer = 65536
+)
+
+var (
+	// errIncomplete is returned when more data is needed to parse a message.
+	errIncomplete = errors.New("incomplete message")
+	// errNotTLS is returned when the data does not look like TLS.
+	errNotTLS = errors.New("not a tls handshake")
+)
+
+// clientHello holds the parsed parts of a TLS ClientHello.
+type clientHello struct {
+	Version          uint16
+	CipherSuites     []uint16
+	Extensions       []uint16
+	SupportedGroups  []uint16
+	ECPointFormats   []uint8
+	SupportedVersion []uint16
+	ServerName       string
+	ALPN             []string
+}
+
+// serverHello holds the parsed parts of a TLS ServerHello and the first
+// server certificate, if available.
+type serverHello struct {
+	Version     uint16
+	CipherSuite uint16
+	Certificate []byte
+}
+
+// readHandshakeMessages extracts the handshake messages from the given TLS
+// records. It returns the messages that could be fully read and whether the
+// handshake records are followed by another record type.
+func readHandshakeMessages(data []byte) (messages [][]byte, ended bool, err error) {
+	// Join the payloads of all handshake records.
+	var handshake []byte
+	for len(data) > 0 {
+		if len(data) < recordHeaderSize {
+			break
+		}
+		if data[0] != recordTypeHandshake {
+			if len(handshake) == 0 {
+				return nil, false, errNotTLS
+			}
+			// Handshake is followed by other records, eg. ChangeCipherSpec.
+			ended = true
+			break
+		}
+		if data[1] != 3 {
+			return nil, false, errNotTLS
+		}
+
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < recordHeaderSize+length {
+			break
+		}
+		handshake = append(handshake, data[recordHeaderSize:recordHeaderSize+length]...)
+		data = data[recordHeaderSize+length:]
+	}
+
+	// Split handshake messages.
+	for len(handshake) >= handshakeHeaderSize {
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if length > maxHandshakeBytesToBuffer {
+			return nil, false, errNotTLS
+		}
+		if len(handshake) < handshakeHeaderSize+length {
+			break
+		}
+		messages = append(messages, handshake[:handshakeHeaderSize+length])
+		handshake = handshake[handshakeHeaderSize+length:]
+	}
+
+	return messages, ended, nil
+}
+
+// parseClientHello parses the ClientHello at the start of the given data.
+func parseClientHello(data []byte) (*clientHello, error) {
+	messages, _, err := readHandshakeMessages(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		if len(data) > maxHandshakeBytesToBuffer {
+			return nil, errNotTLS
+		}
+		return nil, errIncomplete
+	}
+	if messages[0][0] != handshakeTypeClientHello {
+		return nil, errNotTLS
+	}
+
+	r := &reader{data: messages[0][handshakeHeaderSize:]}
+	hello := &clientHello{}
+
+	hello.Version = r.uint16()
+	r.skip(32) // Random
+	r.skip(int(r.uint8()))
+	cipherSuites := r.bytes(int(r.uint16()))
+	for i := 0; i+1 < len(cipherSuites); i += 2 {
+		hello.CipherSuites = append(hello.CipherSuites, binary.BigEndian.Uint16(cipherSuites[i:]))
+	}
+	r.skip(int(r.uint8())) // Compression methods
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: malformed client hello", errNotTLS)
+	}
+
+	// Extensions are optional.
+	if r.empty() {
+		return hello, nil
+	}
+	extensions := &reader{data: r.bytes(int(r.uint16()))}
+	for !extensions.empty() && extensions.err == nil {
+		extType := extensions.uint16()
+		ext := &reader{data: extensions.bytes(int(extensions.uint16()))}
+		hello.Extensions = append(hello.Extensions, extType)
+
+		switch extType {
+		case extensionServerName:
+			names := &reader{data: ext.bytes(int(ext.uint16()))}
+			for !names.empty() && names.err == nil {
+				nameType := names.uint8()
+				name := names.bytes(int(names.uint16()))
+				if nameType == serverNameTypeHostName && hello.ServerName == "" {
+					hello.ServerName = string(name)
+				}
+			}
+		case extensionSupportedGroups:
+			groups := ext.bytes(int(ext.uint16()))
+			for i := 0; i+1 < len(groups); i += 2 {
+				hello.SupportedGroups = append(hello.SupportedGroups, binary.BigEndian.Uint16(groups[i:]))
+			}
+		case extensionECPointFormats:
+			hello.ECPointFormats = append(hello.ECPointFormats, ext.bytes(int(ext.uint8()))...)
+		case extensionALPN:
+			protocols := &reader{data: ext.bytes(int(ext.uint16()))}
+			for !protocols.empty() && protocols.err == nil {
+				hello.ALPN = append(hello.ALPN, string(protocols.bytes(int(protocols.uint8()))))
+			}
+		case extensionSupportedVersions:
+			versions := ext.bytes(int(ext.uint8()))
+			for i := 0; i+1 < len(versions); i += 2 {
+				hello.SupportedVersion = append(hello.SupportedVersion, binary.BigEndian.Uint16(versions[i:]))
+			}
+		}
+	}
+	if extensions.err != nil {
+		return nil, fmt.Errorf("%w: malformed client hello extensions", errNotTLS)
+	}
+
+	return hello, nil
+}
+
+// parseServerHello parses the ServerHello and, for TLS versions below 1.3,
+// the Certificate message at the start of the given data.
+func parseServerHello(data []byte) (*serverHello, error) {
+	messages, ended, err := readHandshakeMessages(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		if len(data) > maxHandshakeBytesToBuffer {
+			return nil, errNotTLS
+		}
+		return nil, errIncomplete
+	}
+	if messages[0][0] != handshakeTypeServerHello {
+		return nil, errNotTLS
+	}
+
+	r := &reader{data: messages[0][handshakeHeaderSize:]}
+	hello := &serverHello{}
+
+	hello.Version = r.uint16()
+	r.skip(32) // Random
+	r.skip(int(r.uint8()))
+	hello.CipherSuite = r.uint16()
+	r.skip(1) // Compression method
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: malformed server hello", errNotTLS)
+	}
+	if !r.empty() {
+		extensions := &reader{data: r.bytes(int(r.uint16()))}
+		for !extensions.empty() && extensions.err == nil {
+			extType := extensions.uint16()
+			ext := &reader{data: extensions.bytes(int(extensions.uint16()))}
+			if extType == extensionSupportedVersions {
+				hello.Version = ext.uint16()
+			}
+		}
+	}
+
+	// The certificate is encrypted in TLS 1.3.
+	if hello.Version >= tlsVersion13 {
+		return hello, nil
+	}
+
+	// Find the certificate message.
+	for _, msg := range messages[1:] {
+		if msg[0] != handshakeTypeCertificate {
+			continue
+		}
+
+		certs := &reader{data: msg[handshakeHeaderSize:]}
+		certs.skip(3) // Length of all certificates.
+		hello.Certificate = certs.bytes(certs.uint24())
+		if certs.err != nil {
+			return nil, fmt.Errorf("%w: malformed certificate message", errNotTLS)
+		}
+		return hello, nil
+	}
+
+	// The certificate directly follows the server hello, unless the session is
+	// resumed.
+	if ended || len(messages) > 1 {
+		return hello, nil
+	}
+	return nil, errIncomplete
+}
+
+// isGREASE returns whether the given value is a GREASE value as defined in
+// RFC8701. GREASE values are ignored in the JA3 fingerprint.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// ja3 returns the JA3 fingerprint and its MD5 hash.
+func (hello *clientHello) ja3() (fingerprint, hash string) {
+	joinUint16 := func(values []uint16) string {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			if !isGREASE(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+	pointFormats := make([]string, 0, len(hello.ECPointFormats))
+	for _, v := range hello.ECPointFormats {
+		pointFormats = append(pointFormats, strconv.Itoa(int(v)))
+	}
+
+	fingerprint = strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		joinUint16(hello.CipherSuites),
+		joinUint16(hello.Extensions),
+		joinUint16(hello.SupportedGroups),
+		strings.Join(pointFormats, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(fingerprint)) //nolint:gosec // JA3 is defined to use MD5.
+	return fingerprint, hex.EncodeToString(sum[:])
+}
+
+// maxVersion returns the highest TLS version offered by the client.
+func (hello *clientHello) maxVersion() uint16 {
+	version := hello.Version
+	for _, v := range hello.SupportedVersion {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+// reader is a simple reader for length prefixed data. It records the first
+// error and returns zero values from then on.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = errIncomplete
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) skip(n int) {
+	_ = r.bytes(n)
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
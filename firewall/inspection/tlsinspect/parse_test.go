@@ -0,0 +1,78 @@
+package tlsinspect
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func captureClientHello(t *testing.T, config *tls.Config) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	go func() {
+		_ = tls.Client(client, config).Handshake()
+	}()
+
+	buf := make([]byte, 4096)
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read client hello: %s", err)
+	}
+	return buf[:n]
+}
+
+func TestParseClientHello(t *testing.T) {
+	t.Parallel()
+
+	data := captureClientHello(t, &tls.Config{
+		ServerName: "Example.com",
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	})
+
+	hello, err := parseClientHello(data)
+	if err != nil {
+		t.Fatalf("failed to parse client hello: %s", err)
+	}
+	if hello.ServerName != "Example.com" {
+		t.Errorf("unexpected server name %q", hello.ServerName)
+	}
+	if len(hello.ALPN) != 2 || hello.ALPN[0] != "h2" || hello.ALPN[1] != "http/1.1" {
+		t.Errorf("unexpected alpn %v", hello.ALPN)
+	}
+	if hello.maxVersion() != tls.VersionTLS13 {
+		t.Errorf("unexpected max version %x", hello.maxVersion())
+	}
+	fingerprint, hash := hello.ja3()
+	if fingerprint == "" || len(hash) != 32 {
+		t.Errorf("unexpected ja3 %q (%s)", fingerprint, hash)
+	}
+
+	// Check incomplete and invalid data.
+	if _, err := parseClientHello(data[:len(data)-1]); !errors.Is(err, errIncomplete) {
+		t.Errorf("expected incomplete error, got %v", err)
+	}
+	if _, err := parseClientHello([]byte("GET / HTTP/1.1\r\n\r\n")); !errors.Is(err, errNotTLS) {
+		t.Errorf("expected not tls error, got %v", err)
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	t.Parallel()
+
+	if !isGREASE(0x0a0a) || !isGREASE(0xfafa) {
+		t.Error("GREASE values not detected")
+	}
+	if isGREASE(0x0a1a) || isGREASE(tls.VersionTLS13) {
+		t.Error("non-GREASE values detected as GREASE")
+	}
+}
@@ -0,0 +1,189 @@
+package tlsinspect
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Inspector extracts metadata from TLS handshakes and applies the endpoint
+// lists of the profile to the requested server name.
+type Inspector struct{}
+
+type inspectorState struct {
+	clientHelloDone bool
+}
+
+// New returns a new TLS inspector.
+func New() *Inspector {
+	return &Inspector{}
+}
+
+// Name returns the name of the inspector.
+func (i *Inspector) Name() string {
+	return "tls"
+}
+
+// Start starts the inspection of outgoing TCP connections.
+func (i *Inspector) Start(conn *network.Connection) (state interface{}, inspect bool) {
+	if conn.Type != network.IPConnection ||
+		conn.IPProtocol != packet.TCP ||
+		conn.Inbound {
+		return nil, false
+	}
+
+	return &inspectorState{}, true
+}
+
+// Inspect parses the TLS ClientHello and ServerHello of the connection.
+func (i *Inspector) Inspect(ctx context.Context, conn *network.Connection, stream *inspection.Stream, inbound bool, state interface{}) (action inspection.Action, reason string) {
+	s, ok := state.(*inspectorState)
+	if !ok {
+		return inspection.ActionStop, ""
+	}
+
+	// The client always speaks first.
+	if !s.clientHelloDone {
+		if inbound {
+			return inspection.ActionStop, ""
+		}
+
+		hello, err := parseClientHello(stream.Outbound.Cumulated)
+		switch {
+		case errors.Is(err, errIncomplete):
+			return inspection.ActionContinue, ""
+		case err != nil:
+			log.Tracer(ctx).Tracef("tlsinspect: stopping: %s", err)
+			return inspection.ActionStop, ""
+		}
+		s.clientHelloDone = true
+
+		ja3, ja3Hash := hello.ja3()
+		conn.TLS = &network.TLSContext{
+			Version: versionName(hello.maxVersion()),
+			SNI:     strings.ToLower(hello.ServerName),
+			ALPN:    hello.ALPN,
+			JA3:     ja3,
+			JA3Hash: ja3Hash,
+		}
+		conn.SaveWhenFinished()
+		log.Tracer(ctx).Debugf("tlsinspect: %s requested %q with JA3 %s", conn, conn.TLS.SNI, ja3Hash)
+
+		if conn.TLS.SNI != "" {
+			if action, reason := checkServerName(ctx, conn); action != inspection.ActionContinue {
+				return action, reason
+			}
+		}
+		return inspection.ActionContinue, ""
+	}
+
+	// Wait for the server response.
+	if !inbound {
+		return inspection.ActionContinue, ""
+	}
+	hello, err := parseServerHello(stream.Inbound.Cumulated)
+	switch {
+	case errors.Is(err, errIncomplete):
+		return inspection.ActionContinue, ""
+	case err != nil:
+		log.Tracer(ctx).Tracef("tlsinspect: stopping: %s", err)
+		return inspection.ActionStop, ""
+	}
+
+	conn.TLS.Version = versionName(hello.Version)
+	conn.TLS.CipherSuite = tls.CipherSuiteName(hello.CipherSuite)
+	if hello.Certificate != nil {
+		cert, err := x509.ParseCertificate(hello.Certificate)
+		if err != nil {
+			log.Tracer(ctx).Debugf("tlsinspect: failed to parse server certificate of %s: %s", conn, err)
+		} else {
+			conn.TLS.ServerCertSubject = cert.Subject.String()
+			conn.TLS.ServerCertIssuer = cert.Issuer.String()
+			conn.TLS.ServerCertDNSNames = cert.DNSNames
+		}
+	}
+	conn.SaveWhenFinished()
+
+	return inspection.ActionStop, ""
+}
+
+// checkServerName applies the endpoint and filter lists of the profile to the
+// server name requested in the ClientHello. The server name is supplied by
+// the client, so it is checked separately from the entity of the connection
+// and can only cause the connection to be blocked.
+func checkServerName(ctx context.Context, conn *network.Connection) (action inspection.Action, reason string) {
+	// Ignore IP addresses, they were already checked.
+	if net.ParseIP(conn.TLS.SNI) != nil {
+		return inspection.ActionContinue, ""
+	}
+	sni := dns.Fqdn(conn.TLS.SNI)
+	if _, ok := dns.IsDomainName(sni); !ok {
+		return inspection.ActionContinue, ""
+	}
+
+	// The resolved domain was already checked when deciding on the
+	// connection.
+	if sni == conn.Entity.Domain {
+		return inspection.ActionContinue, ""
+	}
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return inspection.ActionContinue, ""
+	}
+	entity := &intel.Entity{
+		Protocol: conn.Entity.Protocol,
+		Port:     conn.Entity.Port,
+		Domain:   sni,
+	}
+	entity.SetIP(conn.Entity.IP)
+	entity.SetDstPort(conn.Entity.DstPort())
+
+	layeredProfile.LockForUsage()
+	defer layeredProfile.UnlockForUsage()
+
+	// Check the server name against the endpoint lists first and then
+	// against the filter lists, like the connection itself.
+	result, epReason := layeredProfile.MatchEndpoint(ctx, entity)
+	optionKey := profile.CfgOptionEndpointsKey
+	if !endpoints.IsDecision(result) {
+		result, epReason = layeredProfile.MatchFilterLists(ctx, entity)
+		optionKey = profile.CfgOptionFilterListsKey
+	}
+
+	if result == endpoints.Denied {
+		reason = fmt.Sprintf("tls server name %s: %s", conn.TLS.SNI, epReason.String())
+		conn.BlockWithContext(reason, optionKey, epReason.Context())
+		return inspection.ActionBlockConnection, reason
+	}
+	return inspection.ActionContinue, ""
+}
+
+func versionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("unknown (0x%04x)", version)
+	}
+}
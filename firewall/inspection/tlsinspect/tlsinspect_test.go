@@ -0,0 +1,89 @@
+package tlsinspect
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
+)
+
+func TestCheckServerName(t *testing.T) {
+	localProfile := profile.New(profile.SourceLocal, "tlsinspect-test", "", map[string]interface{}{
+		profile.CfgOptionEndpointsKey: []string{
+			"- blocked.example.com",
+			"+ .example.com",
+		},
+	})
+
+	for _, test := range []struct {
+		name    string
+		domain  string
+		sni     string
+		blocked bool
+	}{
+		{
+			name:    "blocked server name",
+			domain:  "www.example.com.",
+			sni:     "blocked.example.com",
+			blocked: true,
+		},
+		{
+			name:    "blocked server name without resolved domain",
+			sni:     "blocked.example.com",
+			blocked: true,
+		},
+		{
+			name:   "resolved domain was already checked",
+			domain: "blocked.example.com.",
+			sni:    "blocked.example.com",
+		},
+		{
+			name:   "permitted server name",
+			domain: "blocked.example.com.",
+			sni:    "www.example.com",
+		},
+		{
+			name: "unknown server name",
+			sni:  "www.example.net",
+		},
+		{
+			name:   "IP address",
+			domain: "www.example.com.",
+			sni:    "192.0.2.1",
+		},
+	} {
+		entity := &intel.Entity{
+			Protocol: uint8(packet.TCP),
+			Port:     443,
+			Domain:   test.domain,
+		}
+		entity.SetIP(net.ParseIP("192.0.2.1"))
+		entity.SetDstPort(443)
+
+		layeredProfile := profile.NewTemporaryLayeredProfile(localProfile)
+		conn := network.NewHypotheticalConnection(context.Background(), process.NewVirtualProcess(layeredProfile), entity, false)
+		conn.TLS = &network.TLSContext{
+			SNI: test.sni,
+		}
+
+		action, reason := checkServerName(context.Background(), conn)
+		switch {
+		case test.blocked && (action != inspection.ActionBlockConnection || conn.Verdict != network.VerdictBlock):
+			t.Errorf("%s: expected connection to be blocked, got action %d and verdict %s", test.name, action, conn.Verdict)
+		case !test.blocked && action != inspection.ActionContinue:
+			t.Errorf("%s: expected inspection to continue, got action %d: %s", test.name, action, reason)
+		}
+
+		// The server name is supplied by the client and must not be used as
+		// the domain of the connection.
+		if conn.Entity.Domain != test.domain {
+			t.Errorf("%s: domain of connection changed to %q", test.name, conn.Entity.Domain)
+		}
+	}
+}
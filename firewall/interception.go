@@ -53,6 +53,10 @@ func init() {
 }
 
 func interceptionPrep() error {
+	if err := interception.Prep(); err != nil {
+		return err
+	}
+
 	return prepAPIAuth()
 }
 
@@ -347,7 +351,6 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 
 	log.Tracer(pkt.Ctx()).Trace("filter: starting decision process")
 	DecideOnConnection(pkt.Ctx(), conn, pkt)
-	conn.Inspecting = false // TODO: enable inspecting again
 
 	// tunneling
 	// TODO: add implementation for forced tunneling
@@ -365,6 +368,11 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		}
 	}
 
+	// Inspect allowed connections, if enabled.
+	if enableInspection() && conn.Verdict == network.VerdictAccept {
+		inspection.StartInspection(conn, int(inspectionMaxBytes()), int(inspectionMaxPackets()))
+	}
+
 	switch {
 	case conn.Inspecting:
 		log.Tracer(pkt.Ctx()).Trace("filter: start inspecting")
@@ -0,0 +1,139 @@
+package interception
+
+import (
+	"github.com/safing/portbase/config"
+)
+
+// Interception backends.
+const (
+	BackendAuto     = "auto"
+	BackendIPTables = "iptables"
+	BackendNftables = "nftables"
+)
+
+// Queue groups. Packets of every direction and IP version are balanced over
+// their own group of queues.
+const (
+	QueueGroupOutboundIPv4 = "outbound-ipv4"
+	QueueGroupInboundIPv4  = "inbound-ipv4"
+	QueueGroupOutboundIPv6 = "outbound-ipv6"
+	QueueGroupInboundIPv6  = "inbound-ipv6"
+)
+
+// Configuration Keys.
+var (
+	CfgOptionInterceptionBackendKey   = "filter/interceptionBackend"
+	cfgOptionInterceptionBackendOrder = 95
+	interceptionBackend               config.StringOption
+
+	CfgOptionInterceptionQueuesKey   = "filter/interceptionQueues"
+	cfgOptionInterceptionQueuesOrder = 100
+	interceptionQueues               config.IntOption
+
+	CfgOptionInterceptionFailClosedQueuesKey   = "filter/interceptionFailClosedQueues"
+	cfgOptionInterceptionFailClosedQueuesOrder = 101
+	interceptionFailClosedQueues               config.StringArrayOption
+)
+
+func registerConfig() error {
+	err := config.Register(&config.Option{
+		Name:        "Packet Interception Backend",
+		Key:         CfgOptionInterceptionBackendKey,
+		Description: "Defines how the Portmaster installs the firewall rules that send packets to it for inspection. Both backends use the same packet marks and queues.",
+		OptType:     config.OptTypeString,
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Automatic",
+				Value:       BackendAuto,
+				Description: "Use nftables if iptables is not available or is only a compatibility layer on top of nftables, else use iptables.",
+			},
+			{
+				Name:        "iptables",
+				Value:       BackendIPTables,
+				Description: "Add rules to the default iptables and ip6tables chains.",
+			},
+			{
+				Name:        "nftables",
+				Value:       BackendNftables,
+				Description: "Use dedicated nftables tables, which do not interfere with other firewall managers, such as firewalld.",
+			},
+		},
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    BackendAuto,
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionInterceptionBackendOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	interceptionBackend = config.Concurrent.GetAsString(CfgOptionInterceptionBackendKey, BackendAuto)
+
+	err = config.Register(&config.Option{
+		Name:            "Packet Interception Queues",
+		Key:             CfgOptionInterceptionQueuesKey,
+		Description:     "Defines how many queues are used for each direction and IP version. Packets are balanced over the queues by connection, so that every queue can be handled on a different CPU core. Increase this up to the number of CPU cores if packet handling is slow under high load.",
+		OptType:         config.OptTypeInt,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    1,
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionInterceptionQueuesOrder,
+			config.UnitAnnotation:         "queues",
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^([1-9]|1[0-6])$`,
+	})
+	if err != nil {
+		return err
+	}
+	interceptionQueues = config.Concurrent.GetAsInt(CfgOptionInterceptionQueuesKey, 1)
+
+	err = config.Register(&config.Option{
+		Name:        "Fail Closed Packet Interception Queues",
+		Key:         CfgOptionInterceptionFailClosedQueuesKey,
+		Description: "Defines which queues drop packets that cannot be inspected, because the Portmaster is not running, the queue is full or no verdict was found in time. All other queues accept these packets, so that network connectivity is kept, but packets may pass without being filtered. When failing closed, no packet passes without being filtered, but all network connectivity of the queue is lost if the Portmaster is not running.",
+		OptType:     config.OptTypeStringArray,
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Outbound IPv4",
+				Value:       QueueGroupOutboundIPv4,
+				Description: "Drop outgoing IPv4 packets that cannot be inspected.",
+			},
+			{
+				Name:        "Inbound IPv4",
+				Value:       QueueGroupInboundIPv4,
+				Description: "Drop incoming IPv4 packets that cannot be inspected.",
+			},
+			{
+				Name:        "Outbound IPv6",
+				Value:       QueueGroupOutboundIPv6,
+				Description: "Drop outgoing IPv6 packets that cannot be inspected.",
+			},
+			{
+				Name:        "Inbound IPv6",
+				Value:       QueueGroupInboundIPv6,
+				Description: "Drop incoming IPv6 packets that cannot be inspected.",
+			},
+		},
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    []string{},
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionInterceptionFailClosedQueuesOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	interceptionFailClosedQueues = config.Concurrent.GetAsStringArray(CfgOptionInterceptionFailClosedQueuesKey, []string{})
+
+	return nil
+}
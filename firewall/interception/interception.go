@@ -18,6 +18,11 @@ func init() {
 	flag.BoolVar(&disableInterception, "disable-interception", false, "disable packet interception; this breaks a lot of functionality")
 }
 
+// Prep prepares the interception.
+func Prep() error {
+	return prep()
+}
+
 // Start starts the interception.
 func Start() error {
 	if disableInterception {
@@ -7,6 +7,11 @@
 	"github.com/safing/portmaster/network/packet"
 )
 
+// prep prepares the interception.
+func prep() error {
+	return nil
+}
+
 // start starts the interception.
 func start(_ chan packet.Packet) error {
 	log.Critical("interception: this platform has no support for packet interception - a lot of functionality will be broken")
@@ -2,6 +2,11 @@
 
 import "github.com/safing/portmaster/network/packet"
 
+// prep prepares the interception.
+func prep() error {
+	return registerConfig()
+}
+
 // start starts the interception.
 func start(ch chan packet.Packet) error {
 	return StartNfqueueInterception(ch)
@@ -8,6 +8,11 @@
 	"github.com/safing/portmaster/updates"
 )
 
+// prep prepares the interception.
+func prep() error {
+	return nil
+}
+
 // start starts the interception.
 func start(ch chan packet.Packet) error {
 	dllFile, err := updates.GetPlatformFile("kext/portmaster-kext.dll")
@@ -4,10 +4,14 @@
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/log"
+	portmetrics "github.com/safing/portbase/metrics"
 )
 
 var (
@@ -76,3 +80,99 @@ func (pm *packetMetrics) writeMetrics() {
 		}
 	}
 }
+
+// statsQueue is a packet queue that provides statistics.
+type statsQueue interface {
+	ID() uint16
+	Backlog() int
+	PacketsReceived() uint64
+	PacketsFailed() uint64
+	Verdicts() uint64
+	VerdictLatency() time.Duration
+}
+
+var (
+	// metricsQueues holds the active queues by queue ID. The queue metrics
+	// report the statistics of the queue that is currently active with their
+	// queue ID, as they can only be registered once.
+	metricsQueues     = make(map[uint16]statsQueue)
+	metricsQueuesLock sync.RWMutex
+
+	registerQueueMetricsOnce sync.Once
+)
+
+// setMetricsQueues sets the queues that the queue metrics report on.
+func setMetricsQueues(queues map[uint16]statsQueue) {
+	metricsQueuesLock.Lock()
+	defer metricsQueuesLock.Unlock()
+
+	metricsQueues = queues
+}
+
+// queueMetric returns a gauge function that reports the statistic of the
+// active queue with the given ID. It reports zero if the queue is not active.
+func queueMetric(qid uint16, stat func(q statsQueue) float64) func() float64 {
+	return func() float64 {
+		metricsQueuesLock.RLock()
+		q, ok := metricsQueues[qid]
+		metricsQueuesLock.RUnlock()
+
+		if !ok {
+			return 0
+		}
+		return stat(q)
+	}
+}
+
+// registerQueueMetrics registers the backlog and latency metrics of the queue
+// with the given ID. The average verdict latency can be calculated from the
+// total latency and the number of verdicts.
+func registerQueueMetrics(qid uint16, inbound, v6 bool) error {
+	labels := map[string]string{
+		"queue":     strconv.Itoa(int(qid)),
+		"direction": "out",
+		"ip":        "4",
+	}
+	if inbound {
+		labels["direction"] = "in"
+	}
+	if v6 {
+		labels["ip"] = "6"
+	}
+	opts := &portmetrics.Options{
+		Permission:     api.PermitUser,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+	}
+
+	for _, gauge := range []struct {
+		id   string
+		stat func(q statsQueue) float64
+	}{
+		{
+			id:   "firewall/interception/queue/backlog",
+			stat: func(q statsQueue) float64 { return float64(q.Backlog()) },
+		},
+		{
+			id:   "firewall/interception/queue/packets/total",
+			stat: func(q statsQueue) float64 { return float64(q.PacketsReceived()) },
+		},
+		{
+			id:   "firewall/interception/queue/failed/total",
+			stat: func(q statsQueue) float64 { return float64(q.PacketsFailed()) },
+		},
+		{
+			id:   "firewall/interception/queue/verdicts/total",
+			stat: func(q statsQueue) float64 { return float64(q.Verdicts()) },
+		},
+		{
+			id:   "firewall/interception/queue/latency/seconds/total",
+			stat: func(q statsQueue) float64 { return q.VerdictLatency().Seconds() },
+		},
+	} {
+		if _, err := portmetrics.NewGauge(gauge.id, labels, queueMetric(qid, gauge.stat), opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
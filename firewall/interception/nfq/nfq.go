@@ -18,10 +18,29 @@
 	"github.com/florianl/go-nfqueue"
 )
 
+// verdictTimeout is the time after which packets without a verdict receive the
+// default verdict of their queue.
+var verdictTimeout = 20 * time.Second
+
+// nfqueueConn is the netlink connection of a queue. It is implemented by
+// *nfqueue.Nfqueue.
+type nfqueueConn interface {
+	SetVerdictWithMark(id uint32, verdict, mark int) error
+	Close() error
+}
+
 // Queue wraps a nfqueue
 type Queue struct {
+	// Statistics. Must be at the start of the struct for the 64-bit alignment
+	// required by atomic operations.
+	packetsReceived uint64
+	packetsFailed   uint64
+	verdicts        uint64
+	verdictLatency  uint64
+
 	id                   uint16
 	afFamily             uint8
+	failOpen             bool
 	nf                   atomic.Value
 	packets              chan pmpacket.Packet
 	cancelSocketCallback context.CancelFunc
@@ -31,12 +50,15 @@ type Queue struct {
 	verdictCompleted chan struct{}
 }
 
-func (q *Queue) getNfq() *nfqueue.Nfqueue {
-	return q.nf.Load().(*nfqueue.Nfqueue)
+func (q *Queue) getNfq() nfqueueConn {
+	nf, _ := q.nf.Load().(nfqueueConn)
+	return nf
 }
 
-// New opens a new nfQueue.
-func New(qid uint16, v6 bool) (*Queue, error) { //nolint:gocognit
+// New opens a new nfQueue. Packets that cannot be handled, for example
+// because the queue is full or no verdict was set in time, are dropped. If
+// failOpen is set, they are accepted instead.
+func New(qid uint16, v6 bool, failOpen bool) (*Queue, error) { //nolint:gocognit
 	afFamily := unix.AF_INET
 	if v6 {
 		afFamily = unix.AF_INET6
@@ -46,6 +68,7 @@ func New(qid uint16, v6 bool) (*Queue, error) { //nolint:gocognit
 	q := &Queue{
 		id:                   qid,
 		afFamily:             uint8(afFamily),
+		failOpen:             failOpen,
 		nf:                   atomic.Value{},
 		restart:              make(chan struct{}, 1),
 		packets:              make(chan pmpacket.Packet, 1000),
@@ -96,17 +119,7 @@ func New(qid uint16, v6 bool) (*Queue, error) { //nolint:gocognit
 // any other value or queue that might be stored in Queue.nf at
 // the time open is called.
 func (q *Queue) open(ctx context.Context) error {
-	cfg := &nfqueue.Config{
-		NfQueue:      q.id,
-		MaxPacketLen: 1600, // mtu is normally around 1500, make sure to capture it.
-		MaxQueueLen:  0xffff,
-		AfFamily:     q.afFamily,
-		Copymode:     nfqueue.NfQnlCopyPacket,
-		ReadTimeout:  1000 * time.Millisecond,
-		WriteTimeout: 1000 * time.Millisecond,
-	}
-
-	nf, err := nfqueue.Open(cfg)
+	nf, err := nfqueue.Open(q.config())
 	if err != nil {
 		return err
 	}
@@ -121,6 +134,25 @@ func (q *Queue) open(ctx context.Context) error {
 	return nil
 }
 
+// config returns the nfqueue configuration of the queue.
+func (q *Queue) config() *nfqueue.Config {
+	cfg := &nfqueue.Config{
+		NfQueue:      q.id,
+		MaxPacketLen: 1600, // mtu is normally around 1500, make sure to capture it.
+		MaxQueueLen:  0xffff,
+		AfFamily:     q.afFamily,
+		Copymode:     nfqueue.NfQnlCopyPacket,
+		ReadTimeout:  1000 * time.Millisecond,
+		WriteTimeout: 1000 * time.Millisecond,
+	}
+	if q.failOpen {
+		// Let the kernel accept packets when the queue is full. Without this
+		// flag, the kernel drops them.
+		cfg.Flags = nfqueue.NfQaCfgFlagFailOpen
+	}
+	return cfg
+}
+
 func (q *Queue) handleError(e error) int {
 	// embedded interface is required to work-around some
 	// dep-vendoring weirdness
@@ -163,6 +195,8 @@ func (q *Queue) handleError(e error) int {
 }
 
 func (q *Queue) packetHandler(ctx context.Context) func(nfqueue.Attribute) int {
+	timeout := verdictTimeout
+
 	return func(attrs nfqueue.Attribute) int {
 		if attrs.PacketID == nil {
 			// we need a packet id to set a verdict,
@@ -171,6 +205,7 @@ func (q *Queue) packetHandler(ctx context.Context) func(nfqueue.Attribute) int {
 			return 0
 		}
 
+		atomic.AddUint64(&q.packetsReceived, 1)
 		pkt := &packet{
 			pktID:          *attrs.PacketID,
 			queue:          q,
@@ -198,17 +233,17 @@ func (q *Queue) packetHandler(ctx context.Context) func(nfqueue.Attribute) int {
 			return 0
 		case <-time.After(time.Second):
 			log.Warningf("nfqueue: failed to queue packet (%s since it was handed over by the kernel)", time.Since(pkt.received))
+			q.fail(pkt)
+			return 0
 		}
 
 		go func() {
 			select {
 			case <-pkt.verdictSet:
 
-			case <-time.After(20 * time.Second):
-				log.Warningf("nfqueue: no verdict set for packet %s (%s -> %s) after %s, dropping", pkt.ID(), pkt.Info().Src, pkt.Info().Dst, time.Since(pkt.received))
-				if err := pkt.Drop(); err != nil {
-					log.Warningf("nfqueue: failed to apply default-drop to unveridcted packet %s (%s -> %s)", pkt.ID(), pkt.Info().Src, pkt.Info().Dst)
-				}
+			case <-time.After(timeout):
+				log.Warningf("nfqueue: no verdict set for packet %s (%s -> %s) after %s", pkt.ID(), pkt.Info().Src, pkt.Info().Dst, time.Since(pkt.received))
+				q.fail(pkt)
 			}
 		}()
 
@@ -216,6 +251,22 @@ func (q *Queue) packetHandler(ctx context.Context) func(nfqueue.Attribute) int {
 	}
 }
 
+// fail applies the default verdict to a packet that could not be handled.
+func (q *Queue) fail(pkt *packet) {
+	atomic.AddUint64(&q.packetsFailed, 1)
+
+	if q.failOpen {
+		if err := pkt.Accept(); err != nil {
+			log.Warningf("nfqueue: failed to apply default-accept to unverdicted packet %s (%s -> %s)", pkt.ID(), pkt.Info().Src, pkt.Info().Dst)
+		}
+		return
+	}
+
+	if err := pkt.Drop(); err != nil {
+		log.Warningf("nfqueue: failed to apply default-drop to unverdicted packet %s (%s -> %s)", pkt.ID(), pkt.Info().Src, pkt.Info().Dst)
+	}
+}
+
 // Destroy destroys the queue. Any error encountered is logged.
 func (q *Queue) Destroy() {
 	if q == nil {
@@ -235,3 +286,35 @@ func (q *Queue) Destroy() {
 func (q *Queue) PacketChannel() <-chan pmpacket.Packet {
 	return q.packets
 }
+
+// ID returns the queue number.
+func (q *Queue) ID() uint16 {
+	return q.id
+}
+
+// Backlog returns the number of packets waiting to be handled.
+func (q *Queue) Backlog() int {
+	return len(q.packets)
+}
+
+// PacketsReceived returns the number of packets received from the kernel.
+func (q *Queue) PacketsReceived() uint64 {
+	return atomic.LoadUint64(&q.packetsReceived)
+}
+
+// PacketsFailed returns the number of packets that could not be handled in
+// time and received the default verdict.
+func (q *Queue) PacketsFailed() uint64 {
+	return atomic.LoadUint64(&q.packetsFailed)
+}
+
+// Verdicts returns the number of verdicts set.
+func (q *Queue) Verdicts() uint64 {
+	return atomic.LoadUint64(&q.verdicts)
+}
+
+// VerdictLatency returns the total time between receiving packets and
+// setting their verdicts.
+func (q *Queue) VerdictLatency() time.Duration {
+	return time.Duration(atomic.LoadUint64(&q.verdictLatency))
+}
@@ -0,0 +1,180 @@
+// +build linux
+
+package nfq
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/florianl/go-nfqueue"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	pmpacket "github.com/safing/portmaster/network/packet"
+)
+
+// testConn records the verdicts of a queue.
+type testConn struct {
+	sync.Mutex
+	marks map[uint32]int
+	set   chan uint32
+}
+
+func newTestConn() *testConn {
+	return &testConn{
+		marks: make(map[uint32]int),
+		set:   make(chan uint32, 10),
+	}
+}
+
+func (tc *testConn) SetVerdictWithMark(id uint32, _, mark int) error {
+	tc.Lock()
+	defer tc.Unlock()
+
+	tc.marks[id] = mark
+	tc.set <- id
+	return nil
+}
+
+func (tc *testConn) Close() error {
+	return nil
+}
+
+func (tc *testConn) mark(t *testing.T, id uint32) int {
+	t.Helper()
+
+	select {
+	case setID := <-tc.set:
+		if setID != id {
+			t.Fatalf("expected verdict for packet %d, got %d", id, setID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no verdict set for packet %d", id)
+	}
+
+	tc.Lock()
+	defer tc.Unlock()
+	return tc.marks[id]
+}
+
+func newTestQueue(failOpen bool) (*Queue, *testConn) {
+	q := &Queue{
+		id:               17040,
+		failOpen:         failOpen,
+		packets:          make(chan pmpacket.Packet, 10),
+		verdictCompleted: make(chan struct{}, 1),
+	}
+	conn := newTestConn()
+	q.nf.Store(conn)
+	return q, conn
+}
+
+func newTestPayload(t *testing.T) *[]byte {
+	t.Helper()
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(10, 0, 0, 2),
+	}
+	udp := &layers.UDP{
+		SrcPort: 50000,
+		DstPort: 443,
+	}
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}, ip, udp, gopacket.Payload("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := buf.Bytes()
+	return &payload
+}
+
+func receivePacket(t *testing.T, q *Queue) pmpacket.Packet {
+	t.Helper()
+
+	select {
+	case pkt := <-q.PacketChannel():
+		return pkt
+	case <-time.After(time.Second):
+		t.Fatal("no packet queued")
+		return nil
+	}
+}
+
+func TestQueueConfig(t *testing.T) {
+	t.Parallel()
+
+	// The kernel must only accept packets of full queues if failing open.
+	if flags := (&Queue{failOpen: false}).config().Flags; flags&nfqueue.NfQaCfgFlagFailOpen != 0 {
+		t.Errorf("fail closed queue has the fail open flag set: %d", flags)
+	}
+	if flags := (&Queue{failOpen: true}).config().Flags; flags&nfqueue.NfQaCfgFlagFailOpen == 0 {
+		t.Errorf("fail open queue is missing the fail open flag: %d", flags)
+	}
+}
+
+func TestVerdictTimeout(t *testing.T) {
+	previous := verdictTimeout
+	verdictTimeout = 10 * time.Millisecond
+	t.Cleanup(func() {
+		verdictTimeout = previous
+	})
+
+	for _, test := range []struct {
+		failOpen bool
+		mark     int
+	}{
+		{failOpen: false, mark: MarkDrop},
+		{failOpen: true, mark: MarkAccept},
+	} {
+		q, conn := newTestQueue(test.failOpen)
+		ctx, cancel := context.WithCancel(context.Background())
+		handler := q.packetHandler(ctx)
+
+		// A packet without a verdict receives the default verdict.
+		id := uint32(1)
+		handler(nfqueue.Attribute{PacketID: &id, Payload: newTestPayload(t)})
+		receivePacket(t, q)
+		if mark := conn.mark(t, id); mark != test.mark {
+			t.Errorf("fail open %v: expected mark %d after timeout, got %d", test.failOpen, test.mark, mark)
+		}
+		if q.PacketsFailed() != 1 {
+			t.Errorf("fail open %v: expected one failed packet, got %d", test.failOpen, q.PacketsFailed())
+		}
+
+		// A packet with a verdict keeps it.
+		id = 2
+		handler(nfqueue.Attribute{PacketID: &id, Payload: newTestPayload(t)})
+		pkt := receivePacket(t, q)
+		if err := pkt.PermanentBlock(); err != nil {
+			t.Fatal(err)
+		}
+		if mark := conn.mark(t, id); mark != MarkBlockAlways {
+			t.Errorf("fail open %v: expected mark %d, got %d", test.failOpen, MarkBlockAlways, mark)
+		}
+		time.Sleep(2 * verdictTimeout)
+		if q.PacketsFailed() != 1 {
+			t.Errorf("fail open %v: packet with verdict failed", test.failOpen)
+		}
+		select {
+		case setID := <-conn.set:
+			t.Errorf("fail open %v: verdict of packet %d was set twice", test.failOpen, setID)
+		default:
+		}
+
+		cancel()
+	}
+}
@@ -118,6 +118,8 @@ func (pkt *packet) setMark(mark int) error {
 		}
 		break
 	}
+	atomic.AddUint64(&pkt.queue.verdicts, 1)
+	atomic.AddUint64(&pkt.queue.verdictLatency, uint64(time.Since(pkt.received)))
 	log.Tracer(pkt.Ctx()).Tracef("nfqueue: marking packet %s (%s -> %s) on queue %d with %s after %s", pkt.ID(), pkt.Info().Src, pkt.Info().Dst, pkt.queue.id, markToString(mark), time.Since(pkt.received))
 	return nil
 }
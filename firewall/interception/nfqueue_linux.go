@@ -3,6 +3,7 @@
 import (
 	"flag"
 	"fmt"
+	"os/exec"
 	"sort"
 	"strings"
 
@@ -10,6 +11,7 @@
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
 	"github.com/safing/portmaster/firewall/interception/nfq"
 	"github.com/safing/portmaster/network/packet"
 )
@@ -23,13 +25,16 @@
 	v6rules  []string
 	v6once   []string
 
-	out4Queue nfQueue
-	in4Queue  nfQueue
-	out6Queue nfQueue
-	in6Queue  nfQueue
+	queues []*directedQueue
+
+	// queueCount is the number of queues per direction and IP version.
+	queueCount uint16 = 1
 
 	shutdownSignal = make(chan struct{})
 
+	// activeBackend is the backend that installed the firewall rules.
+	activeBackend string
+
 	experimentalNfqueueBackend bool
 )
 
@@ -37,12 +42,62 @@ func init() {
 	flag.BoolVar(&experimentalNfqueueBackend, "experimental-nfqueue", false, "(deprecated flag; always used)")
 }
 
+// Queue numbers. Every direction and IP version uses a range of queues
+// starting at its base queue number.
+const (
+	out4QueueBase = 17040
+	in4QueueBase  = 17140
+	out6QueueBase = 17060
+	in6QueueBase  = 17160
+
+	// maxQueueCount is the maximum number of queues per direction and IP
+	// version. It ensures that the queue number ranges do not overlap.
+	maxQueueCount = 16
+)
+
+// queueGroup is the group of queues that receives the packets of one
+// direction and IP version.
+type queueGroup struct {
+	name    string
+	base    uint16
+	v6      bool
+	inbound bool
+
+	// failOpen defines whether packets are accepted if they cannot be handled
+	// by the queues. Packets are balanced over the queues of a group by a
+	// single rule, so all queues of a group share the failure mode.
+	failOpen bool
+}
+
+var (
+	out4Queues = &queueGroup{name: QueueGroupOutboundIPv4, base: out4QueueBase, failOpen: true}
+	in4Queues  = &queueGroup{name: QueueGroupInboundIPv4, base: in4QueueBase, inbound: true, failOpen: true}
+	out6Queues = &queueGroup{name: QueueGroupOutboundIPv6, base: out6QueueBase, v6: true, failOpen: true}
+	in6Queues  = &queueGroup{name: QueueGroupInboundIPv6, base: in6QueueBase, v6: true, inbound: true, failOpen: true}
+
+	queueGroups = []*queueGroup{out4Queues, in4Queues, out6Queues, in6Queues}
+)
+
+// failMode returns a description of the failure mode of the queue group.
+func (group *queueGroup) failMode() string {
+	if group.failOpen {
+		return "open"
+	}
+	return "closed"
+}
+
 // nfQueue encapsulates nfQueue providers.
 type nfQueue interface {
 	PacketChannel() <-chan packet.Packet
 	Destroy()
 }
 
+// directedQueue is a queue that receives packets of one direction.
+type directedQueue struct {
+	nfQueue
+	inbound bool
+}
+
 func init() {
 
 	v4chains = []string{
@@ -52,11 +107,10 @@ func init() {
 	}
 
 	v4rules = []string{
+		// The NFQUEUE rules depend on the queue configuration and are added
+		// by iptablesQueueRules.
 		"mangle C170 -j CONNMARK --restore-mark",
-		"mangle C170 -m mark --mark 0 -j NFQUEUE --queue-num 17040 --queue-bypass",
-
 		"mangle C171 -j CONNMARK --restore-mark",
-		"mangle C171 -m mark --mark 0 -j NFQUEUE --queue-num 17140 --queue-bypass",
 
 		"filter C17 -m mark --mark 0 -j DROP",
 		"filter C17 -m mark --mark 1700 -j RETURN",
@@ -95,11 +149,10 @@ func init() {
 	}
 
 	v6rules = []string{
+		// The NFQUEUE rules depend on the queue configuration and are added
+		// by iptablesQueueRules.
 		"mangle C170 -j CONNMARK --restore-mark",
-		"mangle C170 -m mark --mark 0 -j NFQUEUE --queue-num 17060 --queue-bypass",
-
 		"mangle C171 -j CONNMARK --restore-mark",
-		"mangle C171 -m mark --mark 0 -j NFQUEUE --queue-num 17160 --queue-bypass",
 
 		"filter C17 -m mark --mark 0 -j DROP",
 		"filter C17 -m mark --mark 1700 -j RETURN",
@@ -131,12 +184,88 @@ func init() {
 
 }
 
+// loadQueueConfig loads the queue configuration, which must not change while
+// the interception is active.
+func loadQueueConfig() {
+	switch count := interceptionQueues(); {
+	case count < 1:
+		queueCount = 1
+	case count > maxQueueCount:
+		queueCount = maxQueueCount
+	default:
+		queueCount = uint16(count)
+	}
+
+	// Failing closed must be selected explicitly.
+	failClosed := interceptionFailClosedQueues()
+	for _, group := range queueGroups {
+		group.failOpen = !utils.StringInSlice(failClosed, group.name)
+	}
+}
+
+// iptablesQueueRules returns the NFQUEUE rules for the given outbound and
+// inbound queue groups.
+func iptablesQueueRules(out, in *queueGroup) []string {
+	return []string{
+		"mangle C170 -m mark --mark 0 " + iptablesQueueTarget(out),
+		"mangle C171 -m mark --mark 0 " + iptablesQueueTarget(in),
+	}
+}
+
+// iptablesQueueTarget returns the NFQUEUE target for the given queue group.
+func iptablesQueueTarget(group *queueGroup) string {
+	target := fmt.Sprintf("-j NFQUEUE --queue-num %d", group.base)
+	if queueCount > 1 {
+		target = fmt.Sprintf("-j NFQUEUE --queue-balance %d:%d", group.base, group.base+queueCount-1)
+	}
+	if group.failOpen {
+		target += " --queue-bypass"
+	}
+	return target
+}
+
+// selectBackend returns the configured backend for installing the firewall
+// rules, or detects which one to use.
+func selectBackend() string {
+	switch backend := interceptionBackend(); backend {
+	case BackendIPTables, BackendNftables:
+		return backend
+	default:
+		if nftablesPreferred() {
+			return BackendNftables
+		}
+		return BackendIPTables
+	}
+}
+
 func activateNfqueueFirewall() error {
-	if err := activateIPTables(iptables.ProtocolIPv4, v4rules, v4once, v4chains); err != nil {
+	activeBackend = selectBackend()
+	log.Infof("interception: using %s backend", activeBackend)
+
+	if activeBackend == BackendNftables {
+		// Remove rules left over from the iptables backend.
+		if _, err := exec.LookPath("iptables"); err == nil {
+			_ = DeactivateNfqueueFirewall()
+		}
+		return activateNftables()
+	}
+
+	// Remove tables left over from the nftables backend.
+	if nftablesAvailable() {
+		_ = DeactivateNftablesFirewall()
+	}
+	return activateIPTablesFirewall()
+}
+
+func activateIPTablesFirewall() error {
+	// The queue rules must be added after the connection mark was restored.
+	rules := append(append([]string{}, v4rules...), iptablesQueueRules(out4Queues, in4Queues)...)
+	if err := activateIPTables(iptables.ProtocolIPv4, rules, v4once, v4chains); err != nil {
 		return err
 	}
 
-	if err := activateIPTables(iptables.ProtocolIPv6, v6rules, v6once, v6chains); err != nil {
+	rules = append(append([]string{}, v6rules...), iptablesQueueRules(out6Queues, in6Queues)...)
+	if err := activateIPTables(iptables.ProtocolIPv6, rules, v6once, v6chains); err != nil {
 		return err
 	}
 
@@ -144,6 +273,7 @@ func activateNfqueueFirewall() error {
 }
 
 // DeactivateNfqueueFirewall drops portmaster related IP tables rules.
+// See DeactivateNftablesFirewall for the nftables backend.
 // Any errors encountered accumulated into a *multierror.Error.
 func DeactivateNfqueueFirewall() error {
 	// IPv4
@@ -238,34 +368,54 @@ func StartNfqueueInterception(packets chan<- packet.Packet) (err error) {
 		log.Warningf("[DEPRECATED] please remove the flag from your configuration!")
 	}
 
+	loadQueueConfig()
+	log.Infof(
+		"interception: using %d queue(s) per direction, failing %s (out4), %s (in4), %s (out6), %s (in6)",
+		queueCount,
+		out4Queues.failMode(),
+		in4Queues.failMode(),
+		out6Queues.failMode(),
+		in6Queues.failMode(),
+	)
+
 	err = activateNfqueueFirewall()
 	if err != nil {
 		_ = Stop()
 		return fmt.Errorf("could not initialize nfqueue: %s", err)
 	}
 
-	out4Queue, err = nfq.New(17040, false)
-	if err != nil {
-		_ = Stop()
-		return fmt.Errorf("nfqueue(IPv4, out): %w", err)
-	}
-	in4Queue, err = nfq.New(17140, false)
-	if err != nil {
-		_ = Stop()
-		return fmt.Errorf("nfqueue(IPv4, in): %w", err)
-	}
-	out6Queue, err = nfq.New(17060, true)
-	if err != nil {
-		_ = Stop()
-		return fmt.Errorf("nfqueue(IPv6, out): %w", err)
-	}
-	in6Queue, err = nfq.New(17160, true)
-	if err != nil {
-		_ = Stop()
-		return fmt.Errorf("nfqueue(IPv6, in): %w", err)
+	metricsQueues := make(map[uint16]statsQueue)
+	for _, group := range queueGroups {
+		for qid := group.base; qid < group.base+queueCount; qid++ {
+			q, err := nfq.New(qid, group.v6, group.failOpen)
+			if err != nil {
+				_ = Stop()
+				return fmt.Errorf("nfqueue(%s, %d): %w", group.name, qid, err)
+			}
+			queues = append(queues, &directedQueue{
+				nfQueue: q,
+				inbound: group.inbound,
+			})
+			metricsQueues[qid] = q
+		}
 	}
 
-	go handleInterception(packets)
+	// Register the queue metrics only once, as they cannot be registered
+	// again when the interception is restarted.
+	setMetricsQueues(metricsQueues)
+	registerQueueMetricsOnce.Do(func() {
+		for _, group := range queueGroups {
+			for qid := group.base; qid < group.base+queueCount; qid++ {
+				if err := registerQueueMetrics(qid, group.inbound, group.v6); err != nil {
+					log.Warningf("interception: failed to register metrics of queue %d: %s", qid, err)
+				}
+			}
+		}
+	})
+
+	for _, q := range queues {
+		go handleInterception(q, packets)
+	}
 	return nil
 }
 
@@ -273,20 +423,18 @@ func StartNfqueueInterception(packets chan<- packet.Packet) (err error) {
 func StopNfqueueInterception() error {
 	defer close(shutdownSignal)
 
-	if out4Queue != nil {
-		out4Queue.Destroy()
-	}
-	if in4Queue != nil {
-		in4Queue.Destroy()
+	for _, q := range queues {
+		q.Destroy()
 	}
-	if out6Queue != nil {
-		out6Queue.Destroy()
+	queues = nil
+	setMetricsQueues(nil)
+
+	var err error
+	if activeBackend == BackendNftables {
+		err = DeactivateNftablesFirewall()
+	} else {
+		err = DeactivateNfqueueFirewall()
 	}
-	if in6Queue != nil {
-		in6Queue.Destroy()
-	}
-
-	err := DeactivateNfqueueFirewall()
 	if err != nil {
 		return fmt.Errorf("interception: error while deactivating nfqueue: %s", err)
 	}
@@ -294,20 +442,19 @@ func StopNfqueueInterception() error {
 	return nil
 }
 
-func handleInterception(packets chan<- packet.Packet) {
+func handleInterception(q *directedQueue, packets chan<- packet.Packet) {
 	for {
 		var pkt packet.Packet
 		select {
 		case <-shutdownSignal:
 			return
-		case pkt = <-out4Queue.PacketChannel():
-			pkt.SetOutbound()
-		case pkt = <-in4Queue.PacketChannel():
+		case pkt = <-q.PacketChannel():
+		}
+
+		if q.inbound {
 			pkt.SetInbound()
-		case pkt = <-out6Queue.PacketChannel():
+		} else {
 			pkt.SetOutbound()
-		case pkt = <-in6Queue.PacketChannel():
-			pkt.SetInbound()
 		}
 
 		select {
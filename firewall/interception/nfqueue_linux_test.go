@@ -0,0 +1,54 @@
+package interception
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestLoadQueueConfig(t *testing.T) {
+	setQueueCount(t, 1)
+	queueConfig, failClosedConfig := interceptionQueues, interceptionFailClosedQueues
+	t.Cleanup(func() {
+		interceptionQueues, interceptionFailClosedQueues = queueConfig, failClosedConfig
+		for _, group := range queueGroups {
+			group.failOpen = true
+		}
+	})
+
+	for _, test := range []struct {
+		queues     int64
+		failClosed []string
+		count      uint16
+		failOpen   []bool // out4, in4, out6, in6
+	}{
+		{queues: 1, count: 1, failOpen: []bool{true, true, true, true}},
+		{queues: 4, failClosed: []string{QueueGroupInboundIPv4}, count: 4, failOpen: []bool{true, false, true, true}},
+		{queues: 0, failClosed: []string{QueueGroupOutboundIPv6, QueueGroupInboundIPv6}, count: 1, failOpen: []bool{true, true, false, false}},
+		{
+			queues:     100,
+			failClosed: []string{QueueGroupOutboundIPv4, QueueGroupInboundIPv4, QueueGroupOutboundIPv6, QueueGroupInboundIPv6},
+			count:      maxQueueCount,
+			failOpen:   []bool{false, false, false, false},
+		},
+		// Unknown queue groups are ignored.
+		{queues: 1, failClosed: []string{"invalid"}, count: 1, failOpen: []bool{true, true, true, true}},
+	} {
+		// Start with the opposite failure modes to check that they are switched.
+		for i, group := range queueGroups {
+			group.failOpen = !test.failOpen[i]
+		}
+		interceptionQueues = config.IntOption(func() int64 { return test.queues })
+		interceptionFailClosedQueues = config.StringArrayOption(func() []string { return test.failClosed })
+
+		loadQueueConfig()
+		if queueCount != test.count {
+			t.Errorf("%d queues: expected %d queues, got %d", test.queues, test.count, queueCount)
+		}
+		for i, group := range queueGroups {
+			if group.failOpen != test.failOpen[i] {
+				t.Errorf("%d queues, fail closed %v: expected %s to fail open %v", test.queues, test.failClosed, group.name, test.failOpen[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package interception
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// nftablesTable is the name of the nftables tables of the Portmaster. There
+// is one table for each of the ip and ip6 families.
+const nftablesTable = "portmaster"
+
+var (
+	// nftablesV4Rules holds the IPv4 ruleset. It mirrors the iptables rules
+	// and uses the same marks and queue numbers. The queue statements are
+	// added by nftablesRuleset.
+	nftablesV4Rules = `
+table ip portmaster {
+	chain mangle_output {
+		type filter hook output priority -150; policy accept;
+		meta mark set ct mark
+		meta mark 0 %[1]s
+	}
+
+	chain mangle_input {
+		type filter hook input priority -150; policy accept;
+		meta mark set ct mark
+		meta mark 0 %[2]s
+	}
+
+	chain verdicts {
+		meta mark 0 drop
+		meta mark 1700 return
+		meta mark 1701 ip protocol icmp return
+		meta mark 1701 reject with icmp type host-prohibited
+		meta mark 1702 drop
+		ct mark set meta mark
+		meta mark 1710 return
+		meta mark 1711 ip protocol icmp return
+		meta mark 1711 reject with icmp type host-prohibited
+		meta mark 1712 drop
+		meta mark 1717 return
+	}
+
+	chain filter_output {
+		type filter hook output priority 0; policy accept;
+		jump verdicts
+	}
+
+	chain filter_input {
+		type filter hook input priority 0; policy accept;
+		jump verdicts
+	}
+
+	chain nat_output {
+		type nat hook output priority -100; policy accept;
+		meta mark 1799 ip protocol udp dnat to 127.0.0.17:53
+		meta mark 1717 ip protocol tcp dnat to 127.0.0.17:717
+		meta mark 1717 ip protocol udp dnat to 127.0.0.17:717
+	}
+}
+`
+
+	// nftablesV6Rules holds the IPv6 ruleset. It mirrors the ip6tables rules
+	// and uses the same marks and queue numbers. The queue statements are
+	// added by nftablesRuleset.
+	nftablesV6Rules = `
+table ip6 portmaster {
+	chain mangle_output {
+		type filter hook output priority -150; policy accept;
+		meta mark set ct mark
+		meta mark 0 %[1]s
+	}
+
+	chain mangle_input {
+		type filter hook input priority -150; policy accept;
+		meta mark set ct mark
+		meta mark 0 %[2]s
+	}
+
+	chain verdicts {
+		meta mark 0 drop
+		meta mark 1700 return
+		meta mark 1701 meta l4proto ipv6-icmp return
+		meta mark 1701 reject with icmpv6 type admin-prohibited
+		meta mark 1702 drop
+		ct mark set meta mark
+		meta mark 1710 return
+		meta mark 1711 meta l4proto ipv6-icmp return
+		meta mark 1711 reject with icmpv6 type admin-prohibited
+		meta mark 1712 drop
+		meta mark 1717 return
+	}
+
+	chain filter_output {
+		type filter hook output priority 0; policy accept;
+		jump verdicts
+	}
+
+	chain filter_input {
+		type filter hook input priority 0; policy accept;
+		jump verdicts
+	}
+
+	chain nat_output {
+		type nat hook output priority -100; policy accept;
+		meta mark 1799 meta l4proto udp dnat to [::1]:53
+		meta mark 1717 meta l4proto tcp dnat to [::1]:717
+		meta mark 1717 meta l4proto udp dnat to [::1]:717
+	}
+}
+`
+)
+
+// nftablesRuleset returns the given ruleset with the queue statements for
+// the given outbound and inbound queue groups.
+func nftablesRuleset(rules string, out, in *queueGroup) string {
+	return fmt.Sprintf(rules, nftablesQueueStatement(out), nftablesQueueStatement(in))
+}
+
+// nftablesQueueStatement returns the queue statement for the given queue
+// group. Packets are balanced over the queues by flow.
+func nftablesQueueStatement(group *queueGroup) string {
+	statement := fmt.Sprintf("queue num %d", group.base)
+	if queueCount > 1 {
+		statement = fmt.Sprintf("queue num %d-%d", group.base, group.base+queueCount-1)
+	}
+	if group.failOpen {
+		statement += " bypass"
+	}
+	return statement
+}
+
+// nftablesDeleteTable returns nftables commands that delete the table of the
+// given family. The table is declared first, so that deleting does not fail
+// if it does not exist.
+func nftablesDeleteTable(family string) string {
+	return fmt.Sprintf("table %s %s\ndelete table %s %s\n", family, nftablesTable, family, nftablesTable)
+}
+
+// nftablesAvailable returns whether the nft command is available.
+func nftablesAvailable() bool {
+	_, err := exec.LookPath("nft")
+	return err == nil
+}
+
+// nftablesPreferred returns whether nftables should be used instead of
+// iptables. This is the case if iptables is not available or if it is only a
+// compatibility layer on top of nftables.
+func nftablesPreferred() bool {
+	if !nftablesAvailable() {
+		return false
+	}
+
+	iptablesPath, err := exec.LookPath("iptables")
+	if err != nil {
+		return true
+	}
+	output, err := exec.Command(iptablesPath, "--version").Output()
+	if err != nil {
+		return true
+	}
+	return strings.Contains(string(output), "nf_tables")
+}
+
+// runNft runs the given nftables script. All commands of a script are applied
+// in a single transaction.
+func runNft(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("nft: %w: %s", err, msg)
+		}
+		return fmt.Errorf("nft: %w", err)
+	}
+	return nil
+}
+
+// activateNftables atomically replaces the Portmaster tables with the current
+// ruleset.
+func activateNftables() error {
+	return runNft(
+		nftablesDeleteTable("ip") + nftablesRuleset(nftablesV4Rules, out4Queues, in4Queues) +
+			nftablesDeleteTable("ip6") + nftablesRuleset(nftablesV6Rules, out6Queues, in6Queues),
+	)
+}
+
+// DeactivateNftablesFirewall removes the Portmaster nftables tables.
+// Any errors encountered accumulated into a *multierror.Error.
+func DeactivateNftablesFirewall() error {
+	var result *multierror.Error
+	if err := runNft(nftablesDeleteTable("ip")); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := runNft(nftablesDeleteTable("ip6")); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
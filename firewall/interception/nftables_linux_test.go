@@ -0,0 +1,212 @@
+package interception
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setQueueCount sets the number of queues for the duration of the test.
+func setQueueCount(t *testing.T, count uint16) {
+	t.Helper()
+
+	previousCount := queueCount
+	t.Cleanup(func() {
+		queueCount = previousCount
+	})
+	queueCount = count
+}
+
+var (
+	nftablesChainRegex    = regexp.MustCompile(`^chain (\S+) \{$`)
+	nftablesPriorityRegex = regexp.MustCompile(`^type (\S+) hook (\S+) priority (-?\d+); policy accept;$`)
+	nftablesMarkRegex     = regexp.MustCompile(`meta mark (\d+)`)
+	iptablesMarkRegex     = regexp.MustCompile(`--mark (\d+)`)
+)
+
+// nftablesChains returns the statements of all chains of the given ruleset.
+func nftablesChains(t *testing.T, ruleset string) map[string][]string {
+	t.Helper()
+
+	chains := make(map[string][]string)
+	var chain string
+	for _, line := range strings.Split(ruleset, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "table "):
+		case line == "}":
+			chain = ""
+		case nftablesChainRegex.MatchString(line):
+			chain = nftablesChainRegex.FindStringSubmatch(line)[1]
+			chains[chain] = nil
+		case chain == "":
+			t.Fatalf("statement outside of chain: %s", line)
+		default:
+			chains[chain] = append(chains[chain], line)
+		}
+	}
+	return chains
+}
+
+// marks returns the set of marks matched by the given rules.
+func marks(rules []string, markRegex *regexp.Regexp) map[string]bool {
+	found := make(map[string]bool)
+	for _, rule := range rules {
+		for _, match := range markRegex.FindAllStringSubmatch(rule, -1) {
+			found[match[1]] = true
+		}
+	}
+	return found
+}
+
+func TestNftablesRuleset(t *testing.T) {
+	setQueueCount(t, 4)
+
+	for _, test := range []struct {
+		name          string
+		rules         string
+		out           *queueGroup
+		in            *queueGroup
+		iptablesRules []string
+	}{
+		{
+			name:          "IPv4",
+			rules:         nftablesV4Rules,
+			out:           &queueGroup{base: out4QueueBase},
+			in:            &queueGroup{base: in4QueueBase, inbound: true, failOpen: true},
+			iptablesRules: append(append([]string{}, v4rules...), v4once...),
+		},
+		{
+			name:          "IPv6",
+			rules:         nftablesV6Rules,
+			out:           &queueGroup{base: out6QueueBase, v6: true, failOpen: true},
+			in:            &queueGroup{base: in6QueueBase, v6: true, inbound: true},
+			iptablesRules: append(append([]string{}, v6rules...), v6once...),
+		},
+	} {
+		ruleset := nftablesRuleset(test.rules, test.out, test.in)
+		if strings.Contains(ruleset, "%!") || strings.Contains(ruleset, "%[") {
+			t.Errorf("%s: ruleset was not formatted correctly:\n%s", test.name, ruleset)
+		}
+		chains := nftablesChains(t, ruleset)
+
+		// Check the hooks and priorities of the base chains. They must be the
+		// same as the ones of the iptables tables.
+		for chain, expected := range map[string]string{
+			"mangle_output": "type filter hook output priority -150; policy accept;",
+			"mangle_input":  "type filter hook input priority -150; policy accept;",
+			"filter_output": "type filter hook output priority 0; policy accept;",
+			"filter_input":  "type filter hook input priority 0; policy accept;",
+			"nat_output":    "type nat hook output priority -100; policy accept;",
+		} {
+			statements, ok := chains[chain]
+			if !ok {
+				t.Errorf("%s: missing chain %s", test.name, chain)
+				continue
+			}
+			if len(statements) == 0 || statements[0] != expected {
+				t.Errorf("%s: chain %s must start with %q, got %v", test.name, chain, expected, statements)
+			}
+		}
+		for chain, statements := range chains {
+			for _, statement := range statements[1:] {
+				if nftablesPriorityRegex.MatchString(statement) {
+					t.Errorf("%s: chain %s has misplaced type statement %q", test.name, chain, statement)
+				}
+			}
+		}
+		if len(chains) != 6 {
+			t.Errorf("%s: expected 6 chains, got %d", test.name, len(chains))
+		}
+
+		// Check that the same marks as with iptables are used and that they
+		// are in the range of the Portmaster.
+		nftMarks := marks(strings.Split(ruleset, "\n"), nftablesMarkRegex)
+		iptMarks := marks(test.iptablesRules, iptablesMarkRegex)
+		for mark := range nftMarks {
+			n, err := strconv.Atoi(mark)
+			if err != nil || (n != 0 && (n < 1700 || n > 1799)) {
+				t.Errorf("%s: mark %s is outside of 1700-1799", test.name, mark)
+			}
+			if !iptMarks[mark] {
+				t.Errorf("%s: mark %s is not used by iptables", test.name, mark)
+			}
+		}
+		for mark := range iptMarks {
+			if !nftMarks[mark] {
+				t.Errorf("%s: mark %s of iptables is not used", test.name, mark)
+			}
+		}
+
+		// Check the queue statements, which must come after the connection
+		// mark was restored.
+		for chain, group := range map[string]*queueGroup{
+			"mangle_output": test.out,
+			"mangle_input":  test.in,
+		} {
+			expected := []string{
+				"meta mark set ct mark",
+				"meta mark 0 " + nftablesQueueStatement(group),
+			}
+			statements := chains[chain]
+			if len(statements) != 3 || statements[1] != expected[0] || statements[2] != expected[1] {
+				t.Errorf("%s: expected chain %s to have statements %v, got %v", test.name, chain, expected, statements)
+			}
+			// Every queue group has its own failure mode.
+			if strings.HasSuffix(expected[1], " bypass") != group.failOpen {
+				t.Errorf("%s: expected chain %s to fail open %v, got %q", test.name, chain, group.failOpen, expected[1])
+			}
+		}
+	}
+}
+
+func TestNftablesQueueStatement(t *testing.T) {
+	for _, test := range []struct {
+		count    uint16
+		failOpen bool
+		nftables string
+		iptables string
+	}{
+		{
+			count:    1,
+			nftables: "queue num 17040",
+			iptables: "-j NFQUEUE --queue-num 17040",
+		},
+		{
+			count:    1,
+			failOpen: true,
+			nftables: "queue num 17040 bypass",
+			iptables: "-j NFQUEUE --queue-num 17040 --queue-bypass",
+		},
+		{
+			count:    4,
+			nftables: "queue num 17040-17043",
+			iptables: "-j NFQUEUE --queue-balance 17040:17043",
+		},
+		{
+			count:    maxQueueCount,
+			failOpen: true,
+			nftables: "queue num 17040-17055 bypass",
+			iptables: "-j NFQUEUE --queue-balance 17040:17055 --queue-bypass",
+		},
+	} {
+		setQueueCount(t, test.count)
+		group := &queueGroup{base: out4QueueBase, failOpen: test.failOpen}
+
+		nftStatement := nftablesQueueStatement(group)
+		if nftStatement != test.nftables {
+			t.Errorf("%d queues (fail open: %v): expected nftables statement %q, got %q", test.count, test.failOpen, test.nftables, nftStatement)
+		}
+		iptTarget := iptablesQueueTarget(group)
+		if iptTarget != test.iptables {
+			t.Errorf("%d queues (fail open: %v): expected iptables target %q, got %q", test.count, test.failOpen, test.iptables, iptTarget)
+		}
+
+		// Both backends balance packets by flow, which requires that neither
+		// distributes them by CPU.
+		if strings.Contains(nftStatement, "fanout") || strings.Contains(iptTarget, "fanout") {
+			t.Errorf("%d queues (fail open: %v): packets must not be distributed by CPU", test.count, test.failOpen)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/intel/filterlists"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/portmaster/resolver"
+)
+
+// ListLookupResult is the result of looking up an entity in the filter lists.
+type ListLookupResult struct {
+	// CNAMEs holds the CNAMEs of the domain, if it was resolved.
+	CNAMEs []string `json:",omitempty"`
+	// IP holds the IP address that was looked up, if any.
+	IP string `json:",omitempty"`
+	// Lookups holds the details of every lookup in the filter lists,
+	// including parent domains and CNAMEs.
+	Lookups []*filterlists.EntityLookup
+	// Profile holds how the filter lists of a profile apply to the entity,
+	// if a profile was given.
+	Profile *ListLookupProfileResult `json:",omitempty"`
+}
+
+// ListLookupProfileResult describes how the filter lists of a profile apply
+// to an entity.
+type ListLookupProfileResult struct {
+	// ID is the ID of the local profile.
+	ID string
+	// Blocked is set if the filter lists of the profile block the entity.
+	Blocked bool
+	// Reason holds the human readable reason of the block.
+	Reason string `json:",omitempty"`
+	// Matches holds the list matches that block the entity.
+	Matches []intel.ListMatch `json:",omitempty"`
+}
+
+// LookupFilterLists looks up the given entity in the filter lists. If resolve
+// is set, the domain of the entity is resolved in order to check its CNAMEs
+// and, if the entity has no IP address, its IP address. If a profile ID is
+// given, the lookup follows the filter list settings of the local profile
+// with that ID and reports whether its filter lists block the entity.
+// Otherwise, parent domains and CNAMEs are always checked.
+func LookupFilterLists(ctx context.Context, entity *intel.Entity, resolve bool, profileID string) (*ListLookupResult, error) {
+	result := &ListLookupResult{}
+
+	if resolve && entity.Domain != "" {
+		if err := resolveListLookupEntity(ctx, entity); err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", entity.Domain, err)
+		}
+		result.CNAMEs = entity.CNAME
+	}
+	if entity.IP != nil {
+		result.IP = entity.IP.String()
+	}
+
+	var layeredProfile *profile.LayeredProfile
+	if profileID != "" {
+		localProfile, err := profile.GetProfile(profile.SourceLocal, profileID, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profile: %w", err)
+		}
+		// Use a temporary layered profile, so that the layered profile in use
+		// is not changed.
+		layeredProfile = profile.NewTemporaryLayeredProfile(localProfile)
+
+		layeredProfile.LockForUsage()
+		defer layeredProfile.UnlockForUsage()
+
+		entity.ResolveSubDomainLists(ctx, layeredProfile.FilterSubDomains())
+		entity.EnableCNAMECheck(ctx, layeredProfile.FilterCNAMEs())
+	} else {
+		entity.ResolveSubDomainLists(ctx, true)
+		entity.EnableCNAMECheck(ctx, true)
+	}
+
+	lookups, err := entity.LookupListDetails(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.Lookups = lookups
+
+	if layeredProfile != nil {
+		profileResult := &ListLookupProfileResult{
+			ID: profileID,
+		}
+		epResult, reason := layeredProfile.MatchFilterLists(ctx, entity)
+		if epResult == endpoints.Denied {
+			profileResult.Blocked = true
+			if reason != nil {
+				profileResult.Reason = reason.String()
+			}
+			if listReason, ok := reason.(intel.ListBlockReason); ok {
+				profileResult.Matches = listReason
+			}
+		}
+		result.Profile = profileResult
+	}
+
+	return result, nil
+}
+
+// resolveListLookupEntity resolves the domain of the given entity and sets
+// its CNAMEs and, if not yet set, its IP address. IPv4 addresses are
+// preferred over IPv6 addresses.
+func resolveListLookupEntity(ctx context.Context, entity *intel.Entity) error {
+	cnames := make(map[string]string)
+	var ipv4, ipv6 net.IP
+	var resolved bool
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrCache, err := resolver.Resolve(ctx, &resolver.Query{
+			FQDN:  entity.Domain,
+			QType: dns.Type(qtype),
+		})
+		if err != nil {
+			// The domain may only have records of one type.
+			lastErr = err
+			continue
+		}
+		resolved = true
+
+		for _, rr := range rrCache.Answer {
+			switch v := rr.(type) {
+			case *dns.CNAME:
+				cnames[v.Hdr.Name] = v.Target
+			case *dns.A:
+				if ipv4 == nil {
+					ipv4 = v.A
+				}
+			case *dns.AAAA:
+				if ipv6 == nil {
+					ipv6 = v.AAAA
+				}
+			}
+		}
+	}
+	if !resolved {
+		return lastErr
+	}
+
+	// Follow the CNAMEs in the correct order.
+	domain := entity.Domain
+	for {
+		nextDomain, isCNAME := cnames[domain]
+		if !isCNAME || len(entity.CNAME) > len(cnames) {
+			break
+		}
+		entity.CNAME = append(entity.CNAME, nextDomain)
+		domain = nextDomain
+	}
+
+	if entity.IP == nil {
+		switch {
+		case ipv4 != nil:
+			entity.SetIP(ipv4)
+		case ipv6 != nil:
+			entity.SetIP(ipv6)
+		}
+	}
+	return nil
+}
+
+func registerListLookupAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "intel/filterlists/lookup",
+		Read:        api.PermitUser,
+		StructFunc:  handleListLookup,
+		Name:        "Look Up Filter List Matches",
+		Description: "Look up a domain, IP address, ASN or country in the filter lists, including parent domains and CNAMEs. Returns the matching sources and where they contain the entity, as well as whether the bloom filter or the database matched.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodGet,
+				Field:       "domain",
+				Value:       "<domain>",
+				Description: "Specify the domain to look up.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "ip",
+				Value:       "<ip>",
+				Description: "Specify the IP address to look up.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "asn",
+				Value:       "<number>",
+				Description: "Specify the autonomous system number to look up.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "country",
+				Value:       "<country code>",
+				Description: "Specify the two letter country code to look up.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "resolve",
+				Value:       "true",
+				Description: "Resolve the domain in order to look up its CNAMEs and IP address too.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "profile",
+				Value:       "<ID>",
+				Description: "Specify the ID of a local profile to check whether its filter lists block the entity.",
+			},
+		},
+	})
+}
+
+func handleListLookup(ar *api.Request) (interface{}, error) {
+	q := ar.Request.URL.Query()
+
+	entity := &intel.Entity{}
+	if domain := q.Get("domain"); domain != "" {
+		entity.Domain = dns.Fqdn(strings.ToLower(domain))
+		if _, ok := dns.IsDomainName(entity.Domain); !ok {
+			return nil, fmt.Errorf("invalid domain: %s", domain)
+		}
+	}
+	if ipParam := q.Get("ip"); ipParam != "" {
+		ip := net.ParseIP(ipParam)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", ipParam)
+		}
+		entity.SetIP(ip)
+	}
+	if asnParam := q.Get("asn"); asnParam != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asnParam), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ASN: %s", asnParam)
+		}
+		entity.ASN = uint(asn)
+	}
+	if country := q.Get("country"); country != "" {
+		if len(country) != 2 {
+			return nil, fmt.Errorf("invalid country code: %s", country)
+		}
+		entity.Country = strings.ToUpper(country)
+	}
+	if entity.Domain == "" && entity.IP == nil && entity.ASN == 0 && entity.Country == "" {
+		return nil, errors.New("missing domain, IP address, ASN or country")
+	}
+
+	var resolve bool
+	if resolveParam := q.Get("resolve"); resolveParam != "" {
+		var err error
+		resolve, err = strconv.ParseBool(resolveParam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolve parameter: %s", resolveParam)
+		}
+	}
+
+	return LookupFilterLists(ar.Context(), entity, resolve, q.Get("profile"))
+}
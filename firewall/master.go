@@ -18,6 +18,7 @@
 	"github.com/safing/portmaster/process"
 	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/portmaster/resolver"
 
 	"github.com/agext/levenshtein"
 )
@@ -37,28 +38,6 @@
 
 const noReasonOptionKey = ""
 
-type deciderFn func(context.Context, *network.Connection, *profile.LayeredProfile, packet.Packet) bool
-
-var defaultDeciders = []deciderFn{
-	checkPortmasterConnection,
-	checkSelfCommunication,
-	checkConnectionType,
-	checkConnectionScope,
-	checkEndpointLists,
-	checkResolverScope,
-	checkConnectivityDomain,
-	checkBypassPrevention,
-	checkFilterLists,
-	dropInbound,
-	checkDomainHeuristics,
-	checkAutoPermitRelated,
-}
-
-var dnsFromSystemResolverDeciders = []deciderFn{
-	checkConnectivityDomain,
-	checkBypassPrevention,
-}
-
 // DecideOnConnection makes a decision about a connection.
 // When called, the connection and profile is already locked.
 func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
@@ -66,6 +45,7 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 	layeredProfile := conn.Process().Profile()
 	if layeredProfile == nil {
 		conn.Deny("unknown process or profile", noReasonOptionKey)
+		conn.Reason.Decider = DeciderNameUnknownProfile
 		return
 	}
 
@@ -92,23 +72,35 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 		}
 	}
 
+	// Run the decision pipeline and prompt the user if required.
+	if !runDecisionPipeline(ctx, conn, layeredProfile, pkt, nil) {
+		prompt(ctx, conn, pkt)
+		conn.Reason.Decider = DeciderNameDefaultAction
+	}
+}
+
+// runDecisionPipeline runs the deciders and applies the default action. It
+// returns false if the user must be prompted instead. Decisions are recorded
+// in the given trace, if set.
+func runDecisionPipeline(ctx context.Context, conn *network.Connection, layeredProfile *profile.LayeredProfile, pkt packet.Packet, trace *DecisionTrace) (decided bool) {
 	// DNS request from the system resolver require a special decision process,
 	// because the original requesting process is not known. Here, we only check
 	// global-only and the most important per-app aspects. The resulting
 	// connection is then blocked when the original requesting process is known.
 	if conn.Type == network.DNSRequest && conn.Process().IsSystemResolver() {
 		// Run all deciders and return if they came to a conclusion.
-		done, _ := runDeciders(ctx, dnsFromSystemResolverDeciders, conn, layeredProfile, pkt)
+		done, _ := runDeciders(ctx, dnsFromSystemResolverDeciders, conn, layeredProfile, pkt, trace)
 		if !done {
 			conn.Accept("allowing system resolver dns request", noReasonOptionKey)
+			conn.Reason.Decider = DeciderNameSystemResolver
 		}
-		return
+		return true
 	}
 
 	// Run all deciders and return if they came to a conclusion.
-	done, defaultAction := runDeciders(ctx, defaultDeciders, conn, layeredProfile, pkt)
+	done, defaultAction := runDeciders(ctx, getDeciderPipeline(), conn, layeredProfile, pkt, trace)
 	if done {
-		return
+		return true
 	}
 
 	// Deciders did not conclude, use default action.
@@ -116,28 +108,56 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 	case profile.DefaultActionPermit:
 		conn.Accept("allowed by default action", profile.CfgOptionDefaultActionKey)
 	case profile.DefaultActionAsk:
-		prompt(ctx, conn, pkt)
+		return false
 	default:
 		conn.Deny("blocked by default action", profile.CfgOptionDefaultActionKey)
 	}
+	conn.Reason.Decider = DeciderNameDefaultAction
+	return true
 }
 
-func runDeciders(ctx context.Context, selectedDeciders []deciderFn, conn *network.Connection, layeredProfile *profile.LayeredProfile, pkt packet.Packet) (done bool, defaultAction uint8) {
+func runDeciders(ctx context.Context, selectedDeciders []*Decider, conn *network.Connection, layeredProfile *profile.LayeredProfile, pkt packet.Packet, trace *DecisionTrace) (done bool, defaultAction uint8) {
 	// Read-lock all the profiles.
 	layeredProfile.LockForUsage()
 	defer layeredProfile.UnlockForUsage()
 
 	// Go though all deciders, return if one sets an action.
 	for _, decider := range selectedDeciders {
-		if decider(ctx, conn, layeredProfile, pkt) {
+		switch {
+		case !decider.AppliesTo(conn.Type):
+			trace.add(decider, DeciderOutcomeNotApplicable)
+			continue
+		case !decider.IsEnabled():
+			trace.add(decider, DeciderOutcomeDisabled)
+			continue
+		}
+
+		if runDecider(ctx, decider, conn, layeredProfile, pkt) {
+			trace.add(decider, DeciderOutcomeDecided)
 			return true, profile.DefaultActionNotSet
 		}
+		trace.add(decider, DeciderOutcomeNoDecision)
 	}
 
 	// Return the default action.
 	return false, layeredProfile.DefaultAction()
 }
 
+// runDecider runs the given decider and records it as the decider of the
+// verdict, if it changed the verdict. A decider may report a decision even
+// though the verdict was not changed, for example when it tried to lower it.
+func runDecider(ctx context.Context, decider *Decider, conn *network.Connection, layeredProfile *profile.LayeredProfile, pkt packet.Packet) (decided bool) {
+	previousVerdict := conn.Verdict
+	if !decider.Fn(ctx, conn, layeredProfile, pkt) {
+		return false
+	}
+
+	if conn.Verdict != previousVerdict {
+		conn.Reason.Decider = decider.Name
+	}
+	return true
+}
+
 // checkPortmasterConnection allows all connection that originate from
 // portmaster itself.
 func checkPortmasterConnection(ctx context.Context, conn *network.Connection, _ *profile.LayeredProfile, pkt packet.Packet) bool {
@@ -366,6 +386,21 @@ func checkResolverScope(_ context.Context, conn *network.Connection, p *profile.
 	return false
 }
 
+func checkDNSSEC(_ context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	// If the IP address was resolved, check the DNSSEC validation state of the answer.
+	switch {
+	case !p.BlockDNSSECFailures():
+		// DNSSEC failures are not blocked.
+	case conn.Resolver == nil:
+		// IP address of connection was not resolved.
+	case conn.Resolver.DNSSEC == resolver.DNSSECBogus:
+		conn.Block("DNSSEC validation of DNS answer failed", profile.CfgOptionBlockDNSSECFailuresKey)
+		return true
+	}
+
+	return false
+}
+
 func checkDomainHeuristics(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
 	if !p.DomainHeuristics() {
 		return false
@@ -0,0 +1,23 @@
+package firewall
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/safing/portmaster/core/pmtesting"
+
+	// module dependencies
+	_ "github.com/safing/portmaster/core"
+)
+
+func TestMain(m *testing.M) {
+	// Tests must not intercept any packets.
+	if err := flag.Set("disable-interception", "true"); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to disable interception: %s\n", err)
+		os.Exit(1)
+	}
+
+	pmtesting.TestMain(m, filterModule)
+}
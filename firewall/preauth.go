@@ -10,6 +10,7 @@
 	"fmt"
 	"net"
 
+	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/resolver"
 )
@@ -22,6 +23,7 @@
 func init() {
 	resolver.SetLocalAddrFactory(PermittedAddr)
 	netenv.SetLocalAddrFactory(PermittedAddr)
+	filterlists.SetLocalAddrFactory(PermittedAddr)
 }
 
 // PermittedAddr returns an already permitted local address for the given network for reliable connectivity.
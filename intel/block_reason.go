@@ -8,6 +8,7 @@
 
 	"github.com/miekg/dns"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/nameserver/nsutil"
 )
 
@@ -17,6 +18,10 @@ type ListMatch struct {
 	Entity        string
 	ActiveLists   []string
 	InactiveLists []string
+
+	// Origins holds the filter list entries of the active
+	// lists that matched the entity.
+	Origins []filterlists.ListOrigin `json:",omitempty"`
 }
 
 func (lm *ListMatch) String() string {
@@ -24,14 +29,27 @@ func (lm *ListMatch) String() string {
 	if len(lm.InactiveLists) > 0 {
 		inactive = " and in deactivated lists " + strings.Join(lm.InactiveLists, ", ")
 	}
+	origins := ""
+	if len(lm.Origins) > 0 {
+		origins = " (" + lm.originsString() + ")"
+	}
 	return fmt.Sprintf(
-		"%s in activated lists %s%s",
+		"%s in activated lists %s%s%s",
 		lm.Entity,
 		strings.Join(lm.ActiveLists, ","),
+		origins,
 		inactive,
 	)
 }
 
+func (lm *ListMatch) originsString() string {
+	origins := make([]string, len(lm.Origins))
+	for idx, origin := range lm.Origins {
+		origins[idx] = origin.String()
+	}
+	return strings.Join(origins, "; ")
+}
+
 // ListBlockReason is a list of list matches.
 type ListBlockReason []ListMatch
 
@@ -82,6 +100,19 @@ func (br ListBlockReason) GetExtraRRs(ctx context.Context, _ *dns.Msg) []dns.RR
 			log.Tracer(ctx).Errorf("intel: failed to create TXT RR for block reason: %s", err)
 		}
 
+		for _, origin := range lm.Origins {
+			matchedBy, err := nsutil.MakeMessageRecord(log.InfoLevel, fmt.Sprintf(
+				"%s matched %s",
+				lm.Entity,
+				origin.String(),
+			))
+			if err == nil {
+				rrs = append(rrs, matchedBy)
+			} else {
+				log.Tracer(ctx).Errorf("intel: failed to create TXT RR for block reason: %s", err)
+			}
+		}
+
 		if len(lm.InactiveLists) > 0 {
 			wouldBeBlockedBy, err := nsutil.MakeMessageRecord(log.InfoLevel, fmt.Sprintf(
 				"%s would be blocked by filter lists %s",
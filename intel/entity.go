@@ -86,6 +86,19 @@ type Entity struct {
 	// to a list of sources where the entity has been observed in.
 	ListOccurences map[string][]string
 
+	// listEntityTypes maps the keys of ListOccurences to the filter list
+	// entity type they were looked up as.
+	listEntityTypes map[string]string
+
+	// listOrigins caches the filter list origins of the keys of
+	// ListOccurences of all lists, as the block reason is built for
+	// every list match.
+	listOrigins map[string][]filterlists.ListOrigin
+
+	// listExceptions holds the filter list exceptions that apply to
+	// endpoint rules that reference filter lists.
+	listExceptions []*ListExceptions
+
 	// we only load each data above at most once
 	fetchLocationOnce  sync.Once
 	reverseResolveOnce sync.Once
@@ -101,6 +114,46 @@ func (e *Entity) Init() *Entity {
 	return e
 }
 
+// Copy returns a copy of the entity data. Slices and maps are copied too,
+// so that later changes to the entity do not affect the copy. The internal
+// state used to load lists and location data is not copied. The caller must
+// hold the lock of the entity.
+func (e *Entity) Copy() *Entity {
+	c := &Entity{
+		Protocol:        e.Protocol,
+		Port:            e.Port,
+		dstPort:         e.dstPort,
+		Domain:          e.Domain,
+		ReverseDomain:   e.ReverseDomain,
+		CNAME:           copyStringSlice(e.CNAME),
+		IPScope:         e.IPScope,
+		Country:         e.Country,
+		ASN:             e.ASN,
+		ASOrg:           e.ASOrg,
+		location:        e.location,
+		BlockedByLists:  copyStringSlice(e.BlockedByLists),
+		BlockedEntities: copyStringSlice(e.BlockedEntities),
+	}
+	if e.IP != nil {
+		c.IP = make(net.IP, len(e.IP))
+		copy(c.IP, e.IP)
+	}
+	if e.ListOccurences != nil {
+		c.ListOccurences = make(map[string][]string, len(e.ListOccurences))
+		for key, lists := range e.ListOccurences {
+			c.ListOccurences[key] = copyStringSlice(lists)
+		}
+	}
+	if e.listEntityTypes != nil {
+		c.listEntityTypes = make(map[string]string, len(e.listEntityTypes))
+		for key, entityType := range e.listEntityTypes {
+			c.listEntityTypes[key] = entityType
+		}
+	}
+
+	return c
+}
+
 // SetIP sets the IP address together with its network scope.
 func (e *Entity) SetIP(ip net.IP) {
 	e.IP = ip
@@ -133,6 +186,8 @@ func (e *Entity) ResetLists() {
 	e.BlockedByLists = nil
 	e.BlockedEntities = nil
 	e.ListOccurences = nil
+	e.listEntityTypes = nil
+	e.listOrigins = nil
 
 	e.domainListLoaded = false
 	e.ipListLoaded = false
@@ -164,6 +219,18 @@ func (e *Entity) EnableCNAMECheck(ctx context.Context, enabled bool) {
 	e.checkCNAMEs = enabled
 }
 
+// SetListExceptions sets the filter list exceptions that apply to
+// endpoint rules that reference filter lists.
+func (e *Entity) SetListExceptions(exceptions ...*ListExceptions) {
+	e.listExceptions = exceptions
+}
+
+// ListExceptions returns the filter list exceptions that apply to
+// endpoint rules that reference filter lists.
+func (e *Entity) ListExceptions() []*ListExceptions {
+	return e.listExceptions
+}
+
 // CNAMECheckEnabled returns true if the entities CNAMEs should
 // also be checked.
 func (e *Entity) CNAMECheckEnabled() bool {
@@ -283,7 +350,7 @@ func (e *Entity) getLists(ctx context.Context) {
 	e.getCountryLists(ctx)
 }
 
-func (e *Entity) mergeList(key string, list []string) {
+func (e *Entity) mergeList(entityType, key string, list []string) {
 	if len(list) == 0 {
 		return
 	}
@@ -291,8 +358,13 @@ func (e *Entity) mergeList(key string, list []string) {
 	if e.ListOccurences == nil {
 		e.ListOccurences = make(map[string][]string)
 	}
+	if e.listEntityTypes == nil {
+		e.listEntityTypes = make(map[string]string)
+	}
 
 	e.ListOccurences[key] = mergeStringList(e.ListOccurences[key], list)
+	e.listEntityTypes[key] = entityType
+	delete(e.listOrigins, key)
 }
 
 func (e *Entity) getDomainLists(ctx context.Context) {
@@ -307,26 +379,7 @@ func (e *Entity) getDomainLists(ctx context.Context) {
 
 	var err error
 	e.loadDomainListOnce.Do(func() {
-		var domainsToInspect = []string{domain}
-
-		if e.checkCNAMEs {
-			log.Tracer(ctx).Tracef("intel: CNAME filtering enabled, checking %v too", e.CNAME)
-			domainsToInspect = append(domainsToInspect, e.CNAME...)
-		}
-
-		var domains []string
-		if e.resolveSubDomainLists {
-			for _, domain := range domainsToInspect {
-				subdomains := splitDomain(domain)
-				domains = append(domains, subdomains...)
-			}
-		} else {
-			domains = domainsToInspect
-		}
-
-		domains = makeDistinct(domains)
-
-		for _, d := range domains {
+		for _, d := range e.getDomainsToInspect(ctx, domain) {
 			log.Tracer(ctx).Tracef("intel: loading domain list for %s", d)
 			var list []string
 			list, err = filterlists.LookupDomain(d)
@@ -335,7 +388,7 @@ func (e *Entity) getDomainLists(ctx context.Context) {
 				return
 			}
 
-			e.mergeList(d, list)
+			e.mergeList("domain", d, list)
 		}
 		e.domainListLoaded = true
 	})
@@ -345,6 +398,30 @@ func (e *Entity) getDomainLists(ctx context.Context) {
 	}
 }
 
+// getDomainsToInspect returns the domains that need to be looked
+// up in the filter lists for the given domain, including CNAMEs
+// and parent domains, if enabled.
+func (e *Entity) getDomainsToInspect(ctx context.Context, domain string) []string {
+	var domainsToInspect = []string{domain}
+
+	if e.checkCNAMEs {
+		log.Tracer(ctx).Tracef("intel: CNAME filtering enabled, checking %v too", e.CNAME)
+		domainsToInspect = append(domainsToInspect, e.CNAME...)
+	}
+
+	var domains []string
+	if e.resolveSubDomainLists {
+		for _, domain := range domainsToInspect {
+			subdomains := splitDomain(domain)
+			domains = append(domains, subdomains...)
+		}
+	} else {
+		domains = domainsToInspect
+	}
+
+	return makeDistinct(domains)
+}
+
 func splitDomain(domain string) []string {
 	domain = strings.Trim(domain, ".")
 	suffix, _ := publicsuffix.PublicSuffix(domain)
@@ -392,7 +469,7 @@ func (e *Entity) getASNLists(ctx context.Context) {
 		}
 
 		e.asnListLoaded = true
-		e.mergeList(asnStr, list)
+		e.mergeList("asn", asnStr, list)
 	})
 }
 
@@ -416,7 +493,7 @@ func (e *Entity) getCountryLists(ctx context.Context) {
 		}
 
 		e.countryListLoaded = true
-		e.mergeList(country, list)
+		e.mergeList("country", country, list)
 	})
 }
 
@@ -449,7 +526,11 @@ func (e *Entity) getIPLists(ctx context.Context) {
 			return
 		}
 		e.ipListLoaded = true
-		e.mergeList(ip.String(), list)
+		if ip.To4() != nil {
+			e.mergeList("ipv4", ip.String(), list)
+		} else {
+			e.mergeList("ipv6", ip.String(), list)
+		}
 	})
 }
 
@@ -461,15 +542,72 @@ func (e *Entity) LoadLists(ctx context.Context) bool {
 	return e.ListOccurences != nil
 }
 
+// lookupListEntity looks up the details of an entity in the filter lists.
+var lookupListEntity = filterlists.LookupEntity
+
+// LookupListDetails looks up the entity in the filter lists like
+// LoadLists does, but returns the details of every single lookup.
+// It does not change the list data of the entity and is meant for
+// debugging filter list matches. Unlike LoadLists, it also looks up
+// IP addresses that are not global.
+func (e *Entity) LookupListDetails(ctx context.Context) ([]*filterlists.EntityLookup, error) {
+	type lookup struct {
+		entityType string
+		value      string
+	}
+	var lookups []lookup
+
+	if domain, ok := e.GetDomain(ctx, false /* mayUseReverseDomain */); ok {
+		for _, d := range e.getDomainsToInspect(ctx, domain) {
+			lookups = append(lookups, lookup{"domain", d})
+		}
+	}
+	if asn, ok := e.GetASN(ctx); ok {
+		lookups = append(lookups, lookup{"asn", fmt.Sprintf("%d", asn)})
+	}
+	if ip, ok := e.GetIP(); ok {
+		if ip4 := ip.To4(); ip4 != nil {
+			lookups = append(lookups, lookup{"ipv4", ip4.String()})
+		} else {
+			lookups = append(lookups, lookup{"ipv6", ip.String()})
+		}
+	}
+	if country, ok := e.GetCountry(ctx); ok {
+		lookups = append(lookups, lookup{"country", country})
+	}
+
+	results := make([]*filterlists.EntityLookup, 0, len(lookups))
+	for _, l := range lookups {
+		result, err := lookupListEntity(l.entityType, l.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s %s: %w", l.entityType, l.value, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 // MatchLists matches the entities lists against a slice
 // of source IDs and  updates various entity properties
 // like BlockedByLists, ListOccurences and BlockedEntitites.
-func (e *Entity) MatchLists(lists []string) bool {
+// If the domain or IP of the entity matches any of the given
+// exceptions, no lists are matched at all. List occurrences
+// of other entities that match an exception, like CNAMEs, are
+// skipped.
+func (e *Entity) MatchLists(lists []string, exceptions ...*ListExceptions) bool {
 	e.BlockedByLists = nil
 	e.BlockedEntities = nil
 
+	if e.isListException(exceptions) {
+		return false
+	}
+
 	lm := makeMap(lists)
 	for key, keyLists := range e.ListOccurences {
+		if e.isListOccurenceException(key, exceptions) {
+			continue
+		}
+
 		for _, keyListID := range keyLists {
 			if _, ok := lm[keyListID]; ok {
 				e.BlockedByLists = append(e.BlockedByLists, keyListID)
@@ -484,6 +622,35 @@ func (e *Entity) MatchLists(lists []string) bool {
 	return len(e.BlockedByLists) > 0
 }
 
+// isListException returns whether the domain or IP of the
+// entity matches any of the given exceptions.
+func (e *Entity) isListException(exceptions []*ListExceptions) bool {
+	for _, le := range exceptions {
+		if le.MatchDomain(e.Domain) || le.MatchIP(e.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// isListOccurenceException returns whether the given key of
+// ListOccurences matches any of the given exceptions.
+func (e *Entity) isListOccurenceException(key string, exceptions []*ListExceptions) bool {
+	for _, le := range exceptions {
+		switch e.listEntityTypes[key] {
+		case "domain":
+			if le.MatchDomain(key) {
+				return true
+			}
+		case "ipv4", "ipv6":
+			if le.MatchIP(net.ParseIP(key)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ListBlockReason returns the block reason for this entity.
 func (e *Entity) ListBlockReason() ListBlockReason {
 	blockedBy := make([]ListMatch, len(e.BlockedEntities))
@@ -507,6 +674,7 @@ func (e *Entity) ListBlockReason() ListBlockReason {
 				Entity:        blockedEntity,
 				ActiveLists:   activeLists,
 				InactiveLists: inactiveLists,
+				Origins:       e.getListOrigins(blockedEntity, activeLists),
 			}
 		}
 	}
@@ -514,6 +682,42 @@ func (e *Entity) ListBlockReason() ListBlockReason {
 	return blockedBy
 }
 
+// lookupListOrigins looks up the filter list origins of an entity.
+var lookupListOrigins = filterlists.LookupOrigins
+
+// getListOrigins returns where the given key of ListOccurences
+// was found in the given lists.
+func (e *Entity) getListOrigins(key string, lists []string) []filterlists.ListOrigin {
+	entityType, ok := e.listEntityTypes[key]
+	if !ok {
+		return nil
+	}
+
+	origins, ok := e.listOrigins[key]
+	if !ok {
+		var err error
+		origins, err = lookupListOrigins(entityType, key)
+		if err != nil {
+			log.Warningf("intel: failed to get filter list origins of %s: %s", key, err)
+			return nil
+		}
+
+		if e.listOrigins == nil {
+			e.listOrigins = make(map[string][]filterlists.ListOrigin)
+		}
+		e.listOrigins[key] = origins
+	}
+
+	lm := makeMap(lists)
+	active := make([]filterlists.ListOrigin, 0, len(origins))
+	for _, origin := range origins {
+		if _, ok := lm[origin.Source]; ok {
+			active = append(active, origin)
+		}
+	}
+	return active
+}
+
 func mergeStringList(a, b []string) []string {
 	listMap := make(map[string]struct{})
 	for _, s := range a {
@@ -531,6 +735,15 @@ func mergeStringList(a, b []string) []string {
 	return res
 }
 
+func copyStringSlice(slice []string) []string {
+	if slice == nil {
+		return nil
+	}
+	c := make([]string, len(slice))
+	copy(c, slice)
+	return c
+}
+
 func makeDistinct(slice []string) []string {
 	m := make(map[string]struct{}, len(slice))
 	result := make([]string, 0, len(slice))
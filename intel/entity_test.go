@@ -0,0 +1,287 @@
+package intel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/safing/portmaster/intel/filterlists"
+)
+
+// newListTestEntity returns an entity with list occurrences of its domain,
+// CNAME and IP.
+func newListTestEntity() *Entity {
+	e := &Entity{
+		Domain: "www.example.com.",
+		CNAME:  []string{"cdn.example.net."},
+	}
+	e.SetIP(net.ParseIP("192.0.2.1"))
+	e.mergeList("domain", "www.example.com.", []string{"A"})
+	e.mergeList("domain", "cdn.example.net.", []string{"B"})
+	e.mergeList("ipv4", "192.0.2.1", []string{"A", "C"})
+	return e
+}
+
+func mustParseListExceptions(t *testing.T, entries ...string) *ListExceptions {
+	t.Helper()
+
+	le, err := ParseListExceptions(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return le
+}
+
+func TestMatchLists(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name       string
+		lists      []string
+		exceptions []*ListExceptions
+		blockedBy  []string
+		entities   []string
+	}{
+		{
+			name:      "all lists",
+			lists:     []string{"A", "B", "C"},
+			blockedBy: []string{"A", "B", "C"},
+			entities:  []string{"192.0.2.1", "cdn.example.net.", "www.example.com."},
+		},
+		{
+			name:      "other lists",
+			lists:     []string{"D"},
+			blockedBy: nil,
+		},
+		{
+			name:       "empty exceptions",
+			lists:      []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{nil, mustParseListExceptions(t)},
+			blockedBy:  []string{"A", "B", "C"},
+			entities:   []string{"192.0.2.1", "cdn.example.net.", "www.example.com."},
+		},
+		{
+			name:       "domain exception",
+			lists:      []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, "www.example.com")},
+			blockedBy:  nil,
+		},
+		{
+			name:       "domain suffix exception",
+			lists:      []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, "*.example.com")},
+			blockedBy:  nil,
+		},
+		{
+			name:       "IP exception",
+			lists:      []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, "192.0.2.0/24")},
+			blockedBy:  nil,
+		},
+		{
+			name:  "exception in second exceptions",
+			lists: []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{
+				mustParseListExceptions(t, "example.org"),
+				mustParseListExceptions(t, "192.0.2.1"),
+			},
+			blockedBy: nil,
+		},
+		{
+			name:       "CNAME exception",
+			lists:      []string{"A", "B", "C"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, ".example.net")},
+			blockedBy:  []string{"A", "C"},
+			entities:   []string{"192.0.2.1", "www.example.com."},
+		},
+		{
+			name:       "CNAME exception of only matching list",
+			lists:      []string{"B"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, "*.example.net")},
+			blockedBy:  nil,
+		},
+		{
+			name:       "unrelated exceptions",
+			lists:      []string{"B"},
+			exceptions: []*ListExceptions{mustParseListExceptions(t, "example.net", "192.0.2.2")},
+			blockedBy:  []string{"B"},
+			entities:   []string{"cdn.example.net."},
+		},
+	} {
+		e := newListTestEntity()
+		// Results of earlier matches must be reset.
+		e.BlockedByLists = []string{"X"}
+		e.BlockedEntities = []string{"x.example.com."}
+
+		blocked := e.MatchLists(test.lists, test.exceptions...)
+		if blocked != (len(test.blockedBy) > 0) {
+			t.Errorf("%s: expected blocked to be %v", test.name, !blocked)
+		}
+
+		sort.Strings(e.BlockedByLists)
+		sort.Strings(e.BlockedEntities)
+		if strings.Join(e.BlockedByLists, ",") != strings.Join(test.blockedBy, ",") {
+			t.Errorf("%s: expected to be blocked by %v, got %v", test.name, test.blockedBy, e.BlockedByLists)
+		}
+		if strings.Join(e.BlockedEntities, ",") != strings.Join(test.entities, ",") {
+			t.Errorf("%s: expected blocked entities %v, got %v", test.name, test.entities, e.BlockedEntities)
+		}
+	}
+}
+
+func TestListOriginsAreCached(t *testing.T) {
+	lookups := make(map[string]int)
+	previous := lookupListOrigins
+	t.Cleanup(func() {
+		lookupListOrigins = previous
+	})
+	lookupListOrigins = func(entityType, value string) ([]filterlists.ListOrigin, error) {
+		lookups[entityType+" "+value]++
+		return []filterlists.ListOrigin{
+			{Source: "A", Line: 1, Entry: value},
+			{Source: "C", Line: 2, Entry: value},
+		}, nil
+	}
+
+	e := newListTestEntity()
+	for _, lists := range [][]string{
+		{"A"},
+		{"A", "C"},
+		{"C"},
+	} {
+		if !e.MatchLists(lists) {
+			t.Fatalf("%v: expected entity to be blocked", lists)
+		}
+
+		for _, match := range e.ListBlockReason() {
+			if len(match.Origins) != len(match.ActiveLists) {
+				t.Errorf("%v: expected origins of active lists %v, got %v", lists, match.ActiveLists, match.Origins)
+			}
+			for _, origin := range match.Origins {
+				if origin.Entry != match.Entity {
+					t.Errorf("%v: origin %v does not belong to %s", lists, origin, match.Entity)
+				}
+			}
+		}
+	}
+
+	// Every entity is only looked up once for all lists.
+	for key, n := range lookups {
+		if n != 1 {
+			t.Errorf("%s was looked up %d times", key, n)
+		}
+	}
+	if lookups["ipv4 192.0.2.1"] != 1 || lookups["domain www.example.com."] != 1 {
+		t.Errorf("unexpected lookups: %v", lookups)
+	}
+
+	// Reloading the lists of an entity looks its origins up again.
+	e.mergeList("ipv4", "192.0.2.1", []string{"D"})
+	e.MatchLists([]string{"D"})
+	e.ListBlockReason()
+	if lookups["ipv4 192.0.2.1"] != 2 {
+		t.Errorf("expected origins of reloaded list to be looked up again, got %d lookups", lookups["ipv4 192.0.2.1"])
+	}
+}
+
+func TestLookupListDetails(t *testing.T) {
+	previous := lookupListEntity
+	t.Cleanup(func() {
+		lookupListEntity = previous
+	})
+	var lookupErr error
+	lookupListEntity = func(entityType, value string) (*filterlists.EntityLookup, error) {
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return &filterlists.EntityLookup{
+			Type:  entityType,
+			Value: value,
+		}, nil
+	}
+
+	for _, test := range []struct {
+		name       string
+		entity     *Entity
+		ip         string
+		subDomains bool
+		cnames     bool
+		expected   []string
+	}{
+		{
+			name: "all entity types",
+			entity: &Entity{
+				Domain:  "www.ads.example.com.",
+				CNAME:   []string{"cdn.example.net."},
+				ASN:     64500,
+				Country: "AT",
+			},
+			ip:         "192.0.2.1",
+			subDomains: true,
+			cnames:     true,
+			expected: []string{
+				"domain www.ads.example.com.",
+				"domain ads.example.com.",
+				"domain example.com.",
+				"domain cdn.example.net.",
+				"domain example.net.",
+				"asn 64500",
+				"ipv4 192.0.2.1",
+				"country AT",
+			},
+		},
+		{
+			name: "without sub domains and CNAMEs",
+			entity: &Entity{
+				Domain: "www.ads.example.com.",
+				CNAME:  []string{"cdn.example.net."},
+			},
+			ip: "192.0.2.1",
+			expected: []string{
+				"domain www.ads.example.com.",
+				"ipv4 192.0.2.1",
+			},
+		},
+		{
+			name:     "IPv6 address",
+			entity:   &Entity{},
+			ip:       "2001:db8::1",
+			expected: []string{"ipv6 2001:db8::1"},
+		},
+	} {
+		e := test.entity
+		e.resolveSubDomainLists = test.subDomains
+		e.checkCNAMEs = test.cnames
+		// Do not look up the location of the test addresses.
+		e.fetchLocationOnce.Do(func() {})
+		e.SetIP(net.ParseIP(test.ip))
+
+		results, err := e.LookupListDetails(context.Background())
+		if err != nil {
+			t.Errorf("%s: failed to look up list details: %s", test.name, err)
+			continue
+		}
+		lookups := make([]string, 0, len(results))
+		for _, result := range results {
+			lookups = append(lookups, result.Type+" "+result.Value)
+		}
+		if strings.Join(lookups, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected lookups %v, got %v", test.name, test.expected, lookups)
+		}
+
+		// The list data of the entity is not changed.
+		if e.ListOccurences != nil || e.BlockedByLists != nil {
+			t.Errorf("%s: list data of entity was changed", test.name)
+		}
+	}
+
+	// Lookup errors are returned.
+	lookupErr = filterlists.ErrNotLoaded
+	e := &Entity{Domain: "example.com."}
+	if _, err := e.LookupListDetails(context.Background()); !errors.Is(err, filterlists.ErrNotLoaded) {
+		t.Errorf("expected lookup error to be returned, got %v", err)
+	}
+}
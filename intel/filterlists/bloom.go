@@ -1,17 +1,86 @@
 package filterlists
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/tannerryan/ring"
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
 )
 
-var defaultFilter = newScopedBloom()
+var defaultFilter = newScopedBloom(nil)
+
+// bloomFormatVersion is the version of the format in which bloom filters are
+// persisted in the cache database. Persisted filters of other versions are
+// discarded and rebuilt.
+const bloomFormatVersion = 1
+
+// bloomCapacityHeadroom is applied to the entry counts when sizing the bloom
+// filters, in order to leave room for updates and custom filter lists.
+const bloomCapacityHeadroom = 1.25
+
+// bloomScopes holds all entity types that have a bloom filter.
+var bloomScopes = []string{"domain", "asn", "country", "ipv4", "ipv6"}
+
+// defaultBloomCapacities holds the minimum capacity of the bloom filter of
+// each scope.
+var defaultBloomCapacities = map[string]int{
+	"domain":  domainBfSize,
+	"asn":     asnBfSize,
+	"country": countryBfSize,
+	"ipv4":    ipv4BfSize,
+	"ipv6":    ipv6BfSize,
+}
+
+var errBloomMismatch = errors.New("persisted bloom filter does not match")
+
+// bloomStats holds the statistics of the bloom filter of a scope.
+type bloomStats struct {
+	// entries is the number of distinct values added to the filter. Values
+	// that the filter already reported as present are not counted. It must
+	// be accessed atomically.
+	entries uint64
+	// capacity is the number of values the filter was sized for.
+	capacity int
+}
+
+func (s *bloomStats) getEntries() uint64 {
+	return atomic.LoadUint64(&s.entries)
+}
+
+// saturation returns the ratio of entries to the capacity of the filter.
+// Values above 1 mean that the filter holds more entries than it was sized
+// for and reports considerably more false positives.
+func (s *bloomStats) saturation() float64 {
+	if s.capacity <= 0 {
+		return 0
+	}
+	return float64(s.getEntries()) / float64(s.capacity)
+}
+
+// estimatedFalsePositiveRate estimates the current false positive rate of
+// the filter from its number of entries. The filter is sized for
+// bfFalsePositiveRate at capacity.
+func (s *bloomStats) estimatedFalsePositiveRate() float64 {
+	n := float64(s.getEntries())
+	c := float64(s.capacity)
+	if n == 0 || c <= 0 {
+		return 0
+	}
+
+	// Derive the number of bits and hash functions the same way the filter
+	// is initialized.
+	m := math.Ceil(-c * math.Log(bfFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Ceil(m/c*math.Ln2))
+	return math.Pow(1-math.Exp(-k*n/m), k)
+}
 
 // scopedBloom is a wrapper around a bloomfilter implementation
 // providing scoped filters for different entity types.
@@ -22,9 +91,16 @@ type scopedBloom struct {
 	country *ring.Ring
 	ipv4    *ring.Ring
 	ipv6    *ring.Ring
+
+	// stats holds the statistics of each scope. The map itself is never
+	// modified after creation, only replaced as a whole.
+	stats map[string]*bloomStats
 }
 
-func newScopedBloom() *scopedBloom {
+// newScopedBloom returns new bloom filters sized for the given number of
+// elements per scope. Missing or too small capacities are replaced with
+// the defaults.
+func newScopedBloom(capacities map[string]int) *scopedBloom {
 	mustInit := func(size int) *ring.Ring {
 		f, err := ring.Init(size, bfFalsePositiveRate)
 		if err != nil {
@@ -35,13 +111,46 @@ func newScopedBloom() *scopedBloom {
 		}
 		return f
 	}
+
+	stats := make(map[string]*bloomStats, len(bloomScopes))
+	for _, scope := range bloomScopes {
+		capacity := defaultBloomCapacities[scope]
+		if capacities[scope] > capacity {
+			capacity = capacities[scope]
+		}
+		stats[scope] = &bloomStats{capacity: capacity}
+	}
+
 	return &scopedBloom{
-		domain:  mustInit(domainBfSize),
-		asn:     mustInit(asnBfSize),
-		country: mustInit(countryBfSize),
-		ipv4:    mustInit(ipv4BfSize),
-		ipv6:    mustInit(ipv6BfSize),
+		domain:  mustInit(stats["domain"].capacity),
+		asn:     mustInit(stats["asn"].capacity),
+		country: mustInit(stats["country"].capacity),
+		ipv4:    mustInit(stats["ipv4"].capacity),
+		ipv6:    mustInit(stats["ipv6"].capacity),
+		stats:   stats,
+	}
+}
+
+// getBloomCapacities returns the number of elements the bloom filters of a
+// full rebuild should be sized for. The entry counts of the list index are
+// used, if available. Otherwise, the entries of the bloom filters in use are
+// counted.
+func getBloomCapacities() map[string]int {
+	var counts map[string]int
+	if index, err := getListIndexFromCache(); err == nil {
+		index.RLock()
+		counts = index.EntryCounts
+		index.RUnlock()
 	}
+	if len(counts) == 0 {
+		counts = defaultFilter.getEntryCounts()
+	}
+
+	capacities := make(map[string]int, len(counts))
+	for scope, count := range counts {
+		capacities[strings.ToLower(scope)] = int(float64(count) * bloomCapacityHeadroom)
+	}
+	return capacities
 }
 
 func (bf *scopedBloom) getBloomForType(entityType string) (*ring.Ring, error) {
@@ -80,7 +189,15 @@ func (bf *scopedBloom) add(scope, value string) {
 		return
 	}
 
-	r.Add([]byte(value))
+	// Only count values that are not in the filter yet. This misses values
+	// that are false positives, which is negligible as long as the filter
+	// is not saturated.
+	data := []byte(value)
+	isNew := !r.Test(data)
+	r.Add(data)
+	if stats, ok := bf.stats[strings.ToLower(scope)]; ok && isNew {
+		atomic.AddUint64(&stats.entries, 1)
+	}
 }
 
 func (bf *scopedBloom) test(scope, value string) bool {
@@ -96,26 +213,74 @@ func (bf *scopedBloom) test(scope, value string) bool {
 	return r.Test([]byte(value))
 }
 
-func (bf *scopedBloom) loadFromCache() error {
-	bf.rw.Lock()
-	defer bf.rw.Unlock()
+// getStats returns the statistics of the bloom filter of the given scope.
+func (bf *scopedBloom) getStats(scope string) (*bloomStats, bool) {
+	bf.rw.RLock()
+	defer bf.rw.RUnlock()
 
-	if err := loadBloomFromCache(bf.domain, "domain"); err != nil {
-		return err
-	}
-	if err := loadBloomFromCache(bf.asn, "asn"); err != nil {
-		return err
+	stats, ok := bf.stats[scope]
+	return stats, ok
+}
+
+// getEntryCounts returns the number of entries of each scope.
+func (bf *scopedBloom) getEntryCounts() map[string]int {
+	bf.rw.RLock()
+	defer bf.rw.RUnlock()
+
+	counts := make(map[string]int, len(bf.stats))
+	for scope, stats := range bf.stats {
+		counts[scope] = int(stats.getEntries())
 	}
-	if err := loadBloomFromCache(bf.country, "country"); err != nil {
-		return err
+	return counts
+}
+
+// warnIfSaturated logs a warning for every scope that holds more entries
+// than its bloom filter was sized for.
+func (bf *scopedBloom) warnIfSaturated() {
+	bf.rw.RLock()
+	defer bf.rw.RUnlock()
+
+	for _, scope := range bloomScopes {
+		stats := bf.stats[scope]
+		if stats.saturation() > 1 {
+			log.Warningf(
+				"intel/filterlists: bloom filter for %s is saturated with %d entries for a capacity of %d, estimated false positive rate is %.4f",
+				scope,
+				stats.getEntries(),
+				stats.capacity,
+				stats.estimatedFalsePositiveRate(),
+			)
+		}
 	}
-	if err := loadBloomFromCache(bf.ipv4, "ipv4"); err != nil {
-		return err
+}
+
+// loadFromCache loads the bloom filters persisted in the cache database. The
+// filters of bf are only replaced if the filters of all scopes could be
+// loaded.
+func (bf *scopedBloom) loadFromCache() error {
+	loaded := &scopedBloom{
+		stats: make(map[string]*bloomStats, len(bloomScopes)),
 	}
-	if err := loadBloomFromCache(bf.ipv6, "ipv6"); err != nil {
-		return err
+	for _, scope := range []struct {
+		name string
+		dst  **ring.Ring
+	}{
+		{"domain", &loaded.domain},
+		{"asn", &loaded.asn},
+		{"country", &loaded.country},
+		{"ipv4", &loaded.ipv4},
+		{"ipv6", &loaded.ipv6},
+	} {
+		r := new(ring.Ring)
+		stats := &bloomStats{}
+		if err := loadBloomFromCache(r, scope.name, stats); err != nil {
+			return err
+		}
+		*scope.dst = r
+		loaded.stats[scope.name] = stats
 	}
 
+	bf.replaceWith(loaded)
 	return nil
 }
 
@@ -123,19 +288,19 @@ func (bf *scopedBloom) saveToCache() error {
 	bf.rw.RLock()
 	defer bf.rw.RUnlock()
 
-	if err := saveBloomToCache(bf.domain, "domain"); err != nil {
+	if err := saveBloomToCache(bf.domain, "domain", bf.stats["domain"]); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.asn, "asn"); err != nil {
+	if err := saveBloomToCache(bf.asn, "asn", bf.stats["asn"]); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.country, "country"); err != nil {
+	if err := saveBloomToCache(bf.country, "country", bf.stats["country"]); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.ipv4, "ipv4"); err != nil {
+	if err := saveBloomToCache(bf.ipv4, "ipv4", bf.stats["ipv4"]); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.ipv6, "ipv6"); err != nil {
+	if err := saveBloomToCache(bf.ipv6, "ipv6", bf.stats["ipv6"]); err != nil {
 		return err
 	}
 
@@ -154,6 +319,54 @@ func (bf *scopedBloom) replaceWith(other *scopedBloom) {
 	bf.country = other.country
 	bf.ipv4 = other.ipv4
 	bf.ipv6 = other.ipv6
+	bf.stats = other.stats
+}
+
+// clone returns a copy of the scoped bloom filter, so that changes can be
+// staged before they are made visible with replaceWith.
+func (bf *scopedBloom) clone() (*scopedBloom, error) {
+	bf.rw.RLock()
+	defer bf.rw.RUnlock()
+
+	cloneRing := func(r *ring.Ring) (*ring.Ring, error) {
+		blob, err := r.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		clone := new(ring.Ring)
+		if err := clone.UnmarshalBinary(blob); err != nil {
+			return nil, err
+		}
+		return clone, nil
+	}
+
+	clone := &scopedBloom{
+		stats: make(map[string]*bloomStats, len(bf.stats)),
+	}
+	for scope, stats := range bf.stats {
+		clone.stats[scope] = &bloomStats{
+			entries:  stats.getEntries(),
+			capacity: stats.capacity,
+		}
+	}
+	for _, scope := range []struct {
+		src *ring.Ring
+		dst **ring.Ring
+	}{
+		{bf.domain, &clone.domain},
+		{bf.asn, &clone.asn},
+		{bf.country, &clone.country},
+		{bf.ipv4, &clone.ipv4},
+		{bf.ipv6, &clone.ipv6},
+	} {
+		r, err := cloneRing(scope.src)
+		if err != nil {
+			return nil, err
+		}
+		*scope.dst = r
+	}
+
+	return clone, nil
 }
 
 type bloomFilterRecord struct {
@@ -161,11 +374,22 @@ type bloomFilterRecord struct {
 	sync.Mutex
 
 	Filter string
+
+	// FormatVersion is the version of the format the filter is stored in.
+	FormatVersion int
+	// Checksum is the hex encoded SHA256 sum of the filter.
+	Checksum string
+	// Capacity is the number of elements the filter was sized for.
+	Capacity int
+	// Entries is the number of elements added to the filter.
+	Entries uint64
 }
 
 // loadBloomFromCache loads the bloom filter stored under scope
-// into bf.
-func loadBloomFromCache(bf *ring.Ring, scope string) error {
+// into bf and its statistics into stats. errBloomMismatch is
+// returned if the stored filter has a different format version
+// or is corrupted.
+func loadBloomFromCache(bf *ring.Ring, scope string, stats *bloomStats) error {
 	r, err := cache.Get(makeBloomCacheKey(scope))
 	if err != nil {
 		return err
@@ -185,30 +409,47 @@ func loadBloomFromCache(bf *ring.Ring, scope string) error {
 		}
 	}
 
+	if filterRecord.FormatVersion != bloomFormatVersion {
+		return fmt.Errorf("%w: %s filter has format version %d, expected %d", errBloomMismatch, scope, filterRecord.FormatVersion, bloomFormatVersion)
+	}
+
 	blob, err := hex.DecodeString(filterRecord.Filter)
 	if err != nil {
 		return err
 	}
 
+	sum := sha256.Sum256(blob)
+	if hex.EncodeToString(sum[:]) != filterRecord.Checksum {
+		return fmt.Errorf("%w: checksum of %s filter is invalid", errBloomMismatch, scope)
+	}
+
 	if err := bf.UnmarshalBinary(blob); err != nil {
 		return err
 	}
 
+	atomic.StoreUint64(&stats.entries, filterRecord.Entries)
+	stats.capacity = filterRecord.Capacity
+
 	return nil
 }
 
 // saveBloomToCache saves the bitset of the bloomfilter bf
-// in the cache db.
-func saveBloomToCache(bf *ring.Ring, scope string) error {
+// together with its statistics in the cache db.
+func saveBloomToCache(bf *ring.Ring, scope string, stats *bloomStats) error {
 	blob, err := bf.MarshalBinary()
 	if err != nil {
 		return err
 	}
 
 	filter := hex.EncodeToString(blob)
+	sum := sha256.Sum256(blob)
 
 	r := &bloomFilterRecord{
-		Filter: filter,
+		Filter:        filter,
+		FormatVersion: bloomFormatVersion,
+		Checksum:      hex.EncodeToString(sum[:]),
+		Capacity:      stats.capacity,
+		Entries:       stats.getEntries(),
 	}
 
 	r.SetKey(makeBloomCacheKey(scope))
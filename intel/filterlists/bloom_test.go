@@ -0,0 +1,212 @@
+package filterlists
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/safing/portbase/database"
+	"github.com/tannerryan/ring"
+)
+
+// putTestBloomRecord persists an empty bloom filter for scope after
+// applying modify to its record.
+func putTestBloomRecord(t *testing.T, scope string, modify func(r *bloomFilterRecord)) {
+	t.Helper()
+
+	filter, err := ring.Init(defaultBloomCapacities[scope], bfFalsePositiveRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(blob)
+
+	r := &bloomFilterRecord{
+		Filter:        hex.EncodeToString(blob),
+		FormatVersion: bloomFormatVersion,
+		Checksum:      hex.EncodeToString(sum[:]),
+		Capacity:      defaultBloomCapacities[scope],
+	}
+	modify(r)
+	r.SetKey(makeBloomCacheKey(scope))
+	if err := cache.Put(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBloomCacheRoundTrip(t *testing.T) {
+	setupTestFilterLists(t)
+
+	saved := newScopedBloom(map[string]int{"ipv4": 500})
+	saved.add("domain", "ads.example.com.")
+	saved.add("domain", "ads.example.com.")
+	saved.add("domain", "tracker.example.com.")
+	saved.add("ipv4", "192.0.2.1")
+	if err := saved.saveToCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newScopedBloom(nil)
+	if err := loaded.loadFromCache(); err != nil {
+		t.Fatalf("failed to load bloom filters: %s", err)
+	}
+	for _, value := range []string{"ads.example.com.", "tracker.example.com."} {
+		if !loaded.test("domain", value) {
+			t.Errorf("expected loaded bloom filter to contain %s", value)
+		}
+	}
+	if !loaded.test("ipv4", "192.0.2.1") {
+		t.Error("expected loaded bloom filter to contain 192.0.2.1")
+	}
+	for _, scope := range bloomScopes {
+		savedStats, _ := saved.getStats(scope)
+		loadedStats, _ := loaded.getStats(scope)
+		if loadedStats.getEntries() != savedStats.getEntries() || loadedStats.capacity != savedStats.capacity {
+			t.Errorf("%s: expected %d entries and capacity %d, got %d and %d",
+				scope, savedStats.getEntries(), savedStats.capacity, loadedStats.getEntries(), loadedStats.capacity)
+		}
+	}
+	if counts := loaded.getEntryCounts(); counts["domain"] != 2 || counts["ipv4"] != 1 {
+		t.Errorf("expected duplicates not to be counted, got %v", counts)
+	}
+
+	// Filters that do not verify are rejected as a whole. The domain filter
+	// is loaded first and must not be replaced either.
+	for _, test := range []struct {
+		name   string
+		scope  string
+		modify func(r *bloomFilterRecord)
+	}{
+		{
+			name:   "other format version",
+			scope:  "ipv6",
+			modify: func(r *bloomFilterRecord) { r.FormatVersion = bloomFormatVersion + 1 },
+		},
+		{
+			name:   "invalid checksum",
+			scope:  "ipv4",
+			modify: func(r *bloomFilterRecord) { r.Checksum = hex.EncodeToString(make([]byte, sha256.Size)) },
+		},
+		{
+			name:   "missing checksum",
+			scope:  "asn",
+			modify: func(r *bloomFilterRecord) { r.Checksum = "" },
+		},
+	} {
+		if err := saved.saveToCache(); err != nil {
+			t.Fatal(err)
+		}
+		putTestBloomRecord(t, test.scope, test.modify)
+
+		target := newScopedBloom(nil)
+		target.add("domain", "other.example.com.")
+		if err := target.loadFromCache(); !errors.Is(err, errBloomMismatch) {
+			t.Errorf("%s: expected bloom filter mismatch, got %v", test.name, err)
+		}
+		if !target.test("domain", "other.example.com.") || target.test("domain", "ads.example.com.") {
+			t.Errorf("%s: bloom filters were replaced", test.name)
+		}
+		if counts := target.getEntryCounts(); counts["domain"] != 1 {
+			t.Errorf("%s: statistics were replaced: %v", test.name, counts)
+		}
+	}
+
+	clearTestCache(t)
+	if err := loaded.loadFromCache(); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected missing bloom filters to fail, got %v", err)
+	}
+}
+
+func TestEstimatedFalsePositiveRate(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		entries  uint64
+		capacity int
+		min      float64
+		max      float64
+	}{
+		{entries: 0, capacity: 1000, min: 0, max: 0},
+		{entries: 1000, capacity: 0, min: 0, max: 0},
+		{entries: 100, capacity: 1000, min: 0, max: bfFalsePositiveRate / 100},
+		// At capacity, the filter has the false positive rate it was sized for.
+		{entries: 1000, capacity: 1000, min: bfFalsePositiveRate * 0.9, max: bfFalsePositiveRate * 1.1},
+		{entries: 1000000, capacity: 1000000, min: bfFalsePositiveRate * 0.9, max: bfFalsePositiveRate * 1.1},
+		{entries: 2000, capacity: 1000, min: bfFalsePositiveRate * 10, max: 1},
+	} {
+		stats := &bloomStats{
+			entries:  test.entries,
+			capacity: test.capacity,
+		}
+		rate := stats.estimatedFalsePositiveRate()
+		if rate < test.min || rate > test.max {
+			t.Errorf("%d entries for capacity %d: expected false positive rate between %f and %f, got %f",
+				test.entries, test.capacity, test.min, test.max, rate)
+		}
+	}
+}
+
+func TestGetBloomCapacities(t *testing.T) {
+	filter := setupTestFilterLists(t,
+		newTestListEntry("domain", "ads.example.com", "ADS"),
+		newTestListEntry("domain", "tracker.example.com", "ADS"),
+		newTestListEntry("domain", "tracker.example.com", "TRACK"),
+		newTestListEntry("domain", "malware.example.com", "MAL"),
+		newTestListEntry("domain", "phishing.example.com", "MAL"),
+		newTestListEntry("ipv4", "192.0.2.1", "ADS"),
+	)
+
+	// Without a list index, the entries of the bloom filters in use are
+	// counted.
+	expected := map[string]int{
+		"domain":  5,
+		"asn":     0,
+		"country": 0,
+		"ipv4":    1,
+		"ipv6":    0,
+	}
+	if capacities := getBloomCapacities(); !reflect.DeepEqual(capacities, expected) {
+		t.Errorf("expected capacities %v from bloom filters, got %v", expected, capacities)
+	}
+
+	// The entry counts of the list index take precedence.
+	index := &ListIndexFile{
+		EntryCounts: map[string]int{
+			"Domain": 2000000,
+			"IPv4":   10,
+		},
+	}
+	index.SetKey(filterListIndexKey)
+	if err := cache.Put(index); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]int{
+		"domain": 2500000,
+		"ipv4":   12,
+	}
+	capacities := getBloomCapacities()
+	if !reflect.DeepEqual(capacities, expected) {
+		t.Errorf("expected capacities %v from list index, got %v", expected, capacities)
+	}
+
+	// Capacities below the defaults are raised.
+	sized := newScopedBloom(capacities)
+	for scope, capacity := range map[string]int{
+		"domain": 2500000,
+		"ipv4":   ipv4BfSize,
+		"ipv6":   ipv6BfSize,
+	} {
+		if stats, _ := sized.getStats(scope); stats.capacity != capacity {
+			t.Errorf("%s: expected capacity %d, got %d", scope, capacity, stats.capacity)
+		}
+	}
+
+	if counts := filter.getEntryCounts(); counts["domain"] != 4 {
+		t.Errorf("expected bloom filter to count distinct domains, got %v", counts)
+	}
+}
@@ -0,0 +1,129 @@
+package filterlists
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+)
+
+// Configuration Keys.
+var (
+	CfgOptionCustomListsKey   = "filter/customLists"
+	cfgOptionCustomListsOrder = 36
+	cfgOptionCustomLists      config.StringArrayOption
+)
+
+// customListIDRegex defines the allowed IDs of custom filter lists.
+var customListIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+func registerConfig() error {
+	err := config.Register(&config.Option{
+		Name:        "Custom Filter Lists",
+		Key:         CfgOptionCustomListsKey,
+		Description: "Add your own filter lists from files or URLs. Custom filter lists can be activated in the filter list setting and used in rules with their ID, just like the built-in filter lists.",
+		Help: strings.ReplaceAll(`Custom filter lists are configured in the format: "id format location [name]"  
+For example: "MYADS hosts /etc/portmaster/ads.txt My Ad List"
+
+- ID: a unique ID consisting of letters, digits, "-" and "_". Use it to activate the list, or in rules like "- L:MYADS".
+- Format: one of "hosts" (hosts files), "adblock" (AdBlock rules for whole domains, like "||example.com^"), "domains" (one domain per line) and "cidr" (one IP address or network per line).
+- Location: an absolute file path, or an HTTP(S) URL. Lists from URLs are downloaded once per day.
+- Name: an optional human readable name.
+
+As with the built-in filter lists, subdomains are matched as defined by the "Block Subdomains of Filter List Entries" setting.
+`, `"`, "`"),
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelBeta,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[A-Za-z0-9_-]{1,32} (hosts|adblock|domains|cidr) [^ ]+( .+)?$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionCustomListsOrder,
+			config.CategoryAnnotation:     "Filter Lists",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionCustomLists = config.Concurrent.GetAsStringArray(CfgOptionCustomListsKey, []string{})
+
+	return nil
+}
+
+// customListSource is a custom filter list source defined in the config.
+type customListSource struct {
+	ID       string
+	Format   string
+	Location string
+	Name     string
+
+	// Definition holds the config entry the source was parsed from.
+	Definition string
+}
+
+// isURL returns whether the list is fetched from a URL.
+func (src *customListSource) isURL() bool {
+	return strings.HasPrefix(src.Location, "http://") ||
+		strings.HasPrefix(src.Location, "https://")
+}
+
+// parseCustomListSource parses a custom filter list definition.
+func parseCustomListSource(definition string) (*customListSource, error) {
+	fields := strings.Fields(definition)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected \"id format location [name]\", got %q", definition)
+	}
+
+	src := &customListSource{
+		ID:         fields[0],
+		Format:     strings.ToLower(fields[1]),
+		Location:   fields[2],
+		Name:       strings.Join(fields[3:], " "),
+		Definition: strings.Join(fields, " "),
+	}
+	if src.Name == "" {
+		src.Name = src.ID
+	}
+
+	if !customListIDRegex.MatchString(src.ID) {
+		return nil, fmt.Errorf("invalid list ID %q", src.ID)
+	}
+	switch src.Format {
+	case CustomListFormatHosts, CustomListFormatAdBlock, CustomListFormatDomains, CustomListFormatCIDR:
+	default:
+		return nil, fmt.Errorf("unsupported list format %q", src.Format)
+	}
+	if !src.isURL() && !filepath.IsAbs(src.Location) {
+		return nil, fmt.Errorf("list location %q must be an absolute path or a HTTP(S) URL", src.Location)
+	}
+
+	return src, nil
+}
+
+// getCustomListSources returns the configured custom filter list sources.
+// Invalid and duplicate definitions are logged and skipped.
+func getCustomListSources() []*customListSource {
+	definitions := cfgOptionCustomLists()
+	sources := make([]*customListSource, 0, len(definitions))
+	seen := make(map[string]struct{}, len(definitions))
+
+	for _, definition := range definitions {
+		src, err := parseCustomListSource(definition)
+		if err != nil {
+			log.Warningf("intel/filterlists: ignoring custom filter list: %s", err)
+			continue
+		}
+		if _, ok := seen[src.ID]; ok {
+			log.Warningf("intel/filterlists: ignoring custom filter list with duplicate ID %s", src.ID)
+			continue
+		}
+		seen[src.ID] = struct{}{}
+
+		sources = append(sources, src)
+	}
+
+	return sources
+}
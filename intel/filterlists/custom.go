@@ -0,0 +1,746 @@
+package filterlists
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/utils"
+)
+
+const (
+	// customListCategory is the ID of the list index category that holds the
+	// custom filter lists.
+	customListCategory = "custom"
+
+	// customListCheckInterval defines how often custom filter lists are
+	// checked for changes.
+	customListCheckInterval = time.Hour
+
+	// customListDownloadInterval defines how often custom filter lists are
+	// downloaded from their URL.
+	customListDownloadInterval = 24 * time.Hour
+
+	customListDownloadTimeout = 5 * time.Minute
+	customListMaxSize         = 64 * 1024 * 1024
+
+	// customListBatchSize is the amount of records written in a single
+	// batch.
+	customListBatchSize = 1000
+)
+
+var (
+	customListsTask *modules.Task
+
+	// prevCustomLists holds the custom filter list config that was last
+	// handled by the config change hook.
+	prevCustomLists string
+
+	customNetworks     []*customNetwork
+	customNetworksLock sync.RWMutex
+
+	customListClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				// Refresh dialer to set an authenticated local address.
+				dialer := &net.Dialer{
+					LocalAddr: getLocalAddr(network),
+					Timeout:   30 * time.Second,
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 30 * time.Second,
+		},
+	}
+)
+
+// customListsState records the custom filter lists that have been applied to
+// the cache database.
+type customListsState struct {
+	record.Base
+	sync.Mutex
+
+	Lists map[string]*customListState
+}
+
+// customListState records the state of an applied custom filter list.
+type customListState struct {
+	// Definition holds the config entry of the list.
+	Definition string
+	// Fingerprint is the SHA256 sum of the applied list file.
+	Fingerprint string
+	// FetchedAt holds when the list was last downloaded, if it has a URL.
+	FetchedAt int64
+	// AppliedAt holds when the list entries were last written.
+	AppliedAt int64
+	// Entities holds the amount of domains and IP addresses of the list.
+	Entities int
+	// Networks holds the IP networks of the list. They are not stored as
+	// entities, but matched in memory.
+	Networks []*customListNetwork
+}
+
+// customListNetwork is an IP network of a custom filter list.
+type customListNetwork struct {
+	// CIDR holds the network in CIDR notation.
+	CIDR string
+	// Origin holds where the network was found in the list.
+	Origin ListOrigin
+}
+
+// customNetwork is an IP network of one or more custom filter lists.
+type customNetwork struct {
+	network *net.IPNet
+	sources []string
+	origins []ListOrigin
+}
+
+func startCustomLists() {
+	prevCustomLists = strings.Join(cfgOptionCustomLists(), "\n")
+
+	customListsTask = module.NewTask("update custom filter lists", func(ctx context.Context, _ *modules.Task) error {
+		return updateCustomLists(ctx)
+	}).Repeat(customListCheckInterval).Queue()
+}
+
+// handleCustomListsConfigChange queues an update of the custom filter lists if
+// their config changed.
+func handleCustomListsConfigChange(_ context.Context, _ interface{}) error {
+	customLists := strings.Join(cfgOptionCustomLists(), "\n")
+	if customLists == prevCustomLists {
+		return nil
+	}
+	prevCustomLists = customLists
+
+	if customListsTask != nil {
+		customListsTask.Queue()
+	}
+	return nil
+}
+
+// updateCustomLists checks the custom filter lists for changes and applies
+// them.
+func updateCustomLists(ctx context.Context) error {
+	listUpdateLock.Lock()
+	defer listUpdateLock.Unlock()
+
+	return applyCustomLists(ctx, false)
+}
+
+// pendingCustomList is a custom filter list that needs to be applied.
+type pendingCustomList struct {
+	src         *customListSource
+	path        string
+	fingerprint string
+	fetchedAt   int64
+}
+
+// applyCustomLists applies all new and changed custom filter lists and
+// removes the entries of changed and removed lists. If force is set, all
+// lists are applied, as needed after the built-in filter lists were updated.
+// The caller must hold listUpdateLock.
+func applyCustomLists(ctx context.Context, force bool) error {
+	sources := getCustomListSources()
+
+	// Skip lists that would shadow built-in lists.
+	index, err := getListIndexFromCache()
+	if err == nil {
+		sources = index.withoutBuiltInIDs(sources)
+	}
+
+	state, err := getCustomListsState()
+	if err != nil {
+		return fmt.Errorf("failed to load custom filter list state: %w", err)
+	}
+
+	var (
+		newLists   = make(map[string]*customListState, len(sources))
+		configured = make(map[string]struct{}, len(sources))
+		removeFrom []string
+		pending    []*pendingCustomList
+	)
+	for _, src := range sources {
+		configured[src.ID] = struct{}{}
+		prev := state.Lists[src.ID]
+		if prev != nil && prev.Definition != src.Definition {
+			// The definition changed, start from scratch.
+			removeFrom = append(removeFrom, src.ID)
+			prev = nil
+		}
+
+		path, fetchedAt, err := getCustomListFile(ctx, src, prev)
+		if err == nil {
+			var fingerprint string
+			fingerprint, err = fingerprintFile(path)
+			if err == nil {
+				switch {
+				case prev != nil && prev.Fingerprint == fingerprint && !force:
+					// Nothing changed.
+					prev.FetchedAt = fetchedAt
+					newLists[src.ID] = prev
+				case prev != nil && prev.Fingerprint != fingerprint:
+					removeFrom = append(removeFrom, src.ID)
+					fallthrough
+				default:
+					pending = append(pending, &pendingCustomList{
+						src:         src,
+						path:        path,
+						fingerprint: fingerprint,
+						fetchedAt:   fetchedAt,
+					})
+				}
+				continue
+			}
+		}
+
+		// Keep the applied entries of the list, if the list is unavailable.
+		log.Warningf("intel/filterlists: failed to get custom filter list %s: %s", src.ID, err)
+		if prev != nil {
+			newLists[src.ID] = prev
+		}
+	}
+	for id := range state.Lists {
+		if _, ok := configured[id]; !ok {
+			removeFrom = append(removeFrom, id)
+		}
+	}
+
+	// Remove entries of changed and removed lists.
+	if len(removeFrom) > 0 {
+		if err := removeCustomListEntries(ctx, removeFrom); err != nil {
+			return fmt.Errorf("failed to remove entries of custom filter lists %v: %w", removeFrom, err)
+		}
+		log.Infof("intel/filterlists: removed entries of custom filter lists %v", removeFrom)
+	}
+
+	// Apply new and changed lists.
+	for _, list := range pending {
+		listState, err := applyCustomList(ctx, list)
+		if err != nil {
+			log.Warningf("intel/filterlists: failed to apply custom filter list %s: %s", list.src.ID, err)
+			continue
+		}
+		newLists[list.src.ID] = listState
+		log.Infof("intel/filterlists: applied custom filter list %s with %d entities and %d networks", list.src.ID, listState.Entities, len(listState.Networks))
+	}
+	setCustomNetworks(newLists)
+
+	if len(pending) > 0 {
+		if err := defaultFilter.saveToCache(); err != nil {
+			log.Errorf("intel/filterlists: failed to persist bloom filters in cache database: %s", err)
+		}
+	}
+
+	state.Lists = newLists
+	if err := cache.Put(state); err != nil {
+		return fmt.Errorf("failed to save custom filter list state: %w", err)
+	}
+
+	if err := updateCustomListIndex(sources); err != nil {
+		log.Warningf("intel/filterlists: failed to add custom filter lists to index: %s", err)
+	}
+
+	return nil
+}
+
+func getCustomListsState() (*customListsState, error) {
+	r, err := cache.Get(customListsStateKey)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			state := &customListsState{
+				Lists: make(map[string]*customListState),
+			}
+			state.SetKey(customListsStateKey)
+			return state, nil
+		}
+		return nil, err
+	}
+
+	var state *customListsState
+	if r.IsWrapped() {
+		state = new(customListsState)
+		if err := record.Unwrap(r, state); err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		state, ok = r.(*customListsState)
+		if !ok {
+			return nil, fmt.Errorf("invalid type, expected customListsState but got %T", r)
+		}
+	}
+
+	if state.Lists == nil {
+		state.Lists = make(map[string]*customListState)
+	}
+	return state, nil
+}
+
+// getCustomListFile returns the path of the file of the given list. Lists
+// with a URL are downloaded if their download is outdated.
+func getCustomListFile(ctx context.Context, src *customListSource, prev *customListState) (path string, fetchedAt int64, err error) {
+	if !src.isURL() {
+		return src.Location, 0, nil
+	}
+
+	dir := dataroot.Root().ChildDir("intel", 0755).ChildDir("custom-lists", 0755)
+	if err := dir.Ensure(); err != nil {
+		return "", 0, err
+	}
+	path = filepath.Join(dir.Path, src.ID)
+
+	// Use the previous download, if it is recent enough.
+	var havePrevDownload bool
+	if prev != nil {
+		if _, err := os.Stat(path); err == nil {
+			havePrevDownload = true
+		}
+	}
+	if havePrevDownload && time.Since(time.Unix(prev.FetchedAt, 0)) < customListDownloadInterval {
+		return path, prev.FetchedAt, nil
+	}
+
+	if err := downloadCustomList(ctx, src.Location, path); err != nil {
+		if havePrevDownload {
+			log.Warningf("intel/filterlists: failed to download custom filter list %s, using previous download: %s", src.ID, err)
+			return path, prev.FetchedAt, nil
+		}
+		return "", 0, err
+	}
+	return path, time.Now().Unix(), nil
+}
+
+// downloadCustomList downloads the list at the given URL to the given path.
+// The connection uses a permitted local port, so that it is not subject to
+// the filter.
+func downloadCustomList(ctx context.Context, url, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, customListDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := customListClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	tmpPath := path + ".download"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, customListMaxSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > customListMaxSize {
+		err = fmt.Errorf("list exceeds maximum size of %d bytes", customListMaxSize)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// fingerprintFile returns the hex encoded SHA256 sum of the given file.
+func fingerprintFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// applyCustomList adds the entities of the given list to the bloom filters
+// and the entity records. Existing records are merged.
+func applyCustomList(ctx context.Context, list *pendingCustomList) (*customListState, error) {
+	f, err := os.Open(list.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	listState := &customListState{
+		Definition:  list.src.Definition,
+		Fingerprint: list.fingerprint,
+		FetchedAt:   list.fetchedAt,
+		AppliedAt:   time.Now().Unix(),
+	}
+	batch := newRecordBatch()
+	seen := make(map[string]struct{})
+
+	var parser *customListParser
+	parser = &customListParser{
+		addEntity: func(entityType, value string) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			key := makeListCacheKey(entityType, value)
+			if _, ok := seen[key]; ok {
+				return nil
+			}
+			seen[key] = struct{}{}
+
+			origin := newCustomListOrigin(list.src.ID, parser.lineNumber, parser.line)
+			r, err := mergeCustomListEntity(key, entityType, value, origin)
+			if err != nil {
+				return err
+			}
+			r.UpdatedAt = listState.AppliedAt
+			defaultFilter.add(entityType, value)
+			listState.Entities++
+
+			return batch.put(r)
+		},
+		addNetwork: func(network *net.IPNet) error {
+			listState.Networks = append(listState.Networks, &customListNetwork{
+				CIDR:   network.String(),
+				Origin: newCustomListOrigin(list.src.ID, parser.lineNumber, parser.line),
+			})
+			return nil
+		},
+	}
+
+	err = parser.parse(f, list.src.Format)
+	if flushErr := batch.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return listState, nil
+}
+
+// mergeCustomListEntity returns the entity record for the given key with the
+// source of the given origin added. A new record is created if none exists.
+func mergeCustomListEntity(key, entityType, value string, origin ListOrigin) (*entityRecord, error) {
+	// The bloom filter does not have false negatives, so there is no need to
+	// check the database if the filter does not match.
+	if defaultFilter.test(entityType, value) {
+		r, err := getEntityRecordByKey(key)
+		switch {
+		case err == nil:
+			if !utils.StringInSlice(r.Sources, origin.Source) {
+				r.Sources = append(r.Sources, origin.Source)
+			}
+			r.setOrigin(origin)
+			return r, nil
+		case !errors.Is(err, database.ErrNotFound):
+			return nil, err
+		}
+	}
+
+	r := &entityRecord{
+		Value:   value,
+		Type:    entityType,
+		Sources: []string{origin.Source},
+		Origins: []ListOrigin{origin},
+	}
+	r.SetKey(key)
+	return r, nil
+}
+
+// removeCustomListEntries removes the given sources from all entity records.
+// Records without any remaining source are deleted.
+func removeCustomListEntries(ctx context.Context, sourceIDs []string) error {
+	iter, err := cache.Query(query.New(filterListKeyPrefix))
+	if err != nil {
+		return err
+	}
+
+	// Collect changed records first, in order to not write to the database
+	// while iterating.
+	var changed []*entityRecord
+	for r := range iter.Next {
+		if ctx.Err() != nil {
+			iter.Cancel()
+			return ctx.Err()
+		}
+
+		e, err := ensureEntityRecord(r)
+		if err != nil {
+			log.Warningf("intel/filterlists: failed to parse entity record %s: %s", r.Key(), err)
+			continue
+		}
+
+		sources := make([]string, 0, len(e.Sources))
+		for _, source := range e.Sources {
+			if !utils.StringInSlice(sourceIDs, source) {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) == len(e.Sources) {
+			continue
+		}
+
+		origins := make([]ListOrigin, 0, len(e.Origins))
+		for _, origin := range e.Origins {
+			if !utils.StringInSlice(sourceIDs, origin.Source) {
+				origins = append(origins, origin)
+			}
+		}
+
+		e.Sources = sources
+		e.Origins = origins
+		if len(sources) == 0 {
+			e.CreateMeta()
+			e.Meta().Delete()
+		}
+		changed = append(changed, e)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	batch := newRecordBatch()
+	for _, e := range changed {
+		if err := batch.put(e); err != nil {
+			_ = batch.flush()
+			return err
+		}
+	}
+	return batch.flush()
+}
+
+// recordBatch writes records to the cache database in batches.
+type recordBatch struct {
+	db    *database.Interface
+	write func(record.Record) error
+	count int
+}
+
+func newRecordBatch() *recordBatch {
+	return &recordBatch{
+		db: database.NewInterface(&database.Options{Local: true, Internal: true}),
+	}
+}
+
+func (b *recordBatch) put(r record.Record) error {
+	if b.write == nil {
+		b.write = b.db.PutMany("cache")
+	}
+	if err := b.write(r); err != nil {
+		b.write = nil
+		return err
+	}
+
+	b.count++
+	if b.count%customListBatchSize == 0 {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *recordBatch) flush() error {
+	if b.write == nil {
+		return nil
+	}
+	write := b.write
+	b.write = nil
+	return write(nil)
+}
+
+// setCustomNetworks sets the IP networks of the given custom filter lists
+// for lookups.
+func setCustomNetworks(lists map[string]*customListState) {
+	ids := make([]string, 0, len(lists))
+	for id := range lists {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var networks []*customNetwork
+	byNetwork := make(map[string]*customNetwork)
+	for _, id := range ids {
+		for _, listNetwork := range lists[id].Networks {
+			if n, ok := byNetwork[listNetwork.CIDR]; ok {
+				if !utils.StringInSlice(n.sources, id) {
+					n.sources = append(n.sources, id)
+				}
+				n.origins = append(n.origins, listNetwork.Origin)
+				continue
+			}
+
+			_, network, err := net.ParseCIDR(listNetwork.CIDR)
+			if err != nil {
+				continue
+			}
+			n := &customNetwork{
+				network: network,
+				sources: []string{id},
+				origins: []ListOrigin{listNetwork.Origin},
+			}
+			byNetwork[listNetwork.CIDR] = n
+			networks = append(networks, n)
+		}
+	}
+
+	customNetworksLock.Lock()
+	defer customNetworksLock.Unlock()
+	customNetworks = networks
+}
+
+// lookupCustomNetworks returns the custom filter lists that contain a network
+// with the given IP address.
+func lookupCustomNetworks(ip net.IP) (sources []string) {
+	customNetworksLock.RLock()
+	defer customNetworksLock.RUnlock()
+
+	for _, n := range customNetworks {
+		if n.network.Contains(ip) {
+			for _, source := range n.sources {
+				if !utils.StringInSlice(sources, source) {
+					sources = append(sources, source)
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// lookupCustomNetworkOrigins returns where networks with the given IP address
+// were found in the custom filter lists.
+func lookupCustomNetworkOrigins(ip net.IP) (origins []ListOrigin) {
+	customNetworksLock.RLock()
+	defer customNetworksLock.RUnlock()
+
+	for _, n := range customNetworks {
+		if n.network.Contains(ip) {
+			origins = append(origins, n.origins...)
+		}
+	}
+	return origins
+}
+
+// updateCustomListIndex sets the given custom filter lists in the cached list
+// index. If the index is not yet cached, the custom filter lists are added
+// when it is.
+func updateCustomListIndex(sources []*customListSource) error {
+	index, err := getListIndexFromCache()
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if !index.setCustomSources(sources) {
+		return nil
+	}
+	return cache.Put(index)
+}
+
+// withoutBuiltInIDs returns the given sources without the ones that use the
+// ID of a built-in source or category.
+func (index *ListIndexFile) withoutBuiltInIDs(sources []*customListSource) []*customListSource {
+	index.RLock()
+	defer index.RUnlock()
+
+	builtInIDs := make(map[string]struct{})
+	for _, s := range index.Sources {
+		if s.Category != customListCategory {
+			builtInIDs[s.ID] = struct{}{}
+		}
+	}
+	for _, c := range index.Categories {
+		if c.ID != customListCategory {
+			builtInIDs[c.ID] = struct{}{}
+		}
+	}
+
+	filtered := make([]*customListSource, 0, len(sources))
+	for _, src := range sources {
+		if _, ok := builtInIDs[src.ID]; ok {
+			log.Warningf("intel/filterlists: ignoring custom filter list %s, as the ID is used by a built-in filter list", src.ID)
+			continue
+		}
+		filtered = append(filtered, src)
+	}
+	return filtered
+}
+
+// setCustomSources replaces the custom filter lists of the index with the
+// given ones and returns whether the index changed.
+func (index *ListIndexFile) setCustomSources(sources []*customListSource) (changed bool) {
+	sources = index.withoutBuiltInIDs(sources)
+
+	index.Lock()
+	defer index.Unlock()
+
+	var (
+		oldCustom  []Source
+		categories = make([]Category, 0, len(index.Categories)+1)
+		newSources = make([]Source, 0, len(index.Sources)+len(sources))
+	)
+	for _, c := range index.Categories {
+		if c.ID != customListCategory {
+			categories = append(categories, c)
+		}
+	}
+	for _, s := range index.Sources {
+		if s.Category == customListCategory {
+			oldCustom = append(oldCustom, s)
+		} else {
+			newSources = append(newSources, s)
+		}
+	}
+
+	var newCustom []Source
+	for _, src := range sources {
+		source := Source{
+			ID:          src.ID,
+			Name:        src.Name,
+			Description: fmt.Sprintf("Custom filter list in the %s format from %s.", src.Format, src.Location),
+			Category:    customListCategory,
+		}
+		if src.isURL() {
+			source.URL = src.Location
+		}
+		newCustom = append(newCustom, source)
+	}
+	if len(newCustom) > 0 {
+		categories = append(categories, Category{
+			ID:          customListCategory,
+			Name:        "Custom",
+			Description: "Custom filter lists defined in the settings.",
+		})
+	}
+
+	index.Categories = categories
+	index.Sources = append(newSources, newCustom...)
+	return !reflect.DeepEqual(oldCustom, newCustom)
+}
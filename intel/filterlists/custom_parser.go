@@ -0,0 +1,207 @@
+package filterlists
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// Custom filter list formats.
+const (
+	// CustomListFormatHosts is the hosts file format: "ip domain [domain...]".
+	CustomListFormatHosts = "hosts"
+	// CustomListFormatAdBlock is the AdBlock domain syntax: "||domain^".
+	CustomListFormatAdBlock = "adblock"
+	// CustomListFormatDomains is a plain list with one domain per line.
+	CustomListFormatDomains = "domains"
+	// CustomListFormatCIDR is a plain list with one IP address or network in
+	// CIDR notation per line.
+	CustomListFormatCIDR = "cidr"
+)
+
+// maxCustomListLineLength is the maximum length of a line in a custom
+// filter list.
+const maxCustomListLineLength = 64 * 1024
+
+// hostsIgnoredNames holds names that are commonly found in hosts files, but
+// must never be blocked.
+var hostsIgnoredNames = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
+
+// customListParser receives the entities parsed from a custom filter list.
+type customListParser struct {
+	// addEntity is called for every domain, IPv4 and IPv6 entity.
+	addEntity func(entityType, value string) error
+	// addNetwork is called for every IP network that is bigger than a single
+	// address.
+	addNetwork func(network *net.IPNet) error
+
+	// lineNumber and line hold the line that is currently parsed, so that
+	// the callbacks can record where an entity was found.
+	lineNumber int
+	line       string
+}
+
+// parse parses the custom filter list in the given format from r. Lines
+// that cannot be parsed are skipped.
+func (p *customListParser) parse(r io.Reader, format string) error {
+	var parseLine func(line string) error
+	switch format {
+	case CustomListFormatHosts:
+		parseLine = p.parseHostsLine
+	case CustomListFormatAdBlock:
+		parseLine = p.parseAdBlockLine
+	case CustomListFormatDomains:
+		parseLine = p.parseDomainsLine
+	case CustomListFormatCIDR:
+		parseLine = p.parseCIDRLine
+	default:
+		return fmt.Errorf("unsupported custom filter list format %q", format)
+	}
+
+	p.lineNumber = 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxCustomListLineLength)
+	for scanner.Scan() {
+		p.lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		p.line = line
+		if err := parseLine(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (p *customListParser) parseHostsLine(line string) error {
+	fields := strings.Fields(stripComment(line, "#"))
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil
+	}
+
+	for _, name := range fields[1:] {
+		if _, ignored := hostsIgnoredNames[strings.ToLower(name)]; ignored {
+			continue
+		}
+		if err := p.addDomain(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *customListParser) parseAdBlockLine(line string) error {
+	// Only blocking rules for whole domains are supported. Comments, exception
+	// rules, element hiding rules and rules for URLs are skipped.
+	if !strings.HasPrefix(line, "||") {
+		return nil
+	}
+	rule := strings.TrimPrefix(line, "||")
+
+	// Split off options.
+	var options string
+	if idx := strings.IndexByte(rule, '$'); idx >= 0 {
+		rule, options = rule[:idx], rule[idx+1:]
+	}
+	// Rules with options are restricted to certain requests and would
+	// overblock when applied to the whole domain.
+	if options != "" && options != "important" {
+		return nil
+	}
+
+	// The rule must match the whole domain.
+	if !strings.HasSuffix(rule, "^") {
+		return nil
+	}
+	domain := strings.TrimSuffix(rule, "^")
+	if strings.ContainsAny(domain, "*/|^") {
+		return nil
+	}
+
+	return p.addDomain(domain)
+}
+
+func (p *customListParser) parseDomainsLine(line string) error {
+	fields := strings.Fields(stripComment(line, "#"))
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// Wildcards are not needed, as subdomains are matched by the filter list
+	// settings.
+	domain := strings.TrimPrefix(fields[0], "*.")
+	domain = strings.TrimPrefix(domain, ".")
+
+	return p.addDomain(domain)
+}
+
+func (p *customListParser) parseCIDRLine(line string) error {
+	fields := strings.Fields(stripComment(stripComment(line, "#"), ";"))
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if !strings.Contains(fields[0], "/") {
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			return p.addIP(ip)
+		}
+		return nil
+	}
+
+	ip, network, err := net.ParseCIDR(fields[0])
+	if err != nil {
+		return nil
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return p.addIP(ip)
+	}
+	return p.addNetwork(network)
+}
+
+// addDomain normalizes and adds the given domain. Invalid domains are
+// skipped.
+func (p *customListParser) addDomain(domain string) error {
+	domain = dns.Fqdn(strings.ToLower(domain))
+	if domain == "." || !netutils.IsValidFqdn(domain) {
+		return nil
+	}
+
+	return p.addEntity("domain", domain)
+}
+
+// addIP adds the given IP address in the same representation as the lookup.
+func (p *customListParser) addIP(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		return p.addEntity("ipv4", ip4.String())
+	}
+	return p.addEntity("ipv6", ip.To16().String())
+}
+
+// stripComment removes everything after the given comment marker.
+func stripComment(line, marker string) string {
+	if idx := strings.Index(line, marker); idx >= 0 {
+		return line[:idx]
+	}
+	return line
+}
@@ -0,0 +1,164 @@
+package filterlists
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseTestList(t *testing.T, format, list string) (entities, networks []string) {
+	t.Helper()
+
+	parser := &customListParser{
+		addEntity: func(entityType, value string) error {
+			entities = append(entities, entityType+":"+value)
+			return nil
+		},
+		addNetwork: func(network *net.IPNet) error {
+			networks = append(networks, network.String())
+			return nil
+		},
+	}
+	if err := parser.parse(strings.NewReader(list), format); err != nil {
+		t.Fatal(err)
+	}
+	return entities, networks
+}
+
+func TestParseCustomLists(t *testing.T) {
+	t.Parallel()
+
+	entities, _ := parseTestList(t, CustomListFormatHosts, `
+# Comment
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 Ads.Example.com tracker.example.net # trailing comment
+0.0.0.0 invalid_domain..com
+not-an-ip example.org
+`)
+	assertParsed(t, CustomListFormatHosts, entities, []string{
+		"domain:ads.example.com.",
+		"domain:tracker.example.net.",
+	})
+
+	entities, _ = parseTestList(t, CustomListFormatAdBlock, `
+[Adblock Plus 2.0]
+! Comment
+||ads.example.com^
+||tracker.example.net^$important
+||example.org^$third-party
+||*.example.org^
+||example.org/ads.js
+@@||allowed.example.com^
+example.com##.banner
+`)
+	assertParsed(t, CustomListFormatAdBlock, entities, []string{
+		"domain:ads.example.com.",
+		"domain:tracker.example.net.",
+	})
+
+	entities, _ = parseTestList(t, CustomListFormatDomains, `
+# Comment
+ads.example.com
+*.tracker.example.net
+.metrics.example.org.
+`)
+	assertParsed(t, CustomListFormatDomains, entities, []string{
+		"domain:ads.example.com.",
+		"domain:tracker.example.net.",
+		"domain:metrics.example.org.",
+	})
+
+	entities, networks := parseTestList(t, CustomListFormatCIDR, `
+; Comment
+192.0.2.1
+198.51.100.0/24 ; SBL123
+203.0.113.7/32
+2001:db8::1
+2001:db8:1::/48
+invalid
+`)
+	assertParsed(t, CustomListFormatCIDR, entities, []string{
+		"ipv4:192.0.2.1",
+		"ipv4:203.0.113.7",
+		"ipv6:2001:db8::1",
+	})
+	assertParsed(t, CustomListFormatCIDR, networks, []string{
+		"198.51.100.0/24",
+		"2001:db8:1::/48",
+	})
+
+	parser := &customListParser{}
+	if err := parser.parse(strings.NewReader(""), "unknown"); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
+
+func assertParsed(t *testing.T, format string, parsed, expected []string) {
+	t.Helper()
+
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("%s: expected %v, got %v", format, expected, parsed)
+	}
+}
+
+func TestParseCustomListSource(t *testing.T) {
+	t.Parallel()
+
+	src, err := parseCustomListSource("MYADS hosts /etc/portmaster/ads.txt My Ad List")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.ID != "MYADS" || src.Format != CustomListFormatHosts || src.Location != "/etc/portmaster/ads.txt" || src.Name != "My Ad List" || src.isURL() {
+		t.Errorf("unexpected source: %+v", src)
+	}
+
+	src, err = parseCustomListSource("drop cidr https://www.spamhaus.org/drop/drop.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Name != "drop" || !src.isURL() {
+		t.Errorf("unexpected source: %+v", src)
+	}
+
+	for _, definition := range []string{
+		"MYADS hosts",
+		"MY.ADS hosts /etc/hosts",
+		"MYADS rpz /etc/hosts",
+		"MYADS hosts relative/path",
+		"MYADS hosts ftp://example.com/list.txt",
+	} {
+		if _, err := parseCustomListSource(definition); err == nil {
+			t.Errorf("definition %q should fail", definition)
+		}
+	}
+}
+
+func TestParseCustomListLines(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	var parser *customListParser
+	parser = &customListParser{
+		addEntity: func(entityType, value string) error {
+			lines = append(lines, fmt.Sprintf("%s:%d:%s", value, parser.lineNumber, parser.line))
+			return nil
+		},
+		addNetwork: func(network *net.IPNet) error {
+			lines = append(lines, fmt.Sprintf("%s:%d:%s", network, parser.lineNumber, parser.line))
+			return nil
+		},
+	}
+
+	err := parser.parse(strings.NewReader("# Comment\n\n  192.0.2.1  \n198.51.100.0/24 ; SBL123\n"), CustomListFormatCIDR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertParsed(t, CustomListFormatCIDR, lines, []string{
+		"192.0.2.1:3:192.0.2.1",
+		"198.51.100.0/24:4:198.51.100.0/24 ; SBL123",
+	})
+}
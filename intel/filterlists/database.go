@@ -21,6 +21,7 @@
 	baseListFilePath         = "intel/lists/base.dsdl"
 	intermediateListFilePath = "intel/lists/intermediate.dsdl"
 	urgentListFilePath       = "intel/lists/urgent.dsdl"
+	deltaListFilePath        = "intel/lists/delta.dsdl"
 	listIndexFilePath        = "intel/lists/index.dsd"
 )
 
@@ -42,6 +43,7 @@
 	baseFile         *updater.File
 	intermediateFile *updater.File
 	urgentFile       *updater.File
+	deltaFile        *updater.File
 
 	filterListsLoaded chan struct{}
 )
@@ -208,6 +210,7 @@ func processEntry(ctx context.Context, filter *scopedBloom, entry *listEntry, re
 		Value:     entry.Entity,
 		Type:      entry.Type,
 		Sources:   entry.getSources(),
+		Origins:   entry.getOrigins(),
 		UpdatedAt: time.Now().Unix(),
 	}
 
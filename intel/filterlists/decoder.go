@@ -35,6 +35,19 @@ func (entry *listEntry) getSources() (sourceIDs []string) {
 	return
 }
 
+func (entry *listEntry) getOrigins() []ListOrigin {
+	origins := make([]ListOrigin, 0, len(entry.Resources))
+
+	for _, resource := range entry.Resources {
+		origins = append(origins, ListOrigin{
+			Source:   resource.SourceID,
+			Resource: resource.ResourceID,
+		})
+	}
+
+	return origins
+}
+
 // decodeFile decodes a DSDL filterlists file and sends decoded entities to
 // ch. It blocks until all list entries have been consumed or ctx is cancelled.
 func decodeFile(ctx context.Context, r io.Reader, ch chan<- *listEntry) error {
@@ -0,0 +1,334 @@
+package filterlists
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+)
+
+// deltaHeaderType is the entity type of the first entry of a delta list file.
+// The entity of this entry holds the version of the filter lists that the
+// delta applies to. The delta holds all entries that were added, changed or
+// removed since that version. Removed entries are marked as whitelisted.
+const deltaHeaderType = "delta"
+
+// deltaList is a decoded delta list file.
+type deltaList struct {
+	path        string
+	version     *version.Version
+	baseVersion *version.Version
+	entries     []*listEntry
+}
+
+// loadedDelta caches the last decoded delta list file. It is only accessed
+// while holding listUpdateLock.
+var loadedDelta *deltaList
+
+// deltaError is returned when a delta list file could not be applied. The
+// cache database must then be rebuilt from the full list files. If records
+// were already written, startedAt holds their UpdatedAt timestamp so that
+// they can be removed before rebuilding.
+type deltaError struct {
+	err       error
+	startedAt int64
+}
+
+func (de *deltaError) Error() string {
+	return fmt.Sprintf("failed to apply delta update: %s", de.err)
+}
+
+func (de *deltaError) Unwrap() error {
+	return de.err
+}
+
+// getDeltaList decodes the given delta list file. The caller must hold
+// listUpdateLock.
+func getDeltaList(ctx context.Context, file *updater.File) (*deltaList, error) {
+	if loadedDelta != nil && loadedDelta.path == file.Path() {
+		return loadedDelta, nil
+	}
+
+	ver, err := version.NewSemver(file.Version())
+	if err != nil {
+		return nil, fmt.Errorf("invalid version of delta file: %w", err)
+	}
+
+	f, err := os.Open(file.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(chan *listEntry, 100)
+	decodeErr := make(chan error, 1)
+	go func() {
+		defer close(values)
+		decodeErr <- decodeFile(ctx, f, values)
+	}()
+
+	var entries []*listEntry
+	for entry := range values {
+		entries = append(entries, entry)
+	}
+	if err := <-decodeErr; err != nil {
+		return nil, fmt.Errorf("failed to decode delta file: %w", err)
+	}
+
+	if len(entries) == 0 || entries[0].Type != deltaHeaderType {
+		return nil, errors.New("delta file is missing the delta header entry")
+	}
+	baseVersion, err := version.NewSemver(entries[0].Entity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base version of delta file: %w", err)
+	}
+	if !ver.GreaterThan(baseVersion) {
+		return nil, fmt.Errorf("delta file version %s is not greater than its base version %s", ver, baseVersion)
+	}
+
+	loadedDelta = &deltaList{
+		path:        file.Path(),
+		version:     ver,
+		baseVersion: baseVersion,
+		entries:     entries[1:],
+	}
+	return loadedDelta, nil
+}
+
+// resolveDeltaUpdateOrder adds the delta file to the given update order, if
+// it applies. If the cache database has a version that the delta applies
+// to, only the delta and any newer files are processed. If the delta chain
+// is broken, the cache database is rebuilt from the full list files and the
+// delta is applied afterwards, if it applies to the rebuilt version.
+func resolveDeltaUpdateOrder(ctx context.Context, order []*updater.File, delta *updater.File, cacheDBVersion *version.Version) ([]*updater.File, error) {
+	// Check if the delta is already applied before decoding it.
+	deltaVersion, err := version.NewSemver(delta.Version())
+	if err != nil || !deltaVersion.GreaterThan(cacheDBVersion) {
+		return order, nil
+	}
+
+	dl, err := getDeltaList(ctx, delta)
+	if err != nil {
+		log.Warningf("intel/filterlists: ignoring delta update %s: %s", delta.Version(), err)
+		return order, nil
+	}
+
+	// Files that are newer than the delta still need to be processed after
+	// it. If this includes the base file, the delta is not needed.
+	var after []*updater.File
+	for _, file := range order {
+		ver, _ := version.NewSemver(file.Version())
+		if ver.GreaterThan(dl.version) {
+			if file == baseFile {
+				return order, nil
+			}
+			after = append(after, file)
+		}
+	}
+
+	if isLoaded() && !dl.baseVersion.GreaterThan(cacheDBVersion) {
+		log.Debugf("intel/filterlists: applying delta update from %s to %s", cacheDBVersion, dl.version)
+		return append([]*updater.File{delta}, after...), nil
+	}
+
+	log.Infof(
+		"intel/filterlists: delta update applies to %s, but cache database has %s, rebuilding",
+		dl.baseVersion,
+		cacheDBVersion,
+	)
+	rebuildOrder, err := getFullRebuildOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the delta after the rebuild, if it fits.
+	rebuiltVersion, _ := version.NewSemver(rebuildOrder[len(rebuildOrder)-1].Version())
+	if !dl.baseVersion.GreaterThan(rebuiltVersion) && dl.version.GreaterThan(rebuiltVersion) {
+		rebuildOrder = append(rebuildOrder, delta)
+	}
+	return rebuildOrder, nil
+}
+
+// getFullRebuildOrder returns the files needed to rebuild the cache database
+// from scratch, starting with the base file.
+func getFullRebuildOrder() ([]*updater.File, error) {
+	if baseFile == nil {
+		var err error
+		baseFile, err = getFile(baseListFilePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := []*updater.File{baseFile}
+	if intermediateFile != nil {
+		files = append(files, intermediateFile)
+	}
+	if urgentFile != nil {
+		files = append(files, urgentFile)
+	}
+	sort.Sort(byAscVersion(files))
+
+	for idx, file := range files {
+		if file == baseFile {
+			return files[idx:], nil
+		}
+	}
+	return files, nil
+}
+
+// applyDeltaFile applies the given delta list file to the cache database and
+// the given bloom filter. If the bloom filter is the one in use, the changes
+// are staged on a copy, which is returned and must replace the one in use
+// once the update completed. All entries are decoded and prepared before
+// the first one is written.
+func applyDeltaFile(ctx context.Context, filter *scopedBloom, file *updater.File) (*scopedBloom, error) {
+	dl, err := getDeltaList(ctx, file)
+	if err != nil {
+		return nil, &deltaError{err: err}
+	}
+	// The decoded delta is not needed anymore after this.
+	defer func() {
+		loadedDelta = nil
+	}()
+
+	staged := filter
+	if filter == defaultFilter {
+		staged, err = filter.clone()
+		if err != nil {
+			return nil, &deltaError{err: fmt.Errorf("failed to copy bloom filters: %w", err)}
+		}
+	}
+
+	// Sources of custom filter lists are kept when built-in entries change.
+	customIDs := make(map[string]struct{})
+	if state, err := getCustomListsState(); err == nil {
+		for id := range state.Lists {
+			customIDs[id] = struct{}{}
+		}
+	}
+
+	// Prepare all records first.
+	var (
+		records = make([]*entityRecord, 0, len(dl.entries))
+		added   int
+		removed int
+		now     = time.Now().Unix()
+	)
+	for _, entry := range dl.entries {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		normalizeEntry(entry)
+		r, err := makeDeltaRecord(staged, entry, customIDs)
+		if err != nil {
+			return nil, &deltaError{err: err}
+		}
+		r.UpdatedAt = now
+
+		if len(r.Sources) == 0 {
+			r.CreateMeta()
+			r.Meta().Delete()
+			removed++
+		} else {
+			staged.add(r.Type, r.Value)
+			added++
+		}
+		records = append(records, r)
+	}
+
+	// Write all records.
+	batch := newRecordBatch()
+	for _, r := range records {
+		if err := batch.put(r); err != nil {
+			_ = batch.flush()
+			return nil, &deltaError{
+				err:       fmt.Errorf("failed to write record: %w", err),
+				startedAt: now,
+			}
+		}
+	}
+	if err := batch.flush(); err != nil {
+		return nil, &deltaError{
+			err:       fmt.Errorf("failed to write records: %w", err),
+			startedAt: now,
+		}
+	}
+
+	log.Infof(
+		"intel/filterlists: applied delta update %s with %d added or changed and %d removed entities",
+		dl.version,
+		added,
+		removed,
+	)
+	return staged, nil
+}
+
+// removeFailedDeltaEntries removes all entries that were written by the
+// failed delta update of the given error. Entries that were deleted or changed
+// by it are restored by rebuilding the cache database afterwards.
+func removeFailedDeltaEntries(ctx context.Context, deltaErr *deltaError) error {
+	if deltaErr.startedAt == 0 {
+		return nil
+	}
+
+	n, err := cache.Purge(ctx, query.New(filterListKeyPrefix).Where(
+		query.Where("UpdatedAt", query.GreaterThanOrEqual, deltaErr.startedAt),
+	))
+	cache.ClearCache()
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("intel/filterlists: removed %d entries of failed delta update", n)
+	return nil
+}
+
+// makeDeltaRecord returns the entity record for the given delta entry. The
+// sources of custom filter lists of an existing record are kept. A record
+// without any sources must be deleted.
+func makeDeltaRecord(filter *scopedBloom, entry *listEntry, customIDs map[string]struct{}) (*entityRecord, error) {
+	r := &entityRecord{
+		Value: entry.Entity,
+		Type:  strings.ToLower(entry.Type),
+	}
+	if !entry.Whitelist {
+		r.Sources = entry.getSources()
+		r.Origins = entry.getOrigins()
+	}
+	key := makeListCacheKey(r.Type, r.Value)
+	r.SetKey(key)
+
+	if len(customIDs) == 0 || !filter.test(r.Type, r.Value) {
+		return r, nil
+	}
+	existing, err := getEntityRecordByKey(key)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return r, nil
+	case err != nil:
+		return nil, err
+	}
+
+	for _, source := range existing.Sources {
+		if _, ok := customIDs[source]; ok {
+			r.Sources = append(r.Sources, source)
+		}
+	}
+	for _, origin := range existing.Origins {
+		if _, ok := customIDs[origin.Source]; ok {
+			r.Origins = append(r.Origins, origin)
+		}
+	}
+	return r, nil
+}
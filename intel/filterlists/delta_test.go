@@ -0,0 +1,323 @@
+package filterlists
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+)
+
+// setupTestRegistry makes the list files available from a registry in a
+// temporary directory and restores the previous files when the test
+// finishes.
+func setupTestRegistry(t *testing.T) *updater.ResourceRegistry {
+	t.Helper()
+
+	reg := &updater.ResourceRegistry{
+		Name: "filterlists-testing",
+	}
+	if err := reg.Initialize(utils.NewDirStructure(t.TempDir(), 0700)); err != nil {
+		t.Fatal(err)
+	}
+
+	previousGetFile := getFile
+	previousFiles := []*updater.File{baseFile, intermediateFile, urgentFile, deltaFile}
+	t.Cleanup(func() {
+		getFile = previousGetFile
+		baseFile, intermediateFile, urgentFile, deltaFile = previousFiles[0], previousFiles[1], previousFiles[2], previousFiles[3]
+		loadedDelta = nil
+	})
+
+	getFile = reg.GetFile
+	baseFile, intermediateFile, urgentFile, deltaFile = nil, nil, nil, nil
+	loadedDelta = nil
+	return reg
+}
+
+// addTestListFile adds a DSDL list file with the given entries to the
+// registry.
+func addTestListFile(t *testing.T, reg *updater.ResourceRegistry, identifier, ver string, entries ...*listEntry) *updater.File {
+	t.Helper()
+
+	if err := reg.AddResource(identifier, ver, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+	reg.SelectVersions()
+	file, err := reg.GetFile(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer([]byte{dsd.LIST, dsd.JSON})
+	for _, entry := range entries {
+		blob, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var length [binary.MaxVarintLen64]byte
+		buf.Write(length[:binary.PutUvarint(length[:], uint64(len(blob)))])
+		buf.Write(blob)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file.Path()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file.Path(), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestDeltaHeader(baseVersion string) *listEntry {
+	return &listEntry{
+		Type:   deltaHeaderType,
+		Entity: baseVersion,
+	}
+}
+
+func fileVersions(files []*updater.File) string {
+	versions := make([]string, 0, len(files))
+	for _, file := range files {
+		versions = append(versions, file.Version())
+	}
+	return strings.Join(versions, ",")
+}
+
+func TestResolveDeltaUpdateOrder(t *testing.T) {
+	setupTestFilterLists(t)
+	loaded := filterListsLoaded
+
+	for _, test := range []struct {
+		name           string
+		notLoaded      bool
+		cacheDBVersion string
+		deltaVersion   string
+		deltaBase      string
+		urgentVersion  string
+		order          []string
+		expected       string
+	}{
+		{
+			name:           "applies to cache database",
+			cacheDBVersion: "1.2.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			expected:       "1.3.0",
+		},
+		{
+			name:           "applies to older cache database",
+			cacheDBVersion: "1.2.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.1.0",
+			expected:       "1.3.0",
+		},
+		{
+			name:           "includes pending files",
+			cacheDBVersion: "1.1.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.1.0",
+			order:          []string{intermediateListFilePath},
+			expected:       "1.3.0",
+		},
+		{
+			name:           "newer files are processed afterwards",
+			cacheDBVersion: "1.2.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			urgentVersion:  "1.4.0",
+			order:          []string{urgentListFilePath},
+			expected:       "1.3.0,1.4.0",
+		},
+		{
+			name:           "already applied",
+			cacheDBVersion: "1.3.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			expected:       "",
+		},
+		{
+			name:           "older than cache database",
+			cacheDBVersion: "1.4.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			expected:       "",
+		},
+		{
+			name:           "version chain gap",
+			cacheDBVersion: "1.1.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			expected:       "1.0.0,1.2.0,1.3.0",
+		},
+		{
+			name:           "version chain gap after rebuild",
+			cacheDBVersion: "1.1.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.5",
+			expected:       "1.0.0,1.2.0",
+		},
+		{
+			name:           "not loaded",
+			notLoaded:      true,
+			cacheDBVersion: "0.0.0",
+			deltaVersion:   "1.3.0",
+			deltaBase:      "1.2.0",
+			order:          []string{baseListFilePath, intermediateListFilePath},
+			expected:       "1.0.0,1.2.0,1.3.0",
+		},
+		{
+			name:           "base file is newer",
+			notLoaded:      true,
+			cacheDBVersion: "0.0.0",
+			deltaVersion:   "0.9.0",
+			deltaBase:      "0.8.0",
+			order:          []string{baseListFilePath, intermediateListFilePath},
+			expected:       "1.0.0,1.2.0",
+		},
+		{
+			name:           "invalid delta file",
+			cacheDBVersion: "1.2.0",
+			deltaVersion:   "1.3.0",
+			expected:       "",
+		},
+	} {
+		reg := setupTestRegistry(t)
+		baseFile = addTestListFile(t, reg, baseListFilePath, "1.0.0")
+		intermediateFile = addTestListFile(t, reg, intermediateListFilePath, "1.2.0")
+		if test.urgentVersion != "" {
+			urgentFile = addTestListFile(t, reg, urgentListFilePath, test.urgentVersion)
+		}
+		var deltaEntries []*listEntry
+		if test.deltaBase != "" {
+			deltaEntries = append(deltaEntries, newTestDeltaHeader(test.deltaBase))
+		}
+		deltaEntries = append(deltaEntries, newTestListEntry("domain", "ads.example.com", "ADS"))
+		deltaFile = addTestListFile(t, reg, deltaListFilePath, test.deltaVersion, deltaEntries...)
+
+		files := map[string]*updater.File{
+			baseListFilePath:         baseFile,
+			intermediateListFilePath: intermediateFile,
+			urgentListFilePath:       urgentFile,
+		}
+		var order []*updater.File
+		for _, identifier := range test.order {
+			order = append(order, files[identifier])
+		}
+
+		filterListsLoaded = loaded
+		if test.notLoaded {
+			filterListsLoaded = make(chan struct{})
+		}
+
+		result, err := resolveDeltaUpdateOrder(context.Background(), order, deltaFile, version.Must(version.NewSemver(test.cacheDBVersion)))
+		if err != nil {
+			t.Errorf("%s: failed to resolve update order: %s", test.name, err)
+			continue
+		}
+		if fileVersions(result) != test.expected {
+			t.Errorf("%s: expected update order %q, got %q", test.name, test.expected, fileVersions(result))
+		}
+	}
+	filterListsLoaded = loaded
+}
+
+func TestDeltaUpdateFallback(t *testing.T) {
+	setupTestFilterLists(t, newTestListEntry("domain", "stale.example.com", "ADS"))
+	reg := setupTestRegistry(t)
+	baseFile = addTestListFile(t, reg, baseListFilePath, "1.0.0",
+		newTestListEntry("domain", "ads.example.com", "ADS"),
+	)
+	// The delta file is missing its header.
+	deltaFile = addTestListFile(t, reg, deltaListFilePath, "1.1.0",
+		newTestListEntry("domain", "new.example.com", "ADS"),
+	)
+
+	processed, filter, cleanupRequired, fullFilesProcessed, err := processUpdateFilesOrRebuild(context.Background(), []*updater.File{deltaFile})
+	if err != nil {
+		t.Fatalf("expected fallback to full rebuild, got %s", err)
+	}
+	if fileVersions(processed) != "1.0.0" {
+		t.Errorf("expected the base file to be processed, got %q", fileVersions(processed))
+	}
+	if !cleanupRequired || !fullFilesProcessed {
+		t.Errorf("expected rebuild to require cleanup and full processing, got %v and %v", cleanupRequired, fullFilesProcessed)
+	}
+	if filter == defaultFilter {
+		t.Error("expected rebuild to create new bloom filters")
+	}
+	if !filter.test("domain", "ads.example.com.") || filter.test("domain", "new.example.com.") {
+		t.Error("expected bloom filters to only hold the entries of the base file")
+	}
+	if _, err := getEntityRecordByKey(makeListCacheKey("domain", "ads.example.com.")); err != nil {
+		t.Errorf("expected entry of base file to be written: %s", err)
+	}
+	if _, err := getEntityRecordByKey(makeListCacheKey("domain", "new.example.com.")); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected entry of failed delta update not to be written, got %v", err)
+	}
+
+	// Other errors do not fall back to a rebuild.
+	if err := os.Remove(baseFile.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := processUpdateFilesOrRebuild(context.Background(), []*updater.File{baseFile}); err == nil {
+		t.Error("expected missing base file to fail the update")
+	}
+}
+
+func TestRemoveFailedDeltaEntries(t *testing.T) {
+	setupTestFilterLists(t)
+
+	startedAt := time.Now().Unix()
+	for _, r := range []*entityRecord{
+		{Type: "domain", Value: "old.example.com.", Sources: []string{"ADS"}, UpdatedAt: startedAt - 10},
+		{Type: "domain", Value: "new.example.com.", Sources: []string{"ADS"}, UpdatedAt: startedAt},
+	} {
+		r.SetKey(makeListCacheKey(r.Type, r.Value))
+		if err := cache.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		startedAt int64
+		remaining []string
+	}{
+		{
+			name:      "nothing written",
+			remaining: []string{"old.example.com.", "new.example.com."},
+		},
+		{
+			name:      "entries written",
+			startedAt: startedAt,
+			remaining: []string{"old.example.com."},
+		},
+	} {
+		if err := removeFailedDeltaEntries(context.Background(), &deltaError{startedAt: test.startedAt}); err != nil {
+			t.Fatalf("%s: failed to remove entries: %s", test.name, err)
+		}
+
+		var remaining []string
+		for _, value := range []string{"old.example.com.", "new.example.com."} {
+			if _, err := getEntityRecordByKey(makeListCacheKey("domain", value)); err == nil {
+				remaining = append(remaining, value)
+			}
+		}
+		if strings.Join(remaining, ",") != strings.Join(test.remaining, ",") {
+			t.Errorf("%s: expected remaining entries %v, got %v", test.name, test.remaining, remaining)
+		}
+	}
+}
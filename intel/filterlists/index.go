@@ -88,6 +88,10 @@ type ListIndexFile struct {
 	SchemaVersion string     `json:"schemaVersion"`
 	Categories    []Category `json:"categories"`
 	Sources       []Source   `json:"sources"`
+
+	// EntryCounts holds the number of entities per entity type in
+	// the filter lists. It is used to size the bloom filters.
+	EntryCounts map[string]int `json:"entryCounts,omitempty"`
 }
 
 func (index *ListIndexFile) getCategorySources(id string) []string {
@@ -219,6 +223,7 @@ func updateListIndex() error {
 		return err
 	}
 	index.SetKey(filterListIndexKey)
+	index.setCustomSources(getCustomListSources())
 
 	if err := cache.Put(index); err != nil {
 		return err
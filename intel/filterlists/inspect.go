@@ -0,0 +1,131 @@
+package filterlists
+
+import (
+	"errors"
+	"net"
+
+	"github.com/safing/portbase/database"
+)
+
+// EntityLookup describes the result of looking up an entity in the filter
+// lists. It is meant for debugging filter list matches.
+type EntityLookup struct {
+	// Type is the entity type, one of "domain", "ipv4", "ipv6", "asn" and
+	// "country".
+	Type string
+	// Value is the entity value, as looked up.
+	Value string
+
+	// BloomHit is set if the bloom filter reported that the entity may be in
+	// the filter lists. The database is only searched on a bloom hit.
+	BloomHit bool
+	// DatabaseHit is set if the entity was found in the cache database. A
+	// bloom hit without a database hit is a false positive of the bloom
+	// filter.
+	DatabaseHit bool
+	// NetworkHit is set if the IP address is in a network of a custom filter
+	// list.
+	NetworkHit bool
+
+	// Sources holds the filter list sources that contain the entity.
+	Sources []*SourceMatch `json:",omitempty"`
+}
+
+// SourceMatch describes a filter list source that contains an entity.
+type SourceMatch struct {
+	ID           string
+	Name         string `json:",omitempty"`
+	Category     string `json:",omitempty"`
+	CategoryName string `json:",omitempty"`
+
+	// Origins holds where the entity was found in the source.
+	Origins []ListOrigin `json:",omitempty"`
+}
+
+// ErrNotLoaded is returned if the filter lists are not yet loaded.
+var ErrNotLoaded = errors.New("filter lists are not loaded")
+
+// LookupEntity looks up the entity with the given type and value in the
+// filter lists and returns the details of the lookup. The entity type is one
+// of "domain", "ipv4", "ipv6", "asn" and "country". Domains must be fully
+// qualified.
+func LookupEntity(entityType, value string) (*EntityLookup, error) {
+	if !isLoaded() {
+		return nil, ErrNotLoaded
+	}
+
+	result := &EntityLookup{
+		Type:  entityType,
+		Value: value,
+	}
+
+	var origins []ListOrigin
+	switch entityType {
+	case "ipv4", "ipv6":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, errors.New("invalid IP")
+		}
+		origins = lookupCustomNetworkOrigins(ip)
+		result.NetworkHit = len(origins) > 0
+	}
+
+	filterListLock.RLock()
+	result.BloomHit = defaultFilter.test(entityType, value)
+	if result.BloomHit {
+		r, err := getEntityRecordByKey(makeListCacheKey(entityType, value))
+		switch {
+		case err == nil:
+			result.DatabaseHit = true
+			origins = append(r.getOrigins(), origins...)
+		case !errors.Is(err, database.ErrNotFound):
+			filterListLock.RUnlock()
+			return nil, err
+		}
+	}
+	filterListLock.RUnlock()
+
+	index, err := getListIndexFromCache()
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	bySource := make(map[string]*SourceMatch)
+	for _, origin := range origins {
+		match, ok := bySource[origin.Source]
+		if !ok {
+			match = &SourceMatch{ID: origin.Source}
+			if index != nil {
+				index.describeSource(match)
+			}
+			bySource[origin.Source] = match
+			result.Sources = append(result.Sources, match)
+		}
+		// Skip origins that only hold the source.
+		if origin.Resource != "" || origin.Line > 0 {
+			match.Origins = append(match.Origins, origin)
+		}
+	}
+
+	return result, nil
+}
+
+// describeSource sets the name and category of the given source match.
+func (index *ListIndexFile) describeSource(match *SourceMatch) {
+	index.RLock()
+	defer index.RUnlock()
+
+	for _, s := range index.Sources {
+		if s.ID == match.ID {
+			match.Name = s.Name
+			match.Category = s.Category
+			break
+		}
+	}
+	for _, c := range index.Categories {
+		if c.ID == match.Category {
+			match.CategoryName = c.Name
+			break
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package filterlists
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+)
+
+// setupTestFilterLists marks the filter lists as loaded with the given
+// entries and restores the previous state when the test finishes.
+func setupTestFilterLists(t *testing.T, entries ...*listEntry) *scopedBloom {
+	t.Helper()
+
+	previousLoaded := filterListsLoaded
+	loaded := make(chan struct{})
+	close(loaded)
+	filterListsLoaded = loaded
+
+	filter := newScopedBloom(nil)
+	filterListLock.Lock()
+	previousFilter := defaultFilter
+	defaultFilter = filter
+	filterListLock.Unlock()
+
+	t.Cleanup(func() {
+		filterListsLoaded = previousLoaded
+		filterListLock.Lock()
+		defaultFilter = previousFilter
+		filterListLock.Unlock()
+		clearTestCache(t)
+	})
+
+	clearTestCache(t)
+	putTestEntries(t, filter, entries...)
+	return filter
+}
+
+// putTestEntries adds the given entries to the filter and the cache
+// database.
+func putTestEntries(t *testing.T, filter *scopedBloom, entries ...*listEntry) {
+	t.Helper()
+
+	records := make(chan record.Record, len(entries))
+	for _, entry := range entries {
+		if err := processEntry(context.Background(), filter, entry, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(records)
+	for r := range records {
+		if err := cache.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// clearTestCache removes all filter list data from the cache database.
+func clearTestCache(t *testing.T) {
+	t.Helper()
+
+	if _, err := cache.Purge(context.Background(), query.New(cacheDBPrefix+"/")); err != nil {
+		t.Fatal(err)
+	}
+	cache.ClearCache()
+}
+
+func newTestListEntry(entityType, entity string, sourceIDs ...string) *listEntry {
+	entry := &listEntry{
+		Type:   entityType,
+		Entity: entity,
+	}
+	for _, sourceID := range sourceIDs {
+		entry.Resources = append(entry.Resources, entryResource{
+			SourceID:   sourceID,
+			ResourceID: sourceID + "-resource",
+		})
+	}
+	return entry
+}
+
+func TestLookupEntity(t *testing.T) {
+	if _, err := LookupEntity("domain", "example.com."); !errors.Is(err, ErrNotLoaded) {
+		t.Fatalf("expected lookups to fail before the filter lists are loaded, got %v", err)
+	}
+
+	filter := setupTestFilterLists(t,
+		newTestListEntry("Domain", "Ads.Example.com", "ADS", "TRACK"),
+		newTestListEntry("IPv4", "192.0.2.1", "ADS"),
+	)
+	// An entity in the bloom filter but not in the database.
+	filter.add("domain", "false-positive.example.com.")
+
+	// Add a list index describing one of the sources.
+	index := &ListIndexFile{
+		Categories: []Category{{ID: "ads", Name: "Advertising"}},
+		Sources:    []Source{{ID: "ADS", Name: "Ad List", Category: "ads"}},
+	}
+	index.SetKey(filterListIndexKey)
+	if err := cache.Put(index); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a network of a custom filter list.
+	_, network, _ := net.ParseCIDR("198.51.100.0/24")
+	customNetworksLock.Lock()
+	previousNetworks := customNetworks
+	customNetworks = []*customNetwork{{
+		network: network,
+		sources: []string{"CUSTOM"},
+		origins: []ListOrigin{newCustomListOrigin("CUSTOM", 3, "198.51.100.0/24")},
+	}}
+	customNetworksLock.Unlock()
+	t.Cleanup(func() {
+		customNetworksLock.Lock()
+		defer customNetworksLock.Unlock()
+		customNetworks = previousNetworks
+	})
+
+	for _, test := range []struct {
+		entityType string
+		value      string
+		expected   *EntityLookup
+	}{
+		{
+			entityType: "domain",
+			value:      "ads.example.com.",
+			expected: &EntityLookup{
+				BloomHit:    true,
+				DatabaseHit: true,
+				Sources: []*SourceMatch{
+					{
+						ID:           "ADS",
+						Name:         "Ad List",
+						Category:     "ads",
+						CategoryName: "Advertising",
+						Origins:      []ListOrigin{{Source: "ADS", Resource: "ADS-resource"}},
+					},
+					{
+						ID:      "TRACK",
+						Origins: []ListOrigin{{Source: "TRACK", Resource: "TRACK-resource"}},
+					},
+				},
+			},
+		},
+		{
+			entityType: "domain",
+			value:      "example.com.",
+			expected:   &EntityLookup{},
+		},
+		{
+			entityType: "domain",
+			value:      "false-positive.example.com.",
+			expected: &EntityLookup{
+				BloomHit: true,
+			},
+		},
+		{
+			entityType: "ipv4",
+			value:      "192.0.2.1",
+			expected: &EntityLookup{
+				BloomHit:    true,
+				DatabaseHit: true,
+				Sources: []*SourceMatch{{
+					ID:           "ADS",
+					Name:         "Ad List",
+					Category:     "ads",
+					CategoryName: "Advertising",
+					Origins:      []ListOrigin{{Source: "ADS", Resource: "ADS-resource"}},
+				}},
+			},
+		},
+		{
+			entityType: "ipv4",
+			value:      "198.51.100.7",
+			expected: &EntityLookup{
+				NetworkHit: true,
+				Sources: []*SourceMatch{{
+					ID:      "CUSTOM",
+					Origins: []ListOrigin{{Source: "CUSTOM", Line: 3, Entry: "198.51.100.0/24"}},
+				}},
+			},
+		},
+	} {
+		result, err := LookupEntity(test.entityType, test.value)
+		if err != nil {
+			t.Errorf("%s %s: failed to look up entity: %s", test.entityType, test.value, err)
+			continue
+		}
+
+		test.expected.Type = test.entityType
+		test.expected.Value = test.value
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s %s: expected %+v, got %+v", test.entityType, test.value, test.expected, result)
+			for i := range result.Sources {
+				t.Logf("source %d: %+v", i, result.Sources[i])
+			}
+		}
+	}
+
+	if _, err := LookupEntity("ipv4", "not an IP"); err == nil {
+		t.Error("expected invalid IP to be rejected")
+	}
+}
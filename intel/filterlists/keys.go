@@ -15,6 +15,10 @@
 	// filterListKeyPrefix is the prefix inside that cache database
 	// used for filter list entries.
 	filterListKeyPrefix = cacheDBPrefix + "/lists/"
+
+	// customListsStateKey is used to store which custom filter lists
+	// have been applied to the cache database.
+	customListsStateKey = cacheDBPrefix + "/custom"
 )
 
 func makeBloomCacheKey(scope string) string {
@@ -6,6 +6,7 @@
 
 	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
 )
 
 // lookupBlockLists loads the entity record for key from
@@ -26,7 +27,9 @@ func lookupBlockLists(entity, value string) ([]string, error) {
 	filterListLock.RLock()
 	defer filterListLock.RUnlock()
 
-	if !defaultFilter.test(entity, value) {
+	bloomHit := defaultFilter.test(entity, value)
+	countBloomLookup(entity, bloomHit)
+	if !bloomHit {
 		return nil, nil
 	}
 
@@ -34,6 +37,8 @@ func lookupBlockLists(entity, value string) ([]string, error) {
 	entry, err := getEntityRecordByKey(key)
 	if err != nil {
 		if err == database.ErrNotFound {
+			// The bloom filter reported a false positive.
+			countBloomFalsePositive(entity)
 			return nil, nil
 		}
 		log.Errorf("intel/filterlists: failed to get entries for key %s: %s", key, err)
@@ -74,13 +79,26 @@ func LookupASNString(asn string) ([]string, error) {
 
 // LookupIP returns a list of block sources that contain
 // a reference to ip. LookupIP automatically checks the IPv4 or
-// IPv6 lists respectively.
+// IPv6 lists respectively, as well as the networks of custom
+// filter lists.
 func LookupIP(ip net.IP) ([]string, error) {
+	var lists []string
+	var err error
 	if ip.To4() == nil {
-		return LookupIPv6(ip)
+		lists, err = LookupIPv6(ip)
+	} else {
+		lists, err = LookupIPv4(ip)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return LookupIPv4(ip)
+	for _, source := range lookupCustomNetworks(ip) {
+		if !utils.StringInSlice(lists, source) {
+			lists = append(lists, source)
+		}
+	}
+	return lists, nil
 }
 
 // LookupIPString is like LookupIP but accepts an IPv4 or
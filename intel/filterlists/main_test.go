@@ -0,0 +1,21 @@
+package filterlists
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portmaster/core/pmtesting"
+
+	// module dependencies
+	_ "github.com/safing/portbase/database/storage/bbolt"
+)
+
+// TestMain only sets up the cache database, as starting the filterlists
+// module would download the filter lists.
+func TestMain(m *testing.M) {
+	pmtesting.TestMainWithDatabases(m, &database.Database{
+		Name:        "cache",
+		Description: "Test Cache",
+		StorageType: "bbolt",
+	})
+}
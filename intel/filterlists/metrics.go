@@ -0,0 +1,127 @@
+package filterlists
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/metrics"
+)
+
+// bloomCounters holds the lookup counters of the bloom filter of a scope.
+type bloomCounters struct {
+	lookups        *metrics.Counter
+	hits           *metrics.Counter
+	falsePositives *metrics.Counter
+}
+
+// bloomMetrics holds the lookup counters of each scope. It is only written
+// during registerMetrics.
+var bloomMetrics = make(map[string]*bloomCounters)
+
+func registerMetrics() error {
+	counterOpts := func(name string) *metrics.Options {
+		return &metrics.Options{
+			Name:           name,
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		}
+	}
+
+	for _, scope := range bloomScopes {
+		scope := scope
+		labels := map[string]string{"type": scope}
+		counters := &bloomCounters{}
+
+		var err error
+		counters.lookups, err = metrics.NewCounter(
+			"intel/filterlists/bloom/lookups/total",
+			labels,
+			counterOpts("Filter List Lookups"),
+		)
+		if err != nil {
+			return err
+		}
+
+		counters.hits, err = metrics.NewCounter(
+			"intel/filterlists/bloom/hits/total",
+			labels,
+			counterOpts("Filter List Bloom Filter Hits"),
+		)
+		if err != nil {
+			return err
+		}
+
+		// Bloom hits that were not found in the cache database. If these
+		// make up a large part of the hits, the bloom filter is saturated.
+		counters.falsePositives, err = metrics.NewCounter(
+			"intel/filterlists/bloom/falsepositives/total",
+			labels,
+			counterOpts("Filter List Bloom Filter False Positives"),
+		)
+		if err != nil {
+			return err
+		}
+
+		bloomMetrics[scope] = counters
+
+		for _, gauge := range []struct {
+			id   string
+			name string
+			fn   func(*bloomStats) float64
+		}{
+			{
+				id:   "intel/filterlists/bloom/entries",
+				name: "Filter List Bloom Filter Entries",
+				fn:   func(s *bloomStats) float64 { return float64(s.getEntries()) },
+			},
+			{
+				id:   "intel/filterlists/bloom/saturation",
+				name: "Filter List Bloom Filter Saturation",
+				fn:   (*bloomStats).saturation,
+			},
+			{
+				id:   "intel/filterlists/bloom/falsepositiverate",
+				name: "Filter List Bloom Filter Estimated False Positive Rate",
+				fn:   (*bloomStats).estimatedFalsePositiveRate,
+			},
+		} {
+			gauge := gauge
+			if _, err := metrics.NewGauge(
+				gauge.id,
+				labels,
+				func() float64 {
+					stats, ok := defaultFilter.getStats(scope)
+					if !ok {
+						return 0
+					}
+					return gauge.fn(stats)
+				},
+				counterOpts(gauge.name),
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// countBloomLookup counts a lookup of the bloom filter of the given scope.
+func countBloomLookup(scope string, hit bool) {
+	counters, ok := bloomMetrics[scope]
+	if !ok {
+		return
+	}
+
+	counters.lookups.Inc()
+	if hit {
+		counters.hits.Inc()
+	}
+}
+
+// countBloomFalsePositive counts a bloom filter hit of the given scope that
+// was not found in the cache database.
+func countBloomFalsePositive(scope string) {
+	if counters, ok := bloomMetrics[scope]; ok {
+		counters.falsePositives.Inc()
+	}
+}
@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/safing/portbase/log"
@@ -36,6 +37,23 @@ func init() {
 }
 
 func prep() error {
+	if err := registerConfig(); err != nil {
+		return err
+	}
+
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"update custom filter lists",
+		handleCustomListsConfigChange,
+	); err != nil {
+		return fmt.Errorf("failed to register config change event handler: %w", err)
+	}
+
 	if err := module.RegisterEventHook(
 		updates.ModuleName,
 		updates.ResourceUpdateEvent,
@@ -84,6 +102,13 @@ func start() error {
 
 		if err = defaultFilter.loadFromCache(); err != nil {
 			err = fmt.Errorf("failed to initialize bloom filters: %w", err)
+
+			// Rebuild the bloom filters and the cache database
+			// right away if the persisted filters don't match.
+			if errors.Is(err, errBloomMismatch) {
+				log.Warningf("intel/filterlists: %s, rebuilding", err)
+				module.StartWorker("rebuild filter lists", tryListUpdate)
+			}
 		}
 	}
 
@@ -95,6 +120,7 @@ func start() error {
 		close(filterListsLoaded)
 	}
 
+	startCustomLists()
 	return nil
 }
 
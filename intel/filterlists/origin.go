@@ -0,0 +1,119 @@
+package filterlists
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/log"
+)
+
+// maxOriginEntryLength is the maximum length of a custom filter list entry
+// that is kept as the origin of an entity.
+const maxOriginEntryLength = 128
+
+// ListOrigin describes where an entity was found in a filter list source.
+type ListOrigin struct {
+	// Source is the ID of the filter list source.
+	Source string
+	// Resource is the ID of the upstream resource of a built-in filter list
+	// that contains the entity.
+	Resource string `json:",omitempty"`
+	// Entry is the entry of a custom filter list that contains the entity,
+	// as found in the list.
+	Entry string `json:",omitempty"`
+	// Line is the line number of Entry in the custom filter list.
+	Line int `json:",omitempty"`
+}
+
+func (origin ListOrigin) String() string {
+	switch {
+	case origin.Line > 0:
+		return fmt.Sprintf("%s line %d: %s", origin.Source, origin.Line, origin.Entry)
+	case origin.Resource != "":
+		return fmt.Sprintf("%s resource %s", origin.Source, origin.Resource)
+	default:
+		return origin.Source
+	}
+}
+
+// newCustomListOrigin returns the origin of an entry of a custom filter list.
+func newCustomListOrigin(sourceID string, line int, entry string) ListOrigin {
+	if len(entry) > maxOriginEntryLength {
+		entry = entry[:maxOriginEntryLength] + "..."
+	}
+	return ListOrigin{
+		Source: sourceID,
+		Entry:  entry,
+		Line:   line,
+	}
+}
+
+// getOrigins returns the origins of the entity record. Records that were
+// stored without origins only report their sources.
+func (r *entityRecord) getOrigins() []ListOrigin {
+	origins := make([]ListOrigin, 0, len(r.Sources))
+	for _, source := range r.Sources {
+		var found bool
+		for _, origin := range r.Origins {
+			if origin.Source == source {
+				origins = append(origins, origin)
+				found = true
+			}
+		}
+		if !found {
+			origins = append(origins, ListOrigin{Source: source})
+		}
+	}
+	return origins
+}
+
+// setOrigin sets the origin of the given source, replacing any previous
+// origins of the source.
+func (r *entityRecord) setOrigin(origin ListOrigin) {
+	origins := make([]ListOrigin, 0, len(r.Origins)+1)
+	for _, o := range r.Origins {
+		if o.Source != origin.Source {
+			origins = append(origins, o)
+		}
+	}
+	r.Origins = append(origins, origin)
+}
+
+// LookupOrigins returns where the entity with the given type and value was
+// found in the filter lists. The entity type is one of "domain", "ipv4",
+// "ipv6", "asn" and "country". For IP addresses, the networks of custom filter
+// lists are included. Domains must be fully qualified.
+func LookupOrigins(entityType, value string) ([]ListOrigin, error) {
+	if !isLoaded() {
+		return nil, nil
+	}
+
+	var origins []ListOrigin
+	switch entityType {
+	case "ipv4", "ipv6":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", value)
+		}
+		origins = lookupCustomNetworkOrigins(ip)
+	}
+
+	filterListLock.RLock()
+	defer filterListLock.RUnlock()
+
+	if !defaultFilter.test(entityType, value) {
+		return origins, nil
+	}
+
+	r, err := getEntityRecordByKey(makeListCacheKey(entityType, value))
+	if err != nil {
+		if err == database.ErrNotFound {
+			return origins, nil
+		}
+		log.Errorf("intel/filterlists: failed to get origins of %s %s: %s", entityType, value, err)
+		return nil, err
+	}
+
+	return append(r.getOrigins(), origins...), nil
+}
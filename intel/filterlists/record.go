@@ -13,6 +13,7 @@ type entityRecord struct {
 
 	Value     string
 	Sources   []string
+	Origins   []ListOrigin
 	Type      string
 	UpdatedAt int64
 }
@@ -23,6 +24,12 @@ func getEntityRecordByKey(key string) (*entityRecord, error) {
 		return nil, err
 	}
 
+	return ensureEntityRecord(r)
+}
+
+// ensureEntityRecord unwraps the given record into an entity record, if
+// necessary.
+func ensureEntityRecord(r record.Record) (*entityRecord, error) {
 	if r.IsWrapped() {
 		new := &entityRecord{}
 		if err := record.Unwrap(r, new); err != nil {
@@ -2,8 +2,10 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-version"
@@ -15,7 +17,13 @@
 	"github.com/tevino/abool"
 )
 
-var updateInProgress = abool.New()
+var (
+	updateInProgress = abool.New()
+
+	// listUpdateLock serializes updates of the built-in and custom
+	// filter lists.
+	listUpdateLock sync.Mutex
+)
 
 // tryListUpdate wraps performUpdate but ensures the module's
 // error state is correctly set or resolved.
@@ -47,13 +55,16 @@ func performUpdate(ctx context.Context) error {
 	}
 	defer updateInProgress.UnSet()
 
+	listUpdateLock.Lock()
+	defer listUpdateLock.Unlock()
+
 	// First, update the list index.
 	err := updateListIndex()
 	if err != nil {
 		log.Errorf("intel/filterlists: failed update list index: %s", err)
 	}
 
-	upgradables, err := getUpgradableFiles()
+	upgradables, err := getUpgradableFiles(ctx)
 	if err != nil {
 		return err
 	}
@@ -64,32 +75,9 @@ func performUpdate(ctx context.Context) error {
 		return nil
 	}
 
-	cleanupRequired := false
-	filterToUpdate := defaultFilter
-
-	// perform the actual upgrade by processing each file
-	// in the returned order.
-	for idx, file := range upgradables {
-		log.Debugf("intel/filterlists: applying update (%d) %s version %s", idx, file.Identifier(), file.Version())
-
-		if file == baseFile {
-			if idx != 0 {
-				log.Warningf("intel/filterlists: upgrade order is wrong, base file needs to be updated first not at idx %d", idx)
-				// we still continue because after processing the base
-				// file everything is correct again, we just used some
-				// CPU and IO resources for nothing when processing
-				// the previous files.
-			}
-			cleanupRequired = true
-
-			// since we are processing a base update we will create our
-			// bloom filters from scratch.
-			filterToUpdate = newScopedBloom()
-		}
-
-		if err := processListFile(ctx, filterToUpdate, file); err != nil {
-			return fmt.Errorf("failed to process upgrade %s: %w", file.Identifier(), err)
-		}
+	upgradables, filterToUpdate, cleanupRequired, fullFilesProcessed, err := processUpdateFilesOrRebuild(ctx, upgradables)
+	if err != nil {
+		return err
 	}
 
 	if filterToUpdate != defaultFilter {
@@ -97,6 +85,7 @@ func performUpdate(ctx context.Context) error {
 		// filter.
 		defaultFilter.replaceWith(filterToUpdate)
 	}
+	defaultFilter.warnIfSaturated()
 
 	// from now on, the database is ready and can be used if
 	// it wasn't loaded yet.
@@ -129,6 +118,13 @@ func performUpdate(ctx context.Context) error {
 		}
 	}
 
+	// Full list files replace entries and possibly the bloom filters, so
+	// the custom filter lists need to be applied again. Delta updates keep
+	// the entries of custom filter lists.
+	if err := applyCustomLists(ctx, fullFilesProcessed); err != nil {
+		log.Warningf("intel/filterlists: failed to apply custom filter lists: %s", err)
+	}
+
 	// try to save the highest version of our files.
 	highestVersion := upgradables[len(upgradables)-1]
 	if err := setCacheDatabaseVersion(highestVersion.Version()); err != nil {
@@ -142,6 +138,82 @@ func performUpdate(ctx context.Context) error {
 	return nil
 }
 
+// processUpdateFilesOrRebuild wraps processUpdateFiles but falls back to
+// rebuilding the cache database from the full list files if a delta update
+// failed. It also returns the files that were actually processed.
+func processUpdateFilesOrRebuild(ctx context.Context, files []*updater.File) (processed []*updater.File, filterToUpdate *scopedBloom, cleanupRequired, fullFilesProcessed bool, err error) {
+	filterToUpdate, cleanupRequired, fullFilesProcessed, err = processUpdateFiles(ctx, files)
+	if err == nil {
+		return files, filterToUpdate, cleanupRequired, fullFilesProcessed, nil
+	}
+
+	var deltaErr *deltaError
+	if !errors.As(err, &deltaErr) {
+		return nil, nil, false, false, err
+	}
+	log.Warningf("intel/filterlists: %s, falling back to full rebuild", err)
+
+	// Entries of the failed delta update would survive the cleanup of
+	// the rebuild, as they are newer than the entries it removes.
+	if err := removeFailedDeltaEntries(ctx, deltaErr); err != nil {
+		return nil, nil, false, false, fmt.Errorf("failed to remove entries of failed delta update: %w", err)
+	}
+
+	files, err = getFullRebuildOrder()
+	if err != nil {
+		return nil, nil, false, false, err
+	}
+	filterToUpdate, cleanupRequired, fullFilesProcessed, err = processUpdateFiles(ctx, files)
+	if err != nil {
+		return nil, nil, false, false, err
+	}
+	return files, filterToUpdate, cleanupRequired, fullFilesProcessed, nil
+}
+
+// processUpdateFiles processes the given list files in order. It returns the
+// bloom filter that holds the update, whether obsolete entries need to be
+// removed and whether any full list files, which replace the entries of custom
+// filter lists, were processed.
+func processUpdateFiles(ctx context.Context, files []*updater.File) (filterToUpdate *scopedBloom, cleanupRequired, fullFilesProcessed bool, err error) {
+	filterToUpdate = defaultFilter
+
+	// perform the actual upgrade by processing each file
+	// in the returned order.
+	for idx, file := range files {
+		log.Debugf("intel/filterlists: applying update (%d) %s version %s", idx, file.Identifier(), file.Version())
+
+		if file == deltaFile {
+			filterToUpdate, err = applyDeltaFile(ctx, filterToUpdate, file)
+			if err != nil {
+				return nil, false, false, err
+			}
+			continue
+		}
+
+		if file == baseFile {
+			if idx != 0 {
+				log.Warningf("intel/filterlists: upgrade order is wrong, base file needs to be updated first not at idx %d", idx)
+				// we still continue because after processing the base
+				// file everything is correct again, we just used some
+				// CPU and IO resources for nothing when processing
+				// the previous files.
+			}
+			cleanupRequired = true
+
+			// since we are processing a base update we will create our
+			// bloom filters from scratch, sized for the current lists.
+			filterToUpdate = newScopedBloom(getBloomCapacities())
+		}
+
+		if err := processListFile(ctx, filterToUpdate, file); err != nil {
+			return nil, false, false, fmt.Errorf("failed to process upgrade %s: %w", file.Identifier(), err)
+		}
+		fullFilesProcessed = true
+	}
+
+	return filterToUpdate, cleanupRequired, fullFilesProcessed, nil
+}
+
 func removeAllObsoleteFilterEntries(ctx context.Context) error {
 	log.Debugf("intel/filterlists: cleanup task started, removing obsolete filter list entries ...")
 	n, err := cache.Purge(ctx, query.New(filterListKeyPrefix).Where(
@@ -160,7 +232,7 @@ func removeAllObsoleteFilterEntries(ctx context.Context) error {
 // getUpgradableFiles returns a slice of filterlists files
 // that should be updated. The files MUST be updated and
 // processed in the returned order!
-func getUpgradableFiles() ([]*updater.File, error) {
+func getUpgradableFiles(ctx context.Context) ([]*updater.File, error) {
 	var updateOrder []*updater.File
 
 	cacheDBInUse := isLoaded()
@@ -201,10 +273,38 @@ func getUpgradableFiles() ([]*updater.File, error) {
 		}
 	}
 
-	return resolveUpdateOrder(updateOrder)
+	if deltaFile == nil || deltaFile.UpgradeAvailable() || !cacheDBInUse {
+		var err error
+		deltaFile, err = getFile(deltaListFilePath)
+		if err != nil && err != updater.ErrNotFound {
+			return nil, err
+		}
+
+		if err == nil {
+			log.Tracef("intel/filterlists: delta file needs update, selected version %s", deltaFile.Version())
+		}
+	}
+	// The delta file is always checked, as it may not have been applied
+	// yet, even if there is no upgrade.
+	if deltaFile != nil {
+		updateOrder = append(updateOrder, deltaFile)
+	}
+
+	return resolveUpdateOrder(ctx, updateOrder)
 }
 
-func resolveUpdateOrder(updateOrder []*updater.File) ([]*updater.File, error) {
+func resolveUpdateOrder(ctx context.Context, updateOrder []*updater.File) ([]*updater.File, error) {
+	// The delta file is handled separately, as it depends on the version
+	// of the cache database.
+	var delta *updater.File
+	for idx, file := range updateOrder {
+		if file == deltaFile {
+			delta = file
+			updateOrder = append(updateOrder[:idx:idx], updateOrder[idx+1:]...)
+			break
+		}
+	}
+
 	// sort the update order by ascending version
 	sort.Sort(byAscVersion(updateOrder))
 	log.Tracef("intel/filterlists: order of updates: %v", updateOrder)
@@ -232,16 +332,29 @@ func resolveUpdateOrder(updateOrder []*updater.File) ([]*updater.File, error) {
 		}
 	}
 
-	// if startAtIdx == -1 we don't have any upgradables to
+	// skip any files that are lower then the current cache db version
+	// or after which a base upgrade would be performed.
+	var order []*updater.File
+	if startAtIdx != -1 {
+		order = updateOrder[startAtIdx:]
+	}
+
+	if delta != nil {
+		var err error
+		order, err = resolveDeltaUpdateOrder(ctx, order, delta, cacheDBVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// if there are no files we don't have any upgradables to
 	// process.
-	if startAtIdx == -1 {
+	if len(order) == 0 {
 		log.Tracef("intel/filterlists: nothing to process, latest version %s already in use", cacheDBVersion)
 		return nil, nil
 	}
 
-	// skip any files that are lower then the current cache db version
-	// or after which a base upgrade would be performed.
-	return updateOrder[startAtIdx:], nil
+	return order, nil
 }
 
 type byAscVersion []*updater.File
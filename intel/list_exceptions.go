@@ -0,0 +1,127 @@
+package intel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// ListExceptions holds domains and IP addresses that are exempt from filter
+// list matches.
+type ListExceptions struct {
+	domains  map[string]struct{}
+	zones    []string
+	suffixes []string
+	networks []*net.IPNet
+}
+
+// ParseListExceptions parses the given filter list exceptions. Supported are
+// exact domains ("example.com"), domains including all their subdomains
+// (".example.com"), only the subdomains of a domain ("*.example.com"), IP
+// addresses and IP networks in CIDR notation.
+func ParseListExceptions(entries []string) (*ListExceptions, error) {
+	le := &ListExceptions{
+		domains: make(map[string]struct{}),
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// IP addresses and networks.
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter list exception %q: %w", entry, err)
+			}
+			le.networks = append(le.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			le.networks = append(le.networks, singleIPNetwork(ip))
+			continue
+		}
+
+		// Domains.
+		domain := dns.Fqdn(strings.ToLower(entry))
+		switch {
+		case strings.HasPrefix(domain, "*."):
+			domain = strings.TrimPrefix(domain, "*.")
+			if !netutils.IsValidFqdn(domain) {
+				return nil, fmt.Errorf("invalid filter list exception %q", entry)
+			}
+			le.suffixes = append(le.suffixes, "."+domain)
+		case strings.HasPrefix(domain, "."):
+			domain = strings.TrimPrefix(domain, ".")
+			if !netutils.IsValidFqdn(domain) {
+				return nil, fmt.Errorf("invalid filter list exception %q", entry)
+			}
+			le.domains[domain] = struct{}{}
+			le.zones = append(le.zones, "."+domain)
+		default:
+			if !netutils.IsValidFqdn(domain) {
+				return nil, fmt.Errorf("invalid filter list exception %q", entry)
+			}
+			le.domains[domain] = struct{}{}
+		}
+	}
+
+	return le, nil
+}
+
+func singleIPNetwork(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+// IsEmpty returns whether there are no exceptions.
+func (le *ListExceptions) IsEmpty() bool {
+	return le == nil ||
+		len(le.domains) == 0 && len(le.suffixes) == 0 && len(le.networks) == 0
+}
+
+// MatchDomain returns whether the given FQDN is exempt from filter list
+// matches.
+func (le *ListExceptions) MatchDomain(domain string) bool {
+	if le == nil || domain == "" {
+		return false
+	}
+	domain = dns.Fqdn(strings.ToLower(domain))
+
+	if _, ok := le.domains[domain]; ok {
+		return true
+	}
+	for _, zone := range le.zones {
+		if strings.HasSuffix(domain, zone) {
+			return true
+		}
+	}
+	for _, suffix := range le.suffixes {
+		if strings.HasSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchIP returns whether the given IP address is exempt from filter list
+// matches.
+func (le *ListExceptions) MatchIP(ip net.IP) bool {
+	if le == nil || ip == nil {
+		return false
+	}
+
+	for _, network := range le.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package intel
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseListExceptions(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		entries []string
+		valid   bool
+	}{
+		{entries: nil, valid: true},
+		{entries: []string{"", "  "}, valid: true},
+		{entries: []string{"example.com", ".example.org", "*.example.net"}, valid: true},
+		{entries: []string{"192.0.2.1", "2001:db8::1", "198.51.100.0/24", "2001:db8:1::/48"}, valid: true},
+		{entries: []string{"not a domain"}},
+		{entries: []string{".not a domain"}},
+		{entries: []string{"*.not a domain"}},
+		{entries: []string{"192.0.2.0/33"}},
+		{entries: []string{"example.com/24"}},
+	} {
+		le, err := ParseListExceptions(test.entries)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%v: failed to parse exceptions: %s", test.entries, err)
+		case test.valid && le.IsEmpty() != (len(test.entries) == 0 || test.entries[0] == ""):
+			t.Errorf("%v: unexpected empty state %v", test.entries, le.IsEmpty())
+		case !test.valid && err == nil:
+			t.Errorf("%v: expected exceptions to be rejected", test.entries)
+		}
+	}
+}
+
+func TestListExceptionsMatchDomain(t *testing.T) {
+	t.Parallel()
+
+	le, err := ParseListExceptions([]string{
+		"Example.COM",
+		".example.org",
+		"*.example.net",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		domain  string
+		matches bool
+	}{
+		// Exact domains.
+		{domain: "example.com.", matches: true},
+		{domain: "example.com", matches: true},
+		{domain: "EXAMPLE.com.", matches: true},
+		{domain: "www.example.com.", matches: false},
+		// Domains including their subdomains.
+		{domain: "example.org.", matches: true},
+		{domain: "www.example.org.", matches: true},
+		{domain: "a.b.example.org.", matches: true},
+		{domain: "badexample.org.", matches: false},
+		// Only subdomains.
+		{domain: "example.net.", matches: false},
+		{domain: "www.example.net.", matches: true},
+		{domain: "a.b.example.net.", matches: true},
+		{domain: "badexample.net.", matches: false},
+		// Others.
+		{domain: "example.", matches: false},
+		{domain: "example.com.evil.", matches: false},
+		{domain: "", matches: false},
+	} {
+		if le.MatchDomain(test.domain) != test.matches {
+			t.Errorf("%q: expected match to be %v", test.domain, test.matches)
+		}
+	}
+
+	var nilExceptions *ListExceptions
+	if nilExceptions.MatchDomain("example.com.") {
+		t.Error("nil exceptions must not match")
+	}
+}
+
+func TestListExceptionsMatchIP(t *testing.T) {
+	t.Parallel()
+
+	le, err := ParseListExceptions([]string{
+		"192.0.2.1",
+		"198.51.100.0/24",
+		"2001:db8::1",
+		"2001:db8:1::/48",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		ip      string
+		matches bool
+	}{
+		// Single addresses.
+		{ip: "192.0.2.1", matches: true},
+		{ip: "192.0.2.2", matches: false},
+		{ip: "::ffff:192.0.2.1", matches: true},
+		{ip: "2001:db8::1", matches: true},
+		{ip: "2001:db8::2", matches: false},
+		// Networks.
+		{ip: "198.51.100.0", matches: true},
+		{ip: "198.51.100.255", matches: true},
+		{ip: "198.51.101.0", matches: false},
+		{ip: "2001:db8:1::1", matches: true},
+		{ip: "2001:db8:1:ffff::1", matches: true},
+		{ip: "2001:db8:2::1", matches: false},
+	} {
+		if le.MatchIP(net.ParseIP(test.ip)) != test.matches {
+			t.Errorf("%s: expected match to be %v", test.ip, test.matches)
+		}
+	}
+
+	if le.MatchIP(nil) {
+		t.Error("nil IP must not match")
+	}
+}
@@ -11,6 +11,12 @@
 // Config Keys
 const (
 	CfgDefaultNameserverAddressKey = "dns/listenAddress"
+
+	CfgOptionEnableQueryLogKey   = "dns/queryLog"
+	cfgOptionEnableQueryLogOrder = 48
+
+	CfgOptionQueryLogMaxAgeKey   = "dns/queryLogMaxAge"
+	cfgOptionQueryLogMaxAgeOrder = 49
 )
 
 var (
@@ -19,6 +25,9 @@
 	nameserverAddressConfig  config.StringOption
 
 	networkServiceMode config.BoolOption
+
+	enableQueryLog config.BoolOption
+	queryLogMaxAge config.IntOption
 )
 
 func init() {
@@ -58,5 +67,47 @@ func registerConfig() error {
 
 	networkServiceMode = config.Concurrent.GetAsBool(core.CfgNetworkServiceKey, false)
 
+	err = config.Register(&config.Option{
+		Name:           "DNS Query Log",
+		Key:            CfgOptionEnableQueryLogKey,
+		Description:    "Save all DNS requests, including the process that made them, the answer and the verdict, to disk. The query log can be exported in the JSON Lines and dnstap formats.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionEnableQueryLogOrder,
+			config.CategoryAnnotation:     "Query Log",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enableQueryLog = config.Concurrent.GetAsBool(CfgOptionEnableQueryLogKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Query Log Retention",
+		Key:            CfgOptionQueryLogMaxAgeKey,
+		Description:    "How long DNS requests are kept in the query log.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   7,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionQueryLogMaxAgeOrder,
+			config.UnitAnnotation:         "days",
+			config.CategoryAnnotation:     "Query Log",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionEnableQueryLogKey,
+				Value: true,
+			},
+		},
+		ValidationRegex: `^[1-9][0-9]{0,3}$`,
+	})
+	if err != nil {
+		return err
+	}
+	queryLogMaxAge = config.Concurrent.GetAsInt(CfgOptionQueryLogMaxAgeKey, 7)
+
 	return nil
 }
@@ -0,0 +1,18 @@
+package nameserver
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portmaster/core/pmtesting"
+)
+
+// TestMain only sets up an in-memory history database, as starting the
+// nameserver module would start listening on the DNS port.
+func TestMain(m *testing.M) {
+	pmtesting.TestMainWithDatabases(m, &database.Database{
+		Name:        "history",
+		Description: "Test History",
+		StorageType: "hashmap",
+	})
+}
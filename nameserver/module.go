@@ -41,6 +41,10 @@ func start() error {
 		return err
 	}
 
+	if err := registerQueryLog(); err != nil {
+		return err
+	}
+
 	ip1, ip2, port, err := getListenAddresses(nameserverAddressConfig())
 	if err != nil {
 		return fmt.Errorf("failed to parse nameserver listen address: %w", err)
@@ -49,8 +53,7 @@ func start() error {
 	// Start listener(s).
 	if ip2 == nil {
 		// Start a single listener.
-		dnsServer := startListener(ip1, port)
-		stopListener = dnsServer.Shutdown
+		stopListener = startListeners(ip1, port)
 
 		// Set nameserver matcher in firewall to fast-track dns queries.
 		if ip1.Equal(net.IPv4zero) || ip1.Equal(net.IPv6zero) {
@@ -70,12 +73,12 @@ func start() error {
 
 	} else {
 		// Dual listener.
-		dnsServer1 := startListener(ip1, port)
-		dnsServer2 := startListener(ip2, port)
+		stopListener1 := startListeners(ip1, port)
+		stopListener2 := startListeners(ip2, port)
 		stopListener = func() error {
 			// Shutdown both listeners.
-			err1 := dnsServer1.Shutdown()
-			err2 := dnsServer2.Shutdown()
+			err1 := stopListener1()
+			err2 := stopListener2()
 			// Return first error.
 			if err1 != nil {
 				return err1
@@ -90,20 +93,38 @@ func start() error {
 	}
 }
 
-func startListener(ip net.IP, port uint16) *dns.Server {
+// startListeners starts an UDP and a TCP listener on the given IP and port and
+// returns a function to stop both.
+func startListeners(ip net.IP, port uint16) (stop func() error) {
+	udpServer := startListener(ip, port, "udp")
+	tcpServer := startListener(ip, port, "tcp")
+
+	return func() error {
+		// Shutdown both listeners.
+		err1 := udpServer.Shutdown()
+		err2 := tcpServer.Shutdown()
+		// Return first error.
+		if err1 != nil {
+			return err1
+		}
+		return err2
+	}
+}
+
+func startListener(ip net.IP, port uint16, network string) *dns.Server {
 	// Create DNS server.
 	dnsServer := &dns.Server{
 		Addr: net.JoinHostPort(
 			ip.String(),
 			strconv.Itoa(int(port)),
 		),
-		Net: "udp",
+		Net:     network,
+		Handler: dns.HandlerFunc(handleRequestAsWorker),
 	}
-	dns.HandleFunc(".", handleRequestAsWorker)
 
 	// Start DNS server as service worker.
-	log.Infof("nameserver: starting to listen on %s", dnsServer.Addr)
-	module.StartServiceWorker("dns resolver", 0, func(ctx context.Context) error {
+	log.Infof("nameserver: starting to listen on %s/%s", dnsServer.Addr, network)
+	module.StartServiceWorker("dns resolver ("+network+")", 0, func(ctx context.Context) error {
 		err := dnsServer.ListenAndServe()
 		if err != nil {
 			// check if we are shutting down
@@ -14,6 +14,7 @@
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/resolver"
 
 	"github.com/miekg/dns"
@@ -52,27 +53,54 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 		QType: dns.Type(originalQuestion.Qtype),
 	}
 
-	// Get remote address of request.
-	remoteAddr, ok := w.RemoteAddr().(*net.UDPAddr)
-	if !ok {
+	// Get remote address and transport protocol of request.
+	var remoteAddr *net.UDPAddr
+	var protocol packet.IPProtocol
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		remoteAddr = addr
+		protocol = packet.UDP
+	case *net.TCPAddr:
+		remoteAddr = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+		protocol = packet.TCP
+	default:
 		log.Warningf("nameserver: failed to get remote address of request for %s%s, ignoring", q.FQDN, q.QType)
 		return nil
 	}
-	// log.Errorf("DEBUG: nameserver: handling new request for %s from %s:%d", q.ID(), remoteAddr.IP, remoteAddr.Port)
 
 	// Start context tracer for context-aware logging.
 	ctx, tracer := log.AddTracer(ctx)
 	defer tracer.Submit()
-	tracer.Tracef("nameserver: handling new request for %s from %s:%d", q.ID(), remoteAddr.IP, remoteAddr.Port)
+	tracer.Tracef("nameserver: handling new request for %s from %s:%d (%s)", q.ID(), remoteAddr.IP, remoteAddr.Port, protocol)
 
 	// Check if there are more than one question.
 	if len(request.Question) > 1 {
 		tracer.Warningf("nameserver: received more than one question from (%s:%d), first question is %s", remoteAddr.IP, remoteAddr.Port, q.ID())
 	}
 
+	// Record the request in the query log when done.
+	var (
+		conn     *network.Connection
+		rrCache  *resolver.RRCache
+		response *dns.Msg
+	)
+	defer func() {
+		logQuery(ctx, &queryLogInfo{
+			started:    startTime,
+			request:    request,
+			response:   response,
+			remoteAddr: remoteAddr,
+			localAddr:  w.LocalAddr(),
+			protocol:   protocol,
+			conn:       conn,
+			rrCache:    rrCache,
+		})
+	}()
+
 	// Setup quick reply function.
 	reply := func(responder nsutil.Responder, rrProviders ...nsutil.RRProvider) error {
-		err := sendResponse(ctx, w, request, responder, rrProviders...)
+		var err error
+		response, err = sendResponse(ctx, w, request, responder, rrProviders...)
 		// Log error here instead of returning it in order to keep the context.
 		if err != nil {
 			tracer.Errorf("nameserver: %s", err)
@@ -80,6 +108,12 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 		return nil
 	}
 
+	// Check the EDNS version, only version 0 is supported.
+	if opt := request.IsEdns0(); opt != nil && opt.Version() != 0 {
+		tracer.Debugf("nameserver: unsupported EDNS version %d in request for %s, returning BADVERS", opt.Version(), q.ID())
+		return reply(nsutil.BadVersion())
+	}
+
 	// Check the Query Class.
 	if originalQuestion.Qclass != dns.ClassINET {
 		// we only serve IN records, return nxdomain
@@ -116,10 +150,9 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 	)
 
 	// Get connection for this request. This identifies the process behind the request.
-	var conn *network.Connection
 	switch {
 	case local:
-		conn = network.NewConnectionFromDNSRequest(ctx, q.FQDN, nil, connID, remoteAddr.IP, uint16(remoteAddr.Port))
+		conn = network.NewConnectionFromDNSRequest(ctx, q.FQDN, nil, connID, protocol, remoteAddr.IP, uint16(remoteAddr.Port))
 
 	case networkServiceMode():
 		conn, err = network.NewConnectionFromExternalDNSRequest(ctx, q.FQDN, nil, connID, remoteAddr.IP)
@@ -135,9 +168,6 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 	conn.Lock()
 	defer conn.Unlock()
 
-	// Create reference for the rrCache.
-	var rrCache *resolver.RRCache
-
 	// Once we decided on the connection we might need to save it to the database,
 	// so we defer that check for now.
 	defer func() {
@@ -162,6 +162,16 @@ func ServerFailure(msgs ...string) ResponderFunc {
 	}
 }
 
+// BadVersion returns a ResponderFunc that replies with BADVERS, which signals
+// that the EDNS version of the request is not supported.
+func BadVersion(msgs ...string) ResponderFunc {
+	return func(ctx context.Context, request *dns.Msg) *dns.Msg {
+		reply := new(dns.Msg).SetRcode(request, dns.RcodeBadVers)
+		AddMessagesToReply(ctx, reply, log.InfoLevel, msgs...)
+		return reply
+	}
+}
+
 // MakeMessageRecord creates an informational resource record that can be added
 // to the extra section of a reply.
 func MakeMessageRecord(level log.Severity, msg string) (dns.RR, error) { //nolint:interfacer
@@ -179,6 +189,13 @@ func MakeMessageRecord(level log.Severity, msg string) (dns.RR, error) { //nolin
 	return rr, nil
 }
 
+// IsMessageRecord returns whether the given resource record is an
+// informational record created by MakeMessageRecord.
+func IsMessageRecord(rr dns.RR) bool {
+	txt, ok := rr.(*dns.TXT)
+	return ok && strings.HasSuffix(txt.Hdr.Name, ".portmaster.")
+}
+
 // AddMessagesToReply creates information resource records using
 // MakeMessageRecord and immediately adds them to the extra section of the given
 // reply. If an error occurs, the resource record will not be added, and the
@@ -0,0 +1,581 @@
+package nameserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/resolver"
+)
+
+const (
+	queryLogKeyPrefix = "history:dns/querylog/"
+
+	// defaultQueryLogLimit is the default amount of entries returned by the
+	// query log API.
+	defaultQueryLogLimit = 1000
+
+	// maxQueryLogKeyPrefixes is the maximum amount of key prefixes the time
+	// range of a query log request is split into.
+	maxQueryLogKeyPrefixes = 100
+)
+
+var (
+	// Writes are not delayed, as pending writes could not be discarded when
+	// the query log is cleared.
+	queryLogDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+)
+
+// Database prefixes:
+// Query Log: history:dns/querylog/<Time>-<RemotePort>-<RequestID>
+
+// QueryLogEntry is a record of an answered DNS request.
+type QueryLogEntry struct {
+	record.Base
+	sync.Mutex
+
+	// Time is when the request was received.
+	Time time.Time
+	// Duration is how long it took to answer the request.
+	Duration time.Duration
+	// ConnID is the ID of the connection of the request.
+	ConnID string
+	// ProcessContext holds information about the process that made the
+	// request.
+	ProcessContext network.ProcessContext
+	// Domain is the requested domain.
+	Domain string
+	// QType is the requested record type.
+	QType string
+	// Resolver holds information about the resolver that answered the
+	// request, if it was resolved.
+	Resolver *resolver.ResolverInfo
+	// RCode is the response code of the response.
+	RCode string
+	// AnswerIPs holds the IP addresses in the answer section of the response.
+	AnswerIPs []net.IP
+	// Verdict is the verdict of the request.
+	Verdict network.Verdict
+	// Reason is the reason of the verdict.
+	Reason string
+	// CacheHit is whether the request was answered from the cache.
+	CacheHit bool
+	// Protocol is the transport protocol of the request.
+	Protocol packet.IPProtocol
+	// ClientIP is the IP address the request was sent from.
+	ClientIP net.IP
+	// ClientPort is the port the request was sent from.
+	ClientPort uint16
+	// ServerIP is the IP address the request was received on.
+	ServerIP net.IP
+	// ServerPort is the port the request was received on.
+	ServerPort uint16
+	// Query holds the packed request message.
+	Query []byte
+	// Response holds the packed response message. It is empty if the request
+	// was dropped.
+	Response []byte
+}
+
+// EnsureQueryLogEntry returns a QueryLogEntry from the given record.
+func EnsureQueryLogEntry(r record.Record) (*QueryLogEntry, error) {
+	// unwrap
+	if r.IsWrapped() {
+		// only allocate a new struct, if we need it
+		new := &QueryLogEntry{}
+		err := record.Unwrap(r, new)
+		if err != nil {
+			return nil, err
+		}
+		return new, nil
+	}
+
+	// or adjust type
+	new, ok := r.(*QueryLogEntry)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *QueryLogEntry, but %T", r)
+	}
+	return new, nil
+}
+
+// queryLogInfo holds the information about a handled request that is needed
+// for logging it.
+type queryLogInfo struct {
+	started    time.Time
+	request    *dns.Msg
+	response   *dns.Msg
+	remoteAddr *net.UDPAddr
+	localAddr  net.Addr
+	protocol   packet.IPProtocol
+	conn       *network.Connection
+	rrCache    *resolver.RRCache
+}
+
+// logQuery saves the handled request to the query log, if enabled.
+func logQuery(ctx context.Context, info *queryLogInfo) {
+	if !enableQueryLog() {
+		return
+	}
+
+	question := info.request.Question[0]
+	entry := &QueryLogEntry{
+		Time:       info.started,
+		Duration:   time.Since(info.started),
+		Domain:     strings.ToLower(question.Name),
+		QType:      dns.Type(question.Qtype).String(),
+		Protocol:   info.protocol,
+		ClientIP:   info.remoteAddr.IP,
+		ClientPort: uint16(info.remoteAddr.Port),
+	}
+
+	// Add the local address.
+	switch addr := info.localAddr.(type) {
+	case *net.UDPAddr:
+		entry.ServerIP = addr.IP
+		entry.ServerPort = uint16(addr.Port)
+	case *net.TCPAddr:
+		entry.ServerIP = addr.IP
+		entry.ServerPort = uint16(addr.Port)
+	}
+
+	// Add the process attribution and verdict.
+	if info.conn != nil {
+		info.conn.Lock()
+		entry.ConnID = info.conn.ID
+		entry.ProcessContext = info.conn.ProcessContext
+		entry.Verdict = info.conn.Verdict
+		entry.Reason = info.conn.Reason.Msg
+		info.conn.Unlock()
+	}
+
+	// Add resolving information.
+	if info.rrCache != nil {
+		entry.Resolver = info.rrCache.Resolver
+		entry.CacheHit = info.rrCache.ServedFromCache
+	}
+
+	// Add the messages.
+	var err error
+	entry.Query, err = info.request.Pack()
+	if err != nil {
+		log.Tracer(ctx).Debugf("nameserver: failed to pack request for query log: %s", err)
+	}
+	if info.response != nil {
+		entry.RCode = dns.RcodeToString[info.response.Rcode]
+		for _, rr := range info.response.Answer {
+			switch v := rr.(type) {
+			case *dns.A:
+				entry.AnswerIPs = append(entry.AnswerIPs, v.A)
+			case *dns.AAAA:
+				entry.AnswerIPs = append(entry.AnswerIPs, v.AAAA)
+			}
+		}
+
+		entry.Response, err = info.response.Pack()
+		if err != nil {
+			log.Tracer(ctx).Debugf("nameserver: failed to pack response for query log: %s", err)
+		}
+	}
+
+	entry.SetKey(fmt.Sprintf(
+		"%s%d-%d-%d",
+		queryLogKeyPrefix,
+		info.started.UnixNano(),
+		info.remoteAddr.Port,
+		info.request.Id,
+	))
+	entry.UpdateMeta()
+	entry.Meta().SetAbsoluteExpiry(info.started.Add(time.Duration(queryLogMaxAge()) * 24 * time.Hour).Unix())
+
+	err = queryLogDB.PutNew(entry)
+	if err != nil {
+		log.Tracer(ctx).Warningf("nameserver: failed to save request to query log: %s", err)
+	}
+}
+
+// queryLogFilter selects entries of the query log.
+type queryLogFilter struct {
+	since   time.Time
+	until   time.Time
+	profile string
+	domain  string
+	limit   int
+}
+
+// parseQueryLogFilter parses a query log filter from the given URL
+// parameters.
+func parseQueryLogFilter(params url.Values) (*queryLogFilter, error) {
+	filter := &queryLogFilter{
+		profile: params.Get("profile"),
+		limit:   defaultQueryLogLimit,
+	}
+
+	if since := params.Get("since"); since != "" {
+		ts, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since timestamp: %s", since)
+		}
+		filter.since = time.Unix(ts, 0)
+	}
+	if until := params.Get("until"); until != "" {
+		ts, err := strconv.ParseInt(until, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until timestamp: %s", until)
+		}
+		filter.until = time.Unix(ts, 0)
+	}
+	if domain := params.Get("domain"); domain != "" {
+		filter.domain = dns.Fqdn(strings.ToLower(domain))
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+		filter.limit = n
+	}
+
+	return filter, nil
+}
+
+// matches returns whether the given entry is selected by the filter.
+func (filter *queryLogFilter) matches(entry *QueryLogEntry) bool {
+	switch {
+	case !filter.since.IsZero() && entry.Time.Before(filter.since):
+		return false
+	case !filter.until.IsZero() && entry.Time.After(filter.until):
+		return false
+	case filter.profile != "" &&
+		filter.profile != entry.ProcessContext.Source+"/"+entry.ProcessContext.Profile:
+		return false
+	case filter.domain != "" &&
+		entry.Domain != filter.domain &&
+		!strings.HasSuffix(entry.Domain, "."+filter.domain):
+		return false
+	default:
+		return true
+	}
+}
+
+// keyRange returns the time range of the query log entries that can match
+// the filter. Entries older than the maximum age of the query log are not
+// included.
+func (filter *queryLogFilter) keyRange() (since, until time.Time) {
+	now := time.Now()
+
+	since = now.Add(-time.Duration(queryLogMaxAge()) * 24 * time.Hour)
+	if filter.since.After(since) {
+		since = filter.since
+	}
+	until = now
+	if !filter.until.IsZero() && filter.until.Before(until) {
+		until = filter.until
+	}
+	return since, until
+}
+
+// queryLogKeyPrefixes returns the key prefixes that select all query log
+// entries of the given time range, from oldest to newest. Keys start with the
+// time of the request in nanoseconds, which has the same amount of digits for
+// all times between 2001 and 2286, so that every key prefix selects a
+// continuous time range. The given time range is covered by as many prefixes
+// of the same length as possible, up to maxQueryLogKeyPrefixes.
+func queryLogKeyPrefixes(since, until time.Time) []string {
+	start := since.UnixNano()
+	end := until.UnixNano()
+	if start > end {
+		return nil
+	}
+
+	// Find the smallest time range per prefix.
+	blockSize := int64(1)
+	for end/blockSize-start/blockSize >= maxQueryLogKeyPrefixes {
+		blockSize *= 10
+	}
+
+	prefixes := make([]string, 0, end/blockSize-start/blockSize+1)
+	for block := start / blockSize; block <= end/blockSize; block++ {
+		prefixes = append(prefixes, queryLogKeyPrefix+strconv.FormatInt(block, 10))
+	}
+	return prefixes
+}
+
+// getQueryLogEntries returns all query log entries with the given key prefix
+// that match the given filter, from oldest to newest.
+func getQueryLogEntries(prefix string, filter *queryLogFilter) ([]*QueryLogEntry, error) {
+	it, err := queryLogDB.Query(query.New(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*QueryLogEntry
+	for r := range it.Next {
+		entry, err := EnsureQueryLogEntry(r)
+		if err != nil {
+			log.Warningf("nameserver: failed to parse query log entry %s: %s", r.Key(), err)
+			continue
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	// Not all storages return records in key order.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// iterateQueryLog calls fn for all query log entries that match the given
+// filter, from oldest to newest. Iteration stops if fn returns an error.
+func iterateQueryLog(filter *queryLogFilter, fn func(*QueryLogEntry) error) error {
+	for _, prefix := range queryLogKeyPrefixes(filter.keyRange()) {
+		entries, err := getQueryLogEntries(prefix, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getQueryLog returns the most recent query log entries that match the given
+// filter, from oldest to newest, up to the limit of the filter.
+func getQueryLog(filter *queryLogFilter) ([]*QueryLogEntry, error) {
+	// Start with the newest entries, so that only the needed entries are
+	// loaded.
+	prefixes := queryLogKeyPrefixes(filter.keyRange())
+	var entries []*QueryLogEntry
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		older, err := getQueryLogEntries(prefixes[i], filter)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(older, entries...)
+
+		if filter.limit > 0 && len(entries) >= filter.limit {
+			return entries[len(entries)-filter.limit:], nil
+		}
+	}
+
+	return entries, nil
+}
+
+// clearQueryLog deletes all entries of the query log.
+func clearQueryLog(ctx context.Context) (int, error) {
+	n, err := queryLogDB.Purge(ctx, query.New(queryLogKeyPrefix))
+	if !errors.Is(err, database.ErrNotImplemented) {
+		return n, err
+	}
+
+	// Fall back to deleting the entries one by one.
+	it, err := queryLogDB.Query(query.New(queryLogKeyPrefix))
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for r := range it.Next {
+		keys = append(keys, r.Key())
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+
+	n = 0
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := queryLogDB.Delete(key); err != nil {
+			log.Warningf("nameserver: failed to delete %s from query log: %s", key, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+func registerQueryLog() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "dns/querylog",
+		Read:        api.PermitUser,
+		StructFunc:  handleGetQueryLog,
+		Name:        "Get DNS Query Log",
+		Description: "Returns the most recent entries of the DNS query log.",
+		Parameters: queryLogFilterParameters(api.Parameter{
+			Method:      http.MethodGet,
+			Field:       "limit",
+			Value:       "<n>",
+			Description: "Specify the maximum amount of entries to return. The default is 1000, 0 returns all entries.",
+		}),
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "dns/querylog/export",
+		Read:        api.PermitUser,
+		HandlerFunc: handleExportQueryLog,
+		Name:        "Export DNS Query Log",
+		Description: "Exports the DNS query log in the JSON Lines or dnstap format.",
+		Parameters: queryLogFilterParameters(api.Parameter{
+			Method:      http.MethodGet,
+			Field:       "format",
+			Value:       QueryLogFormatJSONL + "|" + QueryLogFormatDNSTap,
+			Description: "Specify the export format. The default is JSON Lines.",
+		}),
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:  "dns/querylog/clear",
+		Write: api.PermitUser,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			log.Info("nameserver: user requested query log clearing via action")
+			n, err := clearQueryLog(ar.Context())
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("cleared %d requests from the query log", n), nil
+		},
+		Name:        "Clear DNS Query Log",
+		Description: "Deletes all saved DNS requests from the query log.",
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// queryLogFilterParameters returns the API parameters of the query log
+// filter, followed by the given parameters.
+func queryLogFilterParameters(params ...api.Parameter) []api.Parameter {
+	return append([]api.Parameter{
+		{
+			Method:      http.MethodGet,
+			Field:       "since",
+			Value:       "<unix timestamp>",
+			Description: "Specify the earliest time of the included requests.",
+		},
+		{
+			Method:      http.MethodGet,
+			Field:       "until",
+			Value:       "<unix timestamp>",
+			Description: "Specify the latest time of the included requests.",
+		},
+		{
+			Method:      http.MethodGet,
+			Field:       "profile",
+			Value:       "<Source>/<ID>",
+			Description: "Specify a profile source and ID for which requests should be included.",
+		},
+		{
+			Method:      http.MethodGet,
+			Field:       "domain",
+			Value:       "<domain>",
+			Description: "Specify a domain for which requests, including requests for its subdomains, should be included.",
+		},
+	}, params...)
+}
+
+func handleGetQueryLog(ar *api.Request) (interface{}, error) {
+	filter, err := parseQueryLogFilter(ar.Request.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getQueryLog(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]*queryLogExport, 0, len(entries))
+	for _, entry := range entries {
+		exported = append(exported, entry.export())
+	}
+	return exported, nil
+}
+
+func handleExportQueryLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseQueryLogFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check the requested format.
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "":
+		format = QueryLogFormatJSONL
+		contentType = "application/x-ndjson"
+	case QueryLogFormatJSONL:
+		contentType = "application/x-ndjson"
+	case QueryLogFormatDNSTap:
+		contentType = "application/octet-stream"
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		`attachment; filename="portmaster-querylog-%s.%s"`,
+		time.Now().Format("20060102-150405"),
+		format,
+	))
+	w.WriteHeader(http.StatusOK)
+
+	// Create the writer for the requested format.
+	var writer queryLogWriter
+	if format == QueryLogFormatDNSTap {
+		writer, err = newDNSTapWriter(w)
+		if err != nil {
+			log.Tracer(r.Context()).Warningf("nameserver: failed to start query log export: %s", err)
+			return
+		}
+	} else {
+		writer = newJSONLWriter(w)
+	}
+
+	// Export all matching entries.
+	err = iterateQueryLog(filter, writer.Write)
+	if err != nil {
+		log.Tracer(r.Context()).Warningf("nameserver: failed to export query log: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		log.Tracer(r.Context()).Warningf("nameserver: failed to finish query log export: %s", err)
+	}
+}
@@ -0,0 +1,278 @@
+package nameserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/safing/portbase/info"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// Query log export formats.
+const (
+	QueryLogFormatJSONL  = "jsonl"
+	QueryLogFormatDNSTap = "dnstap"
+)
+
+// queryLogExport is the exported representation of a query log entry. It is
+// used for the JSON Lines export and the extra data of dnstap messages.
+type queryLogExport struct {
+	Time       time.Time `json:"time"`
+	DurationMS float64   `json:"duration_ms"`
+	ClientIP   net.IP    `json:"client_ip,omitempty"`
+	ClientPort uint16    `json:"client_port,omitempty"`
+	Protocol   string    `json:"protocol"`
+	Domain     string    `json:"qname"`
+	QType      string    `json:"qtype"`
+	RCode      string    `json:"rcode,omitempty"`
+	AnswerIPs  []net.IP  `json:"answer_ips,omitempty"`
+	Resolver   string    `json:"resolver,omitempty"`
+	CacheHit   bool      `json:"cache_hit"`
+	Verdict    string    `json:"verdict"`
+	Reason     string    `json:"reason,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Process    string    `json:"process,omitempty"`
+	Binary     string    `json:"binary,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+}
+
+func (entry *QueryLogEntry) export() *queryLogExport {
+	exported := &queryLogExport{
+		Time:       entry.Time,
+		DurationMS: float64(entry.Duration) / float64(time.Millisecond),
+		ClientIP:   entry.ClientIP,
+		ClientPort: entry.ClientPort,
+		Protocol:   entry.Protocol.String(),
+		Domain:     entry.Domain,
+		QType:      entry.QType,
+		RCode:      entry.RCode,
+		AnswerIPs:  entry.AnswerIPs,
+		CacheHit:   entry.CacheHit,
+		Verdict:    entry.Verdict.Verb(),
+		Reason:     entry.Reason,
+		Process:    entry.ProcessContext.ProcessName,
+		Binary:     entry.ProcessContext.BinaryPath,
+		PID:        entry.ProcessContext.PID,
+	}
+	if entry.ProcessContext.Profile != "" {
+		exported.Profile = entry.ProcessContext.Source + "/" + entry.ProcessContext.Profile
+	}
+	if entry.Resolver != nil {
+		exported.Resolver = entry.Resolver.DescriptiveName()
+	}
+	if entry.Response == nil {
+		exported.Verdict = "dropped"
+	}
+	return exported
+}
+
+// queryLogWriter writes query log entries in an export format.
+type queryLogWriter interface {
+	Write(entry *QueryLogEntry) error
+	Close() error
+}
+
+// jsonlWriter writes query log entries as JSON Lines.
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+}
+
+func (jw *jsonlWriter) Write(entry *QueryLogEntry) error {
+	return jw.enc.Encode(entry.export())
+}
+
+func (jw *jsonlWriter) Close() error {
+	return jw.w.Flush()
+}
+
+// Frame Streams constants, as used by dnstap.
+// https://farsightsec.github.io/fstrm/
+const (
+	fstrmControlStart       = 0x02
+	fstrmControlStop        = 0x03
+	fstrmControlFieldType   = 0x01
+	dnstapContentType       = "protobuf:dnstap.Dnstap"
+	dnstapTypeMessage       = 1
+	dnstapMsgClientQuery    = 5
+	dnstapMsgClientResponse = 6
+	dnstapSocketFamilyINET  = 1
+	dnstapSocketFamilyINET6 = 2
+	dnstapSocketProtoUDP    = 1
+	dnstapSocketProtoTCP    = 2
+)
+
+// dnstapWriter writes query log entries as a dnstap Frame Stream. Every entry
+// is written as a client query and a client response message. The process
+// attribution is added as extra data in the JSON Lines format.
+// https://dnstap.info/
+type dnstapWriter struct {
+	w        *bufio.Writer
+	identity []byte
+	version  []byte
+}
+
+func newDNSTapWriter(w io.Writer) (*dnstapWriter, error) {
+	dw := &dnstapWriter{
+		w:       bufio.NewWriter(w),
+		version: []byte("portmaster " + info.Version()),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		dw.identity = []byte(hostname)
+	}
+
+	// Write the start control frame.
+	contentType := []byte(dnstapContentType)
+	control := make([]byte, 0, 12+len(contentType))
+	control = appendUint32(control, fstrmControlStart)
+	control = appendUint32(control, fstrmControlFieldType)
+	control = appendUint32(control, uint32(len(contentType)))
+	control = append(control, contentType...)
+	if err := dw.writeControlFrame(control); err != nil {
+		return nil, err
+	}
+
+	return dw, nil
+}
+
+func (dw *dnstapWriter) Write(entry *QueryLogEntry) error {
+	extra, err := json.Marshal(entry.export())
+	if err != nil {
+		return err
+	}
+
+	if err := dw.writeDataFrame(dw.encode(entry, dnstapMsgClientQuery, extra)); err != nil {
+		return err
+	}
+	if entry.Response == nil {
+		// The request was dropped.
+		return nil
+	}
+	return dw.writeDataFrame(dw.encode(entry, dnstapMsgClientResponse, extra))
+}
+
+func (dw *dnstapWriter) Close() error {
+	if err := dw.writeControlFrame(appendUint32(nil, fstrmControlStop)); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+func (dw *dnstapWriter) writeControlFrame(control []byte) error {
+	// A control frame is escaped with a data frame length of zero.
+	frame := appendUint32(nil, 0)
+	frame = appendUint32(frame, uint32(len(control)))
+	_, err := dw.w.Write(append(frame, control...))
+	return err
+}
+
+func (dw *dnstapWriter) writeDataFrame(data []byte) error {
+	_, err := dw.w.Write(append(appendUint32(nil, uint32(len(data))), data...))
+	return err
+}
+
+// encode encodes the given entry as a dnstap protobuf message of the given
+// type.
+func (dw *dnstapWriter) encode(entry *QueryLogEntry, msgType uint64, extra []byte) []byte {
+	// Encode the Message.
+	var msg []byte
+	msg = appendVarintField(msg, 1, msgType)
+	clientIP := entry.ClientIP
+	serverIP := entry.ServerIP
+	if ip4 := clientIP.To4(); ip4 != nil {
+		msg = appendVarintField(msg, 2, dnstapSocketFamilyINET)
+		clientIP = ip4
+		serverIP = serverIP.To4()
+	} else {
+		msg = appendVarintField(msg, 2, dnstapSocketFamilyINET6)
+		serverIP = serverIP.To16()
+	}
+	if entry.Protocol == packet.TCP {
+		msg = appendVarintField(msg, 3, dnstapSocketProtoTCP)
+	} else {
+		msg = appendVarintField(msg, 3, dnstapSocketProtoUDP)
+	}
+	if clientIP != nil {
+		msg = appendBytesField(msg, 4, clientIP)
+	}
+	if serverIP != nil {
+		msg = appendBytesField(msg, 5, serverIP)
+	}
+	msg = appendVarintField(msg, 6, uint64(entry.ClientPort))
+	if entry.ServerPort != 0 {
+		msg = appendVarintField(msg, 7, uint64(entry.ServerPort))
+	}
+	msg = appendVarintField(msg, 8, uint64(entry.Time.Unix()))
+	msg = appendFixed32Field(msg, 9, uint32(entry.Time.Nanosecond()))
+
+	if msgType == dnstapMsgClientQuery {
+		msg = appendBytesField(msg, 10, entry.Query)
+	} else {
+		responseTime := entry.Time.Add(entry.Duration)
+		msg = appendVarintField(msg, 12, uint64(responseTime.Unix()))
+		msg = appendFixed32Field(msg, 13, uint32(responseTime.Nanosecond()))
+		msg = appendBytesField(msg, 14, entry.Response)
+	}
+
+	// Encode the Dnstap envelope.
+	var frame []byte
+	if len(dw.identity) > 0 {
+		frame = appendBytesField(frame, 1, dw.identity)
+	}
+	frame = appendBytesField(frame, 2, dw.version)
+	frame = appendBytesField(frame, 3, extra)
+	frame = appendBytesField(frame, 14, msg)
+	frame = appendVarintField(frame, 15, dnstapTypeMessage)
+	return frame
+}
+
+// Protocol Buffers wire format helpers.
+// https://developers.google.com/protocol-buffers/docs/encoding
+const (
+	protobufWireVarint  = 0
+	protobufWireBytes   = 2
+	protobufWireFixed32 = 5
+)
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|protobufWireVarint))
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field<<3|protobufWireBytes))
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendFixed32Field(b []byte, field int, v uint32) []byte {
+	b = appendVarint(b, uint64(field<<3|protobufWireFixed32))
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,563 @@
+package nameserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+func TestParseQueryLogFilter(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		params   string
+		expected *queryLogFilter
+	}{
+		{
+			params: "",
+			expected: &queryLogFilter{
+				limit: defaultQueryLogLimit,
+			},
+		},
+		{
+			params: "since=1600000000&until=1600003600&profile=local/abc&domain=Example.COM&limit=0",
+			expected: &queryLogFilter{
+				since:   time.Unix(1600000000, 0),
+				until:   time.Unix(1600003600, 0),
+				profile: "local/abc",
+				domain:  "example.com.",
+				limit:   0,
+			},
+		},
+		{
+			params: "domain=example.com.&limit=10",
+			expected: &queryLogFilter{
+				domain: "example.com.",
+				limit:  10,
+			},
+		},
+		{params: "since=yesterday"},
+		{params: "until=1.5"},
+		{params: "limit=-1"},
+		{params: "limit=all"},
+	} {
+		params, err := url.ParseQuery(test.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter, err := parseQueryLogFilter(params)
+		switch {
+		case test.expected == nil && err == nil:
+			t.Errorf("%q: expected error", test.params)
+		case test.expected == nil:
+		case err != nil:
+			t.Errorf("%q: failed to parse: %s", test.params, err)
+		case !filter.since.Equal(test.expected.since) ||
+			!filter.until.Equal(test.expected.until) ||
+			filter.profile != test.expected.profile ||
+			filter.domain != test.expected.domain ||
+			filter.limit != test.expected.limit:
+			t.Errorf("%q: expected %+v, got %+v", test.params, test.expected, filter)
+		}
+	}
+}
+
+func TestQueryLogFilterMatches(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	entry := &QueryLogEntry{
+		Time:   now,
+		Domain: "www.example.com.",
+		ProcessContext: network.ProcessContext{
+			Source:  "local",
+			Profile: "abc",
+		},
+	}
+
+	for _, test := range []struct {
+		name    string
+		filter  *queryLogFilter
+		matches bool
+	}{
+		{"empty", &queryLogFilter{}, true},
+		{"since before", &queryLogFilter{since: now.Add(-time.Second)}, true},
+		{"since equal", &queryLogFilter{since: now}, true},
+		{"since after", &queryLogFilter{since: now.Add(time.Second)}, false},
+		{"until after", &queryLogFilter{until: now.Add(time.Second)}, true},
+		{"until before", &queryLogFilter{until: now.Add(-time.Second)}, false},
+		{"profile", &queryLogFilter{profile: "local/abc"}, true},
+		{"other profile", &queryLogFilter{profile: "local/def"}, false},
+		{"profile without source", &queryLogFilter{profile: "abc"}, false},
+		{"domain", &queryLogFilter{domain: "www.example.com."}, true},
+		{"parent domain", &queryLogFilter{domain: "example.com."}, true},
+		{"other domain", &queryLogFilter{domain: "example.org."}, false},
+		{"domain suffix", &queryLogFilter{domain: "ample.com."}, false},
+		{"subdomain", &queryLogFilter{domain: "a.www.example.com."}, false},
+	} {
+		if test.filter.matches(entry) != test.matches {
+			t.Errorf("%s: expected match to be %v", test.name, test.matches)
+		}
+	}
+}
+
+func TestQueryLogKeyPrefixes(t *testing.T) {
+	t.Parallel()
+
+	since := time.Unix(1600000000, 123456789)
+	for _, timeRange := range []time.Duration{
+		0,
+		time.Millisecond,
+		time.Second,
+		time.Hour,
+		7 * 24 * time.Hour,
+	} {
+		until := since.Add(timeRange)
+		prefixes := queryLogKeyPrefixes(since, until)
+		if len(prefixes) == 0 || len(prefixes) > maxQueryLogKeyPrefixes {
+			t.Errorf("%s: got %d prefixes", timeRange, len(prefixes))
+			continue
+		}
+		for i := 1; i < len(prefixes); i++ {
+			if prefixes[i-1] >= prefixes[i] {
+				t.Errorf("%s: prefixes are not ordered: %s >= %s", timeRange, prefixes[i-1], prefixes[i])
+			}
+		}
+
+		matched := func(ts time.Time) bool {
+			key := fmt.Sprintf("%s%d-5353-1", queryLogKeyPrefix, ts.UnixNano())
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(key, prefix) {
+					return true
+				}
+			}
+			return false
+		}
+
+		// All entries of the range must be selected.
+		for _, ts := range []time.Time{
+			since,
+			since.Add(timeRange / 3),
+			since.Add(timeRange / 2),
+			until,
+		} {
+			if !matched(ts) {
+				t.Errorf("%s: entry at %s is not selected", timeRange, ts)
+			}
+		}
+
+		// Entries far outside of the range must not be selected.
+		if timeRange >= time.Second {
+			for _, ts := range []time.Time{
+				since.Add(-timeRange / 2),
+				until.Add(timeRange / 2),
+			} {
+				if matched(ts) {
+					t.Errorf("%s: entry at %s is selected", timeRange, ts)
+				}
+			}
+		}
+	}
+
+	if prefixes := queryLogKeyPrefixes(since, since.Add(-time.Second)); len(prefixes) != 0 {
+		t.Errorf("expected no prefixes for an empty range, got %v", prefixes)
+	}
+}
+
+// setupQueryLogTest enables the query log and removes all its entries.
+func setupQueryLogTest(t *testing.T) {
+	t.Helper()
+
+	enabled, maxAge := enableQueryLog, queryLogMaxAge
+	t.Cleanup(func() {
+		enableQueryLog, queryLogMaxAge = enabled, maxAge
+	})
+	enableQueryLog = config.BoolOption(func() bool { return true })
+	queryLogMaxAge = config.IntOption(func() int64 { return 7 })
+
+	if _, err := clearQueryLog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func logTestQuery(t *testing.T, started time.Time, domain string, port int, profile string) {
+	t.Helper()
+
+	request := new(dns.Msg)
+	request.SetQuestion(domain, dns.TypeA)
+	request.Id = uint16(port)
+
+	response := new(dns.Msg)
+	response.SetReply(request)
+	rr, err := dns.NewRR(domain + " 60 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Answer = append(response.Answer, rr)
+
+	logQuery(context.Background(), &queryLogInfo{
+		started:    started,
+		request:    request,
+		response:   response,
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+		localAddr:  testNameserverAddr,
+		protocol:   packet.UDP,
+		conn: &network.Connection{
+			ID:      "test-" + domain,
+			Verdict: network.VerdictAccept,
+			ProcessContext: network.ProcessContext{
+				Source:  "local",
+				Profile: profile,
+			},
+		},
+	})
+}
+
+func TestGetQueryLog(t *testing.T) {
+	setupQueryLogTest(t)
+
+	now := time.Now()
+	logTestQuery(t, now.Add(-5*time.Hour), "a.example.com.", 1001, "abc")
+	logTestQuery(t, now.Add(-4*time.Hour), "b.example.org.", 1002, "def")
+	logTestQuery(t, now.Add(-3*time.Hour), "c.example.com.", 1003, "abc")
+	logTestQuery(t, now.Add(-2*time.Hour), "d.example.org.", 1004, "abc")
+	logTestQuery(t, now.Add(-1*time.Hour), "e.example.com.", 1005, "def")
+	// Expired entries are never returned.
+	logTestQuery(t, now.Add(-8*24*time.Hour), "expired.example.com.", 1006, "abc")
+
+	for _, test := range []struct {
+		name     string
+		filter   *queryLogFilter
+		expected []string
+	}{
+		{
+			name:     "all",
+			filter:   &queryLogFilter{},
+			expected: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:     "limit",
+			filter:   &queryLogFilter{limit: 2},
+			expected: []string{"d", "e"},
+		},
+		{
+			name:     "limit larger than log",
+			filter:   &queryLogFilter{limit: 10},
+			expected: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name: "window",
+			filter: &queryLogFilter{
+				since: now.Add(-270 * time.Minute),
+				until: now.Add(-90 * time.Minute),
+			},
+			expected: []string{"b", "c", "d"},
+		},
+		{
+			name: "window with limit",
+			filter: &queryLogFilter{
+				since: now.Add(-270 * time.Minute),
+				until: now.Add(-90 * time.Minute),
+				limit: 1,
+			},
+			expected: []string{"d"},
+		},
+		{
+			name:     "window before max age",
+			filter:   &queryLogFilter{until: now.Add(-7 * 24 * time.Hour)},
+			expected: nil,
+		},
+		{
+			name:     "domain",
+			filter:   &queryLogFilter{domain: "example.org."},
+			expected: []string{"b", "d"},
+		},
+		{
+			name:     "profile with limit",
+			filter:   &queryLogFilter{profile: "local/abc", limit: 2},
+			expected: []string{"c", "d"},
+		},
+	} {
+		entries, err := getQueryLog(test.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		var got []string
+		for _, entry := range entries {
+			got = append(got, strings.SplitN(entry.Domain, ".", 2)[0])
+		}
+		if strings.Join(got, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+
+		// The export includes the same entries without a limit.
+		var exported int
+		err = iterateQueryLog(test.filter, func(entry *QueryLogEntry) error {
+			if !test.filter.matches(entry) {
+				t.Errorf("%s: exported entry %s does not match", test.name, entry.Domain)
+			}
+			exported++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if test.filter.limit == 0 && exported != len(test.expected) {
+			t.Errorf("%s: expected %d exported entries, got %d", test.name, len(test.expected), exported)
+		}
+	}
+}
+
+func TestClearQueryLog(t *testing.T) {
+	setupQueryLogTest(t)
+
+	now := time.Now()
+	logTestQuery(t, now.Add(-2*time.Hour), "a.example.com.", 1001, "abc")
+	logTestQuery(t, now.Add(-1*time.Hour), "b.example.org.", 1002, "def")
+	logTestQuery(t, now, "c.example.com.", 1003, "abc")
+
+	n, err := clearQueryLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 cleared entries, got %d", n)
+	}
+
+	entries, err := getQueryLog(&queryLogFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected empty query log after clearing, got %d entries", len(entries))
+	}
+}
+
+// protobufField is a decoded field of a protobuf message.
+type protobufField struct {
+	varint  uint64
+	bytes   []byte
+	fixed32 uint32
+}
+
+// decodeProtobuf decodes the fields of a protobuf message. Repeated fields are
+// not supported.
+func decodeProtobuf(t *testing.T, data []byte) map[int]protobufField {
+	t.Helper()
+
+	fields := make(map[int]protobufField)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid field key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		if _, ok := fields[field]; ok {
+			t.Fatalf("duplicate field %d", field)
+		}
+
+		switch key & 0x7 {
+		case protobufWireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatalf("invalid varint of field %d", field)
+			}
+			data = data[n:]
+			fields[field] = protobufField{varint: v}
+		case protobufWireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				t.Fatalf("invalid length of field %d", field)
+			}
+			data = data[n:]
+			fields[field] = protobufField{bytes: data[:length]}
+			data = data[length:]
+		case protobufWireFixed32:
+			if len(data) < 4 {
+				t.Fatalf("invalid fixed32 of field %d", field)
+			}
+			fields[field] = protobufField{fixed32: binary.LittleEndian.Uint32(data)}
+			data = data[4:]
+		default:
+			t.Fatalf("unsupported wire type %d of field %d", key&0x7, field)
+		}
+	}
+	return fields
+}
+
+// readFrame reads a Frame Streams frame and returns its data and whether it is
+// a control frame.
+func readFrame(t *testing.T, r *bytes.Reader) (data []byte, control bool) {
+	t.Helper()
+
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		t.Fatalf("failed to read frame length: %s", err)
+	}
+	if length == 0 {
+		control = true
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			t.Fatalf("failed to read control frame length: %s", err)
+		}
+	}
+
+	data = make([]byte, length)
+	if _, err := r.Read(data); err != nil && length > 0 {
+		t.Fatalf("failed to read frame: %s", err)
+	}
+	return data, control
+}
+
+func TestDNSTapExport(t *testing.T) {
+	t.Parallel()
+
+	request := new(dns.Msg)
+	request.SetQuestion("example.com.", dns.TypeAAAA)
+	response := new(dns.Msg)
+	response.SetReply(request)
+	query, err := request.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packedResponse, err := response.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &QueryLogEntry{
+		Time:       time.Unix(1600000000, 123456789),
+		Duration:   5 * time.Millisecond,
+		Domain:     "example.com.",
+		QType:      "AAAA",
+		Protocol:   packet.TCP,
+		ClientIP:   net.IPv4(127, 0, 0, 1),
+		ClientPort: 5353,
+		ServerIP:   net.IPv4(127, 0, 0, 17),
+		ServerPort: 53,
+		Query:      query,
+		Response:   packedResponse,
+		ProcessContext: network.ProcessContext{
+			ProcessName: "test",
+			Source:      "local",
+			Profile:     "abc",
+		},
+	}
+
+	var buf bytes.Buffer
+	w, err := newDNSTapWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	// Check the start control frame.
+	control, isControl := readFrame(t, r)
+	expectedControl := appendUint32(nil, fstrmControlStart)
+	expectedControl = appendUint32(expectedControl, fstrmControlFieldType)
+	expectedControl = appendUint32(expectedControl, uint32(len(dnstapContentType)))
+	expectedControl = append(expectedControl, dnstapContentType...)
+	if !isControl || !bytes.Equal(control, expectedControl) {
+		t.Fatalf("invalid start control frame: %x", control)
+	}
+
+	// Check the query and response messages.
+	for _, expected := range []struct {
+		msgType     uint64
+		msgField    int
+		msg         []byte
+		timeSec     uint64
+		timeNsec    uint32
+		timeSecKey  int
+		timeNsecKey int
+	}{
+		{
+			msgType:     dnstapMsgClientQuery,
+			msgField:    10,
+			msg:         query,
+			timeSec:     1600000000,
+			timeNsec:    123456789,
+			timeSecKey:  8,
+			timeNsecKey: 9,
+		},
+		{
+			msgType:     dnstapMsgClientResponse,
+			msgField:    14,
+			msg:         packedResponse,
+			timeSec:     1600000000,
+			timeNsec:    128456789,
+			timeSecKey:  12,
+			timeNsecKey: 13,
+		},
+	} {
+		data, isControl := readFrame(t, r)
+		if isControl {
+			t.Fatal("expected data frame")
+		}
+
+		envelope := decodeProtobuf(t, data)
+		if envelope[15].varint != dnstapTypeMessage {
+			t.Errorf("expected dnstap type %d, got %d", dnstapTypeMessage, envelope[15].varint)
+		}
+		if !strings.HasPrefix(string(envelope[2].bytes), "portmaster ") {
+			t.Errorf("unexpected version %q", envelope[2].bytes)
+		}
+		extra := &queryLogExport{}
+		if err := json.Unmarshal(envelope[3].bytes, extra); err != nil {
+			t.Errorf("failed to parse extra data: %s", err)
+		} else if extra.Profile != "local/abc" || extra.Process != "test" || extra.Domain != "example.com." {
+			t.Errorf("unexpected extra data: %+v", extra)
+		}
+
+		msg := decodeProtobuf(t, envelope[14].bytes)
+		switch {
+		case msg[1].varint != expected.msgType:
+			t.Errorf("expected message type %d, got %d", expected.msgType, msg[1].varint)
+		case msg[2].varint != dnstapSocketFamilyINET:
+			t.Errorf("expected socket family %d, got %d", dnstapSocketFamilyINET, msg[2].varint)
+		case msg[3].varint != dnstapSocketProtoTCP:
+			t.Errorf("expected socket protocol %d, got %d", dnstapSocketProtoTCP, msg[3].varint)
+		case !net.IP(msg[4].bytes).Equal(entry.ClientIP) || len(msg[4].bytes) != net.IPv4len:
+			t.Errorf("unexpected query address %v", msg[4].bytes)
+		case !net.IP(msg[5].bytes).Equal(entry.ServerIP) || len(msg[5].bytes) != net.IPv4len:
+			t.Errorf("unexpected response address %v", msg[5].bytes)
+		case msg[6].varint != 5353 || msg[7].varint != 53:
+			t.Errorf("unexpected ports %d and %d", msg[6].varint, msg[7].varint)
+		case msg[expected.timeSecKey].varint != expected.timeSec ||
+			msg[expected.timeNsecKey].fixed32 != expected.timeNsec:
+			t.Errorf("unexpected time %d.%d", msg[expected.timeSecKey].varint, msg[expected.timeNsecKey].fixed32)
+		case !bytes.Equal(msg[expected.msgField].bytes, expected.msg):
+			t.Errorf("message %d does not match", expected.msgType)
+		}
+	}
+
+	// Check the stop control frame.
+	control, isControl = readFrame(t, r)
+	if !isControl || !bytes.Equal(control, appendUint32(nil, fstrmControlStop)) {
+		t.Errorf("invalid stop control frame: %x", control)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d unexpected bytes after stop control frame", r.Len())
+	}
+}
@@ -3,28 +3,35 @@
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/miekg/dns"
 	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/nameserver/nsutil"
 )
 
+// ednsUDPSize is the maximum UDP payload size that is advertised to clients.
+// This follows the recommendation of the DNS Flag Day 2020 in order to avoid
+// IP fragmentation.
+const ednsUDPSize = 1232
+
 // sendResponse sends a response to query using w. The response message is
 // created by responder. If addExtraRRs is not nil and implements the
 // RRProvider interface then it will be also used to add more RRs in the
-// extra section.
+// extra section. The sent response is returned, or nil if the query was
+// dropped.
 func sendResponse(
 	ctx context.Context,
 	w dns.ResponseWriter,
 	request *dns.Msg,
 	responder nsutil.Responder,
 	rrProviders ...nsutil.RRProvider,
-) error {
+) (*dns.Msg, error) {
 	// Have the Responder craft a DNS reply.
 	reply := responder.ReplyWithDNS(ctx, request)
 	if reply == nil {
 		// Dropping query.
-		return nil
+		return nil, nil
 	}
 
 	// Add extra RRs through a custom RRProvider.
@@ -35,12 +42,16 @@ func sendResponse(
 		}
 	}
 
+	// Make the reply fit the transport of the request.
+	setEDNS0(request, reply)
+	fitReply(ctx, reply, maxResponseSize(w, request))
+
 	// Write reply.
 	if err := writeDNSResponse(ctx, w, reply); err != nil {
-		return fmt.Errorf("failed to send response: %w", err)
+		return nil, fmt.Errorf("failed to send response: %w", err)
 	}
 
-	return nil
+	return reply, nil
 }
 
 func writeDNSResponse(ctx context.Context, w dns.ResponseWriter, m *dns.Msg) (err error) {
@@ -57,11 +68,85 @@ func writeDNSResponse(ctx context.Context, w dns.ResponseWriter, m *dns.Msg) (er
 		// If we receive an error we might have exceeded the message size with all
 		// our extra information records. Retry again without the extra section.
 		log.Tracer(ctx).Tracef("nameserver: retrying to write dns message without extra section, error was: %s", err)
+		// Keep the OPT record, as it might hold the extended rcode.
+		opt := m.IsEdns0()
 		m.Extra = nil
+		if opt != nil {
+			m.Extra = []dns.RR{opt}
+		}
 		noExtraErr := w.WriteMsg(m)
-		if noExtraErr == nil {
-			return fmt.Errorf("failed to write dns message without extra section: %w", err)
+		if noExtraErr != nil {
+			return fmt.Errorf("failed to write dns message without extra section: %w", noExtraErr)
+		}
+	}
+	return nil
+}
+
+// setEDNS0 replaces any OPT record of the reply with an OPT record that
+// advertises the supported UDP payload size, if the request has an OPT record.
+// The DO bit is copied from the request.
+func setEDNS0(request, reply *dns.Msg) {
+	// Remove existing OPT records. The extra section may be shared with the
+	// cache, so it must not be modified in place.
+	extra := make([]dns.RR, 0, len(reply.Extra)+1)
+	for _, rr := range reply.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
 		}
 	}
-	return
+	reply.Extra = extra
+
+	// Only add an OPT record if the client supports EDNS0.
+	requestOpt := request.IsEdns0()
+	if requestOpt == nil {
+		return
+	}
+	reply.SetEdns0(ednsUDPSize, requestOpt.Do())
+}
+
+// maxResponseSize returns the maximum size of a response to the given request.
+func maxResponseSize(w dns.ResponseWriter, request *dns.Msg) int {
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		return dns.MaxMsgSize
+	}
+
+	if opt := request.IsEdns0(); opt != nil {
+		size := int(opt.UDPSize())
+		if size > ednsUDPSize {
+			size = ednsUDPSize
+		}
+		if size < dns.MinMsgSize {
+			size = dns.MinMsgSize
+		}
+		return size
+	}
+	return dns.MinMsgSize
+}
+
+// fitReply makes the reply fit into the given size. Informational records are
+// removed first. If the reply still does not fit, records are removed and the
+// TC bit is set, so that the client retries over TCP.
+func fitReply(ctx context.Context, reply *dns.Msg, size int) {
+	reply.Compress = true
+	if reply.Len() <= size {
+		return
+	}
+
+	// Remove informational records.
+	extra := make([]dns.RR, 0, len(reply.Extra))
+	for _, rr := range reply.Extra {
+		if !nsutil.IsMessageRecord(rr) {
+			extra = append(extra, rr)
+		}
+	}
+	reply.Extra = extra
+	if reply.Len() <= size {
+		return
+	}
+
+	// Truncate the reply.
+	reply.Truncate(size)
+	if reply.Truncated {
+		log.Tracer(ctx).Tracef("nameserver: truncated reply to %d bytes", size)
+	}
 }
@@ -0,0 +1,310 @@
+package nameserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/nameserver/nsutil"
+)
+
+// testResponseWriter records the written messages.
+type testResponseWriter struct {
+	remoteAddr net.Addr
+	written    []*dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr       { return testNameserverAddr }
+func (w *testResponseWriter) RemoteAddr() net.Addr      { return w.remoteAddr }
+func (w *testResponseWriter) Write([]byte) (int, error) { return 0, nil }
+func (w *testResponseWriter) Close() error              { return nil }
+func (w *testResponseWriter) TsigStatus() error         { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool)       {}
+func (w *testResponseWriter) Hijack()                   {}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.written = append(w.written, m.Copy())
+	return nil
+}
+
+var (
+	testNameserverAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 17), Port: 53}
+	testUDPClient      = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+	testTCPClient      = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+)
+
+func newTestRequest(edns0Size uint16, do bool, padded bool) *dns.Msg {
+	request := new(dns.Msg)
+	request.SetQuestion("example.com.", dns.TypeA)
+	if edns0Size > 0 {
+		request.SetEdns0(edns0Size, do)
+	}
+	if padded {
+		// Pad the request to a multiple of the block size.
+		// RFC8467, Section 4.1
+		opt := request.IsEdns0()
+		padding := &dns.EDNS0_PADDING{}
+		opt.Option = append(opt.Option, padding)
+		if remainder := request.Len() % 128; remainder != 0 {
+			padding.Padding = make([]byte, 128-remainder)
+		}
+	}
+	return request
+}
+
+// newTestReply returns a reply to the given request with the given amount of
+// A records.
+func newTestReply(request *dns.Msg, records int) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(request)
+	for i := 0; i < records; i++ {
+		rr, err := dns.NewRR(fmt.Sprintf("example.com. 60 IN A 192.0.2.%d", i%256))
+		if err != nil {
+			panic(err)
+		}
+		reply.Answer = append(reply.Answer, rr)
+	}
+	return reply
+}
+
+func TestSetEDNS0(t *testing.T) {
+	t.Parallel()
+
+	// The upstream reply holds an OPT record with a padding option.
+	upstreamOpt := &dns.OPT{
+		Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT},
+	}
+	upstreamOpt.SetUDPSize(4096)
+	upstreamOpt.Option = append(upstreamOpt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 100)})
+
+	for _, test := range []struct {
+		name    string
+		request *dns.Msg
+		do      bool
+	}{
+		{
+			name:    "client without EDNS0",
+			request: newTestRequest(0, false, false),
+		},
+		{
+			name:    "client with EDNS0",
+			request: newTestRequest(4096, false, false),
+		},
+		{
+			name:    "client with EDNS0 and DO",
+			request: newTestRequest(512, true, false),
+			do:      true,
+		},
+		{
+			name:    "client with padding",
+			request: newTestRequest(1232, false, true),
+		},
+	} {
+		reply := newTestReply(test.request, 1)
+		sharedExtra := []dns.RR{upstreamOpt}
+		reply.Extra = sharedExtra
+
+		setEDNS0(test.request, reply)
+
+		if sharedExtra[0] != upstreamOpt {
+			t.Errorf("%s: shared extra section was modified", test.name)
+		}
+
+		opt := reply.IsEdns0()
+		if test.request.IsEdns0() == nil {
+			if opt != nil || len(reply.Extra) != 0 {
+				t.Errorf("%s: reply must not have an OPT record: %v", test.name, reply.Extra)
+			}
+			continue
+		}
+
+		switch {
+		case opt == nil:
+			t.Errorf("%s: reply is missing the OPT record", test.name)
+		case opt == upstreamOpt || len(reply.Extra) != 1:
+			t.Errorf("%s: upstream OPT record was not replaced: %v", test.name, reply.Extra)
+		case opt.UDPSize() != ednsUDPSize:
+			t.Errorf("%s: expected UDP size %d, got %d", test.name, ednsUDPSize, opt.UDPSize())
+		case opt.Do() != test.do:
+			t.Errorf("%s: expected DO bit %v, got %v", test.name, test.do, opt.Do())
+		case len(opt.Option) != 0:
+			// The nameserver is not reached over an encrypted transport, so
+			// replies must not be padded.
+			// RFC7830, Section 6
+			t.Errorf("%s: reply must not have any EDNS0 options, got %v", test.name, opt.Option)
+		}
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name    string
+		client  net.Addr
+		request *dns.Msg
+		size    int
+	}{
+		{
+			name:    "TCP",
+			client:  testTCPClient,
+			request: newTestRequest(0, false, false),
+			size:    dns.MaxMsgSize,
+		},
+		{
+			name:    "UDP without EDNS0",
+			client:  testUDPClient,
+			request: newTestRequest(0, false, false),
+			size:    dns.MinMsgSize,
+		},
+		{
+			name:    "UDP with small EDNS0 size",
+			client:  testUDPClient,
+			request: newTestRequest(100, false, false),
+			size:    dns.MinMsgSize,
+		},
+		{
+			name:    "UDP with EDNS0",
+			client:  testUDPClient,
+			request: newTestRequest(1000, false, false),
+			size:    1000,
+		},
+		{
+			name:    "UDP with large EDNS0 size",
+			client:  testUDPClient,
+			request: newTestRequest(4096, false, false),
+			size:    ednsUDPSize,
+		},
+	} {
+		size := maxResponseSize(&testResponseWriter{remoteAddr: test.client}, test.request)
+		if size != test.size {
+			t.Errorf("%s: expected %d, got %d", test.name, test.size, size)
+		}
+	}
+}
+
+func TestFitReply(t *testing.T) {
+	t.Parallel()
+
+	messageRecord, err := nsutil.MakeMessageRecord(log.InfoLevel, "this is an informational message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := newTestRequest(0, false, false)
+
+	// Small replies are not changed.
+	reply := newTestReply(request, 1)
+	reply.Extra = []dns.RR{messageRecord}
+	fitReply(context.Background(), reply, dns.MinMsgSize)
+	if reply.Truncated || len(reply.Answer) != 1 || len(reply.Extra) != 1 {
+		t.Errorf("small reply was changed: %s", reply)
+	}
+
+	// Informational records are removed first.
+	reply = newTestReply(request, 25)
+	for i := 0; i < 5; i++ {
+		reply.Extra = append(reply.Extra, messageRecord)
+	}
+	fitReply(context.Background(), reply, dns.MinMsgSize)
+	if reply.Truncated || len(reply.Answer) != 25 || len(reply.Extra) != 0 {
+		t.Errorf("expected informational records to be removed, got %d answers, %d extra, truncated=%v",
+			len(reply.Answer), len(reply.Extra), reply.Truncated)
+	}
+
+	// Oversized replies are truncated.
+	for _, size := range []int{dns.MinMsgSize, ednsUDPSize} {
+		reply = newTestReply(request, 200)
+		fitReply(context.Background(), reply, size)
+		if !reply.Truncated {
+			t.Errorf("%d: expected TC bit to be set", size)
+		}
+		if reply.Len() > size {
+			t.Errorf("%d: truncated reply has %d bytes", size, reply.Len())
+		}
+		if len(reply.Answer) == 0 || len(reply.Answer) >= 200 {
+			t.Errorf("%d: expected some answers to be removed, got %d", size, len(reply.Answer))
+		}
+	}
+}
+
+func TestSendResponse(t *testing.T) {
+	t.Parallel()
+
+	responder := func(records int) nsutil.ResponderFunc {
+		return func(_ context.Context, request *dns.Msg) *dns.Msg {
+			return newTestReply(request, records)
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		client    net.Addr
+		request   *dns.Msg
+		records   int
+		truncated bool
+		maxSize   int
+	}{
+		{
+			name:      "oversized reply over UDP without EDNS0",
+			client:    testUDPClient,
+			request:   newTestRequest(0, false, false),
+			records:   200,
+			truncated: true,
+			maxSize:   dns.MinMsgSize,
+		},
+		{
+			name:      "oversized reply over UDP with padded EDNS0 request",
+			client:    testUDPClient,
+			request:   newTestRequest(4096, false, true),
+			records:   200,
+			truncated: true,
+			maxSize:   ednsUDPSize,
+		},
+		{
+			name:    "oversized reply over TCP",
+			client:  testTCPClient,
+			request: newTestRequest(0, false, false),
+			records: 200,
+			maxSize: dns.MaxMsgSize,
+		},
+		{
+			name:    "small reply over UDP",
+			client:  testUDPClient,
+			request: newTestRequest(0, false, false),
+			records: 2,
+			maxSize: dns.MinMsgSize,
+		},
+	} {
+		w := &testResponseWriter{remoteAddr: test.client}
+		if _, err := sendResponse(context.Background(), w, test.request, responder(test.records)); err != nil {
+			t.Errorf("%s: failed to send response: %s", test.name, err)
+			continue
+		}
+		if len(w.written) != 1 {
+			t.Errorf("%s: expected one written message, got %d", test.name, len(w.written))
+			continue
+		}
+
+		written := w.written[0]
+		packed, err := written.Pack()
+		if err != nil {
+			t.Errorf("%s: failed to pack reply: %s", test.name, err)
+			continue
+		}
+		if len(packed) > test.maxSize {
+			t.Errorf("%s: reply has %d bytes, expected at most %d", test.name, len(packed), test.maxSize)
+		}
+		if written.Truncated != test.truncated {
+			t.Errorf("%s: expected truncated=%v, got %v", test.name, test.truncated, written.Truncated)
+		}
+		if !test.truncated && len(written.Answer) != test.records {
+			t.Errorf("%s: expected %d answers, got %d", test.name, test.records, len(written.Answer))
+		}
+		if (written.IsEdns0() != nil) != (test.request.IsEdns0() != nil) {
+			t.Errorf("%s: OPT record of reply does not match request", test.name)
+		}
+	}
+}
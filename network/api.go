@@ -264,7 +264,7 @@ func (conn *Connection) fmtFlagsComponent() string {
 	if conn.Tunneled {
 		f += "T"
 	}
-	if len(conn.activeInspectors) > 0 {
+	if conn.inspectionState != nil {
 		f += "A"
 	}
 	if conn.addedToMetrics {
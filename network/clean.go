@@ -72,7 +72,8 @@ func cleanConnections() (activePIDs map[int]struct{}) {
 					conn.Save()
 				}
 			case conn.Ended < deleteOlderThan:
-				// Step 3: delete
+				// Step 3: archive and delete
+				archiveConnection(conn)
 				log.Tracef("network.clean: deleted %s (ended at %s)", conn.DatabaseKey(), time.Unix(conn.Ended, 0))
 				conn.delete()
 			}
@@ -86,6 +87,7 @@ func cleanConnections() (activePIDs map[int]struct{}) {
 
 			// delete old dns connections
 			if conn.Ended < deleteOlderThan {
+				archiveConnection(conn)
 				log.Tracef("network.clean: deleted %s (ended at %s)", conn.DatabaseKey(), time.Unix(conn.Ended, 0))
 				conn.delete()
 			}
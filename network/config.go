@@ -0,0 +1,90 @@
+package network
+
+import (
+	"github.com/safing/portbase/config"
+)
+
+// Configuration Keys.
+var (
+	CfgOptionEnableHistoryKey   = "history/enable"
+	cfgOptionEnableHistoryOrder = 0
+	enableHistory               config.BoolOption
+
+	CfgOptionHistoryMaxAgeKey   = "history/maxAge"
+	cfgOptionHistoryMaxAgeOrder = 1
+	historyMaxAge               config.IntOption
+
+	CfgOptionHistoryMaxEntriesKey   = "history/maxEntries"
+	cfgOptionHistoryMaxEntriesOrder = 2
+	historyMaxEntries               config.IntOption
+)
+
+func registerConfig() error {
+	err := config.Register(&config.Option{
+		Name:           "Connection History",
+		Key:            CfgOptionEnableHistoryKey,
+		Description:    "Save ended connections and DNS requests to disk in order to be able to review them later on. Connections are otherwise removed ten minutes after they ended.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelUser,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionEnableHistoryOrder,
+			config.CategoryAnnotation:     "History",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enableHistory = config.Concurrent.GetAsBool(CfgOptionEnableHistoryKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "History Retention",
+		Key:            CfgOptionHistoryMaxAgeKey,
+		Description:    "How long connections are kept in the connection history.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelUser,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   7,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionHistoryMaxAgeOrder,
+			config.UnitAnnotation:         "days",
+			config.CategoryAnnotation:     "History",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionEnableHistoryKey,
+				Value: true,
+			},
+		},
+		ValidationRegex: `^[1-9][0-9]{0,3}$`,
+	})
+	if err != nil {
+		return err
+	}
+	historyMaxAge = config.Concurrent.GetAsInt(CfgOptionHistoryMaxAgeKey, 7)
+
+	err = config.Register(&config.Option{
+		Name:           "History Size Limit",
+		Key:            CfgOptionHistoryMaxEntriesKey,
+		Description:    "The maximum amount of connections kept in the connection history. The oldest connections are removed first.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   100000,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionHistoryMaxEntriesOrder,
+			config.UnitAnnotation:         "connections",
+			config.CategoryAnnotation:     "History",
+			config.RequiresAnnotation: config.ValueRequirement{
+				Key:   CfgOptionEnableHistoryKey,
+				Value: true,
+			},
+		},
+		ValidationRegex: `^[1-9][0-9]{2,7}$`,
+	})
+	if err != nil {
+		return err
+	}
+	historyMaxEntries = config.Concurrent.GetAsInt(CfgOptionHistoryMaxEntriesKey, 100000)
+
+	return nil
+}
@@ -137,6 +137,10 @@ type Connection struct {
 	Tunneled bool
 	// Encrypted is currently unused and MUST be ignored.
 	Encrypted bool
+	// TLS holds metadata of the TLS handshake, if the connection was
+	// inspected by the TLS inspector. Access to TLS must be guarded by the
+	// connection lock.
+	TLS *TLSContext
 	// ProcessContext holds additional information about the process
 	// that iniated the connection. It is set once when the connection
 	// object is created and is considered immutable afterwards.
@@ -158,14 +162,9 @@ type Connection struct {
 	// a connection and signals the firewallHandler that a Save()
 	// should be issued after processing the connection.
 	saveWhenFinished bool
-	// activeInspectors is a slice of booleans where each entry
-	// maps to the index of an available inspector. If the value
-	// is true the inspector is currently active. False indicates
-	// that the inspector has finished and should be skipped.
-	activeInspectors []bool
-	// inspectorData holds additional meta data for the inspectors.
-	// using the inspectors index as a map key.
-	inspectorData map[uint8]interface{}
+	// inspectionState holds the state of the inspection framework while the
+	// connection is being inspected.
+	inspectionState interface{}
 	// ProfileRevisionCounter is used to track changes to the process
 	// profile and required for correct re-evaluation of a connections
 	// verdict.
@@ -173,6 +172,9 @@ type Connection struct {
 	// addedToMetrics signifies if the connection has already been counted in
 	// the metrics.
 	addedToMetrics bool
+	// hypothetical is set for connections that are only evaluated and never
+	// handled. Conflicting verdicts are not logged and they are never saved.
+	hypothetical bool
 }
 
 // Reason holds information justifying a verdict, as well as additional
@@ -186,6 +188,8 @@ type Reason struct {
 	// Profile is the database key of the profile that held the setting
 	// that was responsible for the verdict.
 	Profile string
+	// Decider is the name of the firewall decider that set the verdict.
+	Decider string
 	// ReasonContext may hold additional reason-specific information and
 	// any access must be guarded by the connection lock.
 	Context interface{}
@@ -214,8 +218,9 @@ func getProcessContext(ctx context.Context, proc *process.Process) ProcessContex
 	return pCtx
 }
 
-// NewConnectionFromDNSRequest returns a new connection based on the given dns request.
-func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []string, connID string, localIP net.IP, localPort uint16) *Connection {
+// NewConnectionFromDNSRequest returns a new connection based on the given dns
+// request. The protocol is the transport protocol of the request.
+func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []string, connID string, protocol packet.IPProtocol, localIP net.IP, localPort uint16) *Connection {
 	// Determine IP version.
 	ipVersion := packet.IPv6
 	if localIP.To4() != nil {
@@ -228,7 +233,7 @@ func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []stri
 		&packet.Info{
 			Inbound:  false, // outbound as we are looking for the process of the source address
 			Version:  ipVersion,
-			Protocol: packet.UDP,
+			Protocol: protocol,
 			Src:      localIP,   // source as in the process we are looking for
 			SrcPort:  localPort, // source as in the process we are looking for
 			Dst:      nil,       // do not record direction
@@ -350,15 +355,21 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 			// Try again with the global scope, in case DNS went through the system resolver.
 			ipinfo, err = resolver.GetIPInfo(resolver.IPInfoProfileScopeGlobal, pkt.Info().RemoteIP().String())
 		}
+		var lastResolvedDomain *resolver.ResolvedDomain
 		if err == nil {
-			lastResolvedDomain := ipinfo.MostRecentDomain()
-			if lastResolvedDomain != nil {
-				scope = lastResolvedDomain.Domain
-				entity.Domain = lastResolvedDomain.Domain
-				entity.CNAME = lastResolvedDomain.CNAMEs
-				resolverInfo = lastResolvedDomain.Resolver
-				removeOpenDNSRequest(proc.Pid, lastResolvedDomain.Domain)
-			}
+			lastResolvedDomain = ipinfo.MostRecentDomain()
+		}
+		if lastResolvedDomain == nil {
+			// Check the local DNS records, in case the domain was not resolved by
+			// us, eg. because of a hosts file.
+			lastResolvedDomain = resolver.GetOverrideDomain(pkt.Info().RemoteIP())
+		}
+		if lastResolvedDomain != nil {
+			scope = lastResolvedDomain.Domain
+			entity.Domain = lastResolvedDomain.Domain
+			entity.CNAME = lastResolvedDomain.CNAMEs
+			resolverInfo = lastResolvedDomain.Resolver
+			removeOpenDNSRequest(proc.Pid, lastResolvedDomain.Domain)
 		}
 
 		// check if destination IP is the captive portal's IP
@@ -422,6 +433,61 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 	return newConn
 }
 
+// NewHypotheticalConnection returns a new connection of the given process to
+// the given entity. If the entity has no IP address, a DNS request for the
+// entity's domain is created instead. The connection is not added to the
+// connection store and must never be saved.
+func NewHypotheticalConnection(ctx context.Context, proc *process.Process, entity *intel.Entity, inbound bool) *Connection {
+	conn := &Connection{
+		ID:                     "hypothetical",
+		hypothetical:           true,
+		Inbound:                inbound,
+		ProcessContext:         getProcessContext(ctx, proc),
+		process:                proc,
+		Entity:                 entity,
+		Started:                time.Now().Unix(),
+		ProfileRevisionCounter: proc.Profile().RevisionCnt(),
+	}
+
+	if entity.IP == nil {
+		conn.Type = DNSRequest
+		conn.Scope = entity.Domain
+	} else {
+		conn.Type = IPConnection
+		conn.IPProtocol = packet.IPProtocol(entity.Protocol)
+		conn.IPVersion = packet.IPv6
+		if entity.IP.To4() != nil {
+			conn.IPVersion = packet.IPv4
+		}
+
+		switch {
+		case inbound:
+			conn.Scope = IncomingInternet
+			if entity.IPScope.IsLAN() {
+				conn.Scope = IncomingLAN
+			} else if entity.IPScope.IsLocalhost() {
+				conn.Scope = IncomingHost
+			}
+		case entity.Domain != "":
+			conn.Scope = entity.Domain
+		default:
+			conn.Scope = PeerInternet
+			if entity.IPScope.IsLAN() {
+				conn.Scope = PeerLAN
+			} else if entity.IPScope.IsLocalhost() {
+				conn.Scope = PeerHost
+			}
+		}
+	}
+
+	// Inherit internal status of profile.
+	if localProfile := proc.Profile().LocalProfile(); localProfile != nil {
+		conn.Internal = localProfile.Internal
+	}
+
+	return conn
+}
+
 // GetConnection fetches a Connection from the database.
 func GetConnection(id string) (*Connection, bool) {
 	return conns.get(id)
@@ -436,7 +502,7 @@ func (conn *Connection) SetLocalIP(ip net.IP) {
 
 // AcceptWithContext accepts the connection.
 func (conn *Connection) AcceptWithContext(reason, reasonOptionKey string, ctx interface{}) {
-	if !conn.SetVerdict(VerdictAccept, reason, reasonOptionKey, ctx) {
+	if !conn.SetVerdict(VerdictAccept, reason, reasonOptionKey, ctx) && !conn.hypothetical {
 		log.Warningf("filter: tried to accept %s, but current verdict is %s", conn, conn.Verdict)
 	}
 }
@@ -448,7 +514,7 @@ func (conn *Connection) Accept(reason, reasonOptionKey string) {
 
 // BlockWithContext blocks the connection.
 func (conn *Connection) BlockWithContext(reason, reasonOptionKey string, ctx interface{}) {
-	if !conn.SetVerdict(VerdictBlock, reason, reasonOptionKey, ctx) {
+	if !conn.SetVerdict(VerdictBlock, reason, reasonOptionKey, ctx) && !conn.hypothetical {
 		log.Warningf("filter: tried to block %s, but current verdict is %s", conn, conn.Verdict)
 	}
 }
@@ -460,7 +526,7 @@ func (conn *Connection) Block(reason, reasonOptionKey string) {
 
 // DropWithContext drops the connection.
 func (conn *Connection) DropWithContext(reason, reasonOptionKey string, ctx interface{}) {
-	if !conn.SetVerdict(VerdictDrop, reason, reasonOptionKey, ctx) {
+	if !conn.SetVerdict(VerdictDrop, reason, reasonOptionKey, ctx) && !conn.hypothetical {
 		log.Warningf("filter: tried to drop %s, but current verdict is %s", conn, conn.Verdict)
 	}
 }
@@ -486,7 +552,7 @@ func (conn *Connection) Deny(reason, reasonOptionKey string) {
 
 // FailedWithContext marks the connection with VerdictFailed and stores the reason.
 func (conn *Connection) FailedWithContext(reason, reasonOptionKey string, ctx interface{}) {
-	if !conn.SetVerdict(VerdictFailed, reason, reasonOptionKey, ctx) {
+	if !conn.SetVerdict(VerdictFailed, reason, reasonOptionKey, ctx) && !conn.hypothetical {
 		log.Warningf("filter: tried to drop %s due to error but current verdict is %s", conn, conn.Verdict)
 	}
 }
@@ -502,6 +568,7 @@ func (conn *Connection) SetVerdict(newVerdict Verdict, reason, reasonOptionKey s
 		conn.Verdict = newVerdict
 		conn.Reason.Msg = reason
 		conn.Reason.Context = reasonCtx
+		conn.Reason.Decider = "" // Set by the firewall decision pipeline.
 		if reasonOptionKey != "" && conn.Process() != nil {
 			conn.Reason.OptionKey = reasonOptionKey
 			conn.Reason.Profile = conn.Process().Profile().GetProfileSource(conn.Reason.OptionKey)
@@ -527,6 +594,11 @@ func (conn *Connection) SaveWhenFinished() {
 // Callers must make sure to lock the connection itself before calling
 // Save().
 func (conn *Connection) Save() {
+	// Hypothetical connections must never show up in the connection list.
+	if conn.hypothetical {
+		return
+	}
+
 	conn.addToMetrics()
 	conn.UpdateMeta()
 
@@ -628,24 +700,14 @@ func (conn *Connection) packetHandler() {
 	}
 }
 
-// GetActiveInspectors returns the list of active inspectors.
-func (conn *Connection) GetActiveInspectors() []bool {
-	return conn.activeInspectors
-}
-
-// SetActiveInspectors sets the list of active inspectors.
-func (conn *Connection) SetActiveInspectors(new []bool) {
-	conn.activeInspectors = new
-}
-
-// GetInspectorData returns the list of inspector data.
-func (conn *Connection) GetInspectorData() map[uint8]interface{} {
-	return conn.inspectorData
+// GetInspectionState returns the inspection state of the connection.
+func (conn *Connection) GetInspectionState() interface{} {
+	return conn.inspectionState
 }
 
-// SetInspectorData set the list of inspector data.
-func (conn *Connection) SetInspectorData(new map[uint8]interface{}) {
-	conn.inspectorData = new
+// SetInspectionState sets the inspection state of the connection.
+func (conn *Connection) SetInspectionState(state interface{}) {
+	conn.inspectionState = state
 }
 
 // String returns a string representation of conn.
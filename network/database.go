@@ -30,6 +30,7 @@ type StorageInterface struct {
 // Processes:       network:tree/<PID>
 // DNS Requests:    network:tree/<PID>/dns/<ID>
 // IP Connections:  network:tree/<PID>/ip/<ID>
+// History:         network:history/<ProfileSource>/<ProfileID>/<dns|ip>/<Started>-<ID>
 
 func makeKey(pid int, scope, id string) string {
 	if scope == "" {
@@ -88,6 +89,11 @@ func parseDBKey(key string) (pid int, scope, id string, ok bool) {
 
 // Get returns a database record.
 func (s *StorageInterface) Get(key string) (record.Record, error) {
+	// Check if the connection history is requested.
+	if dbKey := strings.TrimPrefix(key, "network:"); isHistoryKey(dbKey) {
+		return getHistoryRecord(dbKey)
+	}
+
 	// Parse key and check if valid.
 	pid, scope, id, ok := parseDBKey(strings.TrimPrefix(key, "network:"))
 	if !ok || pid == process.UndefinedProcessID {
@@ -122,6 +128,12 @@ func (s *StorageInterface) Query(q *query.Query, local, internal bool) (*iterato
 }
 
 func (s *StorageInterface) processQuery(q *query.Query, it *iterator.Iterator) {
+	// Check if the connection history is queried.
+	if isHistoryKey(q.DatabaseKeyPrefix()) {
+		processHistoryQuery(q, it)
+		return
+	}
+
 	pid, scope, _, ok := parseDBKey(q.DatabaseKeyPrefix())
 	if !ok {
 		it.Finish(nil)
@@ -0,0 +1,404 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/iterator"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/database/storage"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/resolver"
+)
+
+const (
+	historyKeyPrefix = "history:network/"
+
+	// historyNetworkScope is the key scope under which the history is made
+	// available in the network database.
+	historyNetworkScope = "history/"
+
+	historyCleanerInterval = 1 * time.Hour
+)
+
+var (
+	// historyDB does not delay writes, as pending writes cannot be discarded
+	// and would restore connections after the history was cleared.
+	historyDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+)
+
+// Database prefixes:
+// History:  history:network/<ProfileSource>/<ProfileID>/<dns|ip>/<Started>-<ID>
+// Exposed as network:history/<ProfileSource>/<ProfileID>/<dns|ip>/<Started>-<ID>
+
+// HistoricConnection is a compact, archived copy of an ended Connection.
+type HistoricConnection struct {
+	record.Base
+	sync.Mutex
+
+	// ID is the ID of the original connection.
+	ID string
+	// Type is the connection type of the original connection.
+	Type ConnectionType
+	// External is copied from the original connection.
+	External bool
+	// IPVersion is copied from the original connection.
+	IPVersion packet.IPVersion
+	// Inbound is copied from the original connection.
+	Inbound bool
+	// IPProtocol is copied from the original connection.
+	IPProtocol packet.IPProtocol
+	// LocalIP is copied from the original connection.
+	LocalIP net.IP
+	// LocalIPScope is copied from the original connection.
+	LocalIPScope netutils.IPScope
+	// LocalPort is copied from the original connection.
+	LocalPort uint16
+	// Entity is the remote entity of the original connection.
+	Entity *intel.Entity
+	// Resolver holds information about the resolver used to resolve
+	// Entity.Domain.
+	Resolver *resolver.ResolverInfo
+	// Verdict is the final verdict of the original connection.
+	Verdict Verdict
+	// Reason is the reason of the final verdict.
+	Reason Reason
+	// TLS is copied from the original connection.
+	TLS *TLSContext
+	// Started is copied from the original connection.
+	Started int64
+	// Ended is copied from the original connection.
+	Ended int64
+	// ProcessContext holds information about the process that initiated the
+	// original connection.
+	ProcessContext ProcessContext
+	// ProfileRevisionCounter holds the profile revision that the final verdict
+	// was made with.
+	ProfileRevisionCounter uint64
+	// Internal is copied from the original connection.
+	Internal bool
+}
+
+func makeHistoryKey(conn *Connection) string {
+	scope := "ip"
+	if conn.Type == DNSRequest {
+		scope = "dns"
+	}
+
+	source := conn.ProcessContext.Source
+	if source == "" {
+		source = "none"
+	}
+	profileID := conn.ProcessContext.Profile
+	if profileID == "" {
+		profileID = "none"
+	}
+
+	return fmt.Sprintf(
+		"%s%s/%s/%s/%d-%s",
+		historyKeyPrefix,
+		source,
+		profileID,
+		scope,
+		conn.Started,
+		conn.ID,
+	)
+}
+
+// archiveConnection saves an ended connection to the connection history, if
+// enabled. The connection must be locked.
+func archiveConnection(conn *Connection) {
+	if !enableHistory() || conn.Ended == 0 {
+		return
+	}
+
+	hc := newHistoricConnection(conn)
+	err := historyDB.PutNew(hc)
+	if err != nil {
+		log.Warningf("network: failed to archive %s: %s", conn.DatabaseKey(), err)
+	}
+}
+
+// newHistoricConnection returns the archived copy of the given connection.
+// The connection must be locked.
+func newHistoricConnection(conn *Connection) *HistoricConnection {
+	hc := &HistoricConnection{
+		ID:                     conn.ID,
+		Type:                   conn.Type,
+		External:               conn.External,
+		IPVersion:              conn.IPVersion,
+		Inbound:                conn.Inbound,
+		IPProtocol:             conn.IPProtocol,
+		LocalIP:                conn.LocalIP,
+		LocalIPScope:           conn.LocalIPScope,
+		LocalPort:              conn.LocalPort,
+		Resolver:               conn.Resolver,
+		Verdict:                conn.Verdict,
+		Reason:                 conn.Reason,
+		TLS:                    conn.TLS,
+		Started:                conn.Started,
+		Ended:                  conn.Ended,
+		ProcessContext:         conn.ProcessContext,
+		ProfileRevisionCounter: conn.ProfileRevisionCounter,
+		Internal:               conn.Internal,
+	}
+	// The entity of the connection may still be changed after it ended, copy
+	// it in order to archive the data at this point in time.
+	if conn.Entity != nil {
+		hc.Entity = conn.Entity.Copy()
+	}
+
+	hc.SetKey(makeHistoryKey(conn))
+	hc.UpdateMeta()
+	hc.Meta().SetAbsoluteExpiry(conn.Ended + int64(historyMaxAge())*86400)
+	return hc
+}
+
+// parseHistoryKeyStarted returns the start time of the connection from the
+// given history key.
+func parseHistoryKeyStarted(key string) (started int64, ok bool) {
+	lastSegment := key[strings.LastIndex(key, "/")+1:]
+	startedPart := strings.SplitN(lastSegment, "-", 2)[0]
+	started, err := strconv.ParseInt(startedPart, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return started, true
+}
+
+// toHistoryKey converts a network database key to a history database key.
+func toHistoryKey(networkKey string) string {
+	return historyKeyPrefix + strings.TrimPrefix(networkKey, historyNetworkScope)
+}
+
+// isHistoryKey returns whether the given network database key (without the
+// database name) belongs to the connection history.
+func isHistoryKey(key string) bool {
+	return key == strings.TrimSuffix(historyNetworkScope, "/") ||
+		strings.HasPrefix(key, historyNetworkScope)
+}
+
+// exposeHistoryRecord returns a copy of the given history record with the
+// key under which it is exposed in the network database.
+func exposeHistoryRecord(r record.Record) (record.Record, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	data, err := r.Marshal(r, record.JSON)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		// Record is deleted.
+		return nil, storage.ErrNotFound
+	}
+
+	return record.NewWrapper(
+		"network:"+historyNetworkScope+strings.TrimPrefix(r.Key(), historyKeyPrefix),
+		r.Meta().Duplicate(),
+		data[0],
+		data[1:],
+	)
+}
+
+func getHistoryRecord(key string) (record.Record, error) {
+	r, err := historyDB.Get(toHistoryKey(key))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return exposeHistoryRecord(r)
+}
+
+func processHistoryQuery(q *query.Query, it *iterator.Iterator) {
+	historyIt, err := historyDB.Query(query.New(toHistoryKey(q.DatabaseKeyPrefix())))
+	if err != nil {
+		it.Finish(err)
+		return
+	}
+
+	for r := range historyIt.Next {
+		if !q.MatchesRecord(r) {
+			continue
+		}
+
+		exposed, err := exposeHistoryRecord(r)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case it.Next <- exposed:
+		case <-it.Done:
+			historyIt.Cancel()
+			it.Finish(nil)
+			return
+		}
+	}
+
+	it.Finish(historyIt.Err())
+}
+
+func registerHistory() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "network/history/clear",
+		Write:       api.PermitUser,
+		ActionFunc:  clearHistory,
+		Name:        "Clear Connection History",
+		Description: "Deletes all saved connections from the connection history.",
+	}); err != nil {
+		return err
+	}
+
+	module.NewTask("connection history cleaner", cleanHistory).
+		Repeat(historyCleanerInterval).
+		Schedule(time.Now().Add(10 * time.Minute))
+
+	return nil
+}
+
+func clearHistory(ar *api.Request) (msg string, err error) {
+	log.Info("network: user requested connection history clearing via action")
+
+	n, err := purgeHistory(ar.Context(), query.New(historyKeyPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("cleared %d connections from history", n), nil
+}
+
+// cleanHistory enforces the configured retention policies.
+func cleanHistory(ctx context.Context, _ *modules.Task) error {
+	// Remove connections older than the maximum age.
+	ageThreshold := time.Now().Add(-time.Duration(historyMaxAge()) * 24 * time.Hour).Unix()
+	n, err := purgeHistory(ctx, query.New(historyKeyPrefix).Where(
+		query.Where("Ended", query.LessThan, ageThreshold),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to remove old connections from history: %w", err)
+	}
+	if n > 0 {
+		log.Debugf("network: removed %d old connections from history", n)
+	}
+
+	// Remove the oldest connections if there are too many.
+	return enforceHistorySizeLimit(ctx)
+}
+
+// enforceHistorySizeLimit removes the oldest connections from the history if
+// it holds more connections than configured. History keys are only ordered by
+// time within a profile, so the connections are counted per start time, as
+// parsed from their keys, in order to find the start time up to which they
+// must be removed. No records are kept in memory.
+func enforceHistorySizeLimit(ctx context.Context) error {
+	it, err := historyDB.Query(query.New(historyKeyPrefix))
+	if err != nil {
+		return err
+	}
+
+	var total int
+	startedCounts := make(map[int64]int)
+	for r := range it.Next {
+		total++
+		if started, ok := parseHistoryKeyStarted(r.Key()); ok {
+			startedCounts[started]++
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	excess := total - int(historyMaxEntries())
+	if excess <= 0 {
+		return nil
+	}
+
+	// Delete the oldest entries.
+	n, err := purgeHistory(ctx, query.New(historyKeyPrefix).Where(
+		query.Where("Started", query.LessThanOrEqual, getHistoryCutoff(startedCounts, excess)),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to remove connections from history: %w", err)
+	}
+
+	log.Debugf("network: removed %d connections from history to enforce size limit", n)
+	return nil
+}
+
+// getHistoryCutoff returns the start time up to which connections need to be
+// removed in order to remove at least the given amount of connections.
+func getHistoryCutoff(startedCounts map[int64]int, remove int) int64 {
+	startTimes := make([]int64, 0, len(startedCounts))
+	for started := range startedCounts {
+		startTimes = append(startTimes, started)
+	}
+	sort.Slice(startTimes, func(i, j int) bool {
+		return startTimes[i] < startTimes[j]
+	})
+
+	var cutoff int64
+	for _, started := range startTimes {
+		cutoff = started
+		remove -= startedCounts[started]
+		if remove <= 0 {
+			break
+		}
+	}
+	return cutoff
+}
+
+// purgeHistory deletes all history records that match the given query. On
+// storages that do not support purging, such as the in-memory storage, the
+// records are deleted one by one.
+func purgeHistory(ctx context.Context, q *query.Query) (int, error) {
+	n, err := historyDB.Purge(ctx, q)
+	if !errors.Is(err, database.ErrNotImplemented) {
+		return n, err
+	}
+
+	it, err := historyDB.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for r := range it.Next {
+		keys = append(keys, r.Key())
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+
+	n = 0
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := historyDB.Delete(key); err != nil {
+			log.Warningf("network: failed to delete %s from history: %s", key, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
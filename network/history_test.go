@@ -0,0 +1,252 @@
+package network
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portmaster/intel"
+)
+
+func TestHistoryKeys(t *testing.T) {
+	for _, conn := range connectionTestData {
+		historyKey := makeHistoryKey(conn)
+		dbKey := "history/" + historyKey[len(historyKeyPrefix):]
+
+		if !isHistoryKey(dbKey) {
+			t.Errorf("%s should be a history key", dbKey)
+		}
+		if toHistoryKey(dbKey) != historyKey {
+			t.Errorf("%s was not converted back to %s", dbKey, historyKey)
+		}
+	}
+
+	// Check prefixes.
+	if !isHistoryKey("history") || !isHistoryKey("history/") {
+		t.Error("history query prefixes should be history keys")
+	}
+	if isHistoryKey("tree/1/ip/") || isHistoryKey("") || isHistoryKey("historyX") {
+		t.Error("non-history keys should not be history keys")
+	}
+}
+
+func TestHistoricConnectionCopiesEntity(t *testing.T) {
+	conn := &Connection{
+		ID:      "copy-test",
+		Started: 1614010349,
+		Ended:   1614010350,
+		Entity: &intel.Entity{
+			Domain: "example.com.",
+			CNAME:  []string{"cdn.example.com."},
+			IP:     net.ParseIP("192.0.2.1"),
+		},
+	}
+
+	hc := newHistoricConnection(conn)
+
+	// Change the entity of the live connection.
+	conn.Entity.Domain = "changed.example.com."
+	conn.Entity.CNAME[0] = "changed.example.net."
+	conn.Entity.IP[len(conn.Entity.IP)-1] = 2
+
+	if hc.Entity == conn.Entity {
+		t.Fatal("archived connection must not share the entity of the live connection")
+	}
+	if hc.Entity.Domain != "example.com." {
+		t.Errorf("archived domain changed to %s", hc.Entity.Domain)
+	}
+	if hc.Entity.CNAME[0] != "cdn.example.com." {
+		t.Errorf("archived CNAME changed to %s", hc.Entity.CNAME[0])
+	}
+	if !hc.Entity.IP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("archived IP changed to %s", hc.Entity.IP)
+	}
+}
+
+func TestHistoryCutoff(t *testing.T) {
+	startedCounts := map[int64]int{
+		300: 1,
+		100: 2,
+		200: 3,
+	}
+
+	for _, test := range []struct {
+		remove int
+		cutoff int64
+	}{
+		{remove: 1, cutoff: 100},
+		{remove: 2, cutoff: 100},
+		{remove: 3, cutoff: 200},
+		{remove: 5, cutoff: 200},
+		{remove: 6, cutoff: 300},
+		{remove: 10, cutoff: 300},
+	} {
+		if cutoff := getHistoryCutoff(startedCounts, test.remove); cutoff != test.cutoff {
+			t.Errorf("removing %d: expected cutoff %d, got %d", test.remove, test.cutoff, cutoff)
+		}
+	}
+
+	if started, ok := parseHistoryKeyStarted("history:network/local/_unidentified/ip/1614010349-6-192.0.2.1-443"); !ok || started != 1614010349 {
+		t.Errorf("failed to parse start time from key: %d", started)
+	}
+	if _, ok := parseHistoryKeyStarted("history:network/local/_unidentified/ip/"); ok {
+		t.Error("parsed start time from key without connection")
+	}
+}
+
+func newHistoryTestConn(id, profileID string, started int64) *Connection {
+	return &Connection{
+		ID:      id,
+		Type:    IPConnection,
+		Started: started,
+		Ended:   started + 10,
+		Entity: &intel.Entity{
+			IP: net.ParseIP("192.0.2.1"),
+		},
+		ProcessContext: ProcessContext{
+			Source:  "local",
+			Profile: profileID,
+		},
+	}
+}
+
+func countHistory(t *testing.T) int {
+	t.Helper()
+
+	it, err := historyDB.Query(query.New(historyKeyPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for range it.Next {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestHistoryArchiveAndRetention(t *testing.T) {
+	// Enable the history with a small size limit.
+	defer func(enable config.BoolOption, maxEntries config.IntOption) {
+		enableHistory = enable
+		historyMaxEntries = maxEntries
+	}(enableHistory, historyMaxEntries)
+	enableHistory = func() bool { return true }
+	historyMaxEntries = func() int64 { return 2 }
+
+	now := time.Now().Unix()
+	testConns := []*Connection{
+		newHistoryTestConn("oldest", "a", now-300),
+		newHistoryTestConn("older", "b", now-200),
+		newHistoryTestConn("newest", "a", now-100),
+		newHistoryTestConn("expired", "b", now-10*86400),
+	}
+	for _, conn := range testConns {
+		archiveConnection(conn)
+	}
+
+	// Query the history through the network database.
+	networkDB := database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+	queryKeys := func(prefix string) map[string]struct{} {
+		it, err := networkDB.Query(query.New(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make(map[string]struct{})
+		for r := range it.Next {
+			keys[r.DatabaseKey()] = struct{}{}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	historyKey := func(conn *Connection) string {
+		return historyNetworkScope + strings.TrimPrefix(makeHistoryKey(conn), historyKeyPrefix)
+	}
+
+	profileKeys := queryKeys("network:history/local/a/")
+	if len(profileKeys) != 2 {
+		t.Errorf("expected 2 connections of profile a, got %d", len(profileKeys))
+	}
+	for _, conn := range []*Connection{testConns[0], testConns[2]} {
+		if _, ok := profileKeys[historyKey(conn)]; !ok {
+			t.Errorf("missing %s in history query", historyKey(conn))
+		}
+	}
+
+	r, err := networkDB.Get("network:" + historyKey(testConns[1]))
+	if err != nil {
+		t.Fatalf("failed to get archived connection: %s", err)
+	}
+	if r.DatabaseKey() != historyKey(testConns[1]) {
+		t.Errorf("archived connection is exposed as %s", r.DatabaseKey())
+	}
+
+	// Enforce the retention policies.
+	if err := cleanHistory(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := queryKeys("network:history/")
+	if len(remaining) != 2 {
+		t.Errorf("expected 2 connections after cleaning, got %d", len(remaining))
+	}
+	for _, conn := range []*Connection{testConns[1], testConns[2]} {
+		if _, ok := remaining[historyKey(conn)]; !ok {
+			t.Errorf("%s should have been kept", conn.ID)
+		}
+	}
+}
+
+func TestClearHistory(t *testing.T) {
+	defer func(enable config.BoolOption) {
+		enableHistory = enable
+	}(enableHistory)
+	enableHistory = func() bool { return true }
+
+	if _, err := purgeHistory(context.Background(), query.New(historyKeyPrefix)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	for _, id := range []string{"first", "second", "third"} {
+		archiveConnection(newHistoryTestConn(id, "a", now))
+	}
+	if n := countHistory(t); n != 3 {
+		t.Fatalf("expected 3 archived connections, got %d", n)
+	}
+
+	msg, err := clearHistory(&api.Request{
+		Request: httptest.NewRequest(http.MethodPost, "/api/v1/network/history/clear", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "cleared 3 connections from history" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+
+	// Wait for any delayed writes to be flushed. They must not restore the
+	// cleared connections.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = historyDB.DelayedCacheWriter(ctx)
+
+	if n := countHistory(t); n != 0 {
+		t.Errorf("expected history to be empty after clearing, got %d connections", n)
+	}
+}
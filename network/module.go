@@ -11,7 +11,7 @@
 )
 
 func init() {
-	module = modules.Register("network", nil, start, nil, "base", "processes")
+	module = modules.Register("network", prep, start, nil, "base", "processes")
 }
 
 // SetDefaultFirewallHandler sets the default firewall handler.
@@ -21,6 +21,10 @@ func SetDefaultFirewallHandler(handler FirewallHandler) {
 	}
 }
 
+func prep() error {
+	return registerConfig()
+}
+
 func start() error {
 	err := registerAsDatabase()
 	if err != nil {
@@ -35,6 +39,10 @@ func start() error {
 		return err
 	}
 
+	if err := registerHistory(); err != nil {
+		return err
+	}
+
 	module.StartServiceWorker("clean connections", 0, connectionCleaner)
 	module.StartServiceWorker("write open dns requests", 0, openDNSRequestWriter)
 
@@ -0,0 +1,11 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/core/pmtesting"
+)
+
+func TestMain(m *testing.M) {
+	pmtesting.TestMain(m, module)
+}
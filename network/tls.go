@@ -0,0 +1,26 @@
+package network
+
+// TLSContext holds metadata of the TLS handshake of a connection.
+type TLSContext struct {
+	// Version is the highest TLS version offered by the client, or the
+	// negotiated version, if the server hello was seen.
+	Version string
+	// CipherSuite is the negotiated cipher suite.
+	CipherSuite string
+	// SNI is the server name requested by the client.
+	SNI string
+	// ALPN holds the application protocols offered by the client.
+	ALPN []string
+	// JA3 is the JA3 fingerprint of the client hello.
+	JA3 string
+	// JA3Hash is the MD5 hash of the JA3 fingerprint.
+	JA3Hash string
+
+	// ServerCertSubject is the subject of the server certificate. Server
+	// certificates are only visible with TLS versions below 1.3.
+	ServerCertSubject string
+	// ServerCertIssuer is the issuer of the server certificate.
+	ServerCertIssuer string
+	// ServerCertDNSNames holds the DNS names of the server certificate.
+	ServerCertDNSNames []string
+}
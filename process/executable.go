@@ -1,16 +1,39 @@
 package process
 
 import (
-	"crypto"
+	"crypto/md5"  //nolint:gosec // Used for fingerprinting only.
+	"crypto/sha1" //nolint:gosec // Used for fingerprinting only.
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
 	"os"
 )
 
 // GetExecHash returns the hash of the executable with the given algorithm.
+// Supported algorithms are "md5", "sha1" and "sha256". The process must be
+// locked.
 func (p *Process) GetExecHash(algorithm string) (string, error) {
-	sum, ok := p.ExecHashes[algorithm]
+	if p.ExecHashes == nil {
+		p.ExecHashes = make(map[string]string)
+	}
+	return getFileHash(p.Path, algorithm, p.ExecHashes)
+}
+
+// GetScriptHash returns the hash of the script run by the process with the
+// given algorithm. The process must be locked.
+func (p *Process) GetScriptHash(algorithm string) (string, error) {
+	if p.scriptHashes == nil {
+		p.scriptHashes = make(map[string]string)
+	}
+	return getFileHash(p.ScriptPath, algorithm, p.scriptHashes)
+}
+
+// getFileHash returns the hash of the file at the given path and caches it in
+// the given map.
+func getFileHash(path, algorithm string, cache map[string]string) (string, error) {
+	sum, ok := cache[algorithm]
 	if ok {
 		return sum, nil
 	}
@@ -18,17 +41,22 @@ func (p *Process) GetExecHash(algorithm string) (string, error) {
 	var hasher hash.Hash
 	switch algorithm {
 	case "md5":
-		hasher = crypto.MD5.New()
+		hasher = md5.New() //nolint:gosec // Used for fingerprinting only.
 	case "sha1":
-		hasher = crypto.SHA1.New()
+		hasher = sha1.New() //nolint:gosec // Used for fingerprinting only.
 	case "sha256":
-		hasher = crypto.SHA256.New()
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algorithm)
 	}
 
-	file, err := os.Open(p.Path)
+	file, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
+	defer func() {
+		_ = file.Close()
+	}()
 
 	_, err = io.Copy(hasher, file)
 	if err != nil {
@@ -36,6 +64,6 @@ func (p *Process) GetExecHash(algorithm string) (string, error) {
 	}
 
 	sum = hex.EncodeToString(hasher.Sum(nil))
-	p.ExecHashes[algorithm] = sum
+	cache[algorithm] = sum
 	return sum, nil
 }
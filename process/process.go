@@ -44,6 +44,10 @@ type Process struct {
 	CmdLine   string
 	FirstArg  string
 
+	// ScriptPath holds the path of the script that is run by the process, if
+	// the process is the interpreter of a known framework.
+	ScriptPath string
+
 	// SpecialDetail holds special information, the meaning of which can change
 	// based on any of the previous attributes.
 	SpecialDetail string
@@ -58,6 +62,8 @@ type Process struct {
 	Error     string // Cache errors
 
 	ExecHashes map[string]string
+
+	scriptHashes map[string]string
 }
 
 // Profile returns the assigned layered profile.
@@ -196,10 +202,12 @@ func loadProcess(ctx context.Context, pid int) (*Process, error) {
 
 	// Current working directory
 	// net yet implemented for windows
-	// new.Cwd, err = pInfo.Cwd()
-	// if err != nil {
-	// 	log.Warningf("process: failed to get Cwd: %s", err)
-	// }
+	if onLinux {
+		new.Cwd, err = pInfo.Cwd()
+		if err != nil {
+			log.Warningf("process: failed to get Cwd: %s", err)
+		}
+	}
 
 	// Command line arguments
 	new.CmdLine, err = pInfo.Cmdline()
@@ -207,6 +215,17 @@ func loadProcess(ctx context.Context, pid int) (*Process, error) {
 		return nil, fmt.Errorf("failed to get Cmdline for p%d: %s", pid, err)
 	}
 
+	// Script of interpreters
+	if framework := profile.GetFramework(new.Path); framework != nil {
+		var args []string
+		args, err = pInfo.CmdlineSlice()
+		if err != nil {
+			log.Warningf("process: failed to get command line arguments of p%d: %s", pid, err)
+		} else {
+			new.ScriptPath = framework.GetScriptPath(args, new.Cwd)
+		}
+	}
+
 	// Name
 	new.Name, err = pInfo.Name()
 	if err != nil {
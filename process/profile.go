@@ -79,7 +79,15 @@ func (p *Process) GetProfile(ctx context.Context) (changed bool, err error) {
 	}
 
 	// Get the (linked) local profile.
-	localProfile, err := profile.GetProfile(profile.SourceLocal, profileID, p.Path)
+	var localProfile *profile.Profile
+	switch {
+	case profileID != "":
+		localProfile, err = profile.GetProfile(profile.SourceLocal, profileID, p.Path)
+	case p.ScriptPath != "":
+		localProfile, err = p.getScriptProfile()
+	default:
+		localProfile, err = profile.GetProfileForExecutable(p.Path, p.GetExecHash)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -91,6 +99,35 @@ func (p *Process) GetProfile(ctx context.Context) (changed bool, err error) {
 	return true, nil
 }
 
+// getScriptProfile returns the profile of the script run by the process. New
+// script profiles are linked to the profile of the interpreter, which then
+// serves as a fallback layer. The process must be locked.
+func (p *Process) getScriptProfile() (*profile.Profile, error) {
+	interpreterProfile, err := profile.GetProfileForExecutable(p.Path, p.GetExecHash)
+	if err != nil {
+		return nil, err
+	}
+	scriptProfile, err := profile.GetProfileForExecutable(p.ScriptPath, p.GetScriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Link the interpreter profile, if the script profile was just created.
+	// Existing script profiles keep the links configured by the user.
+	if scriptProfile.IsNew() && scriptProfile.LinkInitialProfile(interpreterProfile.ScopedID()) {
+		// Save the interpreter profile, as it might not exist yet.
+		interpreterProfile.UpdateMetadata(p.Path)
+		if err := interpreterProfile.Save(); err != nil {
+			log.Warningf("process: failed to save interpreter profile %s: %s", interpreterProfile.ScopedID(), err)
+		}
+		if err := scriptProfile.Save(); err != nil {
+			log.Warningf("process: failed to save script profile %s: %s", scriptProfile.ScopedID(), err)
+		}
+	}
+
+	return scriptProfile, nil
+}
+
 // UpdateProfileMetadata updates the metadata of the local profile
 // as required.
 func (p *Process) UpdateProfileMetadata() {
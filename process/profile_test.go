@@ -0,0 +1,58 @@
+package process
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/safing/portmaster/profile"
+)
+
+func TestGetScriptProfile(t *testing.T) {
+	dir := t.TempDir()
+	p := &Process{
+		Path:       filepath.Join(dir, "interpreter"),
+		ScriptPath: filepath.Join(dir, "script"),
+	}
+	for _, path := range []string{p.Path, p.ScriptPath} {
+		if err := ioutil.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	// New script profiles are linked to the interpreter profile.
+	scriptProfile, err := p.getScriptProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	interpreterProfile, err := profile.GetProfileForExecutable(p.Path, p.GetExecHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptProfile.Lock()
+	linked := scriptProfile.LinkedProfiles
+	scriptProfile.Unlock()
+	if len(linked) != 1 || linked[0] != interpreterProfile.ScopedID() {
+		t.Fatalf("expected script profile to link to %s, got %v", interpreterProfile.ScopedID(), linked)
+	}
+
+	// Links removed by the user are not added again.
+	scriptProfile.Lock()
+	scriptProfile.LinkedProfiles = nil
+	scriptProfile.Unlock()
+	if err := scriptProfile.Save(); err != nil {
+		t.Fatal(err)
+	}
+	scriptProfile, err = p.getScriptProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptProfile.Lock()
+	linked = scriptProfile.LinkedProfiles
+	scriptProfile.Unlock()
+	if len(linked) != 0 {
+		t.Errorf("expected existing script profile to keep its links, got %v", linked)
+	}
+}
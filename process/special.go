@@ -6,6 +6,7 @@
 	"time"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/profile"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -78,3 +79,21 @@ func getSpecialProcess(ctx context.Context, template *Process) *Process {
 	})
 	return p.(*Process)
 }
+
+// NewVirtualProcess returns a process that does not exist on the system and
+// is assigned the given layered profile. It is not saved to storage and is
+// used to evaluate hypothetical connections.
+func NewVirtualProcess(layeredProfile *profile.LayeredProfile) *Process {
+	localProfile := layeredProfile.LocalProfile()
+	return &Process{
+		UserID:          UndefinedProcessID,
+		UserName:        "Virtual",
+		Pid:             UndefinedProcessID,
+		ParentPid:       UndefinedProcessID,
+		Name:            localProfile.Name,
+		Path:            localProfile.LinkedPath,
+		LocalProfileKey: localProfile.Key(),
+		profile:         layeredProfile,
+		FirstSeen:       time.Now().Unix(),
+	}
+}
@@ -8,6 +8,7 @@
 
 	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/profile/endpoints"
 )
@@ -19,6 +20,7 @@
 	cfgEndpoints        endpoints.Endpoints
 	cfgServiceEndpoints endpoints.Endpoints
 	cfgFilterLists      []string
+	cfgListExceptions   *intel.ListExceptions
 )
 
 func registerConfigUpdater() error {
@@ -75,6 +77,12 @@ func updateGlobalConfigProfile(ctx context.Context, task *modules.Task) error {
 		lastErr = err
 	}
 
+	list = cfgOptionFilterListExceptions()
+	cfgListExceptions, err = intel.ParseListExceptions(list)
+	if err != nil {
+		lastErr = err
+	}
+
 	// build global profile for reference
 	profile := New(SourceSpecial, "global-config", "", nil)
 	profile.Name = "Global Configuration"
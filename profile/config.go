@@ -68,6 +68,10 @@
 	cfgOptionFilterSubDomains      config.IntOption // security level option
 	cfgOptionFilterSubDomainsOrder = 35
 
+	CfgOptionFilterListExceptionsKey   = "filter/listExceptions"
+	cfgOptionFilterListExceptions      config.StringArrayOption
+	cfgOptionFilterListExceptionsOrder = 37
+
 	// DNS Filtering
 
 	CfgOptionFilterCNAMEKey   = "filter/includeCNAMEs"
@@ -86,6 +90,10 @@
 	cfgOptionDomainHeuristics      config.IntOption // security level option
 	cfgOptionDomainHeuristicsOrder = 51
 
+	CfgOptionBlockDNSSECFailuresKey   = "filter/blockDNSSECFailures"
+	cfgOptionBlockDNSSECFailures      config.IntOption // security level option
+	cfgOptionBlockDNSSECFailuresOrder = 52
+
 	// Advanced
 
 	CfgOptionPreventBypassingKey   = "filter/preventBypassing"
@@ -97,6 +105,7 @@
 	cfgOptionDisableAutoPermitOrder = 65
 
 	// Permanent Verdicts Order = 96
+	// Connection Inspection Order = 97-99
 
 	CfgOptionUseSPNKey   = "spn/useSPN"
 	cfgOptionUseSPN      config.BoolOption
@@ -329,6 +338,38 @@ func registerConfiguration() error {
 	cfgOptionFilterSubDomains = config.Concurrent.GetAsInt(CfgOptionFilterSubDomainsKey, int64(status.SecurityLevelsAll))
 	cfgIntOptions[CfgOptionFilterSubDomainsKey] = cfgOptionFilterSubDomains
 
+	// Filter list exceptions
+	err = config.Register(&config.Option{
+		Name:        "Filter List Exceptions",
+		Key:         CfgOptionFilterListExceptionsKey,
+		Description: "Never block these domains and IP addresses with filter lists. Use this to work around entries of filter lists that block more than they should.",
+		Help: strings.ReplaceAll(`Exceptions are defined with one entry per line:
+
+- "example.com": the exact domain
+- ".example.com": the domain and all its subdomains
+- "*.example.com": only the subdomains of the domain
+- "192.0.2.1" or "2001:db8::1": the exact IP address
+- "198.51.100.0/24": all IP addresses in the network
+
+Connections to a domain or IP address that matches an exception are never blocked by filter lists. Filter list entries of CNAMEs and parent domains that match an exception are ignored as well.
+Exceptions of the app settings are combined with the global exceptions. Filter lists of enterprise profiles can only be overridden by exceptions of enterprise profiles.
+Exceptions also apply to outgoing and incoming rules that reference filter lists.
+`, `"`, "`"),
+		OptType:        config.OptTypeStringArray,
+		DefaultValue:   []string{},
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionFilterListExceptionsOrder,
+			config.CategoryAnnotation:     "Filter Lists",
+		},
+		ValidationRegex: `^(\*?\.)?[a-zA-Z0-9\.:/_-]+$`,
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionFilterListExceptions = config.Concurrent.GetAsStringArray(CfgOptionFilterListExceptionsKey, []string{})
+	cfgStringArrayOptions[CfgOptionFilterListExceptionsKey] = cfgOptionFilterListExceptions
+
 	// Block Scope Local
 	err = config.Register(&config.Option{
 		Name:           "Block Device-Local Connections",
@@ -493,6 +534,28 @@ func registerConfiguration() error {
 	cfgOptionDomainHeuristics = config.Concurrent.GetAsInt(CfgOptionDomainHeuristicsKey, int64(status.SecurityLevelsAll))
 	cfgIntOptions[CfgOptionDomainHeuristicsKey] = cfgOptionDomainHeuristics
 
+	// Block DNSSEC validation failures
+	err = config.Register(&config.Option{
+		Name:           "Block DNSSEC Failures",
+		Key:            CfgOptionBlockDNSSECFailuresKey,
+		Description:    "Block connections to domains whose DNS answers failed DNSSEC validation, as they might have been tampered with. This setting only takes effect when DNSSEC validation is enabled in the DNS settings.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   status.SecurityLevelOff,
+		PossibleValues: status.AllSecurityLevelValues,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  status.DisplayHintSecurityLevel,
+			config.DisplayOrderAnnotation: cfgOptionBlockDNSSECFailuresOrder,
+			config.CategoryAnnotation:     "DNS Filtering",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionBlockDNSSECFailures = config.Concurrent.GetAsInt(CfgOptionBlockDNSSECFailuresKey, int64(status.SecurityLevelOff))
+	cfgIntOptions[CfgOptionBlockDNSSECFailuresKey] = cfgOptionBlockDNSSECFailures
+
 	// Bypass prevention
 	err = config.Register(&config.Option{
 		Name: "Block Bypassing",
@@ -3,6 +3,7 @@
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/safing/portbase/config"
@@ -35,6 +36,22 @@ func makeProfileKey(source profileSource, id string) string {
 	return profilesDBPath + string(source) + "/" + id
 }
 
+// parseScopedID splits the given scoped ID into its source and ID.
+func parseScopedID(scopedID string) (source profileSource, id string, err error) {
+	splitted := strings.SplitN(scopedID, "/", 2)
+	if len(splitted) != 2 || splitted[1] == "" {
+		return "", "", fmt.Errorf("invalid scoped profile ID %q", scopedID)
+	}
+
+	source = profileSource(splitted[0])
+	switch source {
+	case SourceLocal, SourceSpecial, SourceNetwork, SourceCommunity, SourceEnterprise:
+		return source, splitted[1], nil
+	default:
+		return "", "", fmt.Errorf("invalid profile source %q in scoped profile ID %q", splitted[0], scopedID)
+	}
+}
+
 func registerValidationDBHook() (err error) {
 	_, err = database.RegisterHook(query.New(profilesDBPath), &databaseHook{})
 	return
@@ -57,6 +74,8 @@ func startProfileUpdateChecker() error {
 
 				// mark as outdated
 				markActiveProfileAsOutdated(strings.TrimPrefix(r.Key(), profilesDBPath))
+				// update fingerprints
+				updateFingerprintIndex(r)
 			case <-ctx.Done():
 				return profilesSub.Cancel()
 			}
@@ -83,9 +102,31 @@ func (h *databaseHook) PrePut(r record.Record) (record.Record, error) {
 		return nil, err
 	}
 
+	// community and enterprise profiles are read-only
+	if isPolicySource(profile.Source) && !profile.fromPolicyProvider {
+		return nil, fmt.Errorf("%s profiles are read-only", profile.Source)
+	}
+
 	// clean config
 	config.CleanHierarchicalConfig(profile.Config)
 
+	// check linked profiles
+	for _, scopedID := range profile.LinkedProfiles {
+		if _, _, err := parseScopedID(scopedID); err != nil {
+			return nil, err
+		}
+		if scopedID == profile.ScopedID() {
+			return nil, errors.New("profile must not link to itself")
+		}
+	}
+
+	// check fingerprints
+	for _, fp := range profile.Fingerprints {
+		if err := fp.check(); err != nil {
+			return nil, err
+		}
+	}
+
 	// prepare config
 	err = profile.prepConfig()
 	if err != nil {
@@ -21,7 +21,7 @@ func (ep *EndpointLists) Matches(ctx context.Context, entity *intel.Entity) (EPR
 		return Undeterminable, nil
 	}
 
-	if entity.MatchLists(ep.ListSet) {
+	if entity.MatchLists(ep.ListSet, entity.ListExceptions()...) {
 		return ep.match(ep, entity, ep.Lists, "filterlist contains", "filterlist", entity.ListBlockReason())
 	}
 
@@ -391,8 +391,67 @@ func TestEndpointMatching(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	// TODO: write test for lists matcher
+}
 
+func TestEndpointListsMatching(t *testing.T) {
+	ep, err := parseEndpoint("- L:A,B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exceptions, err := intel.ParseListExceptions([]string{".excepted.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		domain     string
+		lists      []string
+		exceptions []*intel.ListExceptions
+		expected   EPResult
+	}{
+		{
+			name:     "listed domain",
+			domain:   "ads.example.com.",
+			lists:    []string{"A"},
+			expected: Denied,
+		},
+		{
+			name:     "domain on other list",
+			domain:   "ads.example.com.",
+			lists:    []string{"C"},
+			expected: NoMatch,
+		},
+		{
+			name:       "excepted domain",
+			domain:     "ads.excepted.example.com.",
+			lists:      []string{"A", "B"},
+			exceptions: []*intel.ListExceptions{exceptions},
+			expected:   NoMatch,
+		},
+		{
+			name:       "domain without exception",
+			domain:     "ads.example.com.",
+			lists:      []string{"B"},
+			exceptions: []*intel.ListExceptions{exceptions},
+			expected:   Denied,
+		},
+	} {
+		// Filter lists are not loaded during testing, so the list
+		// occurrences are set directly.
+		entity := &intel.Entity{
+			Domain: test.domain,
+			ListOccurences: map[string][]string{
+				test.domain: test.lists,
+			},
+		}
+		entity.SetListExceptions(test.exceptions...)
+
+		result, _ := ep.Matches(context.TODO(), entity)
+		if result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+	}
 }
 
 func getLineNumberOfCaller(levels int) int {
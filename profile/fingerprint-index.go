@@ -0,0 +1,108 @@
+package profile
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+)
+
+// The fingerprint index holds the fingerprints of all local profiles that
+// have any, so that unknown executables can be matched without loading all
+// local profiles from the database. It is loaded when first needed and then
+// kept up to date by the profile update checker.
+var (
+	fingerprintIndex       map[string][]Fingerprint // scoped ID -> fingerprints
+	fingerprintIndexLoaded bool
+	fingerprintIndexLock   sync.Mutex
+)
+
+// fingerprintIndexEntry is a local profile in the fingerprint index.
+type fingerprintIndexEntry struct {
+	scopedID     string
+	fingerprints []Fingerprint
+}
+
+// getFingerprintIndex returns all local profiles that have fingerprints.
+func getFingerprintIndex() ([]fingerprintIndexEntry, error) {
+	fingerprintIndexLock.Lock()
+	defer fingerprintIndexLock.Unlock()
+
+	if !fingerprintIndexLoaded {
+		if err := loadFingerprintIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fingerprint slices in the index are never modified, only replaced.
+	entries := make([]fingerprintIndexEntry, 0, len(fingerprintIndex))
+	for scopedID, fingerprints := range fingerprintIndex {
+		entries = append(entries, fingerprintIndexEntry{
+			scopedID:     scopedID,
+			fingerprints: fingerprints,
+		})
+	}
+	// Sort by scoped ID, so that equally scoring profiles are always matched
+	// in the same order.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].scopedID < entries[j].scopedID
+	})
+	return entries, nil
+}
+
+// loadFingerprintIndex loads the fingerprint index from the database.
+// The fingerprint index lock must be held.
+func loadFingerprintIndex() error {
+	it, err := profileDB.Query(query.New(makeProfileKey(SourceLocal, "")))
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string][]Fingerprint)
+	for r := range it.Next {
+		profile, err := EnsureProfile(r)
+		if err != nil {
+			log.Warningf("profiles: failed to parse profile %s: %s", r.Key(), err)
+			continue
+		}
+		if len(profile.Fingerprints) > 0 {
+			index[profile.ScopedID()] = profile.Fingerprints
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	fingerprintIndex = index
+	fingerprintIndexLoaded = true
+	return nil
+}
+
+// updateFingerprintIndex updates the fingerprint index with the given changed
+// profile record.
+func updateFingerprintIndex(r record.Record) {
+	fingerprintIndexLock.Lock()
+	defer fingerprintIndexLock.Unlock()
+
+	// Changes are included when the index is loaded.
+	if !fingerprintIndexLoaded {
+		return
+	}
+
+	profile, err := EnsureProfile(r)
+	if err != nil {
+		log.Warningf("profiles: failed to parse profile %s: %s", r.Key(), err)
+		return
+	}
+	if profile.Source != SourceLocal {
+		return
+	}
+
+	if r.Meta().IsDeleted() || len(profile.Fingerprints) == 0 {
+		delete(fingerprintIndex, profile.ScopedID())
+		return
+	}
+	fingerprintIndex[profile.ScopedID()] = profile.Fingerprints
+}
@@ -0,0 +1,213 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/safing/portbase/log"
+)
+
+// Platform identifiers
+const (
+	PlatformLinux   = "linux"
+	PlatformWindows = "windows"
+	PlatformMac     = "macos"
+	PlatformOpenBSD = "openbsd"
+)
+
+// Fingerprint Types
+const (
+	// FingerprintTypeFullPath matches the full path of the executable.
+	FingerprintTypeFullPath = "full_path"
+	// FingerprintTypePartialPath matches the last path elements of the
+	// executable, eg. "bin/python".
+	FingerprintTypePartialPath = "partial_path"
+	// FingerprintTypePathGlob matches the path of the executable with a glob
+	// pattern as supported by filepath.Match.
+	FingerprintTypePathGlob = "path_glob"
+	// FingerprintTypePathRegex matches the path of the executable with a
+	// regular expression.
+	FingerprintTypePathRegex = "path_regex"
+	// FingerprintTypeMD5Sum matches the hex encoded MD5 sum of the executable.
+	FingerprintTypeMD5Sum = "md5_sum"
+	// FingerprintTypeSHA1Sum matches the hex encoded SHA1 sum of the executable.
+	FingerprintTypeSHA1Sum = "sha1_sum"
+	// FingerprintTypeSHA256Sum matches the hex encoded SHA256 sum of the
+	// executable.
+	FingerprintTypeSHA256Sum = "sha256_sum"
+)
+
+var (
+	fingerprintWeights = map[string]int{
+		FingerprintTypeFullPath:    2,
+		FingerprintTypePartialPath: 1,
+		FingerprintTypePathGlob:    1,
+		FingerprintTypePathRegex:   1,
+		FingerprintTypeMD5Sum:      4,
+		FingerprintTypeSHA1Sum:     5,
+		FingerprintTypeSHA256Sum:   6,
+	}
+
+	// hashFingerprintTypes holds the hash fingerprint types, ordered from the
+	// strongest to the weakest, and the hash algorithm they use.
+	hashFingerprintTypes = []struct {
+		fpType    string
+		algorithm string
+	}{
+		{FingerprintTypeSHA256Sum, "sha256"},
+		{FingerprintTypeSHA1Sum, "sha1"},
+		{FingerprintTypeMD5Sum, "md5"},
+	}
+)
+
+// Fingerprint links processes to profiles.
+type Fingerprint struct {
+	// OS is the platform the fingerprint applies to. If empty, it applies to
+	// all platforms.
+	OS       string
+	Type     string
+	Value    string
+	Comment  string
+	LastUsed int64
+}
+
+// ExecHashFunc returns the hex encoded hash of an executable. The algorithm is
+// one of "md5", "sha1" or "sha256".
+type ExecHashFunc func(algorithm string) (string, error)
+
+// MatchesOS returns whether the Fingerprint is applicable for the current OS.
+func (fp *Fingerprint) MatchesOS() bool {
+	return fp.OS == "" || fp.OS == currentPlatform()
+}
+
+// GetFingerprintWeight returns the weight of the given fingerprint type.
+func GetFingerprintWeight(fpType string) (weight int) {
+	weight, ok := fingerprintWeights[fpType]
+	if ok {
+		return weight
+	}
+	return 0
+}
+
+func currentPlatform() string {
+	if runtime.GOOS == "darwin" {
+		return PlatformMac
+	}
+	return runtime.GOOS
+}
+
+// check checks if the fingerprint is valid.
+func (fp *Fingerprint) check() error {
+	if _, ok := fingerprintWeights[fp.Type]; !ok {
+		return fmt.Errorf("unknown fingerprint type %q", fp.Type)
+	}
+	if fp.Value == "" {
+		return fmt.Errorf("%s fingerprint has no value", fp.Type)
+	}
+
+	switch fp.Type {
+	case FingerprintTypePathGlob:
+		if _, err := filepath.Match(fp.Value, ""); err != nil {
+			return fmt.Errorf("invalid path glob %q: %w", fp.Value, err)
+		}
+	case FingerprintTypePathRegex:
+		if _, err := regexp.Compile(fp.Value); err != nil {
+			return fmt.Errorf("invalid path regex %q: %w", fp.Value, err)
+		}
+	}
+
+	return nil
+}
+
+// matchesPath returns whether the path fingerprint matches the given path.
+func (fp *Fingerprint) matchesPath(path string) bool {
+	switch fp.Type {
+	case FingerprintTypeFullPath:
+		return fp.Value == path
+	case FingerprintTypePartialPath:
+		partialPath := filepath.ToSlash(fp.Value)
+		slashPath := filepath.ToSlash(path)
+		return slashPath == partialPath ||
+			strings.HasSuffix(slashPath, "/"+strings.TrimPrefix(partialPath, "/"))
+	case FingerprintTypePathGlob:
+		matched, err := filepath.Match(fp.Value, path)
+		return err == nil && matched
+	case FingerprintTypePathRegex:
+		regex, err := regexp.Compile(fp.Value)
+		return err == nil && regex.MatchString(path)
+	default:
+		return false
+	}
+}
+
+var errNoHashFingerprints = errors.New("no hash fingerprints")
+
+// matchFingerprints scores the given fingerprints against the executable at
+// the given path. The score is made up of the weight of the best matching path
+// fingerprint and the weight of the best matching hash fingerprint. Only the
+// strongest available hash type is checked. If it does not match,
+// hashMismatch is set and the hash does not add to the score.
+func matchFingerprints(fingerprints []Fingerprint, path string, getExecHash ExecHashFunc) (score int, hashMismatch bool) {
+	// Score path fingerprints.
+	for _, fp := range fingerprints {
+		if fp.MatchesOS() &&
+			GetFingerprintWeight(fp.Type) > score &&
+			fp.matchesPath(path) {
+			score = GetFingerprintWeight(fp.Type)
+		}
+	}
+
+	// Score hash fingerprints.
+	if getExecHash == nil {
+		return score, false
+	}
+	hashScore, err := matchHashFingerprints(fingerprints, getExecHash)
+	switch {
+	case errors.Is(err, errNoHashFingerprints):
+		return score, false
+	case err != nil:
+		// Do not flag executables that cannot be read.
+		log.Warningf("profile: failed to get hash of executable %s: %s", path, err)
+		return score, false
+	case hashScore == 0:
+		return score, true
+	default:
+		return score + hashScore, false
+	}
+}
+
+func matchHashFingerprints(fingerprints []Fingerprint, getExecHash ExecHashFunc) (score int, err error) {
+	for _, hashType := range hashFingerprintTypes {
+		var sum string
+		var found bool
+
+		for _, fp := range fingerprints {
+			if fp.Type != hashType.fpType || !fp.MatchesOS() {
+				continue
+			}
+			found = true
+
+			// Get hash of the executable, when first needed.
+			if sum == "" {
+				sum, err = getExecHash(hashType.algorithm)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if strings.EqualFold(fp.Value, sum) {
+				return GetFingerprintWeight(fp.Type), nil
+			}
+		}
+
+		// Only check the strongest available hash type.
+		if found {
+			return 0, nil
+		}
+	}
+
+	return 0, errNoHashFingerprints
+}
@@ -1,9 +0,0 @@
-package profile
-
-// Platform identifiers
-const (
-	PlatformLinux   = "linux"
-	PlatformWindows = "windows"
-	PlatformMac     = "macos"
-	PlatformOpenBSD = "openbsd"
-)
@@ -1,6 +0,0 @@
-package profile
-
-// OS Identifier
-const (
-	osIdentifier = PlatformWindows
-)
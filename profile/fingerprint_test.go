@@ -0,0 +1,197 @@
+package profile
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testExecHash(sums map[string]string) ExecHashFunc {
+	return func(algorithm string) (string, error) {
+		sum, ok := sums[algorithm]
+		if !ok {
+			return "", errors.New("unavailable")
+		}
+		return sum, nil
+	}
+}
+
+func TestMatchFingerprints(t *testing.T) {
+	t.Parallel()
+
+	getExecHash := testExecHash(map[string]string{
+		"sha256": "aabbcc",
+		"md5":    "112233",
+	})
+
+	testCases := []struct {
+		name         string
+		fingerprints []Fingerprint
+		path         string
+		score        int
+		hashMismatch bool
+	}{
+		{
+			name:         "full path",
+			fingerprints: []Fingerprint{{Type: FingerprintTypeFullPath, Value: "/usr/bin/app"}},
+			path:         "/usr/bin/app",
+			score:        2,
+		},
+		{
+			name:         "partial path",
+			fingerprints: []Fingerprint{{Type: FingerprintTypePartialPath, Value: "bin/app"}},
+			path:         "/opt/app-1.2/bin/app",
+			score:        1,
+		},
+		{
+			name:         "partial path must match whole elements",
+			fingerprints: []Fingerprint{{Type: FingerprintTypePartialPath, Value: "bin/app"}},
+			path:         "/opt/sbin/app",
+		},
+		{
+			name:         "glob",
+			fingerprints: []Fingerprint{{Type: FingerprintTypePathGlob, Value: "/opt/app-*/bin/app"}},
+			path:         "/opt/app-1.2/bin/app",
+			score:        1,
+		},
+		{
+			name:         "regex",
+			fingerprints: []Fingerprint{{Type: FingerprintTypePathRegex, Value: `^/home/[^/]+/Apps/App-.*\.AppImage$`}},
+			path:         "/home/user/Apps/App-2.0.AppImage",
+			score:        1,
+		},
+		{
+			name:         "other os",
+			fingerprints: []Fingerprint{{OS: "other", Type: FingerprintTypeFullPath, Value: "/usr/bin/app"}},
+			path:         "/usr/bin/app",
+		},
+		{
+			name: "moved binary",
+			fingerprints: []Fingerprint{
+				{Type: FingerprintTypeFullPath, Value: "/usr/bin/app"},
+				{Type: FingerprintTypeSHA256Sum, Value: "AABBCC"},
+			},
+			path:  "/usr/local/bin/app",
+			score: 6,
+		},
+		{
+			name: "path and hash",
+			fingerprints: []Fingerprint{
+				{Type: FingerprintTypeFullPath, Value: "/usr/bin/app"},
+				{Type: FingerprintTypeSHA256Sum, Value: "000000"},
+				{Type: FingerprintTypeSHA256Sum, Value: "aabbcc"},
+			},
+			path:  "/usr/bin/app",
+			score: 8,
+		},
+		{
+			name: "replaced binary",
+			fingerprints: []Fingerprint{
+				{Type: FingerprintTypeFullPath, Value: "/usr/bin/app"},
+				{Type: FingerprintTypeSHA256Sum, Value: "000000"},
+				{Type: FingerprintTypeMD5Sum, Value: "112233"},
+			},
+			path:         "/usr/bin/app",
+			score:        2,
+			hashMismatch: true,
+		},
+		{
+			name:         "unreadable executable",
+			fingerprints: []Fingerprint{{Type: FingerprintTypeSHA1Sum, Value: "aabbcc"}},
+			path:         "/usr/bin/app",
+		},
+	}
+
+	for _, tc := range testCases {
+		score, hashMismatch := matchFingerprints(tc.fingerprints, tc.path, getExecHash)
+		if score != tc.score || hashMismatch != tc.hashMismatch {
+			t.Errorf(
+				"%s: unexpected result: got score %d (mismatch=%v), expected %d (mismatch=%v)",
+				tc.name, score, hashMismatch, tc.score, tc.hashMismatch,
+			)
+		}
+	}
+}
+
+func TestFingerprintCheck(t *testing.T) {
+	t.Parallel()
+
+	invalid := []Fingerprint{
+		{Type: "unknown", Value: "x"},
+		{Type: FingerprintTypeFullPath},
+		{Type: FingerprintTypePathGlob, Value: "/usr/bin/[app"},
+		{Type: FingerprintTypePathRegex, Value: "(app"},
+	}
+	for _, fp := range invalid {
+		if err := fp.check(); err == nil {
+			t.Errorf("fingerprint %+v should be invalid", fp)
+		}
+	}
+
+	valid := Fingerprint{Type: FingerprintTypePathGlob, Value: "/opt/*/bin/app"}
+	if err := valid.check(); err != nil {
+		t.Errorf("fingerprint %+v should be valid: %s", valid, err)
+	}
+}
+
+func TestGetProfileForMovedExecutable(t *testing.T) {
+	// Load the index from the database after saving the test profiles.
+	fingerprintIndexLock.Lock()
+	fingerprintIndexLoaded = false
+	fingerprintIndexLock.Unlock()
+
+	saved := New(SourceLocal, "moved-executable-test", "/opt/app-1/bin/app", nil)
+	saved.Fingerprints = []Fingerprint{
+		{Type: FingerprintTypeSHA256Sum, Value: "aabbcc"},
+	}
+	if err := saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The executable was moved, but its hash still matches.
+	profile, err := GetProfileForExecutable("/opt/app-2/bin/app", testExecHash(map[string]string{
+		"sha256": "aabbcc",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ScopedID() != saved.ScopedID() {
+		t.Errorf("expected moved executable to match %s, got %s", saved.ScopedID(), profile.ScopedID())
+	}
+
+	// A different executable at another path gets a new profile.
+	profile, err = GetProfileForExecutable("/opt/other/bin/app", testExecHash(map[string]string{
+		"sha256": "ddeeff",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ScopedID() == saved.ScopedID() || profile.LinkedPath != "/opt/other/bin/app" {
+		t.Errorf("expected new profile for other executable, got %s for %s", profile.ScopedID(), profile.LinkedPath)
+	}
+
+	// Profiles saved after the index was loaded are added to the index.
+	added := New(SourceLocal, "moved-executable-test-added", "/opt/tool-1/tool", nil)
+	added.Fingerprints = []Fingerprint{
+		{Type: FingerprintTypeSHA256Sum, Value: "112233"},
+	}
+	if err := added.Save(); err != nil {
+		t.Fatal(err)
+	}
+	getExecHash := testExecHash(map[string]string{
+		"sha256": "112233",
+	})
+	for i := 0; ; i++ {
+		profile, err = findProfileByFingerprints("/opt/tool-2/tool", getExecHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile != nil || i >= 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if profile == nil || profile.ScopedID() != added.ScopedID() {
+		t.Errorf("expected added profile %s to be matched, got %v", added.ScopedID(), profile)
+	}
+}
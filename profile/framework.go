@@ -1,76 +1,172 @@
 package profile
 
-// DEACTIVATED
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
 
-// import (
-// 	"fmt"
-// 	"os"
-// 	"path/filepath"
-// 	"regexp"
-// 	"strings"
-//
-// 	"github.com/safing/portbase/log"
-// )
-//
-// type Framework struct {
-// 	// go hirarchy up
-// 	FindParent uint8 `json:",omitempty bson:",omitempty"`
-// 	// get path from parent, amount of levels to go up the tree (1 means parent, 2 means parent of parents, and so on)
-// 	MergeWithParent bool `json:",omitempty bson:",omitempty"`
-// 	// instead of getting the path of the parent, merge with it by presenting connections as if they were from that parent
-//
-// 	// go hirarchy down
-// 	Find string `json:",omitempty bson:",omitempty"`
-// 	// Regular expression for finding path elements
-// 	Build string `json:",omitempty bson:",omitempty"`
-// 	// Path definitions for building path
-// 	Virtual bool `json:",omitempty bson:",omitempty"`
-// 	// Treat resulting path as virtual, do not check if valid
-// }
-//
-// func (f *Framework) GetNewPath(command string, cwd string) (string, error) {
-// 	// "/usr/bin/python script"
-// 	// to
-// 	// "/path/to/script"
-// 	regex, err := regexp.Compile(f.Find)
-// 	if err != nil {
-// 		return "", fmt.Errorf("profiles(framework): failed to compile framework regex: %s", err)
-// 	}
-// 	matched := regex.FindAllStringSubmatch(command, -1)
-// 	if len(matched) == 0 || len(matched[0]) < 2 {
-// 		return "", fmt.Errorf("profiles(framework): regex \"%s\" for constructing path did not match command \"%s\"", f.Find, command)
-// 	}
-//
-// 	var lastError error
-// 	var buildPath string
-// 	for _, buildPath = range strings.Split(f.Build, "|") {
-//
-// 		buildPath = strings.Replace(buildPath, "{CWD}", cwd, -1)
-// 		for i := 1; i < len(matched[0]); i++ {
-// 			buildPath = strings.Replace(buildPath, fmt.Sprintf("{%d}", i), matched[0][i], -1)
-// 		}
-//
-// 		buildPath = filepath.Clean(buildPath)
-//
-// 		if !f.Virtual {
-// 			if !strings.HasPrefix(buildPath, "~/") && !filepath.IsAbs(buildPath) {
-// 				lastError = fmt.Errorf("constructed path \"%s\" from framework is not absolute", buildPath)
-// 				continue
-// 			}
-// 			if _, err := os.Stat(buildPath); os.IsNotExist(err) {
-// 				lastError = fmt.Errorf("constructed path \"%s\" does not exist", buildPath)
-// 				continue
-// 			}
-// 		}
-//
-// 		lastError = nil
-// 		break
-//
-// 	}
-//
-// 	if lastError != nil {
-// 		return "", fmt.Errorf("profiles(framework): failed to construct valid path, last error: %s", lastError)
-// 	}
-// 	log.Tracef("profiles(framework): transformed \"%s\" (%s) to \"%s\"", command, cwd, buildPath)
-// 	return buildPath, nil
-// }
+// Framework describes an interpreter or runtime that runs a script or program
+// given on the command line. Processes of a framework are identified by the
+// script they run instead of the interpreter executable, so that every
+// script gets its own profile.
+type Framework struct {
+	// Name is the name of the framework.
+	Name string
+	// Executables matches the file name of the interpreter executable.
+	Executables *regexp.Regexp
+	// ScriptFlag is the flag that precedes the script, eg. "-jar". If empty,
+	// the first argument that is not a flag is the script.
+	ScriptFlag string
+	// FlagsWithValue are flags that consume the following argument.
+	FlagsWithValue []string
+	// InlineFlags are flags that make the interpreter run code that is not
+	// read from a script file, eg. "-c". No script is identified if one of
+	// them is present before the script.
+	InlineFlags []string
+	// AllowDirectory specifies whether the script may be a directory.
+	AllowDirectory bool
+}
+
+// frameworks is the list of known frameworks.
+var frameworks = []*Framework{
+	{
+		Name:           "Python",
+		Executables:    regexp.MustCompile(`^(python|pythonw|pypy)[0-9.]*(\.exe)?$`),
+		FlagsWithValue: []string{"-W", "-X", "--check-hash-based-pycs"},
+		InlineFlags:    []string{"-c", "-m", "-"},
+	},
+	{
+		Name:        "Node.js",
+		Executables: regexp.MustCompile(`^(node|nodejs)(\.exe)?$`),
+		FlagsWithValue: []string{
+			"-r", "--require", "--loader", "--experimental-loader",
+			"--input-type", "--title", "--conditions", "-C",
+		},
+		InlineFlags: []string{"-e", "--eval", "-p", "--print", "-i", "--interactive", "-"},
+	},
+	{
+		Name:        "Java",
+		Executables: regexp.MustCompile(`^javaw?(\.exe)?$`),
+		ScriptFlag:  "-jar",
+		FlagsWithValue: []string{
+			"-cp", "-classpath", "--class-path",
+			"-p", "--module-path", "--add-modules",
+		},
+	},
+	{
+		Name:           "Shell",
+		Executables:    regexp.MustCompile(`^(sh|bash|dash|zsh|ksh|mksh|fish)(\.exe)?$`),
+		FlagsWithValue: []string{"-o", "+o", "-O", "+O", "--rcfile", "--init-file"},
+		InlineFlags:    []string{"-c", "-s", "-i", "-"},
+	},
+	{
+		Name:           "Perl",
+		Executables:    regexp.MustCompile(`^perl[0-9.]*(\.exe)?$`),
+		FlagsWithValue: []string{"-I", "-M", "-m"},
+		InlineFlags:    []string{"-e", "-E", "-"},
+	},
+	{
+		Name:           "Ruby",
+		Executables:    regexp.MustCompile(`^ruby[0-9.]*(\.exe)?$`),
+		FlagsWithValue: []string{"-I", "-r", "-C", "-E", "--encoding"},
+		InlineFlags:    []string{"-e", "-"},
+	},
+	{
+		Name:           "Electron",
+		Executables:    regexp.MustCompile(`^electron[0-9]*(\.exe)?$`),
+		AllowDirectory: true,
+	},
+}
+
+// GetFramework returns the framework of the given executable, or nil if it is
+// not the interpreter of a known framework.
+func GetFramework(execPath string) *Framework {
+	execName := strings.ToLower(filepath.Base(execPath))
+	for _, framework := range frameworks {
+		if framework.Executables.MatchString(execName) {
+			return framework
+		}
+	}
+	return nil
+}
+
+// GetScriptPath returns the path of the script run by the framework, as given
+// by the command line arguments. The first argument is the interpreter. Relative
+// paths are resolved using the given working directory. It returns an empty
+// string if no existing script could be identified.
+func (f *Framework) GetScriptPath(args []string, cwd string) string {
+	script := f.findScriptArg(args)
+	if script == "" {
+		return ""
+	}
+
+	// Build absolute path.
+	if !filepath.IsAbs(script) {
+		if cwd == "" {
+			return ""
+		}
+		script = filepath.Join(cwd, script)
+	}
+	script = filepath.Clean(script)
+
+	// Check if the script exists.
+	info, err := os.Stat(script)
+	switch {
+	case err != nil:
+		return ""
+	case info.IsDir() && !f.AllowDirectory:
+		return ""
+	}
+
+	return script
+}
+
+func (f *Framework) findScriptArg(args []string) string {
+	if len(args) < 2 {
+		return ""
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case f.ScriptFlag != "" && arg == f.ScriptFlag:
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		case isOneOf(arg, f.InlineFlags):
+			return ""
+		case arg == "--":
+			// End of interpreter flags.
+			if f.ScriptFlag == "" && i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		case isOneOf(arg, f.FlagsWithValue):
+			// Skip the value of the flag.
+			i++
+		case strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+"):
+			// Skip other flags.
+		case f.ScriptFlag == "":
+			return arg
+		default:
+			// The first positional argument is not preceded by the script flag,
+			// eg. a Java main class.
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func isOneOf(s string, list []string) bool {
+	for _, entry := range list {
+		if s == entry {
+			return true
+		}
+	}
+	return false
+}
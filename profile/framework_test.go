@@ -1,30 +1,105 @@
 package profile
 
-// DEACTIVATED
-
-// import (
-// 	"testing"
-// )
-//
-// func testGetNewPath(t *testing.T, f *Framework, command, cwd, expect string) {
-// 	newPath, err := f.GetNewPath(command, cwd)
-// 	if err != nil {
-// 		t.Errorf("GetNewPath failed: %s", err)
-// 	}
-// 	if newPath != expect {
-// 		t.Errorf("GetNewPath return unexpected result: got %s, expected %s", newPath, expect)
-// 	}
-// }
-//
-// func TestFramework(t *testing.T) {
-// 	f1 := &Framework{
-// 		Find:  "([^ ]+)$",
-// 		Build: "{CWD}/{1}",
-// 	}
-// 	testGetNewPath(t, f1, "/usr/bin/python bash", "/bin", "/bin/bash")
-// 	f2 := &Framework{
-// 		Find:  "([^ ]+)$",
-// 		Build: "{1}|{CWD}/{1}",
-// 	}
-// 	testGetNewPath(t, f2, "/usr/bin/python /bin/bash", "/tmp", "/bin/bash")
-// }
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetFramework(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"/usr/bin/python3.9":            "Python",
+		"/usr/bin/node":                 "Node.js",
+		"/usr/lib/jvm/java-11/bin/java": "Java",
+		"/bin/bash":                     "Shell",
+		"/usr/bin/perl5.30":             "Perl",
+		"/usr/bin/ruby":                 "Ruby",
+		"/usr/bin/electron11":           "Electron",
+		"/usr/bin/firefox":              "",
+		"/usr/bin/bashful":              "",
+	}
+	for execPath, expected := range testCases {
+		framework := GetFramework(filepath.FromSlash(execPath))
+		switch {
+		case framework == nil && expected != "":
+			t.Errorf("%s: expected framework %s, got none", execPath, expected)
+		case framework != nil && framework.Name != expected:
+			t.Errorf("%s: expected framework %q, got %s", execPath, expected, framework.Name)
+		}
+	}
+}
+
+func TestFrameworkScriptArg(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"python3", "-u", "script.py", "--verbose"}, "script.py"},
+		{[]string{"python3", "-W", "ignore", "script.py"}, "script.py"},
+		{[]string{"python3", "-c", "print(1)"}, ""},
+		{[]string{"python3", "-m", "http.server"}, ""},
+		{[]string{"python3"}, ""},
+		{[]string{"node", "--require", "dotenv/config", "server.js"}, "server.js"},
+		{[]string{"node", "-e", "console.log(1)"}, ""},
+		{[]string{"java", "-Xmx1g", "-cp", "lib.jar", "-jar", "app.jar", "arg"}, "app.jar"},
+		{[]string{"java", "-cp", "app.jar", "com.example.Main"}, ""},
+		{[]string{"bash", "-e", "--", "-script.sh"}, "-script.sh"},
+		{[]string{"bash", "-c", "echo script.sh"}, ""},
+		{[]string{"perl", "-I", "lib", "tool.pl"}, "tool.pl"},
+		{[]string{"ruby", "-e", "puts 1"}, ""},
+		{[]string{"electron", "--no-sandbox", "."}, "."},
+	}
+	for _, tc := range testCases {
+		framework := GetFramework(tc.args[0])
+		if framework == nil {
+			t.Fatalf("no framework for %s", tc.args[0])
+		}
+		script := framework.findScriptArg(tc.args)
+		if script != tc.expected {
+			t.Errorf("%v: expected script %q, got %q", tc.args, tc.expected, script)
+		}
+	}
+}
+
+func TestFrameworkScriptPath(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "portmaster-framework-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	script := filepath.Join(dir, "script.py")
+	if err := ioutil.WriteFile(script, []byte("print(1)\n"), 0o0600); err != nil {
+		t.Fatal(err)
+	}
+
+	python := GetFramework("python3")
+	if path := python.GetScriptPath([]string{"python3", "script.py"}, dir); path != script {
+		t.Errorf("expected relative script to resolve to %s, got %q", script, path)
+	}
+	if path := python.GetScriptPath([]string{"python3", script}, ""); path != script {
+		t.Errorf("expected absolute script %s, got %q", script, path)
+	}
+	if path := python.GetScriptPath([]string{"python3", "script.py"}, ""); path != "" {
+		t.Errorf("expected no script without working directory, got %q", path)
+	}
+	if path := python.GetScriptPath([]string{"python3", "missing.py"}, dir); path != "" {
+		t.Errorf("expected no script for missing file, got %q", path)
+	}
+	if path := python.GetScriptPath([]string{"python3", "."}, dir); path != "" {
+		t.Errorf("expected no script for directory, got %q", path)
+	}
+
+	electron := GetFramework("electron")
+	if path := electron.GetScriptPath([]string{"electron", "."}, dir); path != dir {
+		t.Errorf("expected electron app directory %s, got %q", dir, path)
+	}
+}
@@ -2,8 +2,10 @@
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/notifications"
 
 	"github.com/safing/portbase/database/query"
 	"github.com/safing/portbase/database/record"
@@ -18,7 +20,23 @@
 // linkedPath parameters whenever available. The linkedPath is used as the key
 // for locking concurrent requests, so it must be supplied if available.
 // If linkedPath is not supplied, source and id make up the key instead.
-func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
+func GetProfile(source profileSource, id, linkedPath string) (*Profile, error) {
+	return fetchProfile(source, id, linkedPath, nil)
+}
+
+// GetProfileForExecutable fetches the local profile of the executable at the
+// given path. In addition to the linked path, profiles are matched by their
+// fingerprints. The given function is used to get the hashes of the
+// executable. If no profile matches, a new one is created.
+func GetProfileForExecutable(linkedPath string, getExecHash ExecHashFunc) (*Profile, error) {
+	if linkedPath == "" {
+		return nil, errors.New("cannot fetch profile for executable without path")
+	}
+
+	return fetchProfile(SourceLocal, "", linkedPath, getExecHash)
+}
+
+func fetchProfile(source profileSource, id, linkedPath string, getExecHash ExecHashFunc) ( //nolint:gocognit
 	profile *Profile,
 	err error,
 ) {
@@ -75,11 +93,12 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 				if profile.outdated.IsSet() {
 					previousVersion = profile
 				} else {
+					profile.checkExecutable(linkedPath, getExecHash)
 					return profile, nil
 				}
 			}
 			// Get from database.
-			profile, err = findProfile(linkedPath)
+			profile, err = findProfile(linkedPath, getExecHash)
 
 		default:
 			return nil, errors.New("cannot fetch profile without ID or path")
@@ -132,9 +151,10 @@ func getProfile(scopedID string) (profile *Profile, err error) {
 	return prepProfile(r)
 }
 
-// findProfile searches for a profile with the given linked path. If it cannot
+// findProfile searches for a profile with the given linked path. If getExecHash
+// is given, profiles are also matched by their fingerprints. If it cannot
 // find one, it will create a new profile for the given linked path.
-func findProfile(linkedPath string) (profile *Profile, err error) {
+func findProfile(linkedPath string, getExecHash ExecHashFunc) (profile *Profile, err error) {
 	// Search the database for a matching profile.
 	it, err := profileDB.Query(
 		query.New(makeProfileKey(SourceLocal, "")).Where(
@@ -153,15 +173,68 @@ func findProfile(linkedPath string) (profile *Profile, err error) {
 	// Prep and return an existing profile.
 	if r != nil {
 		profile, err = prepProfile(r)
+		if err == nil {
+			profile.checkExecutable(linkedPath, getExecHash)
+		}
 		return profile, err
 	}
 
+	// Search for a profile with matching fingerprints.
+	if getExecHash != nil {
+		profile, err = findProfileByFingerprints(linkedPath, getExecHash)
+		if profile != nil || err != nil {
+			return profile, err
+		}
+	}
+
 	// If there was no profile in the database, create a new one, and return it.
 	profile = New(SourceLocal, "", linkedPath, nil)
 
 	return profile, nil
 }
 
+// findProfileByFingerprints searches for the local profile with the highest
+// scoring fingerprints for the executable at the given path. It returns nil if
+// no profile matches.
+func findProfileByFingerprints(linkedPath string, getExecHash ExecHashFunc) (*Profile, error) {
+	candidates, err := getFingerprintIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bestScore    int
+		bestScopedID string
+		bestMismatch bool
+	)
+	for _, candidate := range candidates {
+		score, hashMismatch := matchFingerprints(candidate.fingerprints, linkedPath, getExecHash)
+		if score > bestScore {
+			bestScore = score
+			bestScopedID = candidate.scopedID
+			bestMismatch = hashMismatch
+		}
+	}
+	if bestScopedID == "" {
+		return nil, nil
+	}
+
+	profile, err := getProfile(bestScopedID)
+	if err != nil {
+		// The profile may have been deleted in the meantime.
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	log.Debugf("profiles: matched %s to profile %s by fingerprints with a score of %d", linkedPath, profile.ScopedID(), bestScore)
+	if bestMismatch {
+		profile.flagReplacedExecutable(linkedPath)
+	}
+	return profile, nil
+}
+
 func prepProfile(r record.Record) (*Profile, error) {
 	// ensure its a profile
 	profile, err := EnsureProfile(r)
@@ -184,3 +257,55 @@ func prepProfile(r record.Record) (*Profile, error) {
 	// return parsed profile
 	return profile, nil
 }
+
+// checkExecutable flags the profile if the executable at the given path does
+// not match the hash fingerprints of the profile.
+func (profile *Profile) checkExecutable(linkedPath string, getExecHash ExecHashFunc) {
+	if getExecHash == nil {
+		return
+	}
+
+	profile.RLock()
+	flagged := profile.replacedExecutableFlagged
+	fingerprints := profile.Fingerprints
+	profile.RUnlock()
+	if flagged || len(fingerprints) == 0 {
+		return
+	}
+
+	if _, hashMismatch := matchFingerprints(fingerprints, linkedPath, getExecHash); hashMismatch {
+		profile.flagReplacedExecutable(linkedPath)
+	}
+}
+
+// flagReplacedExecutable informs the user that the executable of the profile
+// does not match its hash fingerprints.
+func (profile *Profile) flagReplacedExecutable(linkedPath string) {
+	profile.Lock()
+	defer profile.Unlock()
+
+	if profile.replacedExecutableFlagged {
+		return
+	}
+	profile.replacedExecutableFlagged = true
+
+	log.Warningf("profiles: executable %s does not match the hash fingerprints of profile %s", linkedPath, profile.ScopedID())
+	notifications.NotifyWarn(
+		"profiles:replaced-executable-"+profile.ID,
+		"App Executable Changed",
+		fmt.Sprintf(
+			"The executable %s does not match the fingerprints of the %s settings. If you did not update the app, it might have been replaced.",
+			linkedPath,
+			profile.Name,
+		),
+		notifications.Action{
+			ID:   "ack",
+			Text: "OK",
+		},
+		notifications.Action{
+			Text:    "Open Settings",
+			Type:    notifications.ActionTypeOpenProfile,
+			Payload: profile.ScopedID(),
+		},
+	)
+}
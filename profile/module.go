@@ -31,6 +31,11 @@ func prep() error {
 		return err
 	}
 
+	err = registerPolicyConfiguration()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -55,6 +60,11 @@ func start() error {
 		return err
 	}
 
+	err = startPolicyProvider()
+	if err != nil {
+		return err
+	}
+
 	module.StartServiceWorker("clean active profiles", 0, cleanActiveProfiles)
 
 	err = updateGlobalConfigProfile(module.Ctx, nil)
@@ -0,0 +1,11 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/core/pmtesting"
+)
+
+func TestMain(m *testing.M) {
+	pmtesting.TestMain(m, module)
+}
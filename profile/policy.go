@@ -0,0 +1,511 @@
+package profile
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates"
+)
+
+// Policy bundles provide community and enterprise profiles. They are loaded
+// from a local policy directory and from the updates. Profiles from policy
+// bundles are read-only and are layered beneath the local profile of all
+// processes they match. Enterprise profiles are enforced: their blocking
+// rules and stricter settings cannot be overridden by the other layers.
+//
+// Bundle files in the policy directory must have the ".json" extension. If
+// trusted keys are configured, every bundle must be accompanied by a
+// "<bundle>.sig" file holding the base64 encoded ed25519 signature of the
+// bundle file. The bundle from the updates is not signed and may only provide
+// community profiles.
+
+// Configuration Keys.
+var (
+	CfgOptionPolicyDirectoryKey   = "core/profilePolicyDirectory"
+	cfgOptionPolicyDirectory      config.StringOption
+	cfgOptionPolicyDirectoryOrder = 530
+
+	CfgOptionPolicyTrustedKeysKey   = "core/profilePolicyTrustedKeys"
+	cfgOptionPolicyTrustedKeys      config.StringArrayOption
+	cfgOptionPolicyTrustedKeysOrder = 531
+)
+
+const (
+	policyBundleExtension    = ".json"
+	policySignatureExtension = ".sig"
+	policyUpdatesResource    = "profiles/policies.json"
+	policyReloadInterval     = 10 * time.Minute
+)
+
+// PolicyBundle is a collection of community or enterprise profiles.
+type PolicyBundle struct {
+	// Source is the source of all profiles in the bundle. It must be either
+	// "community" or "enterprise".
+	Source profileSource
+	// Profiles holds the profiles of the bundle.
+	Profiles []*PolicyProfile
+}
+
+// PolicyProfile is a profile definition within a policy bundle.
+type PolicyProfile struct {
+	// ID is the ID of the profile. It must be unique within the source.
+	ID          string
+	Name        string
+	Description string
+	Homepage    string
+	// MatchPaths is a list of executable paths the profile applies to. Glob
+	// patterns as supported by filepath.Match may be used.
+	MatchPaths []string
+	// Fingerprints are path fingerprints the profile applies to. Hash
+	// fingerprints are not supported for policy profiles.
+	Fingerprints  []Fingerprint
+	SecurityLevel uint8
+	// Config holds the profile settings in the flat (key=value) form.
+	Config map[string]interface{}
+}
+
+// policyMatcher links a loaded policy profile to executable paths.
+type policyMatcher struct {
+	ScopedID     string
+	Source       profileSource
+	MatchPaths   []string
+	Fingerprints []Fingerprint
+}
+
+var (
+	policyLock        sync.RWMutex
+	policyMatchers    []*policyMatcher
+	policyRevision    uint64
+	policyProfileSums = make(map[string]string)
+
+	policyTask *modules.Task
+)
+
+func registerPolicyConfiguration() error {
+	err := config.Register(&config.Option{
+		Name:           "Profile Policy Directory",
+		Key:            CfgOptionPolicyDirectoryKey,
+		Description:    "Directory to load community and enterprise profile bundles from. Enterprise profiles are enforced and can only be made stricter by app settings.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPolicyDirectoryOrder,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPolicyDirectory = config.Concurrent.GetAsString(CfgOptionPolicyDirectoryKey, "")
+
+	err = config.Register(&config.Option{
+		Name:           "Profile Policy Trusted Keys",
+		Key:            CfgOptionPolicyTrustedKeysKey,
+		Description:    "Base64 encoded ed25519 public keys that profile bundles from the policy directory must be signed with. If empty, unsigned bundles are accepted.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPolicyTrustedKeysOrder,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cfgOptionPolicyTrustedKeys = config.Concurrent.GetAsStringArray(CfgOptionPolicyTrustedKeysKey, []string{})
+
+	return nil
+}
+
+func startPolicyProvider() error {
+	policyTask = module.NewTask("load profile policies", loadPolicies).
+		Repeat(policyReloadInterval).
+		Queue()
+
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"reload profile policies",
+		func(_ context.Context, _ interface{}) error {
+			policyTask.Queue()
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	return module.RegisterEventHook(
+		updates.ModuleName,
+		updates.ResourceUpdateEvent,
+		"reload profile policies",
+		func(_ context.Context, _ interface{}) error {
+			policyTask.Queue()
+			return nil
+		},
+	)
+}
+
+// getPolicyRevision returns the revision of the loaded policy profiles. It is
+// increased whenever the policy profiles or their matchers change.
+func getPolicyRevision() uint64 {
+	return atomic.LoadUint64(&policyRevision)
+}
+
+// matchPolicyProfiles returns the scoped IDs of the policy profiles that
+// apply to the given executable path. Enterprise profiles are listed first.
+func matchPolicyProfiles(linkedPath string) (scopedIDs []string) {
+	if linkedPath == "" {
+		return nil
+	}
+
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	for _, source := range []profileSource{SourceEnterprise, SourceCommunity} {
+		for _, matcher := range policyMatchers {
+			if matcher.Source == source && matcher.matches(linkedPath) {
+				scopedIDs = append(scopedIDs, matcher.ScopedID)
+			}
+		}
+	}
+	return scopedIDs
+}
+
+func (matcher *policyMatcher) matches(linkedPath string) bool {
+	for _, pattern := range matcher.MatchPaths {
+		if pattern == linkedPath {
+			return true
+		}
+		if matched, err := filepath.Match(pattern, linkedPath); err == nil && matched {
+			return true
+		}
+	}
+
+	score, _ := matchFingerprints(matcher.Fingerprints, linkedPath, nil)
+	return score > 0
+}
+
+// isPolicySource returns whether profiles of the given source are provided
+// by policy bundles.
+func isPolicySource(source profileSource) bool {
+	return source == SourceCommunity || source == SourceEnterprise
+}
+
+// loadPolicies loads all policy bundles and updates the policy profiles in
+// the database. If any bundle fails to load, the current policy profiles are
+// kept, as removing them would silently lift enforced policies.
+func loadPolicies(ctx context.Context, _ *modules.Task) error {
+	bundles, err := loadPolicyBundles()
+	if err != nil {
+		return fmt.Errorf("keeping current policy profiles: %w", err)
+	}
+
+	return applyPolicyBundles(ctx, bundles)
+}
+
+// loadPolicyBundles loads the bundle from the updates and all bundles from the
+// policy directory. It fails if any of them cannot be loaded.
+func loadPolicyBundles() ([]*PolicyBundle, error) {
+	var bundles []*PolicyBundle
+
+	// Load bundle from the updates.
+	file, err := updates.GetFile(policyUpdatesResource)
+	switch {
+	case err == nil:
+		bundle, err := loadUpdatesPolicyBundle(file.Path())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policy bundle from updates: %w", err)
+		}
+		bundles = append(bundles, bundle)
+	case err != updater.ErrNotFound:
+		return nil, fmt.Errorf("failed to get policy bundle from updates: %w", err)
+	}
+
+	// Load bundles from the policy directory.
+	if dir := cfgOptionPolicyDirectory(); dir != "" {
+		dirBundles, err := loadPolicyDirectory(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policy directory: %w", err)
+		}
+		bundles = append(bundles, dirBundles...)
+	}
+
+	return bundles, nil
+}
+
+// loadUpdatesPolicyBundle loads the bundle from the updates at the given path.
+// It is not signed, so it may only provide community profiles, which are not
+// enforced.
+func loadUpdatesPolicyBundle(path string) (*PolicyBundle, error) {
+	bundle, err := loadPolicyBundle(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Source != SourceCommunity {
+		return nil, fmt.Errorf("bundle has source %q, but may only provide %s profiles", bundle.Source, SourceCommunity)
+	}
+	return bundle, nil
+}
+
+// loadPolicyDirectory loads all bundles from the given directory. It fails if
+// any bundle is invalid.
+func loadPolicyDirectory(dir string) ([]*PolicyBundle, error) {
+	trustedKeys, err := parseTrustedKeys(cfgOptionPolicyTrustedKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundles []*PolicyBundle
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != policyBundleExtension {
+			continue
+		}
+
+		bundle, err := loadPolicyBundle(filepath.Join(dir, entry.Name()), trustedKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load policy bundle %s: %w", entry.Name(), err)
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	return bundles, nil
+}
+
+func parseTrustedKeys(encodedKeys []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(encodedKeys))
+	for _, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %q: %w", encodedKey, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid trusted key %q: not an ed25519 public key", encodedKey)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// loadPolicyBundle loads the bundle at the given path. If trusted keys are
+// given, the bundle must be signed by one of them.
+func loadPolicyBundle(path string, trustedKeys []ed25519.PublicKey) (*PolicyBundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trustedKeys) > 0 {
+		if err := verifyPolicyBundle(path, data, trustedKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle := &PolicyBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+	if !isPolicySource(bundle.Source) {
+		return nil, fmt.Errorf("invalid bundle source %q", bundle.Source)
+	}
+
+	return bundle, nil
+}
+
+func verifyPolicyBundle(path string, data []byte, trustedKeys []ed25519.PublicKey) error {
+	encodedSig, err := ioutil.ReadFile(path + policySignatureExtension)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("bundle is not signed")
+		}
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	for _, key := range trustedKeys {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return errors.New("bundle is not signed by a trusted key")
+}
+
+// applyPolicyBundles saves the profiles of the given bundles, deletes policy
+// profiles that are no longer provided and updates the policy matchers.
+func applyPolicyBundles(ctx context.Context, bundles []*PolicyBundle) error {
+	var matchers []*policyMatcher
+	sums := make(map[string]string)
+	var changed bool
+
+	for _, bundle := range bundles {
+		for _, policyProfile := range bundle.Profiles {
+			if policyProfile.ID == "" {
+				log.Warningf("profile: ignoring %s policy profile without ID", bundle.Source)
+				continue
+			}
+			scopedID := makeScopedID(bundle.Source, policyProfile.ID)
+			if _, ok := sums[scopedID]; ok {
+				log.Warningf("profile: ignoring duplicate policy profile %s", scopedID)
+				continue
+			}
+			if err := policyProfile.checkFingerprints(); err != nil {
+				log.Warningf("profile: ignoring policy profile %s: %s", scopedID, err)
+				continue
+			}
+
+			// Only save the profile if it changed.
+			sum, err := policyProfile.checksum()
+			if err != nil {
+				log.Warningf("profile: ignoring policy profile %s: %s", scopedID, err)
+				continue
+			}
+			policyLock.RLock()
+			previousSum := policyProfileSums[scopedID]
+			policyLock.RUnlock()
+			if sum != previousSum {
+				if err := policyProfile.save(bundle.Source); err != nil {
+					log.Warningf("profile: failed to save policy profile %s: %s", scopedID, err)
+					continue
+				}
+				changed = true
+			}
+
+			sums[scopedID] = sum
+			matchers = append(matchers, &policyMatcher{
+				ScopedID:     scopedID,
+				Source:       bundle.Source,
+				MatchPaths:   policyProfile.MatchPaths,
+				Fingerprints: policyProfile.Fingerprints,
+			})
+		}
+	}
+
+	// Delete policy profiles that are no longer provided.
+	for _, source := range []profileSource{SourceEnterprise, SourceCommunity} {
+		deleted, err := deletePolicyProfiles(ctx, source, sums)
+		if err != nil {
+			log.Warningf("profile: failed to clean up %s profiles: %s", source, err)
+		}
+		if deleted {
+			changed = true
+		}
+	}
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	if changed || !reflect.DeepEqual(matchers, policyMatchers) {
+		policyMatchers = matchers
+		policyProfileSums = sums
+		atomic.AddUint64(&policyRevision, 1)
+		log.Infof("profile: loaded %d policy profiles", len(matchers))
+	}
+
+	return nil
+}
+
+func (policyProfile *PolicyProfile) checkFingerprints() error {
+	for _, fp := range policyProfile.Fingerprints {
+		if err := fp.check(); err != nil {
+			return err
+		}
+		switch fp.Type {
+		case FingerprintTypeMD5Sum, FingerprintTypeSHA1Sum, FingerprintTypeSHA256Sum:
+			return fmt.Errorf("unsupported fingerprint type %s", fp.Type)
+		}
+	}
+	return nil
+}
+
+func (policyProfile *PolicyProfile) checksum() (string, error) {
+	data, err := json.Marshal(policyProfile)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (policyProfile *PolicyProfile) save(source profileSource) error {
+	// The policy profiles are matched by the provider, so they must not have a
+	// linked path.
+	profile := New(source, policyProfile.ID, "", policyProfile.Config)
+	profile.Name = policyProfile.Name
+	profile.Description = policyProfile.Description
+	profile.Homepage = policyProfile.Homepage
+	profile.SecurityLevel = policyProfile.SecurityLevel
+	profile.fromPolicyProvider = true
+
+	return profile.Save()
+}
+
+// deletePolicyProfiles deletes all profiles of the given source that are not
+// in the given set.
+func deletePolicyProfiles(ctx context.Context, source profileSource, keep map[string]string) (deleted bool, err error) {
+	it, err := profileDB.Query(query.New(makeProfileKey(source, "")))
+	if err != nil {
+		return false, err
+	}
+
+	var toDelete []*Profile
+	for r := range it.Next {
+		profile, err := EnsureProfile(r)
+		if err != nil {
+			log.Warningf("profile: failed to parse policy profile %s: %s", r.Key(), err)
+			continue
+		}
+		if _, ok := keep[profile.ScopedID()]; !ok {
+			toDelete = append(toDelete, profile)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return false, err
+	}
+
+	for _, profile := range toDelete {
+		if ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+
+		profile.fromPolicyProvider = true
+		profile.Meta().Delete()
+		if err := profileDB.Put(profile); err != nil && !errors.Is(err, database.ErrNotFound) {
+			log.Warningf("profile: failed to delete policy profile %s: %s", profile.ScopedID(), err)
+			continue
+		}
+		deleted = true
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,381 @@
+package profile
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+func TestLoadSignedPolicyBundle(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := []byte(`{"Source":"enterprise","Profiles":[{"ID":"test"}]}`)
+	tampered := []byte(`{"Source":"enterprise","Profiles":[{"ID":"evil"}]}`)
+	sign := func(key ed25519.PrivateKey, data []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)))
+	}
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		signature   []byte
+		trustedKeys []ed25519.PublicKey
+		valid       bool
+	}{
+		{
+			name:        "valid signature",
+			data:        bundle,
+			signature:   sign(privateKey, bundle),
+			trustedKeys: []ed25519.PublicKey{publicKey},
+			valid:       true,
+		},
+		{
+			name:        "valid signature of second trusted key",
+			data:        bundle,
+			signature:   sign(otherPrivateKey, bundle),
+			trustedKeys: []ed25519.PublicKey{publicKey, otherPublicKey},
+			valid:       true,
+		},
+		{
+			name:        "tampered payload",
+			data:        tampered,
+			signature:   sign(privateKey, bundle),
+			trustedKeys: []ed25519.PublicKey{publicKey},
+		},
+		{
+			name:        "wrong key",
+			data:        bundle,
+			signature:   sign(otherPrivateKey, bundle),
+			trustedKeys: []ed25519.PublicKey{publicKey},
+		},
+		{
+			name:        "invalid signature encoding",
+			data:        bundle,
+			signature:   []byte("not base64!"),
+			trustedKeys: []ed25519.PublicKey{publicKey},
+		},
+		{
+			name:        "unsigned",
+			data:        bundle,
+			trustedKeys: []ed25519.PublicKey{publicKey},
+		},
+		{
+			name:  "unsigned without trusted keys",
+			data:  bundle,
+			valid: true,
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "bundle"+policyBundleExtension)
+		if err := ioutil.WriteFile(path, test.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if test.signature != nil {
+			if err := ioutil.WriteFile(path+policySignatureExtension, test.signature, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loaded, err := loadPolicyBundle(path, test.trustedKeys)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%s: failed to load bundle: %s", test.name, err)
+		case test.valid && (len(loaded.Profiles) != 1 || loaded.Source != SourceEnterprise):
+			t.Errorf("%s: bundle was not loaded correctly: %+v", test.name, loaded)
+		case !test.valid && err == nil:
+			t.Errorf("%s: expected bundle to be rejected", test.name)
+		}
+	}
+}
+
+func TestParseTrustedKeys(t *testing.T) {
+	t.Parallel()
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(publicKey)
+
+	for _, test := range []struct {
+		keys  []string
+		valid bool
+	}{
+		{keys: nil, valid: true},
+		{keys: []string{encodedKey}, valid: true},
+		{keys: []string{" " + encodedKey + "\n"}, valid: true},
+		{keys: []string{"not base64!"}},
+		{keys: []string{base64.StdEncoding.EncodeToString([]byte("too short"))}},
+	} {
+		keys, err := parseTrustedKeys(test.keys)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%v: failed to parse keys: %s", test.keys, err)
+		case test.valid && len(keys) != len(test.keys):
+			t.Errorf("%v: expected %d keys, got %d", test.keys, len(test.keys), len(keys))
+		case !test.valid && err == nil:
+			t.Errorf("%v: expected keys to be rejected", test.keys)
+		}
+	}
+}
+
+// newEnforcedTestProfile returns a layered profile with a local profile and
+// an enterprise profile with the given configurations.
+func newEnforcedTestProfile(localConfig, enterpriseConfig map[string]interface{}) *LayeredProfile {
+	lp := newLayeredProfile(New(SourceLocal, "enforcement-test", "", localConfig))
+	lp.layers = append(lp.layers, New(SourceEnterprise, "enforcement-test", "", enterpriseConfig))
+	lp.linkedLayersLoaded = true
+	lp.updateCaches()
+	return lp
+}
+
+func TestEnforcedEndpoints(t *testing.T) {
+	t.Parallel()
+
+	lp := newEnforcedTestProfile(
+		map[string]interface{}{
+			CfgOptionEndpointsKey: []string{
+				"+ blocked.example.com",
+				"- permitted.example.com",
+				"+ local.example.com",
+			},
+		},
+		map[string]interface{}{
+			CfgOptionEndpointsKey: []string{
+				"- blocked.example.com",
+				"+ permitted.example.com",
+				"+ enterprise.example.com",
+			},
+		},
+	)
+
+	for _, test := range []struct {
+		domain string
+		result endpoints.EPResult
+	}{
+		// Blocking rules of the enterprise profile are matched first.
+		{domain: "blocked.example.com.", result: endpoints.Denied},
+		// Permitting rules of the enterprise profile may be made stricter.
+		{domain: "permitted.example.com.", result: endpoints.Denied},
+		{domain: "local.example.com.", result: endpoints.Permitted},
+		{domain: "enterprise.example.com.", result: endpoints.Permitted},
+	} {
+		result, _ := lp.MatchEndpoint(context.Background(), &intel.Entity{
+			Domain: test.domain,
+		})
+		if result != test.result {
+			t.Errorf("%s: expected %s, got %s", test.domain, test.result, result)
+		}
+	}
+}
+
+func TestEnforcedEndpointListExceptions(t *testing.T) {
+	t.Parallel()
+
+	lp := newEnforcedTestProfile(
+		map[string]interface{}{
+			CfgOptionEndpointsKey:            []string{"- L:A"},
+			CfgOptionFilterListExceptionsKey: []string{".excepted.example.com"},
+		},
+		map[string]interface{}{
+			CfgOptionEndpointsKey: []string{"- L:B"},
+		},
+	)
+
+	for _, test := range []struct {
+		domain string
+		lists  []string
+		result endpoints.EPResult
+	}{
+		{domain: "ads.example.com.", lists: []string{"A"}, result: endpoints.Denied},
+		{domain: "ads.excepted.example.com.", lists: []string{"A"}, result: endpoints.NoMatch},
+		// Exceptions of the local profile do not override rules of the
+		// enterprise profile.
+		{domain: "ads.excepted.example.com.", lists: []string{"B"}, result: endpoints.Denied},
+	} {
+		result, _ := lp.MatchEndpoint(context.Background(), &intel.Entity{
+			Domain: test.domain,
+			ListOccurences: map[string][]string{
+				test.domain: test.lists,
+			},
+		})
+		if result != test.result {
+			t.Errorf("%s on %v: expected %s, got %s", test.domain, test.lists, test.result, result)
+		}
+	}
+}
+
+func TestEnforcedDefaultAction(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		local      string
+		enterprise string
+		expected   uint8
+	}{
+		{local: "permit", enterprise: "block", expected: DefaultActionBlock},
+		{local: "permit", enterprise: "ask", expected: DefaultActionAsk},
+		{local: "ask", enterprise: "block", expected: DefaultActionBlock},
+		{local: "block", enterprise: "permit", expected: DefaultActionBlock},
+		{local: "ask", enterprise: "permit", expected: DefaultActionAsk},
+		{local: "", enterprise: "ask", expected: DefaultActionAsk},
+		{local: "permit", enterprise: "", expected: DefaultActionPermit},
+	} {
+		configFor := func(action string) map[string]interface{} {
+			if action == "" {
+				return nil
+			}
+			return map[string]interface{}{
+				CfgOptionDefaultActionKey: action,
+			}
+		}
+
+		lp := newEnforcedTestProfile(configFor(test.local), configFor(test.enterprise))
+		if action := lp.DefaultAction(); action != test.expected {
+			t.Errorf("local %q, enterprise %q: expected default action %d, got %d", test.local, test.enterprise, test.expected, action)
+		}
+	}
+}
+
+func TestPolicyProfilesAreReadOnly(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		source       profileSource
+		fromProvider bool
+		writable     bool
+	}{
+		{source: SourceLocal, writable: true},
+		{source: SourceEnterprise},
+		{source: SourceCommunity},
+		{source: SourceEnterprise, fromProvider: true, writable: true},
+		{source: SourceCommunity, fromProvider: true, writable: true},
+	} {
+		profile := New(test.source, "read-only-test", "", nil)
+		profile.fromPolicyProvider = test.fromProvider
+
+		err := profile.Save()
+		switch {
+		case test.writable && err != nil:
+			t.Errorf("%s (from provider: %v): failed to save profile: %s", test.source, test.fromProvider, err)
+		case !test.writable && err == nil:
+			t.Errorf("%s (from provider: %v): expected profile to be read-only", test.source, test.fromProvider)
+		}
+	}
+}
+
+func TestLoadUpdatesPolicyBundle(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		source profileSource
+		valid  bool
+	}{
+		{source: SourceCommunity, valid: true},
+		// The bundle from the updates is not signed, so it must not provide
+		// enforced profiles.
+		{source: SourceEnterprise},
+	} {
+		path := filepath.Join(t.TempDir(), "policies"+policyBundleExtension)
+		data := []byte(`{"Source":"` + string(test.source) + `","Profiles":[{"ID":"test"}]}`)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadUpdatesPolicyBundle(path)
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("%s: failed to load bundle: %s", test.source, err)
+		case !test.valid && err == nil:
+			t.Errorf("%s: expected bundle to be rejected", test.source)
+		}
+	}
+}
+
+func TestLoadPoliciesKeepsProfilesOnError(t *testing.T) {
+	previousDir, previousKeys := cfgOptionPolicyDirectory, cfgOptionPolicyTrustedKeys
+	t.Cleanup(func() {
+		cfgOptionPolicyDirectory, cfgOptionPolicyTrustedKeys = previousDir, previousKeys
+		if err := applyPolicyBundles(context.Background(), nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	dir := t.TempDir()
+	trustedKeys := []string{}
+	cfgOptionPolicyDirectory = func() string { return dir }
+	cfgOptionPolicyTrustedKeys = func() []string { return trustedKeys }
+
+	bundle := []byte(`{"Source":"enterprise","Profiles":[{"ID":"keep-test","MatchPaths":["/usr/bin/keep-test"]}]}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bundle"+policyBundleExtension), bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLoaded := func(name string, loaded bool) {
+		t.Helper()
+
+		matched := len(matchPolicyProfiles("/usr/bin/keep-test")) == 1
+		_, err := profileDB.Get(makeProfileKey(SourceEnterprise, "keep-test"))
+		if matched != loaded || (err == nil) != loaded {
+			t.Errorf("%s: expected policy profile to be loaded: %v, matched: %v, get error: %v", name, loaded, matched, err)
+		}
+	}
+
+	if err := loadPolicies(context.Background(), nil); err != nil {
+		t.Fatalf("failed to load policies: %s", err)
+	}
+	checkLoaded("valid directory", true)
+
+	for _, test := range []struct {
+		name  string
+		setup func()
+	}{
+		{
+			name:  "invalid trusted key",
+			setup: func() { trustedKeys = []string{"not a key"} },
+		},
+		{
+			name: "invalid bundle",
+			setup: func() {
+				trustedKeys = nil
+				if err := ioutil.WriteFile(filepath.Join(dir, "invalid"+policyBundleExtension), []byte("{"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "missing directory",
+			setup: func() {
+				cfgOptionPolicyDirectory = func() string { return filepath.Join(dir, "missing") }
+			},
+		},
+	} {
+		test.setup()
+		if err := loadPolicies(context.Background(), nil); err == nil {
+			t.Errorf("%s: expected loading policies to fail", test.name)
+		}
+		checkLoaded(test.name, true)
+	}
+
+	// Profiles are only removed after the policies loaded successfully.
+	emptyDir := t.TempDir()
+	cfgOptionPolicyDirectory = func() string { return emptyDir }
+	if err := loadPolicies(context.Background(), nil); err != nil {
+		t.Fatalf("failed to load policies: %s", err)
+	}
+	checkLoaded("empty directory", false)
+}
@@ -28,6 +28,13 @@ type LayeredProfile struct {
 	RevisionCounter    uint64
 	globalValidityFlag *config.ValidityFlag
 
+	// linkedLayersLoaded is false while the linked profiles of the local
+	// profile still need to be loaded as layers.
+	linkedLayersLoaded bool
+	// policyRevision is the revision of the policy profiles that the layers
+	// were loaded with.
+	policyRevision uint64
+
 	securityLevel *uint32
 
 	// These functions give layered access to configuration options and require
@@ -48,12 +55,46 @@ type LayeredProfile struct {
 	FilterSubDomains    config.BoolOption `json:"-"`
 	FilterCNAMEs        config.BoolOption `json:"-"`
 	PreventBypassing    config.BoolOption `json:"-"`
+	BlockDNSSECFailures config.BoolOption `json:"-"`
 	DomainHeuristics    config.BoolOption `json:"-"`
 	UseSPN              config.BoolOption `json:"-"`
 }
 
 // NewLayeredProfile returns a new layered profile based on the given local profile.
 func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
+	new := newLayeredProfile(localProfile)
+
+	new.CreateMeta()
+	new.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + revisionProviderPrefix + localProfile.ScopedID())
+
+	// Inform database subscribers about the new layered profile.
+	new.Lock()
+	defer new.Unlock()
+
+	pushLayeredProfile(new)
+
+	return new
+}
+
+// NewTemporaryLayeredProfile returns a new layered profile based on the given
+// local profile, with all linked and policy profiles loaded. It is neither
+// assigned to the local profile nor published, so that it can be used to
+// evaluate the profile without any side effects. It is never updated.
+func NewTemporaryLayeredProfile(localProfile *Profile) *LayeredProfile {
+	new := newLayeredProfile(localProfile)
+
+	new.Lock()
+	defer new.Unlock()
+
+	if !new.linkedLayersLoaded {
+		new.loadLinkedLayers()
+		new.updateCaches()
+	}
+
+	return new
+}
+
+func newLayeredProfile(localProfile *Profile) *LayeredProfile {
 	var securityLevelVal uint32
 
 	new := &LayeredProfile{
@@ -109,6 +150,10 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionPreventBypassingKey,
 		cfgOptionPreventBypassing,
 	)
+	new.BlockDNSSECFailures = new.wrapSecurityLevelOption(
+		CfgOptionBlockDNSSECFailuresKey,
+		cfgOptionBlockDNSSECFailures,
+	)
 	new.DomainHeuristics = new.wrapSecurityLevelOption(
 		CfgOptionDomainHeuristicsKey,
 		cfgOptionDomainHeuristics,
@@ -121,19 +166,14 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 	new.LayerIDs = append(new.LayerIDs, localProfile.ScopedID())
 	new.layers = append(new.layers, localProfile)
 
-	// TODO: Load additional profiles.
+	// Linked and policy profiles are loaded with the first update, as the
+	// layered profile is created while the local profile is being fetched.
+	new.policyRevision = getPolicyRevision()
+	new.linkedLayersLoaded = len(localProfile.LinkedProfiles) == 0 &&
+		len(matchPolicyProfiles(localProfile.LinkedPath)) == 0
 
 	new.updateCaches()
 
-	new.CreateMeta()
-	new.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + revisionProviderPrefix + localProfile.ScopedID())
-
-	// Inform database subscribers about the new layered profile.
-	new.Lock()
-	defer new.Unlock()
-
-	pushLayeredProfile(new)
-
 	return new
 }
 
@@ -201,6 +241,11 @@ func (lp *LayeredProfile) NeedsUpdate() (outdated bool) {
 		return true
 	}
 
+	// Check if linked or policy profiles need to be loaded.
+	if !lp.linkedLayersLoaded || lp.policyRevision != getPolicyRevision() {
+		return true
+	}
+
 	// Check config in layers.
 	for _, layer := range lp.layers {
 		if layer.outdated.IsSet() {
@@ -226,12 +271,24 @@ func (lp *LayeredProfile) Update() (revisionCounter uint64) {
 				log.Errorf("profiles: failed to update profile %s", layer.ScopedID())
 			} else {
 				lp.layers[i] = newLayer
+				// The linked profiles of the local profile might have changed.
+				if i == 0 {
+					lp.localProfile = newLayer
+					lp.linkedLayersLoaded = false
+				}
 			}
 		}
 	}
 	if !lp.globalValidityFlag.IsValid() {
 		changed = true
 	}
+	if lp.policyRevision != getPolicyRevision() {
+		lp.linkedLayersLoaded = false
+	}
+	if !lp.linkedLayersLoaded {
+		changed = true
+		lp.loadLinkedLayers()
+	}
 
 	if changed {
 		// get global config validity flag
@@ -249,6 +306,55 @@ func (lp *LayeredProfile) Update() (revisionCounter uint64) {
 	return lp.RevisionCounter
 }
 
+// loadLinkedLayers replaces all layers after the local profile with the
+// linked profiles of the local profile, followed by the matching policy
+// profiles. Linked profiles are layered in the order they are listed in and
+// their own linked profiles are not loaded.
+// The layered profile must be locked.
+func (lp *LayeredProfile) loadLinkedLayers() {
+	lp.policyRevision = getPolicyRevision()
+
+	lp.localProfile.RLock()
+	linkedProfiles := make([]string, len(lp.localProfile.LinkedProfiles))
+	copy(linkedProfiles, lp.localProfile.LinkedProfiles)
+	linkedPath := lp.localProfile.LinkedPath
+	lp.localProfile.RUnlock()
+	linkedProfiles = append(linkedProfiles, matchPolicyProfiles(linkedPath)...)
+
+	layers := make([]*Profile, 1, len(linkedProfiles)+1)
+	layerIDs := make([]string, 1, len(linkedProfiles)+1)
+	layers[0] = lp.localProfile
+	layerIDs[0] = lp.localProfile.ScopedID()
+
+linkedProfilesLoop:
+	for _, scopedID := range linkedProfiles {
+		// Skip profiles that are already layered.
+		for _, layerID := range layerIDs {
+			if layerID == scopedID {
+				continue linkedProfilesLoop
+			}
+		}
+
+		source, id, err := parseScopedID(scopedID)
+		if err != nil {
+			log.Warningf("profiles: profile %s has invalid linked profile: %s", lp.localProfile.ScopedID(), err)
+			continue
+		}
+		linkedProfile, err := GetProfile(source, id, "")
+		if err != nil {
+			log.Warningf("profiles: failed to load linked profile %s of %s: %s", scopedID, lp.localProfile.ScopedID(), err)
+			continue
+		}
+
+		layers = append(layers, linkedProfile)
+		layerIDs = append(layerIDs, scopedID)
+	}
+
+	lp.layers = layers
+	lp.LayerIDs = layerIDs
+	lp.linkedLayersLoaded = true
+}
+
 func (lp *LayeredProfile) updateCaches() {
 	// update security level
 	var newLevel uint8
@@ -272,6 +378,19 @@ func (lp *LayeredProfile) SecurityLevel() uint8 {
 
 // DefaultAction returns the active default action ID. This functions requires the layered profile to be read locked.
 func (lp *LayeredProfile) DefaultAction() uint8 {
+	action := lp.layeredDefaultAction()
+
+	// Enforced profiles may only make the default action stricter.
+	for _, layer := range lp.layers {
+		if layer.enforced() && layer.defaultAction > 0 && layer.defaultAction < action {
+			action = layer.defaultAction
+		}
+	}
+
+	return action
+}
+
+func (lp *LayeredProfile) layeredDefaultAction() uint8 {
 	for _, layer := range lp.layers {
 		if layer.defaultAction > 0 {
 			return layer.defaultAction
@@ -285,6 +404,21 @@ func (lp *LayeredProfile) DefaultAction() uint8 {
 
 // MatchEndpoint checks if the given endpoint matches an entry in any of the profiles. This functions requires the layered profile to be read locked.
 func (lp *LayeredProfile) MatchEndpoint(ctx context.Context, entity *intel.Entity) (endpoints.EPResult, endpoints.Reason) {
+	enforcedExceptions, exceptions := lp.listExceptions()
+	defer entity.SetListExceptions()
+
+	// Blocking rules of enforced profiles cannot be overridden.
+	entity.SetListExceptions(enforcedExceptions...)
+	for _, layer := range lp.layers {
+		if layer.enforced() && layer.endpoints.IsSet() {
+			result, reason := layer.endpoints.Match(ctx, entity)
+			if result == endpoints.Denied {
+				return result, reason
+			}
+		}
+	}
+
+	entity.SetListExceptions(exceptions...)
 	for _, layer := range lp.layers {
 		if layer.endpoints.IsSet() {
 			result, reason := layer.endpoints.Match(ctx, entity)
@@ -303,6 +437,21 @@ func (lp *LayeredProfile) MatchEndpoint(ctx context.Context, entity *intel.Entit
 func (lp *LayeredProfile) MatchServiceEndpoint(ctx context.Context, entity *intel.Entity) (endpoints.EPResult, endpoints.Reason) {
 	entity.EnableReverseResolving()
 
+	enforcedExceptions, exceptions := lp.listExceptions()
+	defer entity.SetListExceptions()
+
+	// Blocking rules of enforced profiles cannot be overridden.
+	entity.SetListExceptions(enforcedExceptions...)
+	for _, layer := range lp.layers {
+		if layer.enforced() && layer.serviceEndpoints.IsSet() {
+			result, reason := layer.serviceEndpoints.Match(ctx, entity)
+			if result == endpoints.Denied {
+				return result, reason
+			}
+		}
+	}
+
+	entity.SetListExceptions(exceptions...)
 	for _, layer := range lp.layers {
 		if layer.serviceEndpoints.IsSet() {
 			result, reason := layer.serviceEndpoints.Match(ctx, entity)
@@ -323,12 +472,28 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 	entity.ResolveSubDomainLists(ctx, lp.FilterSubDomains())
 	entity.EnableCNAMECheck(ctx, lp.FilterCNAMEs())
 
+	enforcedExceptions, exceptions := lp.listExceptions()
+
+	// Filter lists of enforced profiles always apply.
+	for _, layer := range lp.layers {
+		if layer.enforced() && layer.filterListsSet {
+			entity.LoadLists(ctx)
+
+			if entity.MatchLists(layer.filterListIDs, enforcedExceptions...) {
+				return endpoints.Denied, entity.ListBlockReason()
+			}
+		}
+	}
+
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
 	for _, layer := range lp.layers {
 		// Search for the first layer that has filter lists set.
 		if layer.filterListsSet {
 			entity.LoadLists(ctx)
 
-			if entity.MatchLists(layer.filterListIDs) {
+			if entity.MatchLists(layer.filterListIDs, exceptions...) {
 				return endpoints.Denied, entity.ListBlockReason()
 			}
 
@@ -336,12 +501,10 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 		}
 	}
 
-	cfgLock.RLock()
-	defer cfgLock.RUnlock()
 	if len(cfgFilterLists) > 0 {
 		entity.LoadLists(ctx)
 
-		if entity.MatchLists(cfgFilterLists) {
+		if entity.MatchLists(cfgFilterLists, exceptions...) {
 			return endpoints.Denied, entity.ListBlockReason()
 		}
 	}
@@ -349,11 +512,33 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 	return endpoints.NoMatch, nil
 }
 
+// listExceptions returns the filter list exceptions of the enforced profiles
+// and all filter list exceptions, including the global ones. Exceptions of
+// enforced profiles only override the filter lists of enforced profiles, all
+// exceptions override the other filter lists.
+func (lp *LayeredProfile) listExceptions() (enforced, all []*intel.ListExceptions) {
+	cfgLock.RLock()
+	all = []*intel.ListExceptions{cfgListExceptions}
+	cfgLock.RUnlock()
+
+	for _, layer := range lp.layers {
+		if layer.listExceptions.IsEmpty() {
+			continue
+		}
+		if layer.enforced() {
+			enforced = append(enforced, layer.listExceptions)
+		}
+		all = append(all, layer.listExceptions)
+	}
+	return enforced, all
+}
+
 func (lp *LayeredProfile) wrapSecurityLevelOption(configKey string, globalConfig config.IntOption) config.BoolOption {
 	activeAtLevels := lp.wrapIntOption(configKey, globalConfig)
+	enforcedAtLevels := lp.wrapEnforcedIntOption(configKey)
 
 	return func() bool {
-		return uint8(activeAtLevels())&max(
+		return uint8(activeAtLevels()|enforcedAtLevels())&max(
 			lp.SecurityLevel(),           // layered profile security level
 			status.ActiveSecurityLevel(), // global security level
 		) > 0
@@ -424,6 +609,38 @@ func (lp *LayeredProfile) wrapIntOption(configKey string, globalConfig config.In
 	}
 }
 
+// wrapEnforcedIntOption returns the combined bits of the given security level
+// option of all enforced profiles.
+func (lp *LayeredProfile) wrapEnforcedIntOption(configKey string) config.IntOption {
+	var revCnt uint64 = 0
+	var value int64
+	var refreshLock sync.Mutex
+
+	return func() int64 {
+		refreshLock.Lock()
+		defer refreshLock.Unlock()
+
+		// Check if we need to refresh the value.
+		if revCnt != lp.RevisionCounter {
+			revCnt = lp.RevisionCounter
+
+			// Combine the values of all enforced layers.
+			value = 0
+			for _, layer := range lp.layers {
+				if !layer.enforced() {
+					continue
+				}
+				layerValue, ok := layer.configPerspective.GetAsInt(configKey)
+				if ok {
+					value |= layerValue
+				}
+			}
+		}
+
+		return value
+	}
+}
+
 // GetProfileSource returns the database key of the first profile in the
 // layers that has the given configuration key set. If it returns an empty
 // string, the global profile can be assumed to have been effective.
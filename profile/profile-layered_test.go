@@ -0,0 +1,104 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func saveTestProfile(t *testing.T, id string, linkedProfiles ...string) *Profile {
+	t.Helper()
+
+	profile := New(SourceLocal, id, "", nil)
+	profile.LinkedProfiles = linkedProfiles
+	if err := profile.Save(); err != nil {
+		t.Fatalf("failed to save profile %s: %s", id, err)
+	}
+	return profile
+}
+
+func TestLoadLinkedLayers(t *testing.T) {
+	// The linked profile links back to the local profile. Linked profiles of
+	// linked profiles are not loaded, so this must not loop.
+	saveTestProfile(t, "layers-test-linked-a", "local/layers-test-main")
+	saveTestProfile(t, "layers-test-linked-b", "local/layers-test-linked-c")
+	saveTestProfile(t, "layers-test-linked-c")
+
+	for _, test := range []struct {
+		name           string
+		linkedProfiles []string
+		layerIDs       []string
+	}{
+		{
+			name: "no linked profiles",
+			layerIDs: []string{
+				"local/layers-test-main",
+			},
+		},
+		{
+			name: "order",
+			linkedProfiles: []string{
+				"local/layers-test-linked-b",
+				"local/layers-test-linked-a",
+			},
+			layerIDs: []string{
+				"local/layers-test-main",
+				"local/layers-test-linked-b",
+				"local/layers-test-linked-a",
+			},
+		},
+		{
+			name: "missing and invalid linked profiles",
+			linkedProfiles: []string{
+				"local/layers-test-missing",
+				"layers-test-linked-a",
+				"unknown/layers-test-linked-a",
+				"local/layers-test-linked-a",
+			},
+			layerIDs: []string{
+				"local/layers-test-main",
+				"local/layers-test-linked-a",
+			},
+		},
+		{
+			name: "cycles and duplicates",
+			linkedProfiles: []string{
+				"local/layers-test-main",
+				"local/layers-test-linked-a",
+				"local/layers-test-linked-b",
+				"local/layers-test-linked-a",
+			},
+			layerIDs: []string{
+				"local/layers-test-main",
+				"local/layers-test-linked-a",
+				"local/layers-test-linked-b",
+			},
+		},
+	} {
+		localProfile := New(SourceLocal, "layers-test-main", "", nil)
+		localProfile.LinkedProfiles = test.linkedProfiles
+
+		lp := newLayeredProfile(localProfile)
+		lp.Lock()
+		lp.loadLinkedLayers()
+		lp.Unlock()
+
+		if !lp.linkedLayersLoaded {
+			t.Errorf("%s: linked layers not marked as loaded", test.name)
+		}
+		if !reflect.DeepEqual(lp.LayerIDs, test.layerIDs) {
+			t.Errorf("%s: expected layers %v, got %v", test.name, test.layerIDs, lp.LayerIDs)
+		}
+		if len(lp.layers) != len(lp.LayerIDs) {
+			t.Errorf("%s: got %d layers for %d layer IDs", test.name, len(lp.layers), len(lp.LayerIDs))
+			continue
+		}
+		for i, layer := range lp.layers {
+			if layer.ScopedID() != lp.LayerIDs[i] {
+				t.Errorf("%s: layer %d is %s, expected %s", test.name, i, layer.ScopedID(), lp.LayerIDs[i])
+			}
+		}
+		if lp.layers[0] != localProfile {
+			t.Errorf("%s: first layer is not the local profile", test.name)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/utils"
 	"github.com/safing/portbase/utils/osdetail"
+	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/profile/endpoints"
 )
@@ -83,7 +84,14 @@ type Profile struct {
 	// LinkedPath is a filesystem path to the executable this
 	// profile was created for.
 	LinkedPath string // constant
-	// LinkedProfiles is a list of other profiles
+	// Fingerprints link processes to this profile in addition to the
+	// LinkedPath. This allows executables to keep their profile when they are
+	// moved, and to detect executables that were replaced.
+	Fingerprints []Fingerprint
+	// LinkedProfiles is a list of scoped IDs (<Source>/<ID>) of other profiles
+	// that are layered below this profile. Settings of this profile take
+	// precedence, followed by the linked profiles in the listed order and then
+	// the global settings. Linked profiles of linked profiles are not applied.
 	LinkedProfiles []string
 	// SecurityLevel is the mininum security level to apply to
 	// connections made with this profile.
@@ -121,6 +129,15 @@ type Profile struct {
 	// local profile and the associated layered profile.
 	layeredProfile *LayeredProfile
 
+	// fromPolicyProvider is set when the profile is saved by the policy
+	// provider, which is the only one allowed to change community and
+	// enterprise profiles.
+	fromPolicyProvider bool
+
+	// replacedExecutableFlagged is set when the user was notified that the
+	// executable of this profile does not match its hash fingerprints.
+	replacedExecutableFlagged bool
+
 	// Interpreted Data
 	configPerspective *config.Perspective
 	dataParsed        bool
@@ -129,6 +146,7 @@ type Profile struct {
 	serviceEndpoints  endpoints.Endpoints
 	filterListsSet    bool
 	filterListIDs     []string
+	listExceptions    *intel.ListExceptions
 
 	// Lifecycle Management
 	outdated   *abool.AtomicBool
@@ -201,6 +219,15 @@ func (profile *Profile) parseConfig() error {
 		}
 	}
 
+	list, ok = profile.configPerspective.GetAsStringArray(CfgOptionFilterListExceptionsKey)
+	profile.listExceptions = nil
+	if ok {
+		profile.listExceptions, err = intel.ParseListExceptions(list)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
 	return lastErr
 }
 
@@ -250,6 +277,12 @@ func (profile *Profile) ScopedID() string {
 	return makeScopedID(profile.Source, profile.ID)
 }
 
+// enforced returns whether the profile is enforced, meaning that its blocking
+// rules and stricter settings cannot be overridden by other layers.
+func (profile *Profile) enforced() bool {
+	return profile.Source == SourceEnterprise
+}
+
 // makeKey derives and sets the record Key from the profile attributes.
 func (profile *Profile) makeKey() {
 	profile.SetKey(makeProfileKey(profile.Source, profile.ID))
@@ -367,6 +400,30 @@ func (profile *Profile) addEndpointyEntry(cfgKey, newEntry string) {
 	}
 }
 
+// IsNew returns whether the profile was just created and has not been saved
+// to the database yet.
+func (profile *Profile) IsNew() bool {
+	profile.RLock()
+	defer profile.RUnlock()
+
+	return profile.Meta() == nil
+}
+
+// LinkInitialProfile adds the profile with the given scoped ID to the linked
+// profiles, if the profile does not link to any profiles yet. It returns
+// whether the profile was changed and must be saved.
+func (profile *Profile) LinkInitialProfile(scopedID string) (changed bool) {
+	profile.Lock()
+	defer profile.Unlock()
+
+	if len(profile.LinkedProfiles) > 0 || scopedID == profile.ScopedID() {
+		return false
+	}
+
+	profile.LinkedProfiles = []string{scopedID}
+	return true
+}
+
 // LayeredProfile returns the layered profile associated with this profile.
 func (profile *Profile) LayeredProfile() *LayeredProfile {
 	profile.Lock()
@@ -1,10 +1,13 @@
 package resolver
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
 )
 
 func registerAPI() error {
@@ -23,7 +26,23 @@ func registerAPI() error {
 		Read:        api.PermitAnyone,
 		StructFunc:  exportDNSResolvers,
 		Name:        "List DNS Resolvers",
-		Description: "List currently configured DNS resolvers and their status.",
+		Description: "List currently configured DNS resolvers and their status, including latency and error statistics.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "dns/localRecords/import",
+		Write:       api.PermitAdmin,
+		ActionFunc:  importHostsFile,
+		Name:        "Import Hosts File",
+		Description: "Adds the entries of a hosts file to the local DNS records.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       "hosts file",
+			Description: "Send the hosts file as the request body, eg. the content of `/etc/hosts`.",
+		}},
 	}); err != nil {
 		return err
 	}
@@ -52,6 +71,8 @@ func registerAPI() error {
 type resolverExport struct {
 	*Resolver
 	Failing bool
+	Health  ResolverHealth
+	Score   float64
 }
 
 func exportDNSResolvers(*api.Request) (interface{}, error) {
@@ -60,11 +81,46 @@ func exportDNSResolvers(*api.Request) (interface{}, error) {
 
 	export := make([]resolverExport, 0, len(globalResolvers))
 	for _, r := range globalResolvers {
+		health := r.Health()
 		export = append(export, resolverExport{
 			Resolver: r,
 			Failing:  r.Conn.IsFailing(),
+			Health:   health,
+			Score:    health.Score(),
 		})
 	}
 
 	return export, nil
 }
+
+func importHostsFile(ar *api.Request) (msg string, err error) {
+	entries, errs := parseHostsFile(string(ar.InputData))
+	for _, err := range errs {
+		log.Warningf("resolver: ignoring hosts file entry %s", err)
+	}
+
+	// Add new entries to the local records.
+	records := configuredLocalRecords()
+	existing := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		existing[record] = struct{}{}
+	}
+	newRecords := make([]string, 0, len(records)+len(entries))
+	newRecords = append(newRecords, records...)
+	var added int
+	for _, entry := range entries {
+		if _, ok := existing[entry]; !ok {
+			existing[entry] = struct{}{}
+			newRecords = append(newRecords, entry)
+			added++
+		}
+	}
+
+	if added > 0 {
+		if err := config.SetConfigOption(CfgOptionLocalRecordsKey, newRecords); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("imported %d local records, ignored %d invalid entries", added, len(errs)), nil
+}
@@ -57,6 +57,22 @@
 	configuredNameServers     config.StringArrayOption
 	cfgOptionNameServersOrder = 0
 
+	CfgOptionForwardingRulesKey   = "dns/forwardingRules"
+	configuredForwardingRules     config.StringArrayOption
+	cfgOptionForwardingRulesOrder = 4
+
+	CfgOptionLocalRecordsKey   = "dns/localRecords"
+	configuredLocalRecords     config.StringArrayOption
+	cfgOptionLocalRecordsOrder = 7
+
+	CfgOptionResolverSelectionKey   = "dns/resolverSelection"
+	resolverSelection               config.StringOption
+	cfgOptionResolverSelectionOrder = 5
+
+	CfgOptionResolverRaceCountKey   = "dns/resolverRaceCount"
+	resolverRaceCount               config.IntOption
+	cfgOptionResolverRaceCountOrder = 6
+
 	CfgOptionNoAssignedNameserversKey   = "dns/noAssignedNameservers"
 	noAssignedNameservers               status.SecurityLevelOptionFunc
 	cfgOptionNoAssignedNameserversOrder = 1
@@ -73,6 +89,18 @@
 	dontResolveSpecialDomains               status.SecurityLevelOptionFunc
 	cfgOptionDontResolveSpecialDomainsOrder = 16
 
+	CfgOptionDNSSECValidationKey   = "dns/dnssecValidation"
+	dnssecValidation               config.BoolOption
+	cfgOptionDNSSECValidationOrder = 17
+
+	CfgOptionServeStaleKey   = "dns/serveStale"
+	serveStale               config.IntOption
+	cfgOptionServeStaleOrder = 18
+
+	CfgOptionPrefetchKey   = "dns/prefetch"
+	prefetchEnabled        config.BoolOption
+	cfgOptionPrefetchOrder = 19
+
 	CfgOptionNameserverRetryRateKey   = "dns/nameserverRetryRate"
 	nameserverRetryRate               config.IntOption
 	cfgOptionNameserverRetryRateOrder = 32
@@ -83,7 +111,7 @@ func prepConfig() error {
 		Name:        "DNS Servers",
 		Key:         CfgOptionNameServersKey,
 		Description: "DNS Servers to use for resolving DNS requests.",
-		Help: strings.ReplaceAll(`DNS Servers are used in the order as entered. The first one will be used as the primary DNS Server. Only if it fails, will the other servers be used as a fallback - in their respective order. If all fail, or if no DNS Server is configured here, the Portmaster will use the one configured in your system or network.
+		Help: strings.ReplaceAll(`DNS Servers are used in the order as entered. The first one will be used as the primary DNS Server. Only if it fails, will the other servers be used as a fallback - in their respective order. If all fail, or if no DNS Server is configured here, the Portmaster will use the one configured in your system or network. The order can be changed with the "DNS Server Selection" setting.
 
 Additionally, if it is more likely that the DNS Server of your system or network has a (better) answer to a request, they will be asked first. This will be the case for special local domains and domain spaces announced on the current network.
 
@@ -92,23 +120,31 @@ func prepConfig() error {
 
 - Protocol
 	- "dot": DNS-over-TLS (recommended)  
+	- "doh": DNS-over-HTTPS  
 	- "dns": plain old DNS  
 	- "tcp": plain old DNS over TCP
 - IP: always use the IP address and _not_ the domain name!
 - Port: optionally define a custom port
+- Path: optionally define a custom path for "doh", defaults to "/dns-query"
 - Parameters:
 	- "name": give your DNS Server a name that is used for messages and logs
-	- "verify": domain name to verify for "dot", required and only valid for protocol "dot"
+	- "verify": domain name to verify for "dot" and "doh", required and only valid for these protocols
+	- "method": HTTP request method for "doh", either "post" (default) or "get"
 	- "blockedif": detect if the name server blocks a query, options:
 		- "empty": server replies with NXDomain status, but without any other record in any section
 		- "refused": server replies with Refused status
 		- "zeroip": server replies with an IP address, but it is zero
+	- "ecs": how to handle client subnet information, which could be used to locate you, options:
+		- "strip": never send it and remove it from replies (default)
+		- "optout": additionally ask the server to not add it on its own
+	- "padding": pad queries to hide their size, "on" (default) or "off", only valid for "dot" and "doh"
+	- "0x20": randomize the case of queries and verify it in replies to detect spoofing, "on" or "off" (default), only valid for "dns"
 `, `"`, "`"),
 		OptType:         config.OptTypeStringArray,
 		ExpertiseLevel:  config.ExpertiseLevelExpert,
 		ReleaseLevel:    config.ReleaseLevelStable,
 		DefaultValue:    defaultNameServers,
-		ValidationRegex: fmt.Sprintf("^(%s|%s|%s)://.*", ServerTypeDoT, ServerTypeDNS, ServerTypeTCP),
+		ValidationRegex: fmt.Sprintf("^(%s|%s|%s|%s)://.*", ServerTypeDoT, ServerTypeDoH, ServerTypeDNS, ServerTypeTCP),
 		Annotations: config.Annotations{
 			config.DisplayHintAnnotation:  config.DisplayHintOrdered,
 			config.DisplayOrderAnnotation: cfgOptionNameServersOrder,
@@ -156,6 +192,124 @@ func prepConfig() error {
 	}
 	configuredNameServers = config.Concurrent.GetAsStringArray(CfgOptionNameServersKey, defaultNameServers)
 
+	err = config.Register(&config.Option{
+		Name:        "Conditional Forwarding",
+		Key:         CfgOptionForwardingRulesKey,
+		Description: "Resolve specific domains and their subdomains with dedicated DNS servers, eg. company domains that only resolve within the company network.",
+		Help: strings.ReplaceAll(`Forwarding rules are configured in the format: "domain server [nofallback]"  
+For example: "*.corp.example dns://10.0.0.53"
+
+- Domain: the rule applies to the domain and all its subdomains, the "*." prefix is optional. If multiple rules match, the most specific one is used.
+- Server: a DNS Server in the same URL format as the "DNS Servers" setting. Add multiple rules for the same domain in order to use multiple servers.
+- "nofallback": never ask any other DNS Server for the domain, even if the configured ones fail or are not allowed by the other settings. Use this to make sure that internal domains are never leaked to public DNS Servers.
+
+Without "nofallback", the configured servers are asked first and the other DNS Servers are used as a fallback.
+`, `"`, "`"),
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelStable,
+		DefaultValue:    []string{},
+		ValidationRegex: fmt.Sprintf(`^[^ ]+ (%s|%s|%s|%s)://[^ ]+( %s)?$`, ServerTypeDoT, ServerTypeDoH, ServerTypeDNS, ServerTypeTCP, forwardingNoFallbackFlag),
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOrdered,
+			config.DisplayOrderAnnotation: cfgOptionForwardingRulesOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	configuredForwardingRules = config.Concurrent.GetAsStringArray(CfgOptionForwardingRulesKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:        "Local DNS Records",
+		Key:         CfgOptionLocalRecordsKey,
+		Description: "Define DNS records that are answered directly, without asking any DNS Server. Connections to the defined IP addresses are attributed to the defined domains.",
+		Help: strings.ReplaceAll(`Records are configured in the format: "domain type data"  
+For example: "nas.home.arpa A 192.168.1.10"
+
+- Domain: prefix the domain with "*." in order to define the record for all its subdomains. Records of the domain itself take precedence.
+- Type: one of "A", "AAAA", "CNAME", "TXT" and "PTR".
+- Data: the IP address, target domain or text of the record.
+
+Alternatively, records may be entered in the hosts file format: "ip domain [domain...]"  
+This defines A or AAAA records for all domains and a PTR record for the IP address that points to the first domain. Hosts files can be imported with the "dns/localRecords/import" API endpoint.
+`, `"`, "`"),
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelBeta,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[^ ]+ [^ ]+.*$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionLocalRecordsOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	configuredLocalRecords = config.Concurrent.GetAsStringArray(CfgOptionLocalRecordsKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:        "DNS Server Selection",
+		Key:         CfgOptionResolverSelectionKey,
+		Description: "Define how the DNS Servers are selected for resolving. The Portmaster tracks the latency and errors of every DNS Server in order to find the healthiest ones. This does not affect DNS Servers of forwarding rules and local domains.",
+		OptType:     config.OptTypeString,
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "In Order",
+				Value:       ResolverSelectionOrdered,
+				Description: "Use the DNS Servers in the configured order and only use the next one if a DNS Server fails.",
+			},
+			{
+				Name:        "Fastest",
+				Value:       ResolverSelectionFastest,
+				Description: "Use the DNS Server with the lowest latency and error rate first.",
+			},
+			{
+				Name:        "Round Robin",
+				Value:       ResolverSelectionRoundRobin,
+				Description: "Spread queries evenly over all DNS Servers.",
+			},
+			{
+				Name:        "Race",
+				Value:       ResolverSelectionRace,
+				Description: "Query the fastest DNS Servers in parallel and use the first answer. This sends more queries, but is the most resilient against slow DNS Servers.",
+			},
+		},
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   ResolverSelectionOrdered,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionResolverSelectionOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	resolverSelection = config.Concurrent.GetAsString(CfgOptionResolverSelectionKey, ResolverSelectionOrdered)
+
+	err = config.Register(&config.Option{
+		Name:           "Race DNS Servers",
+		Key:            CfgOptionResolverRaceCountKey,
+		Description:    "Amount of DNS Servers that are queried in parallel, if the DNS Server Selection is set to \"Race\".",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   2,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionResolverRaceCountOrder,
+			config.CategoryAnnotation:     "Servers",
+		},
+		ValidationRegex: `^[2-5]$`,
+	})
+	if err != nil {
+		return err
+	}
+	resolverRaceCount = config.Concurrent.GetAsInt(CfgOptionResolverRaceCountKey, 2)
+
 	err = config.Register(&config.Option{
 		Name:           "Retry Timeout",
 		Key:            CfgOptionNameserverRetryRateKey,
@@ -262,6 +416,62 @@ func prepConfig() error {
 	}
 	dontResolveSpecialDomains = status.SecurityLevelOption(CfgOptionDontResolveSpecialDomainsKey)
 
+	err = config.Register(&config.Option{
+		Name:           "Validate DNSSEC",
+		Key:            CfgOptionDNSSECValidationKey,
+		Description:    "Validate DNS answers with DNSSEC, using the root zone as trust anchor. The validation state is shown with the DNS answers and connections. Connections to domains that fail validation can be blocked in the app settings.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionDNSSECValidationOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	dnssecValidation = config.Concurrent.GetAsBool(CfgOptionDNSSECValidationKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Serve Stale Records",
+		Key:            CfgOptionServeStaleKey,
+		Description:    "Answer with expired records from the cache while they are refreshed, if the DNS servers cannot be reached or take too long to answer. This defines for how long records may be used after they expired. Set to 0 to never use expired records.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   defaultServeStale,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionServeStaleOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,7}$`,
+	})
+	if err != nil {
+		return err
+	}
+	serveStale = config.Concurrent.GetAsInt(CfgOptionServeStaleKey, defaultServeStale)
+
+	err = config.Register(&config.Option{
+		Name:           "Prefetch Popular Domains",
+		Key:            CfgOptionPrefetchKey,
+		Description:    "Refresh frequently used records before they expire, so that they are always answered from the cache.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrefetchOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	prefetchEnabled = config.Concurrent.GetAsBool(CfgOptionPrefetchKey, true)
+
 	return nil
 }
 
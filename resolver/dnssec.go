@@ -0,0 +1,1021 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+// DNSSEC Validation States
+const (
+	// DNSSECSecure is the state of answers that were validated with a chain of
+	// trust up to the root trust anchor.
+	DNSSECSecure = "secure"
+
+	// DNSSECInsecure is the state of answers from zones that were proven to be
+	// unsigned.
+	DNSSECInsecure = "insecure"
+
+	// DNSSECBogus is the state of answers that should be signed, but whose
+	// signatures or denial of existence failed validation.
+	DNSSECBogus = "bogus"
+
+	// DNSSECIndeterminate is the state of answers that could not be validated,
+	// because the resolver did not provide the necessary records.
+	DNSSECIndeterminate = "indeterminate"
+)
+
+const (
+	// dnssecUDPSize is the UDP payload size that is advertised to upstream
+	// resolvers when requesting DNSSEC records.
+	dnssecUDPSize = 1232
+
+	// nsec3MaxIterations is the maximum number of NSEC3 iterations that are
+	// computed. Zones with more iterations are treated as insecure, as
+	// recommended by RFC9276.
+	nsec3MaxIterations = 150
+
+	// maxZoneTrustTTL is the maximum time the DNSSEC state of a zone is cached.
+	maxZoneTrustTTL = 1 * time.Hour
+
+	// bogusZoneTrustTTL is the time the DNSSEC state of a bogus zone is cached.
+	bogusZoneTrustTTL = 1 * time.Minute
+)
+
+var (
+	// rootTrustAnchors holds the DS records of the key signing keys of the root
+	// zone, as published at https://data.iana.org/root-anchors/root-anchors.xml
+	rootTrustAnchors = []string{
+		". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	}
+
+	zoneTrustCache     = make(map[string]*zoneTrust)
+	zoneTrustCacheLock sync.Mutex
+
+	errNoValidSignature = errors.New("no valid signature")
+)
+
+// zoneTrust holds the DNSSEC state of a zone.
+type zoneTrust struct {
+	// zone is the apex of the zone.
+	zone string
+	// state is the DNSSEC validation state of the zone.
+	state string
+	// keys holds the validated keys of secure zones.
+	keys []*dns.DNSKEY
+	// expires holds when the state must be checked again.
+	expires time.Time
+}
+
+// inheritTrust returns the state of the given zone trust for a child zone.
+func inheritTrust(parent *zoneTrust) *zoneTrust {
+	return &zoneTrust{
+		zone:    parent.zone,
+		state:   parent.state,
+		keys:    parent.keys,
+		expires: parent.expires,
+	}
+}
+
+func newZoneTrust(zone, state string, keys []*dns.DNSKEY, ttl uint32) *zoneTrust {
+	expires := time.Duration(ttl) * time.Second
+	switch {
+	case state == DNSSECBogus:
+		expires = bogusZoneTrustTTL
+	case expires > maxZoneTrustTTL:
+		expires = maxZoneTrustTTL
+	}
+
+	return &zoneTrust{
+		zone:    zone,
+		state:   state,
+		keys:    keys,
+		expires: time.Now().Add(expires),
+	}
+}
+
+// dnssecQueryFunc sends a query to a resolver.
+type dnssecQueryFunc func(ctx context.Context, q *Query) (*RRCache, error)
+
+// dnssecValidator validates answers by building the chain of trust from the
+// root trust anchors. The DNSKEY and DS records it needs are queried using
+// the same resolver that provided the answer.
+type dnssecValidator struct {
+	query dnssecQueryFunc
+}
+
+// addDNSSECOptions requests DNSSEC records with the given message, if DNSSEC
+// validation is enabled. Checking is disabled upstream, as answers are
+// validated locally and the validation state is reported.
+func addDNSSECOptions(msg *dns.Msg) {
+	if dnssecValidation() {
+		msg.SetEdns0(dnssecUDPSize, true)
+		msg.CheckingDisabled = true
+	}
+}
+
+// dnssecApplies returns whether answers of the given resolver to the given
+// query should be validated.
+func (resolver *Resolver) dnssecApplies(q *Query) bool {
+	switch {
+	case !dnssecValidation():
+		return false
+	case resolver.Info.Type == ServerTypeMDNS ||
+		resolver.Info.Type == ServerTypeEnv ||
+		resolver.Info.Type == ServerTypeOverride:
+		// Local data only.
+		return false
+	case resolver.Info.Source == ServerSourceForwarding:
+		// Forwarding is mostly used for split-horizon zones, which are not signed.
+		return false
+	case domainInScope(q.dotPrefixedFQDN, multicastDomains),
+		domainInScope(q.dotPrefixedFQDN, specialUseDomains),
+		domainInScope(q.dotPrefixedFQDN, specialServiceDomains):
+		// Domains of the local network or special services.
+		return false
+	default:
+		return true
+	}
+}
+
+// validateDNSSEC validates the answer of the resolver to the given query and
+// returns the DNSSEC validation state.
+func (resolver *Resolver) validateDNSSEC(ctx context.Context, q *Query, rrCache *RRCache) string {
+	v := &dnssecValidator{
+		query: resolver.Conn.Query,
+	}
+	return v.validate(ctx, q, rrCache)
+}
+
+func (v *dnssecValidator) validate(ctx context.Context, q *Query, rrCache *RRCache) string {
+	switch rrCache.RCode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		// Nothing to validate.
+		return ""
+	}
+
+	// Validate all RRsets of the answer section.
+	state := DNSSECSecure
+	rrsets, sigs, order := splitRRsets(rrCache.Answer)
+	for _, key := range order {
+		// Skip CNAMEs that were synthesized from a DNAME, as they are not signed.
+		if key.rrType == dns.TypeCNAME && len(sigs[key]) == 0 && synthesizedFromDNAME(key.name, rrsets) {
+			continue
+		}
+
+		state = worseDNSSECState(state, v.validateRRset(ctx, key, rrsets[key], sigs[key], rrCache.Ns))
+	}
+
+	// Validate the denial of existence, if the answer does not hold the
+	// requested records.
+	target := followCNAMEs(dns.CanonicalName(q.FQDN), uint16(q.QType), rrsets)
+	if _, ok := rrsets[rrsetKey{name: target, rrType: uint16(q.QType)}]; !ok {
+		state = worseDNSSECState(
+			state,
+			v.validateDenial(ctx, target, uint16(q.QType), rrCache.RCode == dns.RcodeNameError, rrCache.Ns),
+		)
+	}
+
+	log.Tracer(ctx).Tracef("resolver: DNSSEC validation of %s: %s", q.ID(), state)
+	return state
+}
+
+func (v *dnssecValidator) validateRRset(ctx context.Context, key rrsetKey, rrset []dns.RR, sigs []*dns.RRSIG, ns []dns.RR) string {
+	// Unsigned records are only fine in insecure zones.
+	if len(sigs) == 0 {
+		trust := v.getZoneTrust(ctx, key.name)
+		if trust.state == DNSSECSecure {
+			return DNSSECBogus
+		}
+		return trust.state
+	}
+
+	// Get the keys of the signing zone.
+	signer := dns.CanonicalName(sigs[0].SignerName)
+	if !dns.IsSubDomain(signer, key.name) {
+		return DNSSECBogus
+	}
+	trust := v.getZoneTrust(ctx, signer)
+	switch {
+	case trust.state != DNSSECSecure:
+		return trust.state
+	case trust.zone != signer:
+		return DNSSECBogus
+	}
+
+	// Verify the signatures.
+	sig, err := verifyRRset(rrset, sigs, trust.keys)
+	if err != nil {
+		return DNSSECBogus
+	}
+
+	// Answers that were expanded from a wildcard must come with a proof that
+	// there is no closer match.
+	if int(sig.Labels) < dns.CountLabel(key.name) {
+		nsecs, nsec3s, denialTrust := v.getDenialRecords(ctx, key.name, ns)
+		if denialTrust == nil ||
+			denialTrust.state != DNSSECSecure ||
+			!provesWildcardExpansion(key.name, int(sig.Labels), nsecs, nsec3s) {
+			return DNSSECBogus
+		}
+	}
+
+	return DNSSECSecure
+}
+
+func (v *dnssecValidator) validateDenial(ctx context.Context, name string, qType uint16, nxDomain bool, ns []dns.RR) string {
+	nsecs, nsec3s, trust := v.getDenialRecords(ctx, name, ns)
+
+	// Unsigned denials are only fine in insecure zones.
+	if trust == nil {
+		zoneTrust := v.getZoneTrust(ctx, name)
+		if zoneTrust.state == DNSSECSecure {
+			return DNSSECBogus
+		}
+		return zoneTrust.state
+	}
+	if trust.state != DNSSECSecure {
+		return trust.state
+	}
+
+	if nxDomain {
+		return proveNXDomain(name, nsecs, nsec3s)
+	}
+	return proveNoData(name, qType, nsecs, nsec3s)
+}
+
+// getDenialRecords returns the NSEC and NSEC3 records of the given section
+// and the trust of the zone that signed them. The signing zone must be the
+// given zone or one of its parents. If there are no signed denial records,
+// the returned trust is nil. If the records fail validation, the returned
+// trust is bogus.
+func (v *dnssecValidator) getDenialRecords(ctx context.Context, zone string, section []dns.RR) (
+	nsecs []*dns.NSEC, nsec3s []*dns.NSEC3, trust *zoneTrust,
+) {
+	rrsets, sigs, order := splitRRsets(section)
+	bogus := &zoneTrust{state: DNSSECBogus}
+
+	var signer string
+	for _, key := range order {
+		if key.rrType != dns.TypeNSEC && key.rrType != dns.TypeNSEC3 {
+			continue
+		}
+
+		// All denial records must be signed by the same zone.
+		keySigs := sigs[key]
+		if len(keySigs) == 0 {
+			return nil, nil, bogus
+		}
+		if signer == "" {
+			signer = dns.CanonicalName(keySigs[0].SignerName)
+			if !dns.IsSubDomain(signer, zone) {
+				return nil, nil, bogus
+			}
+			trust = v.getZoneTrust(ctx, signer)
+			switch {
+			case trust.state != DNSSECSecure:
+				return nil, nil, trust
+			case trust.zone != signer:
+				return nil, nil, bogus
+			}
+		}
+		if _, err := verifyRRset(rrsets[key], keySigs, trust.keys); err != nil {
+			return nil, nil, bogus
+		}
+
+		for _, rr := range rrsets[key] {
+			switch record := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, record)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, record)
+			}
+		}
+	}
+
+	return nsecs, nsec3s, trust
+}
+
+// getZoneTrust returns the trust of the zone the given name belongs to.
+func (v *dnssecValidator) getZoneTrust(ctx context.Context, name string) *zoneTrust {
+	name = dns.CanonicalName(name)
+
+	zoneTrustCacheLock.Lock()
+	trust, ok := zoneTrustCache[name]
+	zoneTrustCacheLock.Unlock()
+	if ok && time.Now().Before(trust.expires) {
+		return trust
+	}
+
+	if name == "." {
+		trust = v.getRootTrust(ctx)
+	} else {
+		trust = v.getDelegationTrust(ctx, name)
+	}
+
+	// Cache results, unless validation could not be completed.
+	if trust.state != DNSSECIndeterminate {
+		zoneTrustCacheLock.Lock()
+		zoneTrustCache[name] = trust
+		zoneTrustCacheLock.Unlock()
+	}
+
+	return trust
+}
+
+func (v *dnssecValidator) getRootTrust(ctx context.Context) *zoneTrust {
+	anchors := make([]*dns.DS, 0, len(rootTrustAnchors))
+	for _, anchor := range rootTrustAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			log.Warningf("resolver: failed to parse DNSSEC trust anchor %q: %s", anchor, err)
+			continue
+		}
+		if ds, ok := rr.(*dns.DS); ok {
+			anchors = append(anchors, ds)
+		}
+	}
+
+	keys, ttl, state := v.getVerifiedKeys(ctx, ".", anchors)
+	if state != DNSSECSecure {
+		log.Tracer(ctx).Warningf("resolver: failed to validate DNSSEC root keys: %s", state)
+	}
+	return newZoneTrust(".", state, keys, ttl)
+}
+
+func (v *dnssecValidator) getDelegationTrust(ctx context.Context, name string) *zoneTrust {
+	rrCache, err := v.query(ctx, &Query{
+		FQDN:  name,
+		QType: dns.Type(dns.TypeDS),
+	})
+	if err != nil || rrCache == nil {
+		return &zoneTrust{state: DNSSECIndeterminate}
+	}
+
+	// Check if there are DS records for the name.
+	rrsets, sigs, _ := splitRRsets(rrCache.Answer)
+	dsKey := rrsetKey{name: name, rrType: dns.TypeDS}
+	if dsRRset, ok := rrsets[dsKey]; ok {
+		return v.getSignedZoneTrust(ctx, name, dsRRset, sigs[dsKey], rrCache.Ns)
+	}
+
+	// There are no DS records, check the denial of existence, which must be
+	// signed by a parent zone.
+	nsecs, nsec3s, parentTrust := v.getDenialRecords(ctx, parentDomain(name), rrCache.Ns)
+	switch {
+	case parentTrust == nil:
+		return v.getUnsignedTrust(ctx, name, rrCache.Ns)
+	case parentTrust.state != DNSSECSecure:
+		return inheritTrust(parentTrust)
+	}
+
+	switch proveDelegation(name, nsecs, nsec3s) {
+	case delegationNone:
+		// The name is part of the parent zone.
+		return inheritTrust(parentTrust)
+	case delegationInsecure:
+		return newZoneTrust(name, DNSSECInsecure, nil, lowestTTL(rrCache.Ns))
+	default:
+		return newZoneTrust(name, DNSSECBogus, nil, 0)
+	}
+}
+
+// getSignedZoneTrust returns the trust of the zone with the given DS records.
+func (v *dnssecValidator) getSignedZoneTrust(ctx context.Context, zone string, dsRRset []dns.RR, dsSigs []*dns.RRSIG, ns []dns.RR) *zoneTrust {
+	if len(dsSigs) == 0 {
+		return v.getUnsignedTrust(ctx, zone, ns)
+	}
+
+	// Verify the DS records with the keys of the parent zone.
+	signer := dns.CanonicalName(dsSigs[0].SignerName)
+	if signer == zone || !dns.IsSubDomain(signer, zone) {
+		return newZoneTrust(zone, DNSSECBogus, nil, 0)
+	}
+	parentTrust := v.getZoneTrust(ctx, signer)
+	switch {
+	case parentTrust.state != DNSSECSecure:
+		return inheritTrust(parentTrust)
+	case parentTrust.zone != signer:
+		return newZoneTrust(zone, DNSSECBogus, nil, 0)
+	}
+	if _, err := verifyRRset(dsRRset, dsSigs, parentTrust.keys); err != nil {
+		return newZoneTrust(zone, DNSSECBogus, nil, 0)
+	}
+
+	// Get the keys of the zone that match the DS records.
+	dsRecords := make([]*dns.DS, 0, len(dsRRset))
+	for _, rr := range dsRRset {
+		if ds, ok := rr.(*dns.DS); ok {
+			dsRecords = append(dsRecords, ds)
+		}
+	}
+	keys, ttl, state := v.getVerifiedKeys(ctx, zone, dsRecords)
+	return newZoneTrust(zone, state, keys, ttl)
+}
+
+// getUnsignedTrust returns the trust of a name for which an unsigned response
+// was received. This is only fine if the zone is insecure.
+func (v *dnssecValidator) getUnsignedTrust(ctx context.Context, name string, ns []dns.RR) *zoneTrust {
+	// Use the zone of the response, if it is a parent zone.
+	parent, soaFromParent := getSOAOwner(ns), true
+	if parent == "" || parent == name || !dns.IsSubDomain(parent, name) {
+		parent, soaFromParent = parentDomain(name), false
+	}
+
+	parentTrust := v.getZoneTrust(ctx, parent)
+	switch {
+	case parentTrust.state != DNSSECSecure:
+		return inheritTrust(parentTrust)
+	case soaFromParent:
+		// A secure zone returned unsigned data.
+		return newZoneTrust(parent, DNSSECBogus, nil, 0)
+	default:
+		// The resolver answered from a zone we do not know about, eg. a local
+		// zone.
+		return &zoneTrust{zone: name, state: DNSSECIndeterminate}
+	}
+}
+
+// getVerifiedKeys returns the zone keys of the given zone, if the DNSKEY
+// records are signed with a key that matches one of the given DS records.
+func (v *dnssecValidator) getVerifiedKeys(ctx context.Context, zone string, dsRecords []*dns.DS) (keys []*dns.DNSKEY, ttl uint32, state string) {
+	// Zones are treated as insecure if none of the DS records can be used.
+	var supported bool
+	for _, ds := range dsRecords {
+		if dnssecAlgorithmSupported(ds.Algorithm) && dnssecDigestSupported(ds.DigestType) {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, 0, DNSSECInsecure
+	}
+
+	rrCache, err := v.query(ctx, &Query{
+		FQDN:  zone,
+		QType: dns.Type(dns.TypeDNSKEY),
+	})
+	if err != nil || rrCache == nil {
+		return nil, 0, DNSSECIndeterminate
+	}
+	rrsets, sigs, _ := splitRRsets(rrCache.Answer)
+	keyRRsetKey := rrsetKey{name: zone, rrType: dns.TypeDNSKEY}
+	keyRRset := rrsets[keyRRsetKey]
+	if len(keyRRset) == 0 {
+		return nil, 0, DNSSECBogus
+	}
+
+	// Find the keys that match the DS records.
+	var (
+		allKeys     = make([]*dns.DNSKEY, 0, len(keyRRset))
+		signingKeys []*dns.DNSKEY
+	)
+	for _, rr := range keyRRset {
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+		allKeys = append(allKeys, key)
+
+		for _, ds := range dsRecords {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			keyDS := key.ToDS(ds.DigestType)
+			if keyDS != nil && strings.EqualFold(keyDS.Digest, ds.Digest) {
+				signingKeys = append(signingKeys, key)
+				break
+			}
+		}
+	}
+
+	// Verify the DNSKEY records with the matching keys.
+	if _, err := verifyRRset(keyRRset, sigs[keyRRsetKey], signingKeys); err != nil {
+		return nil, 0, DNSSECBogus
+	}
+
+	// Return all zone keys.
+	for _, key := range allKeys {
+		if key.Flags&dns.ZONE != 0 && key.Flags&dns.REVOKE == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, keyRRset[0].Header().Ttl, DNSSECSecure
+}
+
+// rrsetKey identifies an RRset.
+type rrsetKey struct {
+	name   string
+	rrType uint16
+}
+
+// splitRRsets splits the given section into RRsets and their signatures.
+func splitRRsets(section []dns.RR) (rrsets map[rrsetKey][]dns.RR, sigs map[rrsetKey][]*dns.RRSIG, order []rrsetKey) {
+	rrsets = make(map[rrsetKey][]dns.RR)
+	sigs = make(map[rrsetKey][]*dns.RRSIG)
+
+	for _, rr := range section {
+		name := dns.CanonicalName(rr.Header().Name)
+
+		switch record := rr.(type) {
+		case *dns.RRSIG:
+			key := rrsetKey{name: name, rrType: record.TypeCovered}
+			sigs[key] = append(sigs[key], record)
+		case *dns.OPT:
+			// Not part of any RRset.
+		default:
+			key := rrsetKey{name: name, rrType: rr.Header().Rrtype}
+			if _, ok := rrsets[key]; !ok {
+				order = append(order, key)
+			}
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+
+	return rrsets, sigs, order
+}
+
+// verifyRRset verifies the RRset with the given signatures and keys and
+// returns the first valid signature.
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) (*dns.RRSIG, error) {
+	for _, sig := range sigs {
+		if !dnssecAlgorithmSupported(sig.Algorithm) || !sig.ValidityPeriod(time.Time{}) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag &&
+				key.Algorithm == sig.Algorithm &&
+				sig.Verify(key, rrset) == nil {
+				return sig, nil
+			}
+		}
+	}
+	return nil, errNoValidSignature
+}
+
+func dnssecAlgorithmSupported(algorithm uint8) bool {
+	switch algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1,
+		dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384,
+		dns.ED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+func dnssecDigestSupported(digestType uint8) bool {
+	switch digestType {
+	case dns.SHA1, dns.SHA256, dns.SHA384:
+		return true
+	default:
+		return false
+	}
+}
+
+// worseDNSSECState returns the worse of the two given states.
+func worseDNSSECState(a, b string) string {
+	rank := func(state string) int {
+		switch state {
+		case DNSSECSecure:
+			return 1
+		case DNSSECInsecure:
+			return 2
+		case DNSSECIndeterminate:
+			return 3
+		case DNSSECBogus:
+			return 4
+		default:
+			return 0
+		}
+	}
+
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// followCNAMEs follows the CNAME chain of the given name in the answer and
+// returns the final target.
+func followCNAMEs(name string, qType uint16, rrsets map[rrsetKey][]dns.RR) string {
+	if qType == dns.TypeCNAME {
+		return name
+	}
+
+	for i := 0; i < 16; i++ {
+		cnames := rrsets[rrsetKey{name: name, rrType: dns.TypeCNAME}]
+		if len(cnames) == 0 {
+			break
+		}
+		cname, ok := cnames[0].(*dns.CNAME)
+		if !ok {
+			break
+		}
+		name = dns.CanonicalName(cname.Target)
+	}
+	return name
+}
+
+// synthesizedFromDNAME returns whether the answer has a DNAME record for a
+// parent of the given name.
+func synthesizedFromDNAME(name string, rrsets map[rrsetKey][]dns.RR) bool {
+	for key := range rrsets {
+		if key.rrType == dns.TypeDNAME && key.name != name && dns.IsSubDomain(key.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func getSOAOwner(section []dns.RR) string {
+	for _, rr := range section {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return dns.CanonicalName(rr.Header().Name)
+		}
+	}
+	return ""
+}
+
+func lowestTTL(section []dns.RR) uint32 {
+	var ttl uint32
+	for i, rr := range section {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
+
+// parentDomain returns the parent domain of the given FQDN.
+func parentDomain(fqdn string) string {
+	labels := dns.SplitDomainName(fqdn)
+	if len(labels) <= 1 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+// ancestorWithLabels returns the ancestor of the given FQDN with the given
+// number of labels.
+func ancestorWithLabels(fqdn string, labelCount int) string {
+	labels := dns.SplitDomainName(fqdn)
+	if labelCount <= 0 {
+		return "."
+	}
+	if labelCount >= len(labels) {
+		return fqdn
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-labelCount:], "."))
+}
+
+// wildcardOf returns the wildcard name at the given FQDN.
+func wildcardOf(fqdn string) string {
+	if fqdn == "." {
+		return "*."
+	}
+	return "*." + fqdn
+}
+
+// Delegation proof results.
+const (
+	delegationUnproven = iota
+	delegationNone
+	delegationInsecure
+)
+
+// proveDelegation checks the denial of existence of DS records of the given
+// name and returns whether the name is an insecure delegation or no
+// delegation at all.
+func proveDelegation(name string, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) int {
+	for _, nsec := range nsecs {
+		owner := dns.CanonicalName(nsec.Hdr.Name)
+		switch {
+		case owner == name:
+			return delegationFromTypes(nsec.TypeBitMap)
+		case dns.IsSubDomain(owner, name) &&
+			hasType(nsec.TypeBitMap, dns.TypeNS) &&
+			!hasType(nsec.TypeBitMap, dns.TypeSOA):
+			// The name is below a delegation.
+			if hasType(nsec.TypeBitMap, dns.TypeDS) {
+				return delegationUnproven
+			}
+			return delegationInsecure
+		}
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			// The name does not exist in the zone.
+			return delegationNone
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		if nsec3IterationsTooHigh(nsec3s) {
+			return delegationInsecure
+		}
+		if nsec3 := nsec3Matching(nsec3s, name); nsec3 != nil {
+			return delegationFromTypes(nsec3.TypeBitMap)
+		}
+		closestEncloser, nextCloser := nsec3ClosestEncloser(name, nsec3s)
+		if closestEncloser == nil {
+			return delegationUnproven
+		}
+		if hasType(closestEncloser.TypeBitMap, dns.TypeNS) &&
+			!hasType(closestEncloser.TypeBitMap, dns.TypeSOA) &&
+			!hasType(closestEncloser.TypeBitMap, dns.TypeDS) {
+			// The name is below an insecure delegation.
+			return delegationInsecure
+		}
+		if nextCloser.Flags&nsec3OptOut != 0 {
+			// Opt-out ranges may hold insecure delegations.
+			return delegationInsecure
+		}
+		return delegationNone
+	}
+
+	return delegationUnproven
+}
+
+func delegationFromTypes(types []uint16) int {
+	switch {
+	case hasType(types, dns.TypeDS):
+		return delegationUnproven
+	case hasType(types, dns.TypeNS) && !hasType(types, dns.TypeSOA):
+		return delegationInsecure
+	default:
+		return delegationNone
+	}
+}
+
+// proveNXDomain checks the proof that the given name does not exist.
+func proveNXDomain(name string, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) string {
+	if len(nsecs) > 0 {
+		for _, nsec := range nsecs {
+			if !nsecCovers(nsec, name) {
+				continue
+			}
+			// Check that there is no wildcard at the closest encloser.
+			if nsecsCover(nsecs, wildcardOf(nsecClosestEncloser(name, nsec))) {
+				return DNSSECSecure
+			}
+		}
+		return DNSSECBogus
+	}
+
+	if len(nsec3s) > 0 {
+		if nsec3IterationsTooHigh(nsec3s) {
+			return DNSSECInsecure
+		}
+		closestEncloser, nextCloser := nsec3ClosestEncloser(name, nsec3s)
+		if closestEncloser == nil || !nsec3sCover(nsec3s, wildcardOf(nsec3Name(closestEncloser, name))) {
+			return DNSSECBogus
+		}
+		if nextCloser.Flags&nsec3OptOut != 0 {
+			return DNSSECInsecure
+		}
+		return DNSSECSecure
+	}
+
+	return DNSSECBogus
+}
+
+// proveNoData checks the proof that the given name has no records of the
+// given type.
+func proveNoData(name string, qType uint16, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) string {
+	if len(nsecs) > 0 {
+		for _, nsec := range nsecs {
+			if dns.CanonicalName(nsec.Hdr.Name) == name {
+				if hasType(nsec.TypeBitMap, qType) || hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+					return DNSSECBogus
+				}
+				return DNSSECSecure
+			}
+		}
+
+		// Check for a matching wildcard without the type.
+		for _, nsec := range nsecs {
+			if !nsecCovers(nsec, name) {
+				continue
+			}
+			wildcard := wildcardOf(nsecClosestEncloser(name, nsec))
+			for _, wildcardNSEC := range nsecs {
+				if dns.CanonicalName(wildcardNSEC.Hdr.Name) == wildcard &&
+					!hasType(wildcardNSEC.TypeBitMap, qType) &&
+					!hasType(wildcardNSEC.TypeBitMap, dns.TypeCNAME) {
+					return DNSSECSecure
+				}
+			}
+		}
+		return DNSSECBogus
+	}
+
+	if len(nsec3s) > 0 {
+		if nsec3IterationsTooHigh(nsec3s) {
+			return DNSSECInsecure
+		}
+		if nsec3 := nsec3Matching(nsec3s, name); nsec3 != nil {
+			if hasType(nsec3.TypeBitMap, qType) || hasType(nsec3.TypeBitMap, dns.TypeCNAME) {
+				return DNSSECBogus
+			}
+			return DNSSECSecure
+		}
+
+		closestEncloser, nextCloser := nsec3ClosestEncloser(name, nsec3s)
+		if closestEncloser == nil {
+			return DNSSECBogus
+		}
+		// DS queries for names in opt-out ranges.
+		if qType == dns.TypeDS && nextCloser.Flags&nsec3OptOut != 0 {
+			return DNSSECInsecure
+		}
+		// Check for a matching wildcard without the type.
+		wildcard := nsec3Matching(nsec3s, wildcardOf(nsec3Name(closestEncloser, name)))
+		if wildcard != nil &&
+			!hasType(wildcard.TypeBitMap, qType) &&
+			!hasType(wildcard.TypeBitMap, dns.TypeCNAME) {
+			return DNSSECSecure
+		}
+		return DNSSECBogus
+	}
+
+	return DNSSECBogus
+}
+
+// provesWildcardExpansion checks the proof that the given name, which was
+// expanded from a wildcard with the given number of labels, does not exist.
+func provesWildcardExpansion(name string, wildcardLabels int, nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) bool {
+	if nsecsCover(nsecs, name) {
+		return true
+	}
+	return nsec3sCover(nsec3s, ancestorWithLabels(name, wildcardLabels+1))
+}
+
+// nsec3OptOut is the opt-out flag of NSEC3 records.
+const nsec3OptOut = 1
+
+// nsecCovers returns whether the name lies between the owner and the next
+// name of the NSEC record.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner := dns.CanonicalName(nsec.Hdr.Name)
+	next := dns.CanonicalName(nsec.NextDomain)
+
+	if canonicalCompare(owner, name) >= 0 {
+		return false
+	}
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(name, next) < 0
+	}
+	// The last NSEC record of the zone points back to the apex.
+	return dns.IsSubDomain(next, name)
+}
+
+func nsecsCover(nsecs []*dns.NSEC, name string) bool {
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecClosestEncloser returns the closest existing ancestor of the given name,
+// as proven by the covering NSEC record.
+func nsecClosestEncloser(name string, nsec *dns.NSEC) string {
+	labels := dns.CompareDomainName(name, nsec.Hdr.Name)
+	if nextLabels := dns.CompareDomainName(name, nsec.NextDomain); nextLabels > labels {
+		labels = nextLabels
+	}
+	return ancestorWithLabels(name, labels)
+}
+
+func nsec3Matching(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+func nsec3sCover(nsec3s []*dns.NSEC3, name string) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsec3ClosestEncloser returns the NSEC3 record matching the closest existing
+// ancestor of the given name and the NSEC3 record covering the next closer
+// name.
+func nsec3ClosestEncloser(name string, nsec3s []*dns.NSEC3) (closestEncloser, nextCloser *dns.NSEC3) {
+	labels := dns.CountLabel(name)
+	for i := labels - 1; i >= 0; i-- {
+		closestEncloser = nsec3Matching(nsec3s, ancestorWithLabels(name, i))
+		if closestEncloser == nil {
+			continue
+		}
+
+		nextCloserName := ancestorWithLabels(name, i+1)
+		for _, nsec3 := range nsec3s {
+			if nsec3.Cover(nextCloserName) {
+				return closestEncloser, nsec3
+			}
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// nsec3Name returns the name of the ancestor of the given name that matches
+// the given NSEC3 record.
+func nsec3Name(nsec3 *dns.NSEC3, name string) string {
+	for i := dns.CountLabel(name); i >= 0; i-- {
+		ancestor := ancestorWithLabels(name, i)
+		if nsec3.Match(ancestor) {
+			return ancestor
+		}
+	}
+	return "."
+}
+
+func nsec3IterationsTooHigh(nsec3s []*dns.NSEC3) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Iterations > nsec3MaxIterations {
+			return true
+		}
+	}
+	return false
+}
+
+func hasType(types []uint16, rrType uint16) bool {
+	for _, t := range types {
+		if t == rrType {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalCompare compares two domain names in the canonical DNS name order
+// as defined in RFC4034, Section 6.1.
+func canonicalCompare(a, b string) int {
+	aLabels := dns.SplitDomainName(a)
+	bLabels := dns.SplitDomainName(b)
+
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		aLabel := unescapeLabel(strings.ToLower(aLabels[len(aLabels)-i]))
+		bLabel := unescapeLabel(strings.ToLower(bLabels[len(bLabels)-i]))
+		if c := strings.Compare(aLabel, bLabel); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(aLabels) < len(bLabels):
+		return -1
+	case len(aLabels) > len(bLabels):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// unescapeLabel converts the escape sequences of a label in presentation
+// format to the raw bytes.
+func unescapeLabel(label string) string {
+	if !strings.Contains(label, `\`) {
+		return label
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			b.WriteByte(label[i])
+			continue
+		}
+		// Decimal escape: \DDD
+		if i+3 < len(label) {
+			if value, err := strconv.ParseUint(label[i+1:i+4], 10, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		// Character escape: \X
+		b.WriteByte(label[i+1])
+		i++
+	}
+	return b.String()
+}
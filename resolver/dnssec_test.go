@@ -0,0 +1,310 @@
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZoneKey is a key of a signed test zone.
+type testZoneKey struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func newTestZoneKey(t *testing.T, zone string) *testZoneKey {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		t.Fatal("generated key is not a signer")
+	}
+
+	return &testZoneKey{
+		key:    key,
+		signer: signer,
+	}
+}
+
+// sign returns the given RRset with its signature.
+func (zk *testZoneKey) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	t.Helper()
+
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   rrset[0].Header().Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    rrset[0].Header().Ttl,
+		},
+		Algorithm:  zk.key.Algorithm,
+		KeyTag:     zk.key.KeyTag(),
+		SignerName: zk.key.Hdr.Name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(zk.signer, rrset); err != nil {
+		t.Fatal(err)
+	}
+
+	return append(rrset, sig)
+}
+
+func testRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func testNSEC(name, next string, types ...uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// dnssecTestResolver is a stand-in resolver that serves a signed test zone.
+type dnssecTestResolver struct {
+	answers map[rrsetKey]*RRCache
+	queries int
+}
+
+func (tr *dnssecTestResolver) add(name string, qType uint16, rCode int, answer, ns []dns.RR) {
+	tr.answers[rrsetKey{name: name, rrType: qType}] = &RRCache{
+		Domain:   name,
+		Question: dns.Type(qType),
+		RCode:    rCode,
+		Answer:   answer,
+		Ns:       ns,
+		Resolver: &ResolverInfo{},
+	}
+}
+
+func (tr *dnssecTestResolver) query(_ context.Context, q *Query) (*RRCache, error) {
+	tr.queries++
+	rrCache, ok := tr.answers[rrsetKey{name: q.FQDN, rrType: uint16(q.QType)}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rrCache, nil
+}
+
+// newDNSSECTestResolver creates a stand-in resolver for the test zones "." and
+// "example.", which has the insecure delegation "insecure.example.".
+func newDNSSECTestResolver(t *testing.T) *dnssecTestResolver {
+	t.Helper()
+
+	rootKey := newTestZoneKey(t, ".")
+	zoneKey := newTestZoneKey(t, "example.")
+	rootTrustAnchors = []string{rootKey.key.ToDS(dns.SHA256).String()}
+
+	tr := &dnssecTestResolver{
+		answers: make(map[rrsetKey]*RRCache),
+	}
+	soa := testRR(t, "example. 3600 IN SOA ns.example. hostmaster.example. 1 3600 600 86400 3600")
+	signedSOA := zoneKey.sign(t, soa)
+
+	// Chain of trust.
+	tr.add(".", dns.TypeDNSKEY, dns.RcodeSuccess, rootKey.sign(t, rootKey.key), nil)
+	tr.add("example.", dns.TypeDS, dns.RcodeSuccess, rootKey.sign(t, zoneKey.key.ToDS(dns.SHA256)), nil)
+	tr.add("example.", dns.TypeDNSKEY, dns.RcodeSuccess, zoneKey.sign(t, zoneKey.key), nil)
+
+	// Signed zone data with NSEC chain:
+	// example. -> insecure.example. -> www.example. -> example.
+	nsecApex := zoneKey.sign(t, testNSEC("example.", "insecure.example.",
+		dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY))
+	nsecInsecure := zoneKey.sign(t, testNSEC("insecure.example.", "www.example.",
+		dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC))
+	nsecWWW := zoneKey.sign(t, testNSEC("www.example.", "example.",
+		dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC))
+
+	tr.add("www.example.", dns.TypeA, dns.RcodeSuccess,
+		zoneKey.sign(t, testRR(t, "www.example. 3600 IN A 192.0.2.1")), nil)
+	tr.add("www.example.", dns.TypeAAAA, dns.RcodeSuccess,
+		nil, append(append([]dns.RR{}, signedSOA...), nsecWWW...))
+	tr.add("missing.example.", dns.TypeA, dns.RcodeNameError,
+		nil, append(append(append([]dns.RR{}, signedSOA...), nsecInsecure...), nsecApex...))
+	tr.add("www.example.", dns.TypeDS, dns.RcodeSuccess,
+		nil, append(append([]dns.RR{}, signedSOA...), nsecWWW...))
+	tr.add("unsigned.example.", dns.TypeDS, dns.RcodeNameError,
+		nil, append(append(append([]dns.RR{}, signedSOA...), nsecInsecure...), nsecApex...))
+	tr.add("unsigned.example.", dns.TypeA, dns.RcodeSuccess,
+		[]dns.RR{testRR(t, "unsigned.example. 3600 IN A 192.0.2.2")}, nil)
+
+	// Insecure delegation.
+	tr.add("insecure.example.", dns.TypeDS, dns.RcodeSuccess,
+		nil, append(append([]dns.RR{}, signedSOA...), nsecInsecure...))
+	tr.add("host.insecure.example.", dns.TypeDS, dns.RcodeSuccess,
+		nil, []dns.RR{testRR(t, "insecure.example. 3600 IN SOA ns.insecure.example. hostmaster.insecure.example. 1 3600 600 86400 3600")})
+	tr.add("host.insecure.example.", dns.TypeA, dns.RcodeSuccess,
+		[]dns.RR{testRR(t, "host.insecure.example. 3600 IN A 192.0.2.3")}, nil)
+
+	return tr
+}
+
+func resetZoneTrustCache() {
+	zoneTrustCacheLock.Lock()
+	defer zoneTrustCacheLock.Unlock()
+
+	zoneTrustCache = make(map[string]*zoneTrust)
+}
+
+func TestDNSSECValidation(t *testing.T) { //nolint:paralleltest // Modifies the trust anchors.
+	originalTrustAnchors := rootTrustAnchors
+	defer func() {
+		rootTrustAnchors = originalTrustAnchors
+		resetZoneTrustCache()
+	}()
+	resetZoneTrustCache()
+
+	tr := newDNSSECTestResolver(t)
+	v := &dnssecValidator{query: tr.query}
+
+	testCases := []struct {
+		name     string
+		qType    uint16
+		expected string
+	}{
+		{"www.example.", dns.TypeA, DNSSECSecure},
+		{"www.example.", dns.TypeAAAA, DNSSECSecure},
+		{"missing.example.", dns.TypeA, DNSSECSecure},
+		{"host.insecure.example.", dns.TypeA, DNSSECInsecure},
+		{"unsigned.example.", dns.TypeA, DNSSECBogus},
+	}
+	for _, tc := range testCases {
+		q := &Query{
+			FQDN:  tc.name,
+			QType: dns.Type(tc.qType),
+		}
+		rrCache, err := tr.query(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := v.validate(context.Background(), q, rrCache)
+		if state != tc.expected {
+			t.Errorf("%s: expected DNSSEC state %s, got %s", q.ID(), tc.expected, state)
+		}
+	}
+
+	// Forged answer with the signature of the original answer.
+	forged := tr.answers[rrsetKey{name: "www.example.", rrType: dns.TypeA}].ShallowCopy()
+	forgedA := testRR(t, "www.example. 3600 IN A 198.51.100.1")
+	forged.Answer = []dns.RR{forgedA, forged.Answer[1]}
+	q := &Query{FQDN: "www.example.", QType: dns.Type(dns.TypeA)}
+	if state := v.validate(context.Background(), q, forged); state != DNSSECBogus {
+		t.Errorf("forged answer: expected DNSSEC state %s, got %s", DNSSECBogus, state)
+	}
+
+	// Forged denial of existence without the proof that there is no wildcard.
+	forgedDenial := tr.answers[rrsetKey{name: "missing.example.", rrType: dns.TypeA}].ShallowCopy()
+	forgedDenial.Ns = forgedDenial.Ns[:4]
+	q = &Query{FQDN: "missing.example.", QType: dns.Type(dns.TypeA)}
+	if state := v.validate(context.Background(), q, forgedDenial); state != DNSSECBogus {
+		t.Errorf("forged denial: expected DNSSEC state %s, got %s", DNSSECBogus, state)
+	}
+
+	// Zone trust is cached.
+	queries := tr.queries
+	q = &Query{FQDN: "www.example.", QType: dns.Type(dns.TypeA)}
+	rrCache, _ := tr.query(context.Background(), q)
+	if state := v.validate(context.Background(), q, rrCache); state != DNSSECSecure {
+		t.Errorf("cached: expected DNSSEC state %s, got %s", DNSSECSecure, state)
+	}
+	if tr.queries != queries+1 {
+		t.Errorf("expected zone trust to be cached, but %d queries were made", tr.queries-queries-1)
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	t.Parallel()
+
+	// Canonical order example from RFC4034, Section 6.1.
+	ordered := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		`\001.z.example.`,
+		"*.z.example.",
+		`\200.z.example.`,
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if canonicalCompare(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("expected %s to sort before %s", ordered[i], ordered[i+1])
+		}
+		if canonicalCompare(ordered[i+1], ordered[i]) <= 0 {
+			t.Errorf("expected %s to sort after %s", ordered[i+1], ordered[i])
+		}
+	}
+}
+
+func TestReplyWithDNSSEC(t *testing.T) {
+	t.Parallel()
+
+	rrCache := &RRCache{
+		Domain:   "www.example.",
+		Question: dns.Type(dns.TypeA),
+		Answer: []dns.RR{
+			&dns.A{
+				Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 17},
+				A:   net.IPv4(192, 0, 2, 1),
+			},
+			&dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 17},
+				TypeCovered: dns.TypeA,
+			},
+		},
+		Resolver: &ResolverInfo{
+			DNSSEC: DNSSECSecure,
+		},
+	}
+
+	// Clients without DNSSEC support.
+	request := new(dns.Msg).SetQuestion("www.example.", dns.TypeA)
+	reply := rrCache.ReplyWithDNS(context.Background(), request)
+	if reply.AuthenticatedData || len(reply.Answer) != 1 {
+		t.Errorf("unexpected reply for client without DNSSEC support: %s", reply)
+	}
+
+	// Clients with DNSSEC support.
+	request.SetEdns0(4096, true)
+	reply = rrCache.ReplyWithDNS(context.Background(), request)
+	if !reply.AuthenticatedData || len(reply.Answer) != 2 {
+		t.Errorf("unexpected reply for client with DNSSEC support: %s", reply)
+	}
+	if len(rrCache.Answer) != 2 {
+		t.Error("cached answer was modified")
+	}
+}
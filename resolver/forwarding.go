@@ -0,0 +1,135 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// forwardingNoFallbackFlag is the optional last field of a forwarding rule
+// that disables falling back to other resolvers.
+const forwardingNoFallbackFlag = "nofallback"
+
+// ForwardingRule defines a domain that is resolved by dedicated resolvers.
+type ForwardingRule struct {
+	// Domain is the dot-prefixed FQDN of the domain, eg. ".corp.example.".
+	// The rule applies to the domain itself and all its subdomains.
+	Domain string
+	// Resolvers are the resolvers queries are forwarded to.
+	Resolvers []*Resolver
+	// NoFallback defines whether other resolvers must never be asked for the
+	// domain, even if the forwarding resolvers fail or are not compliant.
+	NoFallback bool
+}
+
+var (
+	forwardingRules []*ForwardingRule // sorted by domain length, longest first
+)
+
+// parseForwardingRule parses a forwarding rule in the format
+// "<domain> <resolver URL> [nofallback]". The domain may be prefixed with
+// "*.", which has no further effect.
+func parseForwardingRule(rule string) (domain, resolverURL string, noFallback bool, err error) {
+	fields := strings.Fields(rule)
+	switch {
+	case len(fields) == 3 && fields[2] == forwardingNoFallbackFlag:
+		noFallback = true
+	case len(fields) == 3:
+		return "", "", false, fmt.Errorf("invalid flag %q", fields[2])
+	case len(fields) != 2:
+		return "", "", false, fmt.Errorf("expected domain and resolver URL, got %d fields", len(fields))
+	}
+
+	// Check domain.
+	domain = strings.TrimPrefix(strings.ToLower(fields[0]), "*.")
+	domain = dns.Fqdn(strings.TrimPrefix(domain, "."))
+	if domain == "." || !netutils.IsValidFqdn(domain) {
+		return "", "", false, fmt.Errorf("invalid domain %q", fields[0])
+	}
+
+	return "." + domain, fields[1], noFallback, nil
+}
+
+func getForwardingRules(list []string) (rules []*ForwardingRule) {
+	for _, entry := range list {
+		domain, resolverURL, noFallback, err := parseForwardingRule(entry)
+		if err != nil {
+			log.Errorf("resolver: cannot use forwarding rule %q: %s", entry, err)
+			continue
+		}
+
+		// Find existing rule for the domain.
+		var rule *ForwardingRule
+		for _, existing := range rules {
+			if existing.Domain == domain {
+				rule = existing
+				break
+			}
+		}
+		if rule == nil {
+			rule = &ForwardingRule{
+				Domain: domain,
+			}
+			rules = append(rules, rule)
+		}
+		// Never fall back if any entry of the domain says so.
+		if noFallback {
+			rule.NoFallback = true
+		}
+
+		// Create resolver.
+		resolver, skip, err := createResolver(resolverURL, ServerSourceForwarding)
+		switch {
+		case err != nil:
+			log.Errorf("resolver: cannot use forwarding resolver %s for %s: %s", resolverURL, domain, err)
+			continue
+		case skip:
+			log.Warningf("resolver: cannot use forwarding resolver %s for %s: localhost resolvers are not supported", resolverURL, domain)
+			continue
+		}
+		rule.Resolvers = append(rule.Resolvers, resolver)
+	}
+
+	// Sort rules by length, so that the most specific rule matches first.
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Domain) > len(rules[j].Domain)
+	})
+
+	return rules
+}
+
+// getForwardingRule returns the forwarding rule for the given dot-prefixed
+// FQDN, or nil if there is none. resolversLock must be held.
+func getForwardingRule(dotPrefixedFQDN string) *ForwardingRule {
+	for _, rule := range forwardingRules {
+		if strings.HasSuffix(dotPrefixedFQDN, rule.Domain) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// checkForwardingRuleWithLocking returns an error if the given resolver must
+// not be used for the given query because of a forwarding rule that does not
+// allow falling back to other resolvers.
+func checkForwardingRuleWithLocking(q *Query, resolver *Resolver) error {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	rule := getForwardingRule(q.dotPrefixedFQDN)
+	if rule == nil || !rule.NoFallback {
+		return nil
+	}
+
+	for _, ruleResolver := range rule.Resolvers {
+		if ruleResolver.Info.ID() == resolver.Info.ID() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must only be resolved by the forwarding resolvers of %s", q.FQDN, rule.Domain)
+}
@@ -0,0 +1,83 @@
+package resolver
+
+import "testing"
+
+func TestParseForwardingRule(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		rule        string
+		domain      string
+		resolverURL string
+		noFallback  bool
+		valid       bool
+	}{
+		{"corp.example dns://10.0.0.53", ".corp.example.", "dns://10.0.0.53", false, true},
+		{"*.Corp.Example. dns://10.0.0.53 nofallback", ".corp.example.", "dns://10.0.0.53", true, true},
+		{"lab dot://10.0.0.1?verify=dns.lab", ".lab.", "dot://10.0.0.1?verify=dns.lab", false, true},
+		{"corp.example dns://10.0.0.53 fallback", "", "", false, false},
+		{"corp.example", "", "", false, false},
+		{". dns://10.0.0.53", "", "", false, false},
+		{"corp..example dns://10.0.0.53", "", "", false, false},
+	}
+
+	for _, tc := range testCases {
+		domain, resolverURL, noFallback, err := parseForwardingRule(tc.rule)
+		switch {
+		case tc.valid && err != nil:
+			t.Errorf("%q: unexpected error: %s", tc.rule, err)
+		case !tc.valid && err == nil:
+			t.Errorf("%q: should be invalid", tc.rule)
+		case domain != tc.domain || resolverURL != tc.resolverURL || noFallback != tc.noFallback:
+			t.Errorf(
+				"%q: got %q, %q, %v, expected %q, %q, %v",
+				tc.rule, domain, resolverURL, noFallback, tc.domain, tc.resolverURL, tc.noFallback,
+			)
+		}
+	}
+}
+
+func TestGetForwardingRules(t *testing.T) {
+	t.Parallel()
+
+	rules := getForwardingRules([]string{
+		"example dns://10.0.0.1",
+		"corp.example dns://10.0.0.53",
+		"corp.example tcp://10.0.0.54 nofallback",
+		"invalid.example dns://localhost",
+		"lab dns://127.0.0.1 nofallback",
+	})
+
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %d", len(rules))
+	}
+	for i := 1; i < len(rules); i++ {
+		if len(rules[i-1].Domain) < len(rules[i].Domain) {
+			t.Errorf("rules are not sorted by specificity: %s before %s", rules[i-1].Domain, rules[i].Domain)
+		}
+	}
+
+	corp := getTestForwardingRule(rules, ".corp.example.")
+	if corp == nil || !corp.NoFallback || len(corp.Resolvers) != 2 {
+		t.Errorf("unexpected rule for corp.example: %+v", corp)
+	}
+	example := getTestForwardingRule(rules, ".example.")
+	if example == nil || example.NoFallback || len(example.Resolvers) != 1 {
+		t.Errorf("unexpected rule for example: %+v", example)
+	}
+	// Rules without usable resolvers are kept, so that they still prevent
+	// falling back to other resolvers.
+	lab := getTestForwardingRule(rules, ".lab.")
+	if lab == nil || !lab.NoFallback || len(lab.Resolvers) != 0 {
+		t.Errorf("unexpected rule for lab: %+v", lab)
+	}
+}
+
+func getTestForwardingRule(rules []*ForwardingRule, domain string) *ForwardingRule {
+	for _, rule := range rules {
+		if rule.Domain == domain {
+			return rule
+		}
+	}
+	return nil
+}
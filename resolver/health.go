@@ -0,0 +1,252 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+// Resolver Selection Strategies
+const (
+	ResolverSelectionOrdered    = "ordered"
+	ResolverSelectionFastest    = "fastest"
+	ResolverSelectionRoundRobin = "roundrobin"
+	ResolverSelectionRace       = "race"
+)
+
+const (
+	// healthSmoothingFactor is the weight of a new sample in the moving
+	// averages of the resolver health.
+	healthSmoothingFactor = 0.2
+
+	// healthErrorPenalty defines how much the error rate worsens the score of
+	// a resolver. A resolver that always fails is regarded as this many times
+	// slower than its latency suggests.
+	healthErrorPenalty = 10
+)
+
+var (
+	roundRobinCounter uint32
+
+	// healthErrorLatency is the latency sample recorded for a failed query.
+	// This keeps resolvers that never answer from having a low latency.
+	healthErrorLatency = defaultRequestTimeout
+)
+
+// ResolverHealth holds the health statistics of a resolver.
+type ResolverHealth struct {
+	// Queries is the amount of queries sent to the resolver.
+	Queries uint64
+	// Errors is the amount of failed queries, including timeouts.
+	Errors uint64
+	// Timeouts is the amount of queries that timed out.
+	Timeouts uint64
+
+	// AvgLatency is the moving average of the latency of queries. Failed
+	// queries are counted with the latency of a timeout.
+	AvgLatency time.Duration
+	// ErrorRate is the moving average of the share of failed queries, between
+	// 0 and 1.
+	ErrorRate float64
+
+	// LastAnswer is when the resolver last answered a query.
+	LastAnswer time.Time
+	// LastError is when a query to the resolver last failed.
+	LastError time.Time
+}
+
+// Score returns the health score of the resolver. It is the average latency
+// in milliseconds, worsened by the error rate. Lower is better. Resolvers
+// that were never queried have a score of zero, so that they are tried and
+// measured first.
+func (health *ResolverHealth) Score() float64 {
+	if health.Queries == 0 {
+		return 0
+	}
+
+	latency := float64(health.AvgLatency) / float64(time.Millisecond)
+	if latency < 1 {
+		latency = 1
+	}
+	return latency * (1 + healthErrorPenalty*health.ErrorRate)
+}
+
+// resolverHealth tracks the health of a resolver. The zero value is ready to
+// use.
+type resolverHealth struct {
+	sync.Mutex
+	ResolverHealth
+}
+
+func (rh *resolverHealth) reportAnswer(latency time.Duration) {
+	rh.Lock()
+	defer rh.Unlock()
+
+	rh.addLatencySample(latency)
+	rh.ErrorRate -= healthSmoothingFactor * rh.ErrorRate
+
+	rh.Queries++
+	rh.LastAnswer = time.Now()
+}
+
+func (rh *resolverHealth) reportError(timeout bool) {
+	rh.Lock()
+	defer rh.Unlock()
+
+	rh.addLatencySample(healthErrorLatency)
+	rh.ErrorRate += healthSmoothingFactor * (1 - rh.ErrorRate)
+
+	rh.Queries++
+	rh.Errors++
+	if timeout {
+		rh.Timeouts++
+	}
+	rh.LastError = time.Now()
+}
+
+// addLatencySample adds the given latency to the moving average. The health
+// must be locked.
+func (rh *resolverHealth) addLatencySample(latency time.Duration) {
+	// Start the average with the first sample.
+	if rh.Queries == 0 {
+		rh.AvgLatency = latency
+	} else {
+		rh.AvgLatency += time.Duration(healthSmoothingFactor * float64(latency-rh.AvgLatency))
+	}
+}
+
+// Health returns a copy of the health statistics of the resolver.
+func (resolver *Resolver) Health() ResolverHealth {
+	resolver.health.Lock()
+	defer resolver.health.Unlock()
+
+	return resolver.health.ResolverHealth
+}
+
+// query sends the query to the resolver and records the outcome in the health
+// statistics of the resolver.
+func (resolver *Resolver) query(ctx context.Context, q *Query) (*RRCache, error) {
+	started := time.Now()
+	rrCache, err := resolver.Conn.Query(ctx, q)
+
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrBlocked):
+		resolver.health.reportAnswer(time.Since(started))
+	case errors.Is(err, ErrContinue):
+		// The resolver is not responsible for the query.
+	case ctx.Err() != nil, !netenv.Online():
+		// Don't blame the resolver for canceled queries or being offline.
+	default:
+		resolver.health.reportError(errors.Is(err, ErrTimeout))
+	}
+
+	return rrCache, err
+}
+
+// orderResolvers returns the given resolvers in the order defined by the
+// given selection strategy. The given slice is not modified.
+func orderResolvers(strategy string, resolvers []*Resolver) []*Resolver {
+	if len(resolvers) < 2 {
+		return resolvers
+	}
+
+	switch strategy {
+	case ResolverSelectionFastest, ResolverSelectionRace:
+		scores := make(map[*Resolver]float64, len(resolvers))
+		for _, resolver := range resolvers {
+			health := resolver.Health()
+			scores[resolver] = health.Score()
+		}
+
+		ordered := make([]*Resolver, len(resolvers))
+		copy(ordered, resolvers)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return scores[ordered[i]] < scores[ordered[j]]
+		})
+		return ordered
+
+	case ResolverSelectionRoundRobin:
+		start := int(atomic.AddUint32(&roundRobinCounter, 1) % uint32(len(resolvers)))
+
+		ordered := make([]*Resolver, 0, len(resolvers))
+		ordered = append(ordered, resolvers[start:]...)
+		return append(ordered, resolvers[:start]...)
+
+	default: // ResolverSelectionOrdered
+		return resolvers
+	}
+}
+
+// getRaceCandidates returns the first resolvers that did not fail recently,
+// up to the configured race count.
+func getRaceCandidates(resolvers []*Resolver) []*Resolver {
+	raceCount := int(resolverRaceCount())
+	candidates := make([]*Resolver, 0, raceCount)
+	for _, resolver := range resolvers {
+		if len(candidates) >= raceCount {
+			break
+		}
+		if !resolver.Conn.IsFailing() {
+			candidates = append(candidates, resolver)
+		}
+	}
+	return candidates
+}
+
+type raceResult struct {
+	resolver *Resolver
+	rrCache  *RRCache
+	err      error
+}
+
+// raceResolvers sends the query to all given resolvers in parallel and
+// returns the first answer. If the returned error is nil, the returned
+// resolver and RRCache are set.
+func raceResolvers(ctx context.Context, q *Query, resolvers []*Resolver) (*Resolver, *RRCache, error) {
+	raceCtx, cancelRace := context.WithCancel(ctx)
+	defer cancelRace()
+
+	log.Tracer(ctx).Tracef("resolver: racing query for %s on %d resolvers", q.ID(), len(resolvers))
+	results := make(chan *raceResult, len(resolvers))
+	for _, resolver := range resolvers {
+		go func(resolver *Resolver) {
+			rrCache, err := resolver.query(raceCtx, q)
+			results <- &raceResult{
+				resolver: resolver,
+				rrCache:  rrCache,
+				err:      err,
+			}
+		}(resolver)
+	}
+
+	var lastErr error
+	for range resolvers {
+		result := <-results
+		switch {
+		case result.err == nil && result.rrCache != nil:
+			return result.resolver, result.rrCache, nil
+		case errors.Is(result.err, ErrNotFound), errors.Is(result.err, ErrBlocked):
+			// The resolver answered, but there are no records.
+			return result.resolver, nil, result.err
+		case result.err == nil, errors.Is(result.err, ErrContinue):
+			// The resolver has no answer.
+			lastErr = ErrContinue
+		case errors.Is(result.err, ErrTimeout):
+			result.resolver.Conn.ReportFailure()
+			log.Tracer(ctx).Debugf("resolver: query to %s timed out", result.resolver.Info.ID())
+			lastErr = result.err
+		default:
+			result.resolver.Conn.ReportFailure()
+			log.Tracer(ctx).Debugf("resolver: query to %s failed: %s", result.resolver.Info.ID(), result.err)
+			lastErr = result.err
+		}
+	}
+
+	return nil, nil, lastErr
+}
@@ -0,0 +1,190 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testHealthConn is a resolver connection that answers after a delay.
+type testHealthConn struct {
+	delay time.Duration
+	err   error
+}
+
+func (thc *testHealthConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	select {
+	case <-time.After(thc.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if thc.err != nil {
+		return nil, thc.err
+	}
+	return &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+	}, nil
+}
+
+func (thc *testHealthConn) ReportFailure() {}
+
+func (thc *testHealthConn) IsFailing() bool {
+	return false
+}
+
+func (thc *testHealthConn) ResetFailure() {}
+
+func newTestHealthResolver(name string, delay time.Duration, err error) *Resolver {
+	return &Resolver{
+		ConfigURL: name,
+		Info: &ResolverInfo{
+			Name:   name,
+			Type:   ServerTypeDNS,
+			Source: ServerSourceConfigured,
+		},
+		Conn: &testHealthConn{
+			delay: delay,
+			err:   err,
+		},
+	}
+}
+
+func TestResolverHealthScore(t *testing.T) {
+	t.Parallel()
+
+	resolver := newTestHealthResolver("test", 0, nil)
+	health := resolver.Health()
+	if score := health.Score(); score != 0 {
+		t.Errorf("expected score 0 for unused resolver, got %f", score)
+	}
+
+	resolver.health.reportAnswer(20 * time.Millisecond)
+	health = resolver.Health()
+	if health.AvgLatency != 20*time.Millisecond {
+		t.Errorf("expected first sample to be the average latency, got %s", health.AvgLatency)
+	}
+	healthyScore := health.Score()
+
+	resolver.health.reportError(true)
+	health = resolver.Health()
+	if health.Queries != 2 || health.Errors != 1 || health.Timeouts != 1 {
+		t.Errorf("unexpected statistics: %+v", health)
+	}
+	if health.Score() <= healthyScore {
+		t.Errorf("expected score to worsen after error, got %f after %f", health.Score(), healthyScore)
+	}
+
+	// Successful queries let the error rate decay.
+	errorScore := health.Score()
+	resolver.health.reportAnswer(20 * time.Millisecond)
+	health = resolver.Health()
+	if health.Score() >= errorScore {
+		t.Errorf("expected score to improve after answer, got %f after %f", health.Score(), errorScore)
+	}
+}
+
+func TestOrderResolvers(t *testing.T) {
+	t.Parallel()
+
+	slow := newTestHealthResolver("slow", 0, nil)
+	slow.health.reportAnswer(200 * time.Millisecond)
+	flaky := newTestHealthResolver("flaky", 0, nil)
+	flaky.health.reportAnswer(20 * time.Millisecond)
+	flaky.health.reportError(false)
+	flaky.health.reportError(false)
+	fast := newTestHealthResolver("fast", 0, nil)
+	fast.health.reportAnswer(30 * time.Millisecond)
+	unused := newTestHealthResolver("unused", 0, nil)
+	resolvers := []*Resolver{slow, flaky, fast, unused}
+
+	ordered := orderResolvers(ResolverSelectionOrdered, resolvers)
+	checkTestResolverOrder(t, ResolverSelectionOrdered, ordered, "slow", "flaky", "fast", "unused")
+
+	ordered = orderResolvers(ResolverSelectionFastest, resolvers)
+	checkTestResolverOrder(t, ResolverSelectionFastest, ordered, "unused", "fast", "slow", "flaky")
+	checkTestResolverOrder(t, "original", resolvers, "slow", "flaky", "fast", "unused")
+
+	// Every resolver is first once in a round.
+	firsts := make(map[string]int)
+	for i := 0; i < len(resolvers); i++ {
+		ordered = orderResolvers(ResolverSelectionRoundRobin, resolvers)
+		if len(ordered) != len(resolvers) {
+			t.Fatalf("round robin returned %d resolvers", len(ordered))
+		}
+		firsts[ordered[0].Info.Name]++
+	}
+	if len(firsts) != len(resolvers) {
+		t.Errorf("round robin did not rotate through all resolvers: %v", firsts)
+	}
+}
+
+func TestOrderResolversDeadResolver(t *testing.T) {
+	t.Parallel()
+
+	// A resolver that never answers must not be preferred over a slow, but
+	// healthy one.
+	dead := newTestHealthResolver("dead", 0, nil)
+	for i := 0; i < 5; i++ {
+		dead.health.reportError(false)
+	}
+	timingOut := newTestHealthResolver("timeout", 0, nil)
+	timingOut.health.reportError(true)
+	slow := newTestHealthResolver("slow", 0, nil)
+	for i := 0; i < 5; i++ {
+		slow.health.reportAnswer(500 * time.Millisecond)
+	}
+
+	for _, strategy := range []string{ResolverSelectionFastest, ResolverSelectionRace} {
+		ordered := orderResolvers(strategy, []*Resolver{dead, timingOut, slow})
+		if ordered[0] != slow {
+			t.Errorf("%s: expected slow resolver first, got %s", strategy, ordered[0].Info.Name)
+		}
+	}
+}
+
+func checkTestResolverOrder(t *testing.T, strategy string, resolvers []*Resolver, expected ...string) {
+	t.Helper()
+
+	for i, resolver := range resolvers {
+		if resolver.Info.Name != expected[i] {
+			t.Errorf("%s: expected %s at position %d, got %s", strategy, expected[i], i, resolver.Info.Name)
+		}
+	}
+}
+
+func TestRaceResolvers(t *testing.T) {
+	t.Parallel()
+
+	q := &Query{
+		FQDN:  "example.com.",
+		QType: dns.Type(dns.TypeA),
+	}
+
+	failing := newTestHealthResolver("failing", 0, ErrFailure)
+	slow := newTestHealthResolver("slow", time.Second, nil)
+	fast := newTestHealthResolver("fast", 10*time.Millisecond, nil)
+
+	started := time.Now()
+	winner, rrCache, err := raceResolvers(silencingTraceCtx, q, []*Resolver{failing, slow, fast})
+	switch {
+	case err != nil:
+		t.Fatalf("race failed: %s", err)
+	case rrCache == nil:
+		t.Fatal("race returned no answer")
+	case winner != fast:
+		t.Errorf("expected fast resolver to win the race, got %s", winner.Info.Name)
+	case time.Since(started) > 500*time.Millisecond:
+		t.Errorf("race waited for slow resolver")
+	}
+
+	_, _, err = raceResolvers(silencingTraceCtx, q, []*Resolver{failing, newTestHealthResolver("timeout", 0, ErrTimeout)})
+	if !errors.Is(err, ErrFailure) && !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected race to fail, got %v", err)
+	}
+}
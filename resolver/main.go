@@ -37,6 +37,10 @@ func prep() error {
 }
 
 func start() error {
+	if err := registerMetrics(); err != nil {
+		return err
+	}
+
 	// load resolvers from config and environment
 	loadResolvers()
 
@@ -56,13 +60,13 @@ func(_ context.Context, _ interface{}) error {
 	}
 
 	// reload after config change
-	prevNameservers := strings.Join(configuredNameServers(), " ")
+	prevNameservers := getNameserverConfig()
 	err = module.RegisterEventHook(
 		"config",
 		"config change",
 		"update nameservers",
 		func(_ context.Context, _ interface{}) error {
-			newNameservers := strings.Join(configuredNameServers(), " ")
+			newNameservers := getNameserverConfig()
 			if newNameservers != prevNameservers {
 				prevNameservers = newNameservers
 
@@ -82,12 +86,22 @@ func(_ context.Context, _ interface{}) error {
 		listenToMDNS,
 	)
 
+	module.StartServiceWorker("prefetcher", 0, prefetcher)
+
 	module.StartServiceWorker("name record delayed cache writer", 0, recordDatabase.DelayedCacheWriter)
 	module.StartServiceWorker("ip info delayed cache writer", 0, ipInfoDatabase.DelayedCacheWriter)
 
 	return nil
 }
 
+// getNameserverConfig returns the current nameserver related configuration in
+// order to detect changes.
+func getNameserverConfig() string {
+	return strings.Join(configuredNameServers(), " ") + "\n" +
+		strings.Join(configuredForwardingRules(), "\n") + "\n" +
+		strings.Join(configuredLocalRecords(), "\n")
+}
+
 var (
 	localAddrFactory func(network string) net.Addr
 )
@@ -0,0 +1,37 @@
+package resolver
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/metrics"
+)
+
+var (
+	staleAnswerCounter *metrics.Counter
+	prefetchCounter    *metrics.Counter
+)
+
+func registerMetrics() (err error) {
+	staleAnswerCounter, err = metrics.NewCounter(
+		"resolver/answers/stale/total",
+		nil,
+		&metrics.Options{
+			Name:           "Stale DNS Answers",
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+	if err != nil {
+		return err
+	}
+
+	prefetchCounter, err = metrics.NewCounter(
+		"resolver/prefetches/total",
+		nil,
+		&metrics.Options{
+			Name:           "Prefetched DNS Records",
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+		})
+
+	return err
+}
@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+const (
+	// prefetchMinHits defines how many cache hits an entry needs within its
+	// TTL in order to be regarded as popular.
+	prefetchMinHits = 3
+
+	// prefetchInterval defines how often popular entries are checked.
+	prefetchInterval = 10 * time.Second
+
+	// prefetchWindow defines how long before expiry popular entries are
+	// refreshed. It must be bigger than prefetchInterval.
+	prefetchWindow = 30 * time.Second
+
+	// prefetchMaxEntries limits the amount of tracked entries.
+	prefetchMaxEntries = 1000
+
+	// prefetchMaxIdle defines after how long without cache hits an entry is
+	// not tracked anymore.
+	prefetchMaxIdle = 1 * time.Hour
+)
+
+var (
+	prefetchEntries     = make(map[string]*prefetchEntry)
+	prefetchEntriesLock sync.Mutex
+)
+
+type prefetchEntry struct {
+	q       *Query
+	hits    int
+	expires int64
+	lastHit time.Time
+}
+
+// recordCacheHit records a cache hit of the given query for prefetching.
+func recordCacheHit(q *Query, rrCache *RRCache) {
+	if !prefetchEnabled() || !rrCache.Cacheable() {
+		return
+	}
+
+	prefetchEntriesLock.Lock()
+	defer prefetchEntriesLock.Unlock()
+
+	entry, ok := prefetchEntries[q.ID()]
+	if !ok {
+		if len(prefetchEntries) >= prefetchMaxEntries {
+			return
+		}
+
+		// Copy the query, as it is used after the request finished.
+		qCopy := *q
+		entry = &prefetchEntry{
+			q: &qCopy,
+		}
+		prefetchEntries[q.ID()] = entry
+	}
+
+	entry.hits++
+	entry.expires = rrCache.Expires
+	entry.lastHit = time.Now()
+}
+
+// getPrefetchQueries returns the queries of popular entries that expire
+// before the given time and resets their hits. It also removes idle entries.
+func getPrefetchQueries(expiresBefore time.Time) (queries []*Query) {
+	prefetchEntriesLock.Lock()
+	defer prefetchEntriesLock.Unlock()
+
+	idleSince := time.Now().Add(-prefetchMaxIdle)
+	for id, entry := range prefetchEntries {
+		switch {
+		case entry.lastHit.Before(idleSince):
+			delete(prefetchEntries, id)
+		case entry.hits >= prefetchMinHits && entry.expires <= expiresBefore.Unix():
+			queries = append(queries, entry.q)
+			// Entries must be popular again within their next TTL.
+			entry.hits = 0
+		}
+	}
+
+	return queries
+}
+
+func prefetcher(ctx context.Context) error {
+	ticker := time.NewTicker(prefetchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !prefetchEnabled() {
+				continue
+			}
+			for _, q := range getPrefetchQueries(time.Now().Add(prefetchWindow)) {
+				if ctx.Err() != nil {
+					return nil
+				}
+				prefetch(ctx, q)
+			}
+		}
+	}
+}
+
+func prefetch(ctx context.Context, q *Query) {
+	tracingCtx, tracer := log.AddTracer(ctx)
+	defer tracer.Submit()
+
+	// Skip if the entry is already being resolved.
+	markRequestFinished := deduplicateRequest(tracingCtx, q)
+	if markRequestFinished == nil {
+		return
+	}
+	defer markRequestFinished()
+
+	tracer.Tracef("resolver: prefetching popular entry %s", q.ID())
+	_, err := resolveAndCache(tracingCtx, q, nil)
+	if err != nil {
+		tracer.Debugf("resolver: failed to prefetch %s: %s", q.ID(), err)
+		return
+	}
+	prefetchCounter.Inc()
+}
@@ -0,0 +1,42 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPrefetchQueries(t *testing.T) { //nolint:paralleltest // Modifies the prefetch entries.
+	defer func() {
+		prefetchEntriesLock.Lock()
+		defer prefetchEntriesLock.Unlock()
+		prefetchEntries = make(map[string]*prefetchEntry)
+	}()
+
+	popular := &Query{FQDN: "popular.example.com.", QType: dns.Type(dns.TypeA)}
+	rare := &Query{FQDN: "rare.example.com.", QType: dns.Type(dns.TypeA)}
+	later := &Query{FQDN: "later.example.com.", QType: dns.Type(dns.TypeA)}
+	expiresSoon := &RRCache{RCode: dns.RcodeSuccess, Expires: time.Now().Add(10 * time.Second).Unix()}
+	expiresLater := &RRCache{RCode: dns.RcodeSuccess, Expires: time.Now().Add(time.Hour).Unix()}
+
+	for i := 0; i < prefetchMinHits; i++ {
+		recordCacheHit(popular, expiresSoon)
+		recordCacheHit(later, expiresLater)
+	}
+	recordCacheHit(rare, expiresSoon)
+
+	queries := getPrefetchQueries(time.Now().Add(prefetchWindow))
+	if len(queries) != 1 || queries[0].ID() != popular.ID() {
+		t.Fatalf("expected only %s to be prefetched, got %v", popular.ID(), queries)
+	}
+	if queries[0] == popular {
+		t.Error("prefetch query must be a copy")
+	}
+
+	// Hits are reset after prefetching.
+	queries = getPrefetchQueries(time.Now().Add(prefetchWindow))
+	if len(queries) != 0 {
+		t.Errorf("expected no queries to be prefetched twice, got %v", queries)
+	}
+}
@@ -0,0 +1,186 @@
+package resolver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Query privacy settings.
+//
+// Client subnet information (ECS) and DNS cookies are never sent, as both can
+// be used to track the user. Also, QNAME minimisation does not apply to
+// queries sent to the configured servers, as they do the recursion and
+// always receive the full question. The only queries the Portmaster builds
+// itself are the DS and DNSKEY queries of the DNSSEC validation, which only
+// ever contain the names of the zones in question.
+const (
+	// ECSStrip never sends client subnet information and strips it from
+	// replies. This is the default.
+	ECSStrip = "strip"
+	// ECSOptOut additionally asks the server to not add client subnet
+	// information on its own when resolving, by sending an empty client
+	// subnet with a source prefix length of zero.
+	// RFC7871, Section 7.1.2
+	ECSOptOut = "optout"
+
+	// paddingBlockSize is the block size queries are padded to.
+	// RFC8467, Section 4.1
+	paddingBlockSize = 128
+
+	privacyParamOn  = "on"
+	privacyParamOff = "off"
+)
+
+// parsePrivacyParam parses an on/off URL parameter of the resolver config.
+func parsePrivacyParam(value string, defaultValue bool) (enabled bool, err error) {
+	switch strings.ToLower(value) {
+	case "":
+		return defaultValue, nil
+	case privacyParamOn:
+		return true, nil
+	case privacyParamOff:
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected %q or %q, got %q", privacyParamOn, privacyParamOff, value)
+	}
+}
+
+// newQueryMsg creates a query message for the given query and applies the
+// privacy settings of the resolver.
+func (resolver *Resolver) newQueryMsg(q *Query) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(q.FQDN, uint16(q.QType))
+	addDNSSECOptions(msg)
+
+	if resolver.ECS == ECSOptOut {
+		opt := ensureEdns0(msg)
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1, // IPv4
+			SourceNetmask: 0,
+			Address:       net.IPv4zero,
+		})
+	}
+
+	if resolver.Use0x20 {
+		msg.Question[0].Name = randomizeCase(q.FQDN)
+	}
+
+	// Padding must be added last, as it depends on the message size.
+	if resolver.Padding {
+		padQueryMsg(msg)
+	}
+
+	return msg
+}
+
+// ensureEdns0 returns the OPT record of the given message and adds one if
+// it does not exist yet.
+func ensureEdns0(msg *dns.Msg) *dns.OPT {
+	if opt := msg.IsEdns0(); opt != nil {
+		return opt
+	}
+	msg.SetEdns0(dnssecUDPSize, false)
+	return msg.IsEdns0()
+}
+
+// padQueryMsg pads the given message to a multiple of the padding block
+// size.
+// RFC7830, RFC8467
+func padQueryMsg(msg *dns.Msg) {
+	opt := ensureEdns0(msg)
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(opt.Option, padding)
+
+	if remainder := msg.Len() % paddingBlockSize; remainder != 0 {
+		padding.Padding = make([]byte, paddingBlockSize-remainder)
+	}
+}
+
+// randomizeCase randomizes the case of the letters of the given name in
+// order to make spoofing replies harder.
+// draft-vixie-dnsext-dns0x20-00
+func randomizeCase(name string) string {
+	random := make([]byte, len(name))
+	if _, err := rand.Read(random); err != nil {
+		return name
+	}
+
+	randomized := []byte(name)
+	for i, c := range randomized {
+		if random[i]&1 == 0 {
+			continue
+		}
+		switch {
+		case 'a' <= c && c <= 'z':
+			randomized[i] = c - 'a' + 'A'
+		case 'A' <= c && c <= 'Z':
+			randomized[i] = c - 'A' + 'a'
+		}
+	}
+	return string(randomized)
+}
+
+// checkReply checks if the given reply answers the given query message and
+// removes any privacy sensitive options from it. If the case of the question
+// was randomized, the reply must repeat the question exactly and the
+// original case is restored.
+func (resolver *Resolver) checkReply(q *Query, msg, reply *dns.Msg) error {
+	randomized := msg.Question[0].Name != q.FQDN
+
+	switch {
+	case len(reply.Question) == 0:
+		// Some servers do not repeat the question in error replies.
+		if randomized {
+			return fmt.Errorf("%w: reply from %s is missing the question", ErrFailure, resolver.Info.DescriptiveName())
+		}
+	case !strings.EqualFold(reply.Question[0].Name, q.FQDN) ||
+		reply.Question[0].Qtype != uint16(q.QType):
+		return fmt.Errorf("%w: reply from %s does not match question", ErrFailure, resolver.Info.DescriptiveName())
+	case randomized && reply.Question[0].Name != msg.Question[0].Name:
+		return fmt.Errorf("%w: reply from %s does not match the case of the question, possibly spoofed", ErrFailure, resolver.Info.DescriptiveName())
+	case randomized:
+		restoreCase(q.FQDN, reply)
+	}
+
+	stripPrivacyOptions(reply)
+	return nil
+}
+
+// restoreCase sets the name of all records that match the given name
+// case-insensitively to the given name.
+func restoreCase(name string, reply *dns.Msg) {
+	reply.Question[0].Name = name
+	for _, section := range [][]dns.RR{reply.Answer, reply.Ns, reply.Extra} {
+		for _, rr := range section {
+			if strings.EqualFold(rr.Header().Name, name) {
+				rr.Header().Name = name
+			}
+		}
+	}
+}
+
+// stripPrivacyOptions removes client subnet, cookie and padding options from
+// the OPT record of the given reply, so that they are neither cached nor
+// passed on.
+func stripPrivacyOptions(reply *dns.Msg) {
+	opt := reply.IsEdns0()
+	if opt == nil || len(opt.Option) == 0 {
+		return
+	}
+
+	options := make([]dns.EDNS0, 0, len(opt.Option))
+	for _, option := range opt.Option {
+		switch option.Option() {
+		case dns.EDNS0SUBNET, dns.EDNS0COOKIE, dns.EDNS0PADDING:
+			// Remove.
+		default:
+			options = append(options, option)
+		}
+	}
+	opt.Option = options
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPrivacyResolverConfig(t *testing.T) {
+	t.Parallel()
+
+	// Check defaults.
+	resolver, _, err := createResolver("dot://9.9.9.9?verify=dns.quad9.net", ServerSourceConfigured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.ECS != ECSStrip || !resolver.Padding || resolver.Use0x20 {
+		t.Errorf("unexpected dot privacy defaults: ecs=%s padding=%v 0x20=%v", resolver.ECS, resolver.Padding, resolver.Use0x20)
+	}
+	resolver, _, err = createResolver("dns://9.9.9.9", ServerSourceConfigured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.ECS != ECSStrip || resolver.Padding || resolver.Use0x20 {
+		t.Errorf("unexpected dns privacy defaults: ecs=%s padding=%v 0x20=%v", resolver.ECS, resolver.Padding, resolver.Use0x20)
+	}
+
+	// Check parameters.
+	resolver, _, err = createResolver("dns://9.9.9.9?ecs=optout&0x20=on", ServerSourceConfigured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.ECS != ECSOptOut || !resolver.Use0x20 {
+		t.Errorf("unexpected dns privacy settings: ecs=%s 0x20=%v", resolver.ECS, resolver.Use0x20)
+	}
+	resolver, _, err = createResolver("doh://9.9.9.9?verify=dns.quad9.net&padding=off", ServerSourceConfigured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.Padding {
+		t.Error("padding should be disabled")
+	}
+
+	// Check invalid parameters.
+	for _, resolverURL := range []string{
+		"dns://9.9.9.9?ecs=send",
+		"dns://9.9.9.9?padding=on",
+		"dot://9.9.9.9?verify=dns.quad9.net&padding=yes",
+		"dot://9.9.9.9?verify=dns.quad9.net&0x20=on",
+		"tcp://9.9.9.9?0x20=on",
+	} {
+		if _, _, err := createResolver(resolverURL, ServerSourceConfigured); err == nil {
+			t.Errorf("resolver %s should fail", resolverURL)
+		}
+	}
+}
+
+func TestQueryPadding(t *testing.T) {
+	t.Parallel()
+
+	q := &Query{
+		FQDN:  "example.com.",
+		QType: dns.Type(dns.TypeA),
+	}
+	for _, resolver := range []*Resolver{
+		{Padding: true},
+		{Padding: true, ECS: ECSOptOut},
+	} {
+		msg := resolver.newQueryMsg(q)
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packed)%paddingBlockSize != 0 {
+			t.Errorf("query is not padded to block size: %d bytes", len(packed))
+		}
+	}
+
+	msg := (&Resolver{}).newQueryMsg(q)
+	if opt := msg.IsEdns0(); opt != nil && len(opt.Option) > 0 {
+		t.Errorf("query without padding should not have options, got %v", opt.Option)
+	}
+}
+
+func TestCaseRandomization(t *testing.T) {
+	t.Parallel()
+
+	resolver := &Resolver{
+		Info: &ResolverInfo{
+			Name: "test",
+			Type: ServerTypeDNS,
+		},
+		Use0x20: true,
+	}
+	q := &Query{
+		FQDN:  "a-rather-long-domain-for-case-randomization.example.com.",
+		QType: dns.Type(dns.TypeA),
+	}
+
+	// Retry, as the randomized case may be equal to the original.
+	var msg *dns.Msg
+	for i := 0; i < 10; i++ {
+		msg = resolver.newQueryMsg(q)
+		if msg.Question[0].Name != q.FQDN {
+			break
+		}
+	}
+	if !strings.EqualFold(msg.Question[0].Name, q.FQDN) || msg.Question[0].Name == q.FQDN {
+		t.Fatalf("unexpected randomized question %s", msg.Question[0].Name)
+	}
+
+	// A reply with the exact question is accepted and the case is restored.
+	reply := new(dns.Msg)
+	reply.SetReply(msg)
+	reply.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+	}}
+	if err := resolver.checkReply(q, msg, reply); err != nil {
+		t.Fatalf("valid reply was rejected: %s", err)
+	}
+	if reply.Question[0].Name != q.FQDN || reply.Answer[0].Header().Name != q.FQDN {
+		t.Errorf("case was not restored: %s", reply)
+	}
+
+	// A reply with a different case is rejected.
+	reply = new(dns.Msg)
+	reply.SetReply(msg)
+	reply.Question[0].Name = strings.ToLower(msg.Question[0].Name)
+	if err := resolver.checkReply(q, msg, reply); err == nil {
+		t.Error("reply with different case should be rejected")
+	}
+
+	// A reply without a question is rejected.
+	reply = new(dns.Msg)
+	reply.SetReply(msg)
+	reply.Question = nil
+	if err := resolver.checkReply(q, msg, reply); err == nil {
+		t.Error("reply without question should be rejected")
+	}
+}
+
+func TestStripPrivacyOptions(t *testing.T) {
+	t.Parallel()
+
+	reply := new(dns.Msg)
+	reply.SetQuestion("example.com.", dns.TypeA)
+	reply.SetEdns0(dnssecUDPSize, true)
+	opt := reply.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+		&dns.EDNS0_PADDING{Padding: make([]byte, 16)},
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73"},
+	)
+
+	resolver := &Resolver{Info: &ResolverInfo{Type: ServerTypeDNS}}
+	if err := resolver.checkReply(&Query{FQDN: "example.com.", QType: dns.Type(dns.TypeA)}, reply.Copy(), reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0NSID {
+		t.Errorf("unexpected options after stripping: %v", opt.Option)
+	}
+}
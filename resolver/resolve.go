@@ -134,8 +134,15 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 	// check the cache
 	if !q.NoCaching {
 		rrCache = checkCache(ctx, q)
-		if rrCache != nil && !rrCache.Expired() {
-			return rrCache, nil
+		if rrCache != nil {
+			switch {
+			case !rrCache.Expired():
+				return rrCache, nil
+			case rrCache.IsServableStale():
+				// The cache entry is stale, refresh it or serve it if that fails.
+				return resolveOrServeStale(ctx, q, rrCache)
+			}
+			// The expired cache entry is used as a backup if resolving fails.
 		}
 
 		// dedupe!
@@ -163,6 +170,12 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 		return nil
 	}
 
+	// Never ask cache for domains with local records, as they might have been
+	// cached before the records were defined.
+	if hasOverrideWithLocking(q.FQDN) {
+		return nil
+	}
+
 	// Get data from cache.
 	rrCache, err := GetRRCache(q.FQDN, q.QType)
 
@@ -188,6 +201,13 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 		return nil
 	}
 
+	// Check if the resolver may be used according to the forwarding rules.
+	err = checkForwardingRuleWithLocking(q, resolver)
+	if err != nil {
+		log.Tracer(ctx).Debugf("resolver: ignoring cached entry for %s%s: %s", q.FQDN, q.QType.String(), err)
+		return nil
+	}
+
 	// Check if we want to reset the cache for this entry.
 	if shouldResetCache(q) {
 		err := ResetCachedRecord(q.FQDN, q.QType.String())
@@ -204,7 +224,8 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 
 	// Check if the cache has already expired.
 	// We still return the cache, if it isn't NXDomain, as it will be used if the
-	// new query fails.
+	// new query fails. If it may be served stale, it is also used if the new
+	// query takes too long.
 	if rrCache.Expired() {
 		if rrCache.RCode == dns.RcodeSuccess {
 			return rrCache
@@ -212,6 +233,9 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 		return nil
 	}
 
+	// Record the cache hit for prefetching.
+	recordCacheHit(q, rrCache)
+
 	// Check if the cache will expire soon and start an async request.
 	if rrCache.ExpiresSoon() {
 		// Set flag that we are refreshing this entry.
@@ -309,7 +333,7 @@ func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 
 func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache *RRCache, err error) { //nolint:gocognit,gocyclo
 	// get resolvers
-	resolvers, tryAll := GetResolversInScope(ctx, q)
+	resolvers, tryAll, globalOnly := GetResolversInScope(ctx, q)
 	if len(resolvers) == 0 {
 		return nil, ErrNoCompliance
 	}
@@ -325,10 +349,27 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 
 	// start resolving
 
+	var answeredBy *Resolver
+
+	// Race the healthiest resolvers, if enabled.
+	if globalOnly && resolverSelection() == ResolverSelectionRace {
+		if candidates := getRaceCandidates(resolvers); len(candidates) > 1 {
+			answeredBy, rrCache, err = raceResolvers(ctx, q, candidates)
+			switch {
+			case err == nil:
+				// Continue with the answer.
+			case errors.Is(err, ErrNotFound), errors.Is(err, ErrBlocked):
+				return nil, err
+			default:
+				log.Tracer(ctx).Debugf("resolver: all raced resolvers failed, trying one by one: %s", err)
+			}
+		}
+	}
+
 	var i int
 	// once with skipping recently failed resolvers, once without
 resolveLoop:
-	for i = 0; i < 2; i++ {
+	for i = 0; i < 2 && answeredBy == nil; i++ {
 		for _, resolver := range resolvers {
 			if module.IsStopping() {
 				return nil, errors.New("shutting down")
@@ -342,7 +383,7 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 
 			// resolve
 			log.Tracer(ctx).Tracef("resolver: sending query for %s to %s", q.ID(), resolver.Info.ID())
-			rrCache, err = resolver.Conn.Query(ctx, q)
+			rrCache, err = resolver.query(ctx, q)
 			if err != nil {
 				switch {
 				case errors.Is(err, ErrNotFound):
@@ -379,13 +420,21 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 				continue
 			}
 
-			// Report a successful connection.
-			resolver.Conn.ResetFailure()
-
+			answeredBy = resolver
 			break resolveLoop
 		}
 	}
 
+	if answeredBy != nil {
+		// Report a successful connection.
+		answeredBy.Conn.ResetFailure()
+
+		// Validate DNSSEC.
+		if answeredBy.dnssecApplies(q) {
+			rrCache.Resolver.DNSSEC = answeredBy.validateDNSSEC(ctx, q, rrCache)
+		}
+	}
+
 	// Post-process errors
 	if err != nil {
 		// tried all resolvers, possibly twice
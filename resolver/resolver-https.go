@@ -0,0 +1,231 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+const (
+	dohMessageContentType = "application/dns-message"
+	dohDefaultPath        = "/dns-query"
+	dohMaxResponseSize    = dns.MaxMsgSize
+
+	// DoH request methods, configured with the `method` parameter.
+	dohMethodPost = "post"
+	dohMethodGet  = "get"
+)
+
+// HTTPSResolver is a resolver using DNS-over-HTTPS as defined in RFC8484.
+// Connections are kept alive and reused via HTTP/2 whenever possible.
+type HTTPSResolver struct {
+	BasicResolverConn
+
+	client   *http.Client
+	endpoint string
+	useGET   bool
+}
+
+// NewHTTPSResolver returns a new HTTPSResolver.
+func NewHTTPSResolver(resolver *Resolver) *HTTPSResolver {
+	// Build the endpoint URL. The host part is always the verify domain, as it is
+	// used for the Host header. Connections are always dialed to the configured
+	// IP address.
+	host := resolver.VerifyDomain
+	if resolver.Info.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(resolver.Info.Port)))
+	}
+	path := resolver.Path
+	if path == "" {
+		path = dohDefaultPath
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// Refresh dialer to set an authenticated local address.
+			dialer := &net.Dialer{
+				LocalAddr: getLocalAddr(network),
+				Timeout:   defaultConnectTimeout,
+				KeepAlive: defaultClientTTL,
+			}
+			return dialer.DialContext(ctx, network, resolver.ServerAddress)
+		},
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: resolver.VerifyDomain,
+			// TODO: use portbase rng
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     defaultClientTTL,
+		TLSHandshakeTimeout: defaultConnectTimeout,
+	}
+
+	newResolver := &HTTPSResolver{
+		BasicResolverConn: BasicResolverConn{
+			resolver: resolver,
+		},
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   maxRequestTimeout,
+		},
+		endpoint: (&url.URL{
+			Scheme: "https",
+			Host:   host,
+			Path:   path,
+		}).String(),
+		useGET: resolver.Method == dohMethodGet,
+	}
+	newResolver.BasicResolverConn.init()
+	return newResolver
+}
+
+// Query executes the given query against the resolver.
+func (hr *HTTPSResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	// create query
+	dnsQuery := hr.resolver.newQueryMsg(q)
+	// RFC8484 4.1: Use an ID of 0 in order to be friendly to HTTP caches.
+	dnsQuery.Id = 0
+
+	// limit the request time
+	ctx, cancel := context.WithTimeout(ctx, defaultRequestTimeout)
+	defer cancel()
+
+	// build http request
+	request, err := hr.buildRequest(ctx, dnsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// query server
+	started := time.Now()
+	reply, err := hr.exchange(request)
+	log.Tracer(ctx).Tracef("resolver: query took %s", time.Since(started))
+	// error handling
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+
+		// Hint network environment at failed connection if err is not a timeout.
+		var nErr net.Error
+		if errors.As(err, &nErr) {
+			if nErr.Timeout() {
+				return nil, ErrTimeout
+			}
+			netenv.ReportFailedConnection()
+		}
+
+		return nil, err
+	}
+
+	// check if the reply matches our question
+	if len(reply.Question) == 0 {
+		return nil, fmt.Errorf("%w: reply from %s does not match question", ErrFailure, hr.resolver.Info.DescriptiveName())
+	}
+	if err := hr.resolver.checkReply(q, dnsQuery, reply); err != nil {
+		return nil, err
+	}
+
+	// check if blocked
+	if hr.resolver.IsBlockedUpstream(reply) {
+		return nil, &BlockedUpstreamError{hr.resolver.Info.DescriptiveName()}
+	}
+
+	// hint network environment at successful connection
+	netenv.ReportSuccessfulConnection()
+
+	newRecord := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    reply.Rcode,
+		Answer:   reply.Answer,
+		Ns:       reply.Ns,
+		Extra:    reply.Extra,
+		Resolver: hr.resolver.Info.Copy(),
+	}
+
+	return newRecord, nil
+}
+
+func (hr *HTTPSResolver) buildRequest(ctx context.Context, dnsQuery *dns.Msg) (*http.Request, error) {
+	packed, err := dnsQuery.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	var request *http.Request
+	if hr.useGET {
+		// RFC8484 4.1: The query is sent as base64url encoded "dns" parameter.
+		request, err = http.NewRequestWithContext(
+			ctx,
+			http.MethodGet,
+			hr.endpoint+"?dns="+base64.RawURLEncoding.EncodeToString(packed),
+			nil,
+		)
+	} else {
+		request, err = http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			hr.endpoint,
+			bytes.NewReader(packed),
+		)
+		if err == nil {
+			request.Header.Set("Content-Type", dohMessageContentType)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Accept", dohMessageContentType)
+
+	return request, nil
+}
+
+func (hr *HTTPSResolver) exchange(request *http.Request) (*dns.Msg, error) {
+	resp, err := hr.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Drain the body in order to be able to reuse the connection.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	// check response
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected http status from %s: %s", ErrFailure, hr.resolver.Info.DescriptiveName(), resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != dohMessageContentType {
+		return nil, fmt.Errorf("%w: unexpected content type from %s: %q", ErrFailure, hr.resolver.Info.DescriptiveName(), contentType)
+	}
+
+	// read and parse response
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, dohMaxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	reply := new(dns.Msg)
+	err = reply.Unpack(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse response from %s: %s", ErrFailure, hr.resolver.Info.DescriptiveName(), err)
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,125 @@
+package resolver
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHTTPSResolver(t *testing.T) {
+	testIP := net.IPv4(192, 0, 2, 1)
+
+	// Start a DoH server that answers all queries with testIP.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			data, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohMessageContentType {
+				http.Error(w, "invalid content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			data, err = ioutil.ReadAll(r.Body)
+		}
+		if err != nil || r.URL.Path != dohDefaultPath {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := new(dns.Msg)
+		reply.SetReply(query)
+		reply.Answer = append(reply.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   query.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: testIP,
+		})
+		packed, err := reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMessageContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer server.Close()
+
+	// Trust the test server certificate.
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	for _, method := range []string{dohMethodPost, dohMethodGet} {
+		// Create resolver manually, as createResolver skips localhost resolvers.
+		resolver := &Resolver{
+			ConfigURL: "doh://" + server.Listener.Addr().String() + "?verify=example.com&method=" + method,
+			Info: &ResolverInfo{
+				Type:   ServerTypeDoH,
+				Source: ServerSourceConfigured,
+				IP:     net.IPv4(127, 0, 0, 1),
+				Port:   443,
+			},
+			ServerAddress:          server.Listener.Addr().String(),
+			VerifyDomain:           "example.com", // Covered by the httptest certificate.
+			Method:                 method,
+			UpstreamBlockDetection: BlockDetectionDisabled,
+		}
+		hr := NewHTTPSResolver(resolver)
+		hr.client.Transport.(*http.Transport).TLSClientConfig.RootCAs = rootCAs
+
+		rrCache, err := hr.Query(silencingTraceCtx, &Query{
+			FQDN:  "example.org.",
+			QType: dns.Type(dns.TypeA),
+		})
+		if err != nil {
+			t.Fatalf("%s query failed: %s", method, err)
+		}
+		ips := rrCache.ExportAllARecords()
+		if len(ips) != 1 || !ips[0].Equal(testIP) {
+			t.Errorf("%s query returned unexpected answer: %v", method, rrCache.Answer)
+		}
+	}
+}
+
+func TestHTTPSResolverConfig(t *testing.T) {
+	resolver, _, err := createResolver("doh://9.9.9.9/custom-path?verify=dns.quad9.net&method=GET", ServerSourceConfigured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.Info.Port != 443 {
+		t.Errorf("unexpected default port %d", resolver.Info.Port)
+	}
+	if resolver.Path != "/custom-path" || resolver.Method != dohMethodGet {
+		t.Errorf("unexpected path %q or method %q", resolver.Path, resolver.Method)
+	}
+	if _, ok := resolver.Conn.(*HTTPSResolver); !ok {
+		t.Errorf("unexpected resolver conn type %T", resolver.Conn)
+	}
+
+	// DoH requires domain verification.
+	_, _, err = createResolver("doh://9.9.9.9", ServerSourceConfigured)
+	if err == nil {
+		t.Error("doh resolver without verify parameter should fail")
+	}
+
+	// The request method is only valid for DoH.
+	_, _, err = createResolver("dot://9.9.9.9?verify=dns.quad9.net&method=get", ServerSourceConfigured)
+	if err == nil {
+		t.Error("dot resolver with method parameter should fail")
+	}
+}
@@ -0,0 +1,412 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+const (
+	// overrideTTL is the TTL of answers from local records.
+	overrideTTL = 17
+
+	// maxOverrideCNAMEChain limits how many CNAMEs are followed within the
+	// local records.
+	maxOverrideCNAMEChain = 8
+
+	// maxTXTStringLength is the maximum length of a single TXT string.
+	maxTXTStringLength = 255
+)
+
+var (
+	overrideResolver = &Resolver{
+		ConfigURL: ServerSourceOverride,
+		Info: &ResolverInfo{
+			Type:    ServerTypeOverride,
+			Source:  ServerSourceOverride,
+			IPScope: netutils.HostLocal,
+		},
+		Conn: &overrideResolverConn{},
+	}
+	overrideResolvers = []*Resolver{overrideResolver}
+
+	overrides = newOverrideRecords() // protected by resolversLock
+)
+
+// overrideRecords holds the user defined local records.
+type overrideRecords struct {
+	// names holds the records by FQDN.
+	names map[string][]dns.RR
+	// wildcards holds the records of wildcard names by their dot-prefixed
+	// parent domain, eg. ".example.com." for "*.example.com.".
+	wildcards map[string][]dns.RR
+	// domains holds the first domain defined for an IP address.
+	domains map[string]string
+}
+
+func newOverrideRecords() *overrideRecords {
+	return &overrideRecords{
+		names:     make(map[string][]dns.RR),
+		wildcards: make(map[string][]dns.RR),
+		domains:   make(map[string]string),
+	}
+}
+
+// parseOverrideName parses and checks a domain of a local record.
+func parseOverrideName(name string, allowWildcard bool) (fqdn string, wildcard bool, err error) {
+	fqdn = dns.Fqdn(strings.ToLower(name))
+	if allowWildcard && strings.HasPrefix(fqdn, "*.") {
+		fqdn = strings.TrimPrefix(fqdn, "*.")
+		wildcard = true
+	}
+
+	if fqdn == "." || !netutils.IsValidFqdn(fqdn) {
+		return "", false, fmt.Errorf("invalid domain %q", name)
+	}
+	return fqdn, wildcard, nil
+}
+
+// parseOverrideRecord parses a local record in the format
+// "<domain> <type> <data>" or in the hosts file format
+// "<ip> <domain> [<domain>...]". The domain may be prefixed with "*." in
+// order to define the record for all subdomains.
+func parseOverrideRecord(entry string) (rrs []dns.RR, err error) {
+	fields := strings.Fields(entry)
+
+	// Check for the hosts file format.
+	if len(fields) > 0 {
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			return parseHostsEntry(ip, fields[1:])
+		}
+	}
+
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected domain, type and data, got %d fields", len(fields))
+	}
+	fqdn, wildcard, err := parseOverrideName(fields[0], true)
+	if err != nil {
+		return nil, err
+	}
+	// Wildcard records are stored with the wildcard name.
+	if wildcard {
+		fqdn = "*." + fqdn
+	}
+	header := dns.RR_Header{
+		Name:  fqdn,
+		Class: dns.ClassINET,
+		Ttl:   overrideTTL,
+	}
+	data := strings.Join(fields[2:], " ")
+
+	switch strings.ToUpper(fields[1]) {
+	case "A":
+		ip := net.ParseIP(data)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", data)
+		}
+		header.Rrtype = dns.TypeA
+		return []dns.RR{&dns.A{Hdr: header, A: ip.To4()}}, nil
+
+	case "AAAA":
+		ip := net.ParseIP(data)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address %q", data)
+		}
+		header.Rrtype = dns.TypeAAAA
+		return []dns.RR{&dns.AAAA{Hdr: header, AAAA: ip}}, nil
+
+	case "CNAME":
+		target, _, err := parseOverrideName(data, false)
+		if err != nil {
+			return nil, err
+		}
+		header.Rrtype = dns.TypeCNAME
+		return []dns.RR{&dns.CNAME{Hdr: header, Target: target}}, nil
+
+	case "PTR":
+		target, _, err := parseOverrideName(data, false)
+		if err != nil {
+			return nil, err
+		}
+		header.Rrtype = dns.TypePTR
+		return []dns.RR{&dns.PTR{Hdr: header, Ptr: target}}, nil
+
+	case "TXT":
+		header.Rrtype = dns.TypeTXT
+		return []dns.RR{&dns.TXT{Hdr: header, Txt: splitTXT(strings.Trim(data, `"`))}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", fields[1])
+	}
+}
+
+// parseHostsEntry parses the domains of a hosts file entry. The first domain
+// is also used for the reverse (PTR) record of the IP address.
+func parseHostsEntry(ip net.IP, names []string) (rrs []dns.RR, err error) {
+	for _, name := range names {
+		// Ignore comments.
+		if strings.HasPrefix(name, "#") {
+			break
+		}
+
+		fqdn, _, err := parseOverrideName(name, false)
+		if err != nil {
+			return nil, err
+		}
+		header := dns.RR_Header{
+			Name:  fqdn,
+			Class: dns.ClassINET,
+			Ttl:   overrideTTL,
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			header.Rrtype = dns.TypeA
+			rrs = append(rrs, &dns.A{Hdr: header, A: ip4})
+		} else {
+			header.Rrtype = dns.TypeAAAA
+			rrs = append(rrs, &dns.AAAA{Hdr: header, AAAA: ip})
+		}
+	}
+	if len(rrs) == 0 {
+		return nil, errors.New("missing domain")
+	}
+
+	reverseName, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, err
+	}
+	rrs = append(rrs, &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   reverseName,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    overrideTTL,
+		},
+		Ptr: rrs[0].Header().Name,
+	})
+
+	return rrs, nil
+}
+
+// splitTXT splits the given text into TXT strings of the maximum length.
+func splitTXT(text string) []string {
+	txt := make([]string, 0, len(text)/maxTXTStringLength+1)
+	for len(text) > maxTXTStringLength {
+		txt = append(txt, text[:maxTXTStringLength])
+		text = text[maxTXTStringLength:]
+	}
+	return append(txt, text)
+}
+
+// parseHostsFile parses the given hosts file and returns the entries as local
+// records. Invalid entries are returned as errors.
+func parseHostsFile(hostsFile string) (entries []string, errs []error) {
+	for _, line := range strings.Split(hostsFile, "\n") {
+		// Remove comments and whitespace.
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+
+		// Only accept entries in the hosts file format.
+		fields := strings.Fields(line)
+		if net.ParseIP(fields[0]) == nil {
+			errs = append(errs, fmt.Errorf("%q: invalid IP address", line))
+			continue
+		}
+		if _, err := parseOverrideRecord(line); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", line, err))
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, errs
+}
+
+func getOverrideRecords(list []string) *overrideRecords {
+	records := newOverrideRecords()
+
+	for _, entry := range list {
+		rrs, err := parseOverrideRecord(entry)
+		if err != nil {
+			log.Errorf("resolver: cannot use local record %q: %s", entry, err)
+			continue
+		}
+
+		for _, rr := range rrs {
+			name := rr.Header().Name
+			if strings.HasPrefix(name, "*.") {
+				parent := strings.TrimPrefix(name, "*")
+				records.wildcards[parent] = append(records.wildcards[parent], rr)
+				continue
+			}
+			records.names[name] = append(records.names[name], rr)
+
+			// Remember the first domain of IP addresses for attribution.
+			var ip net.IP
+			switch v := rr.(type) {
+			case *dns.A:
+				ip = v.A
+			case *dns.AAAA:
+				ip = v.AAAA
+			}
+			if ip != nil {
+				if _, ok := records.domains[ip.String()]; !ok {
+					records.domains[ip.String()] = name
+				}
+			}
+		}
+	}
+
+	return records
+}
+
+// get returns the records of the given FQDN and whether any exist. Records of
+// wildcards are returned with the given FQDN as name.
+func (or *overrideRecords) get(fqdn string) (rrs []dns.RR, ok bool) {
+	if rrs, ok := or.names[fqdn]; ok {
+		return rrs, true
+	}
+
+	// Find the most specific wildcard.
+	parent := fqdn
+	for {
+		i := strings.IndexByte(parent, '.')
+		if i < 0 || i == len(parent)-1 {
+			return nil, false
+		}
+		parent = parent[i+1:]
+
+		wildcardRRs, ok := or.wildcards["."+parent]
+		if !ok {
+			continue
+		}
+		rrs = make([]dns.RR, 0, len(wildcardRRs))
+		for _, rr := range wildcardRRs {
+			rr = dns.Copy(rr)
+			rr.Header().Name = fqdn
+			rrs = append(rrs, rr)
+		}
+		return rrs, true
+	}
+}
+
+// answer returns the answer for the given FQDN and type. CNAMEs are followed
+// within the local records. If a CNAME target has no local records, it is
+// returned for resolving elsewhere.
+func (or *overrideRecords) answer(fqdn string, qType uint16) (answer []dns.RR, exists bool, unresolvedTarget string) {
+	name := fqdn
+	for i := 0; i < maxOverrideCNAMEChain; i++ {
+		rrs, ok := or.get(name)
+		if !ok {
+			if i == 0 {
+				return nil, false, ""
+			}
+			return answer, true, name
+		}
+
+		var cname dns.RR
+		var found bool
+		for _, rr := range rrs {
+			switch rr.Header().Rrtype {
+			case qType:
+				answer = append(answer, rr)
+				found = true
+			case dns.TypeCNAME:
+				cname = rr
+			}
+		}
+		if found || cname == nil {
+			return answer, true, ""
+		}
+
+		answer = append(answer, cname)
+		name = cname.(*dns.CNAME).Target
+	}
+
+	return answer, true, ""
+}
+
+// hasOverrideWithLocking returns whether there are local records for the
+// given FQDN.
+func hasOverrideWithLocking(fqdn string) bool {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	_, ok := overrides.get(fqdn)
+	return ok
+}
+
+// GetOverrideDomain returns the domain of the local records for the given IP
+// address, or nil if there is none.
+func GetOverrideDomain(ip net.IP) *ResolvedDomain {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	domain, ok := overrides.domains[ip.String()]
+	if !ok {
+		return nil
+	}
+	return &ResolvedDomain{
+		Domain:   domain,
+		Resolver: overrideResolver.Info.Copy(),
+	}
+}
+
+type overrideResolverConn struct{}
+
+func (orc *overrideResolverConn) Query(ctx context.Context, q *Query) (*RRCache, error) {
+	// Disable caching, as the records are always available.
+	q.NoCaching = true
+
+	resolversLock.RLock()
+	answer, exists, unresolvedTarget := overrides.answer(q.FQDN, uint16(q.QType))
+	resolversLock.RUnlock()
+
+	rrCache := &RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Answer:   answer,
+		Resolver: overrideResolver.Info.Copy(),
+	}
+	if !exists {
+		// The records were removed in the meantime.
+		rrCache.RCode = dns.RcodeNameError
+		return rrCache, nil
+	}
+
+	// Resolve a CNAME target that has no local records.
+	if unresolvedTarget != "" {
+		targetRRCache, err := Resolve(ctx, &Query{
+			FQDN:          unresolvedTarget,
+			QType:         q.QType,
+			SecurityLevel: q.SecurityLevel,
+		})
+		if err != nil {
+			log.Tracer(ctx).Debugf("resolver: failed to resolve target %s of local record %s: %s", unresolvedTarget, q.FQDN, err)
+		} else {
+			rrCache.Answer = append(rrCache.Answer, targetRRCache.Answer...)
+		}
+	}
+
+	return rrCache, nil
+}
+
+func (orc *overrideResolverConn) ReportFailure() {}
+
+func (orc *overrideResolverConn) IsFailing() bool {
+	return false
+}
+
+func (orc *overrideResolverConn) ResetFailure() {}
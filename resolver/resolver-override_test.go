@@ -0,0 +1,146 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseOverrideRecord(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		entry string
+		rrs   int
+		valid bool
+	}{
+		{"nas.home.arpa A 192.168.1.10", 1, true},
+		{"*.dev.example aaaa fd00::1", 1, true},
+		{"www.example CNAME web.example", 1, true},
+		{`example TXT "hello world"`, 1, true},
+		{"10.1.168.192.in-addr.arpa PTR nas.home.arpa", 1, true},
+		{"192.168.1.10 nas.home.arpa nas # comment", 3, true},
+		{"fd00::1 dev.example", 2, true},
+		{"nas.home.arpa A fd00::1", 0, false},
+		{"nas.home.arpa AAAA 192.168.1.10", 0, false},
+		{"nas.home.arpa MX mail.home.arpa", 0, false},
+		{"www.example CNAME *.example", 0, false},
+		{"nas.home.arpa A", 0, false},
+		{"192.168.1.10", 0, false},
+		{". A 192.168.1.10", 0, false},
+	}
+	for _, tc := range testCases {
+		rrs, err := parseOverrideRecord(tc.entry)
+		switch {
+		case tc.valid && err != nil:
+			t.Errorf("%q: unexpected error: %s", tc.entry, err)
+		case !tc.valid && err == nil:
+			t.Errorf("%q: should be invalid", tc.entry)
+		case len(rrs) != tc.rrs:
+			t.Errorf("%q: expected %d records, got %d", tc.entry, tc.rrs, len(rrs))
+		}
+	}
+}
+
+func TestOverrideRecordsAnswer(t *testing.T) {
+	t.Parallel()
+
+	records := getOverrideRecords([]string{
+		"192.168.1.10 nas.home.arpa nas.lan",
+		"*.dev.example A 10.0.0.1",
+		"api.dev.example A 10.0.0.2",
+		"www.example CNAME web.example",
+		"web.example CNAME nas.home.arpa",
+		"docs.example CNAME docs.upstream.example",
+		"invalid entry",
+	})
+
+	testCases := []struct {
+		fqdn             string
+		qType            uint16
+		answer           []string
+		exists           bool
+		unresolvedTarget string
+	}{
+		{"nas.home.arpa.", dns.TypeA, []string{"192.168.1.10"}, true, ""},
+		{"nas.home.arpa.", dns.TypeAAAA, nil, true, ""},
+		{"10.1.168.192.in-addr.arpa.", dns.TypePTR, []string{"nas.home.arpa."}, true, ""},
+		{"foo.dev.example.", dns.TypeA, []string{"10.0.0.1"}, true, ""},
+		{"bar.foo.dev.example.", dns.TypeA, []string{"10.0.0.1"}, true, ""},
+		{"api.dev.example.", dns.TypeA, []string{"10.0.0.2"}, true, ""},
+		{"dev.example.", dns.TypeA, nil, false, ""},
+		{"www.example.", dns.TypeA, []string{"web.example.", "nas.home.arpa.", "192.168.1.10"}, true, ""},
+		{"www.example.", dns.TypeCNAME, []string{"web.example."}, true, ""},
+		{"docs.example.", dns.TypeA, []string{"docs.upstream.example."}, true, "docs.upstream.example."},
+		{"example.", dns.TypeA, nil, false, ""},
+	}
+	for _, tc := range testCases {
+		answer, exists, unresolvedTarget := records.answer(tc.fqdn, tc.qType)
+		if exists != tc.exists || unresolvedTarget != tc.unresolvedTarget {
+			t.Errorf("%s%s: expected exists=%v and target %q, got exists=%v and target %q",
+				tc.fqdn, dns.Type(tc.qType), tc.exists, tc.unresolvedTarget, exists, unresolvedTarget)
+			continue
+		}
+		if len(answer) != len(tc.answer) {
+			t.Errorf("%s%s: expected answer %v, got %v", tc.fqdn, dns.Type(tc.qType), tc.answer, answer)
+			continue
+		}
+		for i, rr := range answer {
+			var data string
+			switch v := rr.(type) {
+			case *dns.A:
+				data = v.A.String()
+			case *dns.CNAME:
+				data = v.Target
+			case *dns.PTR:
+				data = v.Ptr
+			}
+			if data != tc.answer[i] {
+				t.Errorf("%s%s: expected answer %v, got %v", tc.fqdn, dns.Type(tc.qType), tc.answer, answer)
+				break
+			}
+			if rr.Header().Name == "" || rr.Header().Name[0] == '*' {
+				t.Errorf("%s%s: answer has wildcard name: %s", tc.fqdn, dns.Type(tc.qType), rr)
+			}
+		}
+	}
+
+	// Check attribution of IP addresses.
+	if domain := records.domains[net.IPv4(192, 168, 1, 10).String()]; domain != "nas.home.arpa." {
+		t.Errorf("expected 192.168.1.10 to be attributed to nas.home.arpa., got %q", domain)
+	}
+	if _, ok := records.domains["10.0.0.1"]; ok {
+		t.Error("wildcard records must not be attributed")
+	}
+}
+
+func TestParseHostsFile(t *testing.T) {
+	t.Parallel()
+
+	entries, errs := parseHostsFile(`# Static table lookup for hostnames.
+127.0.0.1	localhost
+::1		localhost ip6-localhost   # loopback
+
+192.168.1.10  nas.home.arpa  nas.lan
+nas.lan A 192.168.1.10
+300.1.1.1 invalid.lan
+`)
+
+	expected := []string{
+		"127.0.0.1 localhost",
+		"::1 localhost ip6-localhost",
+		"192.168.1.10 nas.home.arpa nas.lan",
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected entries %v, got %v", expected, entries)
+	}
+	for i, entry := range entries {
+		if entry != expected[i] {
+			t.Errorf("expected entry %q, got %q", expected[i], entry)
+		}
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors, got %v", errs)
+	}
+}
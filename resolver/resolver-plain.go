@@ -36,8 +36,7 @@ func NewPlainResolver(resolver *Resolver) *PlainResolver {
 // Query executes the given query against the resolver.
 func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
 	// create query
-	dnsQuery := new(dns.Msg)
-	dnsQuery.SetQuestion(q.FQDN, uint16(q.QType))
+	dnsQuery := pr.resolver.newQueryMsg(q)
 
 	// get timeout from context and config
 	var timeout time.Duration
@@ -62,6 +61,13 @@ func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 	// query server
 	reply, ttl, err := dnsClient.Exchange(dnsQuery, pr.resolver.ServerAddress)
 	log.Tracer(ctx).Tracef("resolver: query took %s", ttl)
+	// retry over tcp if the reply was truncated
+	if err == nil && reply.Truncated {
+		dnsClient.Net = "tcp"
+		dnsClient.Dialer.LocalAddr = getLocalAddr("tcp")
+		reply, ttl, err = dnsClient.Exchange(dnsQuery, pr.resolver.ServerAddress)
+		log.Tracer(ctx).Tracef("resolver: truncated reply, retried over tcp, query took %s", ttl)
+	}
 	// error handling
 	if err != nil {
 		// Hint network environment at failed connection if err is not a timeout.
@@ -72,6 +78,11 @@ func (pr *PlainResolver) Query(ctx context.Context, q *Query) (*RRCache, error)
 		return nil, err
 	}
 
+	// check if the reply matches our question
+	if err := pr.resolver.checkReply(q, dnsQuery, reply); err != nil {
+		return nil, err
+	}
+
 	// check if blocked
 	if pr.resolver.IsBlockedUpstream(reply) {
 		return nil, &BlockedUpstreamError{pr.resolver.Info.DescriptiveName()}
@@ -99,8 +99,7 @@ func (tr *TCPResolver) submitQuery(_ context.Context, q *Query) *InFlightQuery {
 	tr.startClient()
 
 	// create msg
-	msg := &dns.Msg{}
-	msg.SetQuestion(q.FQDN, uint16(q.QType))
+	msg := tr.resolver.newQueryMsg(q)
 
 	// save to waitlist
 	inFlight := &InFlightQuery{
@@ -171,6 +170,11 @@ func (tr *TCPResolver) Query(ctx context.Context, q *Query) (*RRCache, error) {
 		return nil, ErrFailure
 	}
 
+	// check if the reply matches our question
+	if err := tr.resolver.checkReply(q, inFlight.Msg, reply); err != nil {
+		return nil, err
+	}
+
 	if tr.resolver.IsBlockedUpstream(reply) {
 		return nil, &BlockedUpstreamError{tr.resolver.Info.DescriptiveName()}
 	}
@@ -475,6 +479,12 @@ func (mgr *tcpResolverConnMgr) handleQueryResponse(conn *dns.Conn, msg *dns.Msg)
 		return
 	}
 
+	// check if the reply matches our question
+	if err := mgr.tr.resolver.checkReply(inFlight.Query, inFlight.Msg, msg); err != nil {
+		log.Debugf("resolver: not caching late reply: %s", err)
+		return
+	}
+
 	// persist to database
 	rrCache := inFlight.MakeCacheRecord(msg)
 	rrCache.Clean(minTTL)
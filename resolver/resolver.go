@@ -17,17 +17,20 @@
 
 // DNS Resolver Attributes
 const (
-	ServerTypeDNS  = "dns"
-	ServerTypeTCP  = "tcp"
-	ServerTypeDoT  = "dot"
-	ServerTypeDoH  = "doh"
-	ServerTypeMDNS = "mdns"
-	ServerTypeEnv  = "env"
+	ServerTypeDNS      = "dns"
+	ServerTypeTCP      = "tcp"
+	ServerTypeDoT      = "dot"
+	ServerTypeDoH      = "doh"
+	ServerTypeMDNS     = "mdns"
+	ServerTypeEnv      = "env"
+	ServerTypeOverride = "override"
 
 	ServerSourceConfigured      = "config"
 	ServerSourceOperatingSystem = "system"
 	ServerSourceMDNS            = "mdns"
 	ServerSourceEnv             = "env"
+	ServerSourceForwarding      = "forwarding"
+	ServerSourceOverride        = "override"
 )
 
 var (
@@ -39,12 +42,18 @@
 type Resolver struct {
 	// Server config url (and ID)
 	// Supported parameters:
-	// - `verify=domain`: verify domain (dot only)
+	// - `verify=domain`: verify domain (dot and doh only)
+	// - `method=get`: use GET instead of POST requests (doh only)
 	// - `name=name`: human readable name for resolver
 	// - `blockedif=empty`: how to detect if the dns service blocked something
 	//	- `empty`: NXDomain result, but without any other record in any section
 	//  - `refused`: Request was refused
 	//	- `zeroip`: Answer only contains zeroip
+	// - `ecs=strip`: how to handle client subnet information
+	//	- `strip`: never send it and strip it from replies (default)
+	//	- `optout`: additionally ask the server to not add any
+	// - `padding=on`: pad queries to hide their size (dot and doh only, default on)
+	// - `0x20=on`: randomize the case of queries and verify it in replies (dns only, default off)
 	ConfigURL string
 
 	// Info holds the parsed configuration.
@@ -66,8 +75,20 @@ type Resolver struct {
 	VerifyDomain string
 	Search       []string
 
+	// DNS-over-HTTPS Options
+	Path   string
+	Method string
+
+	// Privacy Options
+	ECS     string
+	Padding bool
+	Use0x20 bool
+
 	// logic interface
 	Conn ResolverConn `json:"-"`
+
+	// health tracks the health statistics of the resolver.
+	health resolverHealth
 }
 
 // ResolverInfo is a subset of resolver attributes that is attached to answers
@@ -78,11 +99,11 @@ type ResolverInfo struct {
 	Name string
 
 	// Type describes the type of the resolver.
-	// Possible values include dns, tcp, dot, doh, mdns, env.
+	// Possible values include dns, tcp, dot, doh, mdns, env, override.
 	Type string
 
 	// Source describes where the resolver configuration came from.
-	// Possible values include config, system, mdns, env.
+	// Possible values include config, system, mdns, env, forwarding, override.
 	Source string
 
 	// IP is the IP address of the resolver
@@ -94,6 +115,10 @@ type ResolverInfo struct {
 	// Port is the udp/tcp port of the resolver.
 	Port uint16
 
+	// DNSSEC holds the DNSSEC validation state of the answer this info is
+	// attached to. It is empty if the answer was not validated.
+	DNSSEC string
+
 	// id holds a unique ID for this resolver.
 	id    string
 	idGen sync.Once
@@ -108,6 +133,8 @@ func (info *ResolverInfo) ID() string {
 			info.id = ServerTypeMDNS
 		case ServerTypeEnv:
 			info.id = ServerTypeEnv
+		case ServerTypeOverride:
+			info.id = ServerTypeOverride
 		default:
 			info.id = fmt.Sprintf(
 				"%s://%s:%d#%s",
@@ -130,6 +157,8 @@ func (info *ResolverInfo) DescriptiveName() string {
 		return "MDNS"
 	case info.Type == ServerTypeEnv:
 		return "Portmaster Environment"
+	case info.Type == ServerTypeOverride:
+		return "Local DNS Records"
 	case info.Name != "":
 		return fmt.Sprintf(
 			"%s (%s)",
@@ -158,6 +187,7 @@ func (info *ResolverInfo) Copy() *ResolverInfo {
 		IP:      info.IP,
 		IPScope: info.IPScope,
 		Port:    info.Port,
+		DNSSEC:  info.DNSSEC,
 		id:      info.id,
 	}
 	// Trigger idGen.Do(), as the ID is already generated.
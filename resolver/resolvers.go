@@ -67,6 +67,8 @@ func resolverConnFactory(resolver *Resolver) ResolverConn {
 		return NewTCPResolver(resolver)
 	case ServerTypeDoT:
 		return NewTCPResolver(resolver).UseTLS()
+	case ServerTypeDoH:
+		return NewHTTPSResolver(resolver)
 	case ServerTypeDNS:
 		return NewPlainResolver(resolver)
 	default:
@@ -81,7 +83,7 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 	}
 
 	switch u.Scheme {
-	case ServerTypeDNS, ServerTypeDoT, ServerTypeTCP:
+	case ServerTypeDNS, ServerTypeDoT, ServerTypeDoH, ServerTypeTCP:
 	default:
 		return nil, false, fmt.Errorf("DNS resolver scheme %q invalid", u.Scheme)
 	}
@@ -118,12 +120,29 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 
 	query := u.Query()
 	verifyDomain := query.Get("verify")
-	if verifyDomain != "" && u.Scheme != ServerTypeDoT {
-		return nil, false, fmt.Errorf("domain verification only supported in DOT")
+	switch u.Scheme {
+	case ServerTypeDoT, ServerTypeDoH:
+		if verifyDomain == "" {
+			return nil, false, fmt.Errorf("%s must have a verify query parameter set", strings.ToUpper(u.Scheme))
+		}
+	default:
+		if verifyDomain != "" {
+			return nil, false, fmt.Errorf("domain verification only supported in DOT and DOH")
+		}
+	}
+
+	method := strings.ToLower(query.Get("method"))
+	switch {
+	case method == "":
+	case u.Scheme != ServerTypeDoH:
+		return nil, false, fmt.Errorf("request method only supported in DOH")
+	case method != dohMethodGet && method != dohMethodPost:
+		return nil, false, fmt.Errorf("invalid value for request method (method=)")
 	}
 
-	if verifyDomain == "" && u.Scheme == ServerTypeDoT {
-		return nil, false, fmt.Errorf("DOT must have a verify query parameter set")
+	var path string
+	if u.Scheme == ServerTypeDoH {
+		path = u.Path
 	}
 
 	blockType := query.Get("blockedif")
@@ -137,6 +156,32 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 		return nil, false, fmt.Errorf("invalid value for upstream block detection (blockedif=)")
 	}
 
+	ecs := strings.ToLower(query.Get("ecs"))
+	switch ecs {
+	case "":
+		ecs = ECSStrip
+	case ECSStrip, ECSOptOut:
+	default:
+		return nil, false, fmt.Errorf("invalid value for client subnet handling (ecs=)")
+	}
+
+	encrypted := u.Scheme == ServerTypeDoT || u.Scheme == ServerTypeDoH
+	padding, err := parsePrivacyParam(query.Get("padding"), encrypted)
+	switch {
+	case err != nil:
+		return nil, false, fmt.Errorf("invalid value for padding (padding=): %w", err)
+	case padding && !encrypted:
+		return nil, false, fmt.Errorf("padding only supported in DOT and DOH")
+	}
+
+	use0x20, err := parsePrivacyParam(query.Get("0x20"), false)
+	switch {
+	case err != nil:
+		return nil, false, fmt.Errorf("invalid value for case randomization (0x20=): %w", err)
+	case use0x20 && u.Scheme != ServerTypeDNS:
+		return nil, false, fmt.Errorf("case randomization only supported in DNS")
+	}
+
 	new := &Resolver{
 		ConfigURL: resolverURL,
 		Info: &ResolverInfo{
@@ -149,7 +194,12 @@ func createResolver(resolverURL, source string) (*Resolver, bool, error) {
 		},
 		ServerAddress:          net.JoinHostPort(ip.String(), strconv.Itoa(int(port))),
 		VerifyDomain:           verifyDomain,
+		Path:                   path,
+		Method:                 method,
 		UpstreamBlockDetection: blockType,
+		ECS:                    ecs,
+		Padding:                padding,
+		Use0x20:                use0x20,
 	}
 
 	new.Conn = resolverConnFactory(new)
@@ -252,6 +302,12 @@ func loadResolvers() {
 	// assing resolvers to scopes
 	setScopedResolvers(globalResolvers)
 
+	// load forwarding rules
+	forwardingRules = getForwardingRules(configuredForwardingRules())
+
+	// load local records
+	overrides = getOverrideRecords(configuredLocalRecords())
+
 	// set active resolvers (for cache validation)
 	// reset
 	activeResolvers = make(map[string]*Resolver)
@@ -259,8 +315,14 @@ func loadResolvers() {
 	for _, resolver := range newResolvers {
 		activeResolvers[resolver.Info.ID()] = resolver
 	}
+	for _, rule := range forwardingRules {
+		for _, resolver := range rule.Resolvers {
+			activeResolvers[resolver.Info.ID()] = resolver
+		}
+	}
 	activeResolvers[mDNSResolver.Info.ID()] = mDNSResolver
 	activeResolvers[envResolver.Info.ID()] = envResolver
+	activeResolvers[overrideResolver.Info.ID()] = overrideResolver
 
 	// log global resolvers
 	if len(globalResolvers) > 0 {
@@ -306,6 +368,18 @@ func loadResolvers() {
 		log.Info("resolver: no scopes loaded")
 	}
 
+	// log forwarding rules
+	if len(forwardingRules) > 0 {
+		log.Trace("resolver: loaded forwarding rules:")
+		for _, rule := range forwardingRules {
+			var ruleServers []string
+			for _, resolver := range rule.Resolvers {
+				ruleServers = append(ruleServers, resolver.ConfigURL)
+			}
+			log.Tracef("resolver: %s (no fallback: %v): %s", rule.Domain, rule.NoFallback, strings.Join(ruleServers, ", "))
+		}
+	}
+
 	// alert if no resolvers are loaded
 	if len(globalResolvers) == 0 && len(localResolvers) == 0 {
 		log.Critical("resolver: no resolvers loaded!")
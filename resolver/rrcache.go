@@ -36,6 +36,7 @@ type RRCache struct {
 	ServedFromCache bool
 	RequestingNew   bool
 	IsBackup        bool
+	IsStale         bool
 	Filtered        bool
 	FilteredEntries []string
 
@@ -228,6 +229,9 @@ func (rrCache *RRCache) Flags() string {
 	if rrCache.IsBackup {
 		s += "B"
 	}
+	if rrCache.IsStale {
+		s += "S"
+	}
 	if rrCache.Filtered {
 		s += "F"
 	}
@@ -255,6 +259,7 @@ func (rrCache *RRCache) ShallowCopy() *RRCache {
 		ServedFromCache: rrCache.ServedFromCache,
 		RequestingNew:   rrCache.RequestingNew,
 		IsBackup:        rrCache.IsBackup,
+		IsStale:         rrCache.IsStale,
 		Filtered:        rrCache.Filtered,
 		FilteredEntries: rrCache.FilteredEntries,
 		Modified:        rrCache.Modified,
@@ -284,9 +289,56 @@ func (rrCache *RRCache) ReplyWithDNS(ctx context.Context, request *dns.Msg) *dns
 	reply.Ns = rrCache.Ns
 	reply.Extra = rrCache.Extra
 
+	// Only include DNSSEC records if the client requested them.
+	requestOpt := request.IsEdns0()
+	dnssecOK := requestOpt != nil && requestOpt.Do()
+	if !dnssecOK {
+		reply.Answer = withoutDNSSECRecords(reply.Answer)
+		reply.Ns = withoutDNSSECRecords(reply.Ns)
+		reply.Extra = withoutDNSSECRecords(reply.Extra)
+	}
+
+	// Set the AD bit for validated answers, if the client understands it.
+	// RFC6840, Section 5.8
+	if rrCache.Resolver != nil && rrCache.Resolver.DNSSEC == DNSSECSecure {
+		reply.AuthenticatedData = dnssecOK || request.AuthenticatedData
+	}
+
 	return reply
 }
 
+// withoutDNSSECRecords returns the given section without DNSSEC records. The
+// given section is not modified, as it may be shared with the cache.
+func withoutDNSSECRecords(section []dns.RR) []dns.RR {
+	var hasDNSSECRecords bool
+	for _, rr := range section {
+		if isDNSSECRecord(rr) {
+			hasDNSSECRecords = true
+			break
+		}
+	}
+	if !hasDNSSECRecords {
+		return section
+	}
+
+	filtered := make([]dns.RR, 0, len(section))
+	for _, rr := range section {
+		if !isDNSSECRecord(rr) {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
+
+func isDNSSECRecord(rr dns.RR) bool {
+	switch rr.Header().Rrtype {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	default:
+		return false
+	}
+}
+
 // GetExtraRRs returns a slice of RRs with additional informational records.
 func (rrCache *RRCache) GetExtraRRs(ctx context.Context, query *dns.Msg) (extra []dns.RR) {
 	// Add cache status and source of data.
@@ -311,6 +363,14 @@ func (rrCache *RRCache) GetExtraRRs(ctx context.Context, query *dns.Msg) (extra
 	if rrCache.IsBackup {
 		extra = addExtra(ctx, extra, "this record is served because a fresh request was unsuccessful")
 	}
+	if rrCache.IsStale {
+		extra = addExtra(ctx, extra, "this record is expired and served because a fresh request was unsuccessful or too slow")
+	}
+
+	// Add DNSSEC validation state.
+	if rrCache.Resolver.DNSSEC != "" {
+		extra = addExtra(ctx, extra, "DNSSEC validation state: "+rrCache.Resolver.DNSSEC)
+	}
 
 	// Add information about filtered entries.
 	if rrCache.Filtered {
@@ -109,20 +109,35 @@ func domainInScope(dotPrefixedFQDN string, scopeList []string) bool {
 }
 
 // GetResolversInScope returns all resolvers that are in scope the resolve the given query and options.
-func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, tryAll bool) { //nolint:gocognit // TODO
+// If globalOnly is true, only global resolvers were selected. They are ordered by the configured
+// resolver selection strategy, but have no priority among them otherwise.
+func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, tryAll, globalOnly bool) { //nolint:gocognit // TODO
 	resolversLock.RLock()
 	defer resolversLock.RUnlock()
 
 	// Internal use domains
 	if domainInScope(q.dotPrefixedFQDN, internalSpecialUseDomains) {
-		return envResolvers, false
+		return envResolvers, false, false
+	}
+
+	// User defined local records
+	if _, ok := overrides.get(q.FQDN); ok {
+		return overrideResolvers, false, false
+	}
+
+	// User configured forwarding rules
+	if rule := getForwardingRule(q.dotPrefixedFQDN); rule != nil {
+		selected = addResolvers(ctx, q, selected, rule.Resolvers)
+		if rule.NoFallback {
+			return selected, false, false
+		}
 	}
 
 	// Special connectivity domains
 	if netenv.IsConnectivityDomain(q.FQDN) && len(systemResolvers) > 0 {
 		// Do not do compliance checks for connectivity domains.
 		selected = append(selected, systemResolvers...) // dhcp assigned resolvers
-		return selected, false
+		return selected, false, false
 	}
 
 	// Prioritize search scopes
@@ -137,7 +152,7 @@ func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, t
 		selected = addResolvers(ctx, q, selected, mDNSResolvers)
 		selected = addResolvers(ctx, q, selected, localResolvers)
 		selected = addResolvers(ctx, q, selected, systemResolvers)
-		return selected, true
+		return selected, true, false
 	}
 
 	// Special use domains
@@ -145,12 +160,13 @@ func GetResolversInScope(ctx context.Context, q *Query) (selected []*Resolver, t
 		domainInScope(q.dotPrefixedFQDN, specialServiceDomains) {
 		selected = addResolvers(ctx, q, selected, localResolvers)
 		selected = addResolvers(ctx, q, selected, systemResolvers)
-		return selected, true
+		return selected, true, false
 	}
 
 	// Global domains
-	selected = addResolvers(ctx, q, selected, globalResolvers)
-	return selected, false
+	globalOnly = len(selected) == 0
+	selected = addResolvers(ctx, q, selected, orderResolvers(resolverSelection(), globalResolvers))
+	return selected, false, globalOnly
 }
 
 func addResolvers(ctx context.Context, q *Query, selected []*Resolver, addResolvers []*Resolver) []*Resolver {
@@ -219,6 +235,8 @@ func (resolver *Resolver) checkCompliance(_ context.Context, q *Query) error {
 			// compliant
 		case ServerTypeEnv:
 			// compliant (data is sourced from local network only and is highly limited)
+		case ServerTypeOverride:
+			// compliant (data is defined by the user)
 		default:
 			return errInsecureProtocol
 		}
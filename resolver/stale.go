@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+const (
+	// defaultServeStale is the default for how long expired records may be
+	// served, in seconds. RFC8767 suggests one to three days.
+	defaultServeStale = 3 * 24 * 60 * 60 // 3 days
+
+	// staleAnswerClientTimeout defines how long to wait for a fresh answer
+	// before answering with a stale record. The answered records always have a
+	// short TTL, see RRCache.Clean.
+	// RFC8767, Section 5
+	staleAnswerClientTimeout = 1800 * time.Millisecond
+)
+
+// IsServableStale returns whether the record has expired, but may still be
+// served while it is being refreshed.
+func (rrCache *RRCache) IsServableStale() bool {
+	maxStale := serveStale()
+	return rrCache.RCode == dns.RcodeSuccess &&
+		maxStale > 0 &&
+		rrCache.Expired() &&
+		time.Now().Unix() < rrCache.Expires+maxStale
+}
+
+type staleRefreshResult struct {
+	rrCache            *RRCache
+	err                error
+	refreshedElsewhere bool
+}
+
+// resolveOrServeStale refreshes the given stale cache entry in the background.
+// If the refresh does not finish in time or fails, the stale entry is
+// returned instead.
+func resolveOrServeStale(ctx context.Context, q *Query, staleCache *RRCache) (*RRCache, error) {
+	results := make(chan *staleRefreshResult, 1)
+	module.StartWorker("refresh stale cache", func(workerCtx context.Context) error {
+		tracingCtx, tracer := log.AddTracer(workerCtx)
+		defer tracer.Submit()
+
+		// Deduplicate refreshing.
+		markRequestFinished := deduplicateRequest(tracingCtx, q)
+		if markRequestFinished == nil {
+			results <- &staleRefreshResult{refreshedElsewhere: true}
+			return nil
+		}
+		defer markRequestFinished()
+
+		// Refresh with a copy of the stale entry, as it may already be in use.
+		tracer.Tracef("resolver: refreshing stale cache of %s", q.ID())
+		rrCache, err := resolveAndCache(tracingCtx, q, staleCache.ShallowCopy())
+		if err != nil {
+			tracer.Debugf("resolver: failed to refresh stale cache of %s: %s", q.ID(), err)
+		}
+		results <- &staleRefreshResult{
+			rrCache: rrCache,
+			err:     err,
+		}
+		return nil
+	})
+
+	select {
+	case result := <-results:
+		switch {
+		case result.refreshedElsewhere:
+			// Another request finished refreshing the entry, recheck the cache.
+			if rrCache := checkCache(ctx, q); rrCache != nil && !rrCache.Expired() {
+				return rrCache, nil
+			}
+			return serveStaleAnswer(ctx, q, staleCache, false), nil
+		case errors.Is(result.err, ErrNotFound), errors.Is(result.err, ErrBlocked):
+			// The fresh answer supersedes the stale entry.
+			return nil, result.err
+		case result.err != nil:
+			return serveStaleAnswer(ctx, q, staleCache, false), nil
+		case result.rrCache.IsBackup:
+			// The refresh failed and the stale entry was used as a backup.
+			staleAnswerCounter.Inc()
+			return result.rrCache, nil
+		default:
+			return result.rrCache, nil
+		}
+
+	case <-time.After(staleAnswerClientTimeout):
+		return serveStaleAnswer(ctx, q, staleCache, true), nil
+
+	case <-ctx.Done():
+		// The refresh continues in the background and is cached when done.
+		return nil, ctx.Err()
+	}
+}
+
+// serveStaleAnswer marks the given cache entry as stale and returns it.
+func serveStaleAnswer(ctx context.Context, q *Query, staleCache *RRCache, refreshing bool) *RRCache {
+	log.Tracer(ctx).Debugf(
+		"resolver: serving stale cache of %s, expired since %s",
+		q.ID(),
+		time.Since(time.Unix(staleCache.Expires, 0)).Round(time.Second),
+	)
+
+	staleCache.IsStale = true
+	staleCache.RequestingNew = refreshing
+	staleAnswerCounter.Inc()
+	return staleCache
+}
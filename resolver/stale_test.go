@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsServableStale(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		rCode    int
+		expired  time.Duration
+		servable bool
+	}{
+		{dns.RcodeSuccess, -time.Minute, false},
+		{dns.RcodeSuccess, time.Minute, true},
+		{dns.RcodeSuccess, defaultServeStale * time.Second * 2, false},
+		{dns.RcodeNameError, time.Minute, false},
+	}
+	for _, tc := range testCases {
+		rrCache := &RRCache{
+			RCode:   tc.rCode,
+			Expires: time.Now().Add(-tc.expired).Unix(),
+		}
+		if rrCache.IsServableStale() != tc.servable {
+			t.Errorf("rcode %d expired since %s: expected servable stale to be %v", tc.rCode, tc.expired, tc.servable)
+		}
+	}
+}
+
+func TestServeExpiredCacheAsBackup(t *testing.T) {
+	// Expired entries are served as a backup when resolving fails, even if
+	// serving stale entries is disabled.
+	previousServeStale := serveStale
+	serveStale = func() int64 { return 0 }
+	defer func() {
+		serveStale = previousServeStale
+	}()
+
+	failing := newTestHealthResolver("failing", 0, ErrFailure)
+	failing.Info.Type = ServerTypeDoT
+	failing.Info.IP = net.IPv4(192, 0, 2, 53)
+	failing.Info.Port = 853
+
+	resolversLock.Lock()
+	previousGlobal, previousActive := globalResolvers, activeResolvers
+	previousLocal, previousSystem := localResolvers, systemResolvers
+	globalResolvers, localResolvers, systemResolvers = []*Resolver{failing}, nil, nil
+	activeResolvers = map[string]*Resolver{failing.Info.ID(): failing}
+	resolversLock.Unlock()
+	defer func() {
+		resolversLock.Lock()
+		globalResolvers, activeResolvers = previousGlobal, previousActive
+		localResolvers, systemResolvers = previousLocal, previousSystem
+		resolversLock.Unlock()
+	}()
+
+	answer, err := dns.NewRR("backup.safing.net. 60 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := &RRCache{
+		Domain:   "backup.safing.net.",
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer:   []dns.RR{answer},
+		Expires:  time.Now().Add(-time.Minute).Unix(),
+		Resolver: failing.Info,
+	}
+	if err := expired.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rrCache, err := Resolve(silencingTraceCtx, &Query{
+		FQDN:  "backup.safing.net.",
+		QType: dns.Type(dns.TypeA),
+	})
+	switch {
+	case rrCache == nil:
+		t.Fatalf("expected expired entry to be served, got error %v", err)
+	case !rrCache.IsBackup:
+		t.Errorf("expected expired entry to be served as backup, got error %v: %+v", err, rrCache)
+	case rrCache.IsStale:
+		t.Error("expected expired entry not to be served stale")
+	}
+}